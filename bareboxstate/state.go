@@ -0,0 +1,381 @@
+// Package bareboxstate reads and writes the variable storage of the
+// barebox state framework using the "raw" backend format.
+//
+// Every copy is written to a "direct" bucket, like barebox does on block
+// devices and files since v2017.05: the raw data follows a meta header
+// with the bucket magic and the written length. Copies written by older
+// versions without that header are read as well and their layout is
+// kept when saving.
+//
+// The layout of the state (magic, variables and backend stride) is
+// described in the barebox device tree; callers pass the same
+// information as a Layout.
+package bareboxstate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// headerSize is the size of the raw backend header: magic, reserved,
+// data_len, data_crc and header_crc
+const headerSize = 16
+
+// directMagic starts the meta header of a direct bucket, it is followed
+// by the written length of the raw data
+const directMagic = 0x2354fdf3
+
+// directMetaSize is the size of the meta header of a direct bucket
+const directMetaSize = 8
+
+// DefaultCopies is the number of redundant copies barebox keeps when
+// a backend stride is used
+const DefaultCopies = 3
+
+// VarType is the type of a state variable as named in the device tree
+type VarType int
+
+const (
+	// Uint8 is a "uint8" variable
+	Uint8 VarType = iota
+	// Uint32 is a "uint32" variable
+	Uint32
+	// Enum32 is a "enum32" variable, stored as index into Names
+	Enum32
+	// MAC is a "mac" variable of six bytes
+	MAC
+	// String is a "string" variable, padded with zero bytes
+	String
+)
+
+// Variable describes a single state variable
+type Variable struct {
+	Name string
+	Type VarType
+	// Offset and Size are the "reg" property of the variable
+	Offset int
+	Size   int
+	// Names are the "names" of an Enum32 variable
+	Names []string
+	// Default is used when no valid copy of the state exists
+	Default string
+}
+
+// Layout describes a state node of the device tree
+type Layout struct {
+	Magic     uint32
+	Variables []Variable
+	// Stride is the "backend-stride-size", zero means a single copy
+	Stride int64
+	// Copies is the number of copies with a stride, zero means
+	// DefaultCopies
+	Copies int
+	// Offset of the first copy in the backend
+	Offset int64
+}
+
+// ErrNoValidCopy is returned by Open when none of the copies contains
+// a valid state
+var ErrNoValidCopy = errors.New("no valid copy of the barebox state")
+
+func (l *Layout) copies() int {
+	if l.Stride == 0 {
+		return 1
+	}
+	if l.Copies == 0 {
+		return DefaultCopies
+	}
+	return l.Copies
+}
+
+func (l *Layout) dataSize() int {
+	size := 0
+	for _, v := range l.Variables {
+		if v.Offset+v.Size > size {
+			size = v.Offset + v.Size
+		}
+	}
+	return size
+}
+
+func (l *Layout) validate() error {
+	for _, v := range l.Variables {
+		want := 0
+		switch v.Type {
+		case Uint8:
+			want = 1
+		case Uint32, Enum32:
+			want = 4
+		case MAC:
+			want = 6
+		case String:
+			want = v.Size
+		default:
+			return fmt.Errorf("variable %q: unknown type %v", v.Name, v.Type)
+		}
+		if v.Size != want || v.Size <= 0 || v.Offset < 0 {
+			return fmt.Errorf("variable %q: invalid reg <%d %d>", v.Name, v.Offset, v.Size)
+		}
+	}
+	if size := directMetaSize + headerSize + l.dataSize(); l.Stride != 0 && l.Stride < int64(size) {
+		return fmt.Errorf("backend stride %d too small for %d bytes of state", l.Stride, size)
+	}
+	return nil
+}
+
+func (l *Layout) variable(name string) (*Variable, error) {
+	for i := range l.Variables {
+		if l.Variables[i].Name == name {
+			return &l.Variables[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no such variable: %q", name)
+}
+
+// State contains the data of a barebox state
+type State struct {
+	fname  string
+	layout Layout
+	data   []byte
+	// direct is set when the copies have the meta header of a
+	// direct bucket
+	direct bool
+}
+
+// Create writes a new state with the default values of all variables
+// to all copies.
+func Create(fname string, layout *Layout) (*State, error) {
+	if err := layout.validate(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	s := &State{
+		fname:  fname,
+		layout: *layout,
+		data:   make([]byte, layout.dataSize()),
+		direct: true,
+	}
+	if err := s.setDefaults(); err != nil {
+		return nil, err
+	}
+	if err := s.Save(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Open reads the state from the given file or device. The first valid
+// copy is used, like barebox does.
+func Open(fname string, layout *Layout) (*State, error) {
+	if err := layout.validate(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &State{
+		fname:  fname,
+		layout: *layout,
+	}
+	var errs []string
+	for i := 0; i < layout.copies(); i++ {
+		data, direct, err := s.readCopy(f, layout.Offset+int64(i)*layout.Stride)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("copy %d: %v", i, err))
+			continue
+		}
+		s.data = data
+		s.direct = direct
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoValidCopy, strings.Join(errs, ", "))
+}
+
+// readCopy reads the copy at the given offset and returns its data and
+// whether it is stored in a direct bucket
+func (s *State) readCopy(r io.ReaderAt, offset int64) ([]byte, bool, error) {
+	header := make([]byte, headerSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return nil, false, err
+	}
+	direct := binary.LittleEndian.Uint32(header[0:]) == directMagic
+	written := 0
+	if direct {
+		written = int(binary.LittleEndian.Uint32(header[4:]))
+		offset += directMetaSize
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, false, err
+		}
+	}
+	if magic := binary.LittleEndian.Uint32(header[0:]); magic != s.layout.Magic {
+		return nil, false, fmt.Errorf("bad magic: 0x%08x != 0x%08x", magic, s.layout.Magic)
+	}
+	if crc := crc32.ChecksumIEEE(header[:12]); crc != binary.LittleEndian.Uint32(header[12:]) {
+		return nil, false, fmt.Errorf("bad header CRC")
+	}
+	dataLen := int(binary.LittleEndian.Uint16(header[6:]))
+	if dataLen != s.layout.dataSize() {
+		return nil, false, fmt.Errorf("data length %d does not match layout size %d", dataLen, s.layout.dataSize())
+	}
+	if direct && written < headerSize+dataLen {
+		return nil, false, fmt.Errorf("written length %d too small for %d bytes of state", written, headerSize+dataLen)
+	}
+	data := make([]byte, dataLen)
+	if _, err := r.ReadAt(data, offset+headerSize); err != nil {
+		return nil, false, err
+	}
+	if crc := crc32.ChecksumIEEE(data); crc != binary.LittleEndian.Uint32(header[8:]) {
+		return nil, false, fmt.Errorf("bad data CRC")
+	}
+	return data, direct, nil
+}
+
+func (s *State) setDefaults() error {
+	for _, v := range s.layout.Variables {
+		if v.Default == "" {
+			continue
+		}
+		if err := s.Set(v.Name, v.Default); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *State) String() string {
+	out := ""
+	for _, v := range s.layout.Variables {
+		out += fmt.Sprintf("%s=%s\n", v.Name, s.get(&v))
+	}
+	return out
+}
+
+// Get the value of the state variable, unknown variables are empty
+func (s *State) Get(name string) string {
+	v, err := s.layout.variable(name)
+	if err != nil {
+		return ""
+	}
+	return s.get(v)
+}
+
+func (s *State) get(v *Variable) string {
+	raw := s.data[v.Offset : v.Offset+v.Size]
+	switch v.Type {
+	case Uint8:
+		return strconv.Itoa(int(raw[0]))
+	case Uint32:
+		return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(raw)), 10)
+	case Enum32:
+		idx := binary.LittleEndian.Uint32(raw)
+		if int(idx) < len(v.Names) {
+			return v.Names[idx]
+		}
+		return strconv.FormatUint(uint64(idx), 10)
+	case MAC:
+		return net.HardwareAddr(raw).String()
+	case String:
+		if i := bytes.IndexByte(raw, 0); i >= 0 {
+			raw = raw[:i]
+		}
+		return string(raw)
+	}
+	return ""
+}
+
+// Set the state variable to the given value. The value is parsed
+// according to the type of the variable.
+func (s *State) Set(name, value string) error {
+	v, err := s.layout.variable(name)
+	if err != nil {
+		return err
+	}
+	raw := s.data[v.Offset : v.Offset+v.Size]
+	switch v.Type {
+	case Uint8:
+		n, err := strconv.ParseUint(value, 0, 8)
+		if err != nil {
+			return fmt.Errorf("cannot set %s: %v", name, err)
+		}
+		raw[0] = byte(n)
+	case Uint32:
+		n, err := strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			return fmt.Errorf("cannot set %s: %v", name, err)
+		}
+		binary.LittleEndian.PutUint32(raw, uint32(n))
+	case Enum32:
+		for i, n := range v.Names {
+			if n == value {
+				binary.LittleEndian.PutUint32(raw, uint32(i))
+				return nil
+			}
+		}
+		return fmt.Errorf("cannot set %s: %q is not one of %s", name, value, strings.Join(v.Names, ", "))
+	case MAC:
+		mac, err := net.ParseMAC(value)
+		if err != nil || len(mac) != 6 {
+			return fmt.Errorf("cannot set %s: invalid MAC address %q", name, value)
+		}
+		copy(raw, mac)
+	case String:
+		if len(value) > len(raw) {
+			return fmt.Errorf("cannot set %s: value too long: %d bytes, %d available", name, len(value), len(raw))
+		}
+		n := copy(raw, value)
+		for i := n; i < len(raw); i++ {
+			raw[i] = 0
+		}
+	}
+	return nil
+}
+
+// Save writes the state to all copies
+func (s *State) Save() error {
+	raw := make([]byte, headerSize+len(s.data))
+	binary.LittleEndian.PutUint32(raw[0:], s.layout.Magic)
+	binary.LittleEndian.PutUint16(raw[6:], uint16(len(s.data)))
+	binary.LittleEndian.PutUint32(raw[8:], crc32.ChecksumIEEE(s.data))
+	binary.LittleEndian.PutUint32(raw[12:], crc32.ChecksumIEEE(raw[:12]))
+	copy(raw[headerSize:], s.data)
+
+	block := raw
+	if s.direct {
+		block = make([]byte, directMetaSize, directMetaSize+len(raw))
+		binary.LittleEndian.PutUint32(block[0:], directMagic)
+		binary.LittleEndian.PutUint32(block[4:], uint32(len(raw)))
+		block = append(block, raw...)
+	}
+
+	f, err := os.OpenFile(s.fname, os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for i := 0; i < s.layout.copies(); i++ {
+		if _, err := f.WriteAt(block, s.layout.Offset+int64(i)*s.layout.Stride); err != nil {
+			return err
+		}
+	}
+
+	return f.Sync()
+}
@@ -0,0 +1,195 @@
+package bareboxstate
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type stateTestSuite struct {
+	stateFile string
+	layout    Layout
+}
+
+var _ = Suite(&stateTestSuite{})
+
+func (s *stateTestSuite) SetUpTest(c *C) {
+	s.stateFile = filepath.Join(c.MkDir(), "state")
+	s.layout = Layout{
+		Magic: 0xcafe0001,
+		Variables: []Variable{
+			{Name: "bootstate.system0.remaining_attempts", Type: Uint32, Offset: 0, Size: 4, Default: "3"},
+			{Name: "bootstate.system0.priority", Type: Uint8, Offset: 4, Size: 1, Default: "20"},
+			{Name: "bootstate.last_chosen", Type: Enum32, Offset: 8, Size: 4, Names: []string{"system0", "system1"}},
+			{Name: "mac", Type: MAC, Offset: 12, Size: 6},
+			{Name: "serial", Type: String, Offset: 18, Size: 8},
+		},
+		Stride: 64,
+	}
+}
+
+func (s *stateTestSuite) TestCreateDefaults(c *C) {
+	state, err := Create(s.stateFile, &s.layout)
+	c.Assert(err, IsNil)
+	c.Assert(state.String(), Equals, `bootstate.system0.remaining_attempts=3
+bootstate.system0.priority=20
+bootstate.last_chosen=system0
+mac=00:00:00:00:00:00
+serial=
+`)
+
+	content, err := ioutil.ReadFile(s.stateFile)
+	c.Assert(err, IsNil)
+	// three copies in direct buckets with a stride of 64 bytes
+	c.Assert(content, HasLen, 2*64+directMetaSize+headerSize+26)
+	c.Assert(binary.LittleEndian.Uint32(content[0:]), Equals, uint32(directMagic))
+	c.Assert(binary.LittleEndian.Uint32(content[4:]), Equals, uint32(headerSize+26))
+	raw := content[directMetaSize:]
+	c.Assert(binary.LittleEndian.Uint32(raw[0:]), Equals, uint32(0xcafe0001))
+	c.Assert(binary.LittleEndian.Uint16(raw[6:]), Equals, uint16(26))
+	c.Assert(binary.LittleEndian.Uint32(raw[8:]), Equals, crc32.ChecksumIEEE(raw[headerSize:headerSize+26]))
+	c.Assert(binary.LittleEndian.Uint32(raw[12:]), Equals, crc32.ChecksumIEEE(raw[:12]))
+	c.Assert(content[64:64+directMetaSize+headerSize+26], DeepEquals, content[:directMetaSize+headerSize+26])
+}
+
+// bareboxCopy is a copy of the state of bareboxLayout as barebox
+// writes it to a direct bucket on a block device: the bucket magic and
+// written length followed by the raw header and the data
+var bareboxCopy = []byte{
+	0xf3, 0xfd, 0x54, 0x23, 0x1c, 0x00, 0x00, 0x00,
+	0x30, 0x32, 0x43, 0x4d, 0x00, 0x00, 0x0c, 0x00,
+	0x2b, 0x4c, 0x06, 0x4c, 0x98, 0x6c, 0xeb, 0x15,
+	0x03, 0x00, 0x00, 0x00, 0x14, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x00, 0x00,
+}
+
+// bareboxLayout is the state node of the barebox bootchooser example
+var bareboxLayout = Layout{
+	Magic: 0x4d433230,
+	Variables: []Variable{
+		{Name: "bootstate.system0.remaining_attempts", Type: Uint32, Offset: 0, Size: 4},
+		{Name: "bootstate.system0.priority", Type: Uint32, Offset: 4, Size: 4},
+		{Name: "bootstate.last_chosen", Type: Enum32, Offset: 8, Size: 4, Names: []string{"system0", "system1"}},
+	},
+	Stride: 0x40,
+}
+
+func (s *stateTestSuite) TestOpenBarebox(c *C) {
+	// the first copy is erased, the others are valid
+	img := make([]byte, 3*0x40)
+	for i := range img[:0x40] {
+		img[i] = 0xff
+	}
+	copy(img[0x40:], bareboxCopy)
+	copy(img[0x80:], bareboxCopy)
+	c.Assert(ioutil.WriteFile(s.stateFile, img, 0644), IsNil)
+
+	state, err := Open(s.stateFile, &bareboxLayout)
+	c.Assert(err, IsNil)
+	c.Check(state.Get("bootstate.system0.remaining_attempts"), Equals, "3")
+	c.Check(state.Get("bootstate.system0.priority"), Equals, "20")
+	c.Check(state.Get("bootstate.last_chosen"), Equals, "system1")
+
+	// saves write what barebox writes
+	c.Assert(state.Save(), IsNil)
+	content, err := ioutil.ReadFile(s.stateFile)
+	c.Assert(err, IsNil)
+	for i := 0; i < 3; i++ {
+		c.Check(content[i*0x40:i*0x40+len(bareboxCopy)], DeepEquals, bareboxCopy)
+	}
+}
+
+func (s *stateTestSuite) TestOpenWithoutDirectBucket(c *C) {
+	// written by barebox before v2017.05, without the meta header
+	img := make([]byte, 3*0x40)
+	for i := 0; i < 3; i++ {
+		copy(img[i*0x40:], bareboxCopy[directMetaSize:])
+	}
+	c.Assert(ioutil.WriteFile(s.stateFile, img, 0644), IsNil)
+
+	state, err := Open(s.stateFile, &bareboxLayout)
+	c.Assert(err, IsNil)
+	c.Check(state.Get("bootstate.last_chosen"), Equals, "system1")
+
+	// the layout is kept
+	c.Assert(state.Set("bootstate.last_chosen", "system0"), IsNil)
+	c.Assert(state.Save(), IsNil)
+	content, err := ioutil.ReadFile(s.stateFile)
+	c.Assert(err, IsNil)
+	c.Check(binary.LittleEndian.Uint32(content[0:]), Equals, uint32(0x4d433230))
+	state, err = Open(s.stateFile, &bareboxLayout)
+	c.Assert(err, IsNil)
+	c.Check(state.Get("bootstate.last_chosen"), Equals, "system0")
+}
+
+func (s *stateTestSuite) TestSetSaveOpen(c *C) {
+	state, err := Create(s.stateFile, &s.layout)
+	c.Assert(err, IsNil)
+	c.Assert(state.Set("bootstate.system0.remaining_attempts", "0x10"), IsNil)
+	c.Assert(state.Set("bootstate.last_chosen", "system1"), IsNil)
+	c.Assert(state.Set("mac", "02:00:00:12:34:56"), IsNil)
+	c.Assert(state.Set("serial", "abc"), IsNil)
+	c.Assert(state.Save(), IsNil)
+
+	state, err = Open(s.stateFile, &s.layout)
+	c.Assert(err, IsNil)
+	c.Assert(state.Get("bootstate.system0.remaining_attempts"), Equals, "16")
+	c.Assert(state.Get("bootstate.last_chosen"), Equals, "system1")
+	c.Assert(state.Get("mac"), Equals, "02:00:00:12:34:56")
+	c.Assert(state.Get("serial"), Equals, "abc")
+	c.Assert(state.Get("no-such-variable"), Equals, "")
+}
+
+func (s *stateTestSuite) TestSetInvalid(c *C) {
+	state, err := Create(s.stateFile, &s.layout)
+	c.Assert(err, IsNil)
+	c.Assert(state.Set("bootstate.system0.priority", "256"), ErrorMatches, "cannot set bootstate.system0.priority: .* value out of range")
+	c.Assert(state.Set("bootstate.last_chosen", "system2"), ErrorMatches, `cannot set bootstate.last_chosen: "system2" is not one of system0, system1`)
+	c.Assert(state.Set("mac", "foo"), ErrorMatches, `cannot set mac: invalid MAC address "foo"`)
+	c.Assert(state.Set("serial", "123456789"), ErrorMatches, "cannot set serial: value too long: 9 bytes, 8 available")
+	c.Assert(state.Set("foo", "1"), ErrorMatches, `no such variable: "foo"`)
+}
+
+func (s *stateTestSuite) TestOpenFallsBackToNextCopy(c *C) {
+	state, err := Create(s.stateFile, &s.layout)
+	c.Assert(err, IsNil)
+	c.Assert(state.Set("serial", "good"), IsNil)
+	c.Assert(state.Save(), IsNil)
+
+	f, err := os.OpenFile(s.stateFile, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	_, err = f.WriteAt([]byte{0xff}, headerSize+20)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	state, err = Open(s.stateFile, &s.layout)
+	c.Assert(err, IsNil)
+	c.Assert(state.Get("serial"), Equals, "good")
+}
+
+func (s *stateTestSuite) TestOpenNoValidCopy(c *C) {
+	c.Assert(ioutil.WriteFile(s.stateFile, make([]byte, 256), 0644), IsNil)
+	_, err := Open(s.stateFile, &s.layout)
+	c.Assert(errors.Is(err, ErrNoValidCopy), Equals, true)
+	c.Assert(err, ErrorMatches, "no valid copy of the barebox state: copy 0: bad magic: 0x00000000 != 0xcafe0001, .*")
+}
+
+func (s *stateTestSuite) TestInvalidLayout(c *C) {
+	s.layout.Variables[0].Size = 2
+	_, err := Create(s.stateFile, &s.layout)
+	c.Assert(err, ErrorMatches, `variable "bootstate.system0.remaining_attempts": invalid reg <0 2>`)
+
+	s.layout.Variables[0].Size = 4
+	s.layout.Stride = 16
+	_, err = Create(s.stateFile, &s.layout)
+	c.Assert(err, ErrorMatches, "backend stride 16 too small for 50 bytes of state")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// lockName is the lock file the U-Boot userspace tools share
+const lockName = "fw_printenv.lock"
+
+// errReported is returned by tools that printed their errors already
+var errReported = errors.New("failed")
+
+// run runs the tool selected by the program name or the first
+// argument and returns the exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	tool := filepath.Base(args[0])
+	args = args[1:]
+	if tool != "fw_printenv" && tool != "fw_setenv" && len(args) > 0 {
+		tool, args = "fw_"+args[0], args[1:]
+	}
+
+	var err error
+	switch tool {
+	case "fw_printenv":
+		err = printenv(args, stdout, stderr)
+	case "fw_setenv":
+		err = setenv(args, stdin, stderr)
+	default:
+		fmt.Fprintf(stderr, "usage: fwenv printenv|setenv [options] [name [value ...]]\n")
+		return 2
+	}
+	if err == flag.ErrHelp {
+		return 2
+	}
+	if err == errReported {
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "## Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// options are the flags both tools understand
+type options struct {
+	config  string
+	lockDir string
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.config, "c", uenv.DefaultConfigFile, "configuration file")
+	fs.StringVar(&o.lockDir, "l", "/var/lock", "directory of the lock file")
+}
+
+// open locks and opens the environment of the configuration
+func (o *options) open() (*uenv.Env, *uenv.Lock, error) {
+	lock, err := uenv.LockFile(filepath.Join(o.lockDir, lockName))
+	if err != nil {
+		return nil, nil, err
+	}
+	env, err := uenv.OpenConfigFile(o.config, uenv.Options{})
+	if err != nil {
+		lock.Unlock()
+		return nil, nil, err
+	}
+	return env, lock, nil
+}
+
+func printenv(args []string, stdout, stderr io.Writer) error {
+	var opts options
+	fs := flag.NewFlagSet("fw_printenv", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts.register(fs)
+	valueOnly := fs.Bool("n", false, "print the value of a single variable without its name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	names := fs.Args()
+	if *valueOnly && len(names) != 1 {
+		return fmt.Errorf("`-n' option requires exactly one argument")
+	}
+
+	env, lock, err := opts.open()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	defer env.Close()
+
+	if len(names) == 0 {
+		fmt.Fprint(stdout, env)
+		return nil
+	}
+	err = nil
+	for _, name := range names {
+		value := env.Get(name)
+		switch {
+		case value == "":
+			fmt.Fprintf(stderr, "## Error: %q not defined\n", name)
+			err = errReported
+		case *valueOnly:
+			fmt.Fprintln(stdout, value)
+		default:
+			fmt.Fprintf(stdout, "%s=%s\n", name, value)
+		}
+	}
+	return err
+}
+
+func setenv(args []string, stdin io.Reader, stderr io.Writer) error {
+	var opts options
+	fs := flag.NewFlagSet("fw_setenv", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts.register(fs)
+	script := fs.String("s", "", `file with "name value" lines to set, "-" for stdin`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var vars [][2]string
+	switch {
+	case *script != "":
+		if fs.NArg() > 0 {
+			return fmt.Errorf("variables cannot be given together with -s")
+		}
+		r := stdin
+		if *script != "-" {
+			f, err := os.Open(*script)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		var err error
+		if vars, err = readScript(r); err != nil {
+			return err
+		}
+	case fs.NArg() > 0:
+		// the values are joined like the shell arguments of setenv
+		vars = [][2]string{{fs.Arg(0), strings.Join(fs.Args()[1:], " ")}}
+	default:
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	for _, v := range vars {
+		if strings.ContainsRune(v[0], '=') {
+			return fmt.Errorf("illegal character '=' in variable name %q", v[0])
+		}
+	}
+
+	env, lock, err := opts.open()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	defer env.Close()
+
+	for _, v := range vars {
+		env.Set(v[0], v[1])
+	}
+	return env.Save()
+}
+
+// readScript reads the variables of a fw_setenv script: every line is
+// a name followed by whitespace and the value, a name without value
+// removes the variable. Empty lines and lines starting with "#" are
+// skipped.
+func readScript(r io.Reader) ([][2]string, error) {
+	var vars [][2]string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, uenv.MaxSize)
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, value := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			name, value = line[:i], strings.TrimLeft(line[i:], " \t")
+		}
+		vars = append(vars, [2]string{name, value})
+	}
+	return vars, scanner.Err()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type fwenvTestSuite struct {
+	envFile string
+	config  string
+	lockDir string
+}
+
+var _ = Suite(&fwenvTestSuite{})
+
+func (s *fwenvTestSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	s.envFile = filepath.Join(dir, "uboot.env")
+	s.config = filepath.Join(dir, "fw_env.config")
+	s.lockDir = c.MkDir()
+
+	env, err := uenv.Create(s.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("bootcmd", "run distro_bootcmd")
+	env.Set("bootdelay", "3")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(os.WriteFile(s.config, []byte(s.envFile+" 0x0 0x1000\n"), 0644), IsNil)
+}
+
+// run runs the tool with the options of the test environment
+func (s *fwenvTestSuite) run(stdin string, args ...string) (status int, stdout, stderr string) {
+	args = append([]string{args[0], "-c", s.config, "-l", s.lockDir}, args[1:]...)
+	var out, errOut bytes.Buffer
+	status = run(args, strings.NewReader(stdin), &out, &errOut)
+	return status, out.String(), errOut.String()
+}
+
+func (s *fwenvTestSuite) TestPrintenv(c *C) {
+	status, out, errOut := s.run("", "/usr/bin/fw_printenv")
+	c.Check(status, Equals, 0)
+	c.Check(out, Equals, "bootcmd=run distro_bootcmd\nbootdelay=3\n")
+	c.Check(errOut, Equals, "")
+
+	status, out, _ = s.run("", "fw_printenv", "bootdelay")
+	c.Check(status, Equals, 0)
+	c.Check(out, Equals, "bootdelay=3\n")
+
+	status, out, _ = s.run("", "fw_printenv", "-n", "bootcmd")
+	c.Check(status, Equals, 0)
+	c.Check(out, Equals, "run distro_bootcmd\n")
+}
+
+func (s *fwenvTestSuite) TestPrintenvErrors(c *C) {
+	status, out, errOut := s.run("", "fw_printenv", "bootdelay", "missing")
+	c.Check(status, Equals, 1)
+	c.Check(out, Equals, "bootdelay=3\n")
+	c.Check(errOut, Equals, "## Error: \"missing\" not defined\n")
+
+	status, _, errOut = s.run("", "fw_printenv", "-n", "bootcmd", "bootdelay")
+	c.Check(status, Equals, 1)
+	c.Check(errOut, Equals, "## Error: `-n' option requires exactly one argument\n")
+
+	var out2, errOut2 bytes.Buffer
+	status = run([]string{"fw_printenv", "-c", filepath.Join(c.MkDir(), "missing"), "-l", s.lockDir}, nil, &out2, &errOut2)
+	c.Check(status, Equals, 1)
+	c.Check(errOut2.String(), Matches, "## Error: open .*/missing: no such file or directory\n")
+}
+
+func (s *fwenvTestSuite) TestSetenv(c *C) {
+	status, _, errOut := s.run("", "fw_setenv", "bootargs", "console=ttyS0,115200", "quiet")
+	c.Check(status, Equals, 0)
+	c.Check(errOut, Equals, "")
+	// no value removes the variable
+	status, _, _ = s.run("", "fw_setenv", "bootdelay")
+	c.Check(status, Equals, 0)
+
+	env, err := uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "bootargs=console=ttyS0,115200 quiet\nbootcmd=run distro_bootcmd\n")
+
+	status, _, errOut = s.run("", "fw_setenv", "a=b", "c")
+	c.Check(status, Equals, 1)
+	c.Check(errOut, Equals, "## Error: illegal character '=' in variable name \"a=b\"\n")
+}
+
+func (s *fwenvTestSuite) TestSetenvScript(c *C) {
+	script := `# comment
+
+  bootdelay	0
+bootcmd
+ethaddr 00:11:22:33:44:55
+`
+	status, _, errOut := s.run(script, "fw_setenv", "-s", "-")
+	c.Check(status, Equals, 0)
+	c.Check(errOut, Equals, "")
+
+	env, err := uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "bootdelay=0\nethaddr=00:11:22:33:44:55\n")
+
+	fname := filepath.Join(c.MkDir(), "script")
+	c.Assert(os.WriteFile(fname, []byte("foo bar baz\n"), 0644), IsNil)
+	status, _, _ = s.run("", "fw_setenv", "-s", fname)
+	c.Check(status, Equals, 0)
+	env, err = uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar baz")
+}
+
+func (s *fwenvTestSuite) TestToolArgument(c *C) {
+	var out, errOut bytes.Buffer
+	status := run([]string{"fwenv", "printenv", "-c", s.config, "-l", s.lockDir, "bootdelay"}, nil, &out, &errOut)
+	c.Check(status, Equals, 0)
+	c.Check(out.String(), Equals, "bootdelay=3\n")
+
+	c.Check(run([]string{"fwenv"}, nil, &out, &errOut), Equals, 2)
+	c.Check(errOut.String(), Matches, "usage: .*\n")
+}
+
+// mkenvimage is the output of
+//
+//	printf 'bootcmd=run distro_bootcmd\nbootdelay=3\n' | mkenvimage -s 64 -o uboot.env -
+//
+// for a board without CONFIG_SYS_REDUNDAND_ENVIRONMENT, the CRC is
+// followed by the variables
+func mkenvimage(crc []byte, bootdelay string) []byte {
+	img := append(append([]byte(nil), crc...), "bootcmd=run distro_bootcmd\x00bootdelay="+bootdelay+"\x00\x00"...)
+	return append(img, bytes.Repeat([]byte{0xff}, 64-len(img))...)
+}
+
+func (s *fwenvTestSuite) TestMkenvimageRoundTrip(c *C) {
+	c.Assert(os.WriteFile(s.envFile, mkenvimage([]byte{0x2b, 0x5a, 0xde, 0x1e}, "3"), 0644), IsNil)
+	c.Assert(os.WriteFile(s.config, []byte(s.envFile+" 0x0 0x40\n"), 0644), IsNil)
+
+	status, out, errOut := s.run("", "fw_printenv")
+	c.Check(status, Equals, 0)
+	c.Check(out, Equals, "bootcmd=run distro_bootcmd\nbootdelay=3\n")
+	c.Check(errOut, Equals, "")
+
+	status, _, errOut = s.run("", "fw_setenv", "bootdelay", "5")
+	c.Check(status, Equals, 0)
+	c.Check(errOut, Equals, "")
+
+	// the image is still what mkenvimage writes
+	content, err := os.ReadFile(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(content, DeepEquals, mkenvimage([]byte{0x51, 0x28, 0x14, 0xf3}, "5"))
+}
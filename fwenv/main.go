@@ -0,0 +1,19 @@
+// Command fwenv prints and sets uboot environment variables like
+// fw_printenv and fw_setenv of the U-Boot userspace tools, so that a
+// single static binary can replace them:
+//
+//	ln -s fwenv /usr/bin/fw_printenv
+//	ln -s fwenv /usr/bin/fw_setenv
+//
+// Called by any other name the tool is given as first argument, e.g.
+// "fwenv printenv -n bootcmd". The environment is described by
+// /etc/fw_env.config unless another file is given with -c.
+package main
+
+import (
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args, os.Stdin, os.Stdout, os.Stderr))
+}
@@ -0,0 +1,67 @@
+// Package gadget creates the boot environment files of Ubuntu Core
+// gadget snaps that boot with U-Boot.
+//
+// Gadgets for Ubuntu Core 16 and 18 ship a ready made uboot.env that
+// snapd modifies in place. Gadgets for Ubuntu Core 20 and later ship an
+// empty uboot.conf as marker and snapd creates boot.sel itself; the
+// same format is used when a boot.sel needs to be prepared at image
+// build time.
+package gadget
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+const (
+	// UbootEnvName is the environment file of Ubuntu Core 16/18 gadgets
+	UbootEnvName = "uboot.env"
+	// UbootConfName is the marker file of Ubuntu Core 20+ gadgets
+	UbootConfName = "uboot.conf"
+	// BootSelName is the environment file snapd keeps on ubuntu-boot
+	BootSelName = "boot.sel"
+)
+
+const (
+	// UbootEnvSize is the size of uboot.env, it matches
+	// CONFIG_ENV_SIZE of the reference gadgets (mkenvimage -s 131072)
+	UbootEnvSize = 128 * 1024
+	// BootSelSize is the size snapd uses for boot.sel
+	BootSelSize = uenv.SnapdBootSelSize
+)
+
+func writeEnv(fname string, size int, vars map[string]string) error {
+	env, err := uenv.CreateWithOptions(fname, size, uenv.SnapdOptions())
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	for k, v := range vars {
+		env.Set(k, v)
+	}
+	return env.Save()
+}
+
+// WriteUbootEnv writes the uboot.env of an Ubuntu Core 16/18 gadget
+// with the given variables to dir
+func WriteUbootEnv(dir string, vars map[string]string) error {
+	return writeEnv(filepath.Join(dir, UbootEnvName), UbootEnvSize, vars)
+}
+
+// WriteBootSel writes a boot.sel with the given variables to dir
+func WriteBootSel(dir string, vars map[string]string) error {
+	return writeEnv(filepath.Join(dir, BootSelName), BootSelSize, vars)
+}
+
+// WriteUbootConf writes the empty uboot.conf that marks an Ubuntu
+// Core 20+ gadget as using U-Boot
+func WriteUbootConf(dir string) error {
+	f, err := os.Create(filepath.Join(dir, UbootConfName))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,61 @@
+package gadget
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type gadgetTestSuite struct {
+	dir string
+}
+
+var _ = Suite(&gadgetTestSuite{})
+
+func (s *gadgetTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *gadgetTestSuite) TestWriteUbootEnv(c *C) {
+	err := WriteUbootEnv(s.dir, map[string]string{
+		"snap_core":   "core_1.snap",
+		"snap_kernel": "pi-kernel_1.snap",
+		"snap_mode":   "",
+	})
+	c.Assert(err, IsNil)
+
+	st, err := os.Stat(filepath.Join(s.dir, "uboot.env"))
+	c.Assert(err, IsNil)
+	c.Assert(st.Size(), Equals, int64(131072))
+
+	env, err := uenv.Open(filepath.Join(s.dir, "uboot.env"))
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "snap_core=core_1.snap\nsnap_kernel=pi-kernel_1.snap\n")
+}
+
+func (s *gadgetTestSuite) TestWriteBootSel(c *C) {
+	err := WriteBootSel(s.dir, map[string]string{"kernel_status": "", "snap_kernel": "pi-kernel_1.snap"})
+	c.Assert(err, IsNil)
+
+	st, err := os.Stat(filepath.Join(s.dir, "boot.sel"))
+	c.Assert(err, IsNil)
+	c.Assert(st.Size(), Equals, int64(4096))
+
+	env, err := uenv.Open(filepath.Join(s.dir, "boot.sel"))
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("snap_kernel"), Equals, "pi-kernel_1.snap")
+}
+
+func (s *gadgetTestSuite) TestWriteUbootConf(c *C) {
+	c.Assert(WriteUbootConf(s.dir), IsNil)
+	st, err := os.Stat(filepath.Join(s.dir, "uboot.conf"))
+	c.Assert(err, IsNil)
+	c.Assert(st.Size(), Equals, int64(0))
+}
@@ -0,0 +1,186 @@
+// Package grubenv reads and writes GRUB environment blocks (grubenv)
+// with the same API the uenv package offers for U-Boot environments.
+package grubenv
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// header is the first line of every environment block
+const header = "# GRUB Environment Block\n"
+
+// DefaultSize is the size of the environment blocks grub-editenv
+// creates.
+const DefaultSize = 1024
+
+// Env contains the data of a GRUB environment block
+type Env struct {
+	fname string
+	size  int
+	data  map[string]string
+	// keys keeps the order of the variables like GRUB does
+	keys []string
+}
+
+// Create a new empty GRUB environment block file with the given size.
+// The file contains a valid empty block afterwards.
+func Create(fname string, size int) (*Env, error) {
+	if size < len(header) {
+		return nil, fmt.Errorf("invalid grubenv size %d: must be at least %d", size, len(header))
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	env := &Env{
+		fname: fname,
+		size:  size,
+		data:  make(map[string]string),
+	}
+	if err := env.Save(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// Open opens a existing GRUB environment block file
+func Open(fname string) (*Env, error) {
+	content, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(content, []byte(header)) {
+		return nil, fmt.Errorf("cannot open %s: invalid grubenv header", fname)
+	}
+
+	env := &Env{
+		fname: fname,
+		size:  len(content),
+		data:  make(map[string]string),
+	}
+	for _, line := range splitLines(content[len(header):]) {
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		l := strings.SplitN(unescape(line), "=", 2)
+		if len(l) != 2 || l[0] == "" {
+			return nil, fmt.Errorf("cannot parse line %q as key=value pair", line)
+		}
+		env.Set(l[0], l[1])
+	}
+
+	return env, nil
+}
+
+// splitLines splits the block into lines, a newline escaped with a
+// backslash does not end a line
+func splitLines(data []byte) []string {
+	var lines []string
+	start := 0
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '\n':
+			lines = append(lines, string(data[start:i]))
+			start = i + 1
+		}
+	}
+	if start < len(data) {
+		lines = append(lines, string(data[start:]))
+	}
+	return lines
+}
+
+// unescape removes the backslashes GRUB puts in front of backslashes
+// and newlines
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// escape escapes backslashes and newlines like GRUB does
+func escape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\\n").Replace(s)
+}
+
+func (env *Env) String() string {
+	out := ""
+	for _, k := range env.keys {
+		out += fmt.Sprintf("%s=%s\n", k, env.data[k])
+	}
+	return out
+}
+
+// Get the value of the environment variable
+func (env *Env) Get(name string) string {
+	return env.data[name]
+}
+
+// Set an environment name to the given value, if the value is empty
+// the variable will be removed from the environment
+func (env *Env) Set(name, value string) {
+	if name == "" {
+		panic(fmt.Sprintf("Set() can not be called with empty key for value: %q", value))
+	}
+	if value == "" {
+		if _, ok := env.data[name]; ok {
+			delete(env.data, name)
+			for i, k := range env.keys {
+				if k == name {
+					env.keys = append(env.keys[:i], env.keys[i+1:]...)
+					break
+				}
+			}
+		}
+		return
+	}
+	if _, ok := env.data[name]; !ok {
+		env.keys = append(env.keys, name)
+	}
+	env.data[name] = value
+}
+
+// Save will write out the environment block
+func (env *Env) Save() error {
+	w := bytes.NewBufferString(header)
+	for _, k := range env.keys {
+		fmt.Fprintf(w, "%s=%s\n", escape(k), escape(env.data[k]))
+	}
+	if w.Len() > env.size {
+		return fmt.Errorf("environment too big: %d bytes needed, %d available", w.Len(), env.size)
+	}
+	// pad the rest of the block with '#'
+	w.Write(bytes.Repeat([]byte{'#'}, env.size-w.Len()))
+
+	// GRUB writes the block in place through the blocklist of the
+	// file, so the file must never be reallocated: overwrite it in
+	// place and do not truncate it.
+	f, err := os.OpenFile(env.fname, os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(w.Bytes()); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
@@ -0,0 +1,100 @@
+package grubenv
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type grubenvTestSuite struct {
+	envFile string
+}
+
+var _ = Suite(&grubenvTestSuite{})
+
+func (g *grubenvTestSuite) SetUpTest(c *C) {
+	g.envFile = filepath.Join(c.MkDir(), "grubenv")
+}
+
+func (g *grubenvTestSuite) TestCreateWritesEmptyBlock(c *C) {
+	_, err := Create(g.envFile, DefaultSize)
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadFile(g.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, DefaultSize)
+	c.Assert(string(content[:len(header)]), Equals, "# GRUB Environment Block\n")
+	c.Assert(content[len(header):], DeepEquals, bytes.Repeat([]byte{'#'}, DefaultSize-len(header)))
+}
+
+func (g *grubenvTestSuite) TestSetSaveOpen(c *C) {
+	env, err := Create(g.envFile, DefaultSize)
+	c.Assert(err, IsNil)
+	env.Set("snap_mode", "try")
+	env.Set("kernel", "pc-kernel_1.snap")
+	env.Set("snap_mode", "trying")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := ioutil.ReadFile(g.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, DefaultSize)
+	c.Assert(string(content[:67]), Equals, "# GRUB Environment Block\nsnap_mode=trying\nkernel=pc-kernel_1.snap\n#")
+
+	env, err = Open(g.envFile)
+	c.Assert(err, IsNil)
+	// the order of the file is kept
+	c.Assert(env.String(), Equals, "snap_mode=trying\nkernel=pc-kernel_1.snap\n")
+	c.Assert(env.Get("kernel"), Equals, "pc-kernel_1.snap")
+	c.Assert(env.Get("no-such-key"), Equals, "")
+}
+
+func (g *grubenvTestSuite) TestSetEmptyUnsets(c *C) {
+	env, err := Create(g.envFile, DefaultSize)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	env.Set("b", "2")
+	env.Set("a", "")
+	c.Assert(env.String(), Equals, "b=2\n")
+}
+
+func (g *grubenvTestSuite) TestEscaping(c *C) {
+	env, err := Create(g.envFile, DefaultSize)
+	c.Assert(err, IsNil)
+	env.Set("multi", "line1\nline2\\")
+	env.Set("after", "x")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := ioutil.ReadFile(g.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.HasPrefix(content, []byte(header+"multi=line1\\\nline2\\\\\nafter=x\n#")), Equals, true)
+
+	env, err = Open(g.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("multi"), Equals, "line1\nline2\\")
+	c.Assert(env.Get("after"), Equals, "x")
+}
+
+func (g *grubenvTestSuite) TestOpenInvalidHeader(c *C) {
+	c.Assert(ioutil.WriteFile(g.envFile, []byte("foo=bar\n"), 0644), IsNil)
+	_, err := Open(g.envFile)
+	c.Assert(err, ErrorMatches, "cannot open .*/grubenv: invalid grubenv header")
+}
+
+func (g *grubenvTestSuite) TestOpenMalformed(c *C) {
+	c.Assert(ioutil.WriteFile(g.envFile, []byte(header+"foo\n####"), 0644), IsNil)
+	_, err := Open(g.envFile)
+	c.Assert(err, ErrorMatches, `cannot parse line "foo" as key=value pair`)
+}
+
+func (g *grubenvTestSuite) TestSaveTooBig(c *C) {
+	env, err := Create(g.envFile, 32)
+	c.Assert(err, IsNil)
+	env.Set("foo", "barbaz")
+	c.Assert(env.Save(), ErrorMatches, "environment too big: 36 bytes needed, 32 available")
+}
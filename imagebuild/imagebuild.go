@@ -0,0 +1,122 @@
+// Package imagebuild injects uboot environments into disk images for
+// image build pipelines.
+package imagebuild
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Layout describes where the environment is stored in an image
+type Layout struct {
+	// Size is the size of the environment in bytes
+	Size int `json:"size"`
+	// Offsets are the positions of the environment in the image, a
+	// second offset holds the redundant copy
+	Offsets []int64 `json:"offsets"`
+}
+
+// LoadLayout reads a layout from a JSON file like
+//
+//	{"size": 16384, "offsets": [4177920, 4194304]}
+func LoadLayout(fname string) (*Layout, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var layout Layout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return nil, fmt.Errorf("cannot read layout %s: %v", fname, err)
+	}
+	return &layout, nil
+}
+
+// LoadVars reads the variables to inject. Files ending in ".json"
+// hold a JSON object of strings, all others are key=value text files
+// as read by mkenvimage and Env.Import.
+func LoadVars(fname string) (map[string]string, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	if filepath.Ext(fname) == ".json" {
+		if err := json.Unmarshal(data, &vars); err != nil {
+			return nil, fmt.Errorf("cannot read variables %s: %v", fname, err)
+		}
+		return vars, nil
+	}
+	// the env is never saved, its size does not matter
+	env, err := uenv.NewEnv(4096)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.Import(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("cannot read variables %s: %v", fname, err)
+	}
+	env.Range(func(key, value string) bool {
+		vars[key] = value
+		return true
+	})
+	return vars, nil
+}
+
+func (l *Layout) config(image string) *uenv.Config {
+	cfg := &uenv.Config{Size: l.Size}
+	for _, off := range l.Offsets {
+		cfg.Devices = append(cfg.Devices, uenv.DeviceConfig{Path: image, Offset: off})
+	}
+	return cfg
+}
+
+// Inject makes the environment in the image contain exactly the given
+// variables and returns the changes that were made. A new environment
+// is created if the image has none yet, i.e. the region is erased or
+// zeroed, while a corrupted one is an error. Running Inject again with
+// the same variables changes nothing and does not write.
+func Inject(image string, layout *Layout, vars map[string]string) ([]uenv.Change, error) {
+	cfg := layout.config(image)
+	old, err := uenv.OpenFromConfig(cfg, uenv.Options{})
+	var uninitErr *uenv.UninitializedError
+	if errors.As(err, &uninitErr) || errors.Is(err, fs.ErrNotExist) {
+		old, err = uenv.CreateFromConfig(cfg, uenv.Options{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer old.Close()
+
+	env, err := uenv.OpenFromConfig(cfg, uenv.Options{})
+	if err != nil {
+		return nil, err
+	}
+	defer env.Close()
+	env.Range(func(key, value string) bool {
+		if _, ok := vars[key]; !ok {
+			env.Set(key, "")
+		}
+		return true
+	})
+	for k, v := range vars {
+		if k == "" {
+			return nil, fmt.Errorf("cannot inject variable with empty name")
+		}
+		env.Set(k, v)
+	}
+
+	changes := uenv.Diff(old, env)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err := env.Save(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,172 @@
+package imagebuild
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type imagebuildTestSuite struct {
+	dir   string
+	image string
+}
+
+var _ = Suite(&imagebuildTestSuite{})
+
+func (s *imagebuildTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.image = filepath.Join(s.dir, "disk.img")
+	// the environment regions of the layouts below are erased
+	disk := bytes.Repeat([]byte{0xaa}, 8192)
+	copy(disk[2048:3072], bytes.Repeat([]byte{0xff}, 1024))
+	copy(disk[4096:5120], bytes.Repeat([]byte{0xff}, 1024))
+	c.Assert(os.WriteFile(s.image, disk, 0644), IsNil)
+}
+
+func (s *imagebuildTestSuite) TestInjectIdempotent(c *C) {
+	layout := &Layout{Size: 1024, Offsets: []int64{2048, 4096}}
+	vars := map[string]string{"bootcmd": "run distro_bootcmd", "bootdelay": "0"}
+
+	changes, err := Inject(s.image, layout, vars)
+	c.Assert(err, IsNil)
+	c.Assert(changes, DeepEquals, []uenv.Change{
+		{Name: "bootcmd", NewValue: "run distro_bootcmd"},
+		{Name: "bootdelay", NewValue: "0"},
+	})
+	st, err := os.Stat(s.image)
+	c.Assert(err, IsNil)
+	mtime := st.ModTime()
+
+	// the second run changes nothing
+	changes, err = Inject(s.image, layout, vars)
+	c.Assert(err, IsNil)
+	c.Assert(changes, HasLen, 0)
+	st, err = os.Stat(s.image)
+	c.Assert(err, IsNil)
+	c.Assert(st.ModTime(), Equals, mtime)
+
+	// variables not in the input are removed
+	changes, err = Inject(s.image, layout, map[string]string{"bootdelay": "3"})
+	c.Assert(err, IsNil)
+	c.Assert(changes, DeepEquals, []uenv.Change{
+		{Name: "bootcmd", OldValue: "run distro_bootcmd"},
+		{Name: "bootdelay", OldValue: "0", NewValue: "3"},
+	})
+
+	// the rest of the image is untouched
+	content, err := os.ReadFile(s.image)
+	c.Assert(err, IsNil)
+	c.Assert(content[:2048], DeepEquals, bytes.Repeat([]byte{0xaa}, 2048))
+	c.Assert(content[3072:4096], DeepEquals, bytes.Repeat([]byte{0xaa}, 1024))
+	c.Assert(content[5120:], DeepEquals, bytes.Repeat([]byte{0xaa}, 8192-5120))
+}
+
+func (s *imagebuildTestSuite) TestInjectCorrupted(c *C) {
+	layout := &Layout{Size: 1024, Offsets: []int64{2048, 4096}}
+	_, err := Inject(s.image, layout, map[string]string{"bootdelay": "0"})
+	c.Assert(err, IsNil)
+
+	// a corrupted environment is not silently replaced
+	f, err := os.OpenFile(s.image, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	for _, off := range layout.Offsets {
+		_, err = f.WriteAt([]byte("x"), off+10)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(f.Close(), IsNil)
+	before, err := os.ReadFile(s.image)
+	c.Assert(err, IsNil)
+
+	_, err = Inject(s.image, layout, map[string]string{"bootdelay": "3"})
+	c.Assert(err, ErrorMatches, "no valid copy of the environment: copy 0: bad CRC: .*")
+	after, err := os.ReadFile(s.image)
+	c.Assert(err, IsNil)
+	c.Assert(after, DeepEquals, before)
+
+	// neither is a layout that points outside of the image
+	_, err = Inject(s.image, &Layout{Size: 1024, Offsets: []int64{16384}}, map[string]string{"bootdelay": "3"})
+	c.Assert(err, ErrorMatches, "cannot read 1024 bytes at offset 16384 of .*/disk.img: EOF")
+}
+
+func (s *imagebuildTestSuite) TestLoadLayoutAndVars(c *C) {
+	layoutFile := filepath.Join(s.dir, "layout.json")
+	c.Assert(os.WriteFile(layoutFile, []byte(`{"size": 16384, "offsets": [4177920, 4194304]}`), 0644), IsNil)
+	layout, err := LoadLayout(layoutFile)
+	c.Assert(err, IsNil)
+	c.Assert(layout, DeepEquals, &Layout{Size: 16384, Offsets: []int64{4177920, 4194304}})
+
+	jsonFile := filepath.Join(s.dir, "env.json")
+	c.Assert(os.WriteFile(jsonFile, []byte(`{"a": "1", "b": "x=y"}`), 0644), IsNil)
+	vars, err := LoadVars(jsonFile)
+	c.Assert(err, IsNil)
+	c.Assert(vars, DeepEquals, map[string]string{"a": "1", "b": "x=y"})
+
+	txtFile := filepath.Join(s.dir, "env.txt")
+	c.Assert(os.WriteFile(txtFile, []byte("# comment\na=1\nb=x=y\n"), 0644), IsNil)
+	vars, err = LoadVars(txtFile)
+	c.Assert(err, IsNil)
+	c.Assert(vars, DeepEquals, map[string]string{"a": "1", "b": "x=y"})
+
+	// continuation lines like mkenvimage reads them
+	c.Assert(os.WriteFile(txtFile, []byte("a=1\\\n2\n"), 0644), IsNil)
+	vars, err = LoadVars(txtFile)
+	c.Assert(err, IsNil)
+	c.Assert(vars, DeepEquals, map[string]string{"a": "1\n2"})
+
+	c.Assert(os.WriteFile(txtFile, []byte("novalue\n"), 0644), IsNil)
+	_, err = LoadVars(txtFile)
+	c.Assert(err, ErrorMatches, `cannot read variables .*/env.txt: Invalid line: "novalue"`)
+}
+
+func (s *imagebuildTestSuite) TestManifest(c *C) {
+	layout := &Layout{Size: 1024, Offsets: []int64{2048, 4096}}
+	_, err := Inject(s.image, layout, map[string]string{"bootcmd": "run distro_bootcmd", "ver": "2024.04"})
+	c.Assert(err, IsNil)
+
+	m, err := NewManifest(s.image, layout, []string{"ver", "unset"})
+	c.Assert(err, IsNil)
+	content, err := os.ReadFile(s.image)
+	c.Assert(err, IsNil)
+	c.Check(m.File, Equals, "disk.img")
+	c.Check(m.Layout, Equals, layout)
+	c.Check(m.Size, Equals, int64(8192))
+	c.Check(m.SHA256, Equals, fmt.Sprintf("%x", sha256.Sum256(content)))
+	c.Check(m.CRCs, DeepEquals, []string{
+		fmt.Sprintf("%08x", binary.LittleEndian.Uint32(content[2048:])),
+		fmt.Sprintf("%08x", binary.LittleEndian.Uint32(content[4096:])),
+	})
+	c.Check(m.Variables, Equals, 2)
+	c.Check(m.KeyVariables, DeepEquals, map[string]string{"ver": "2024.04"})
+	c.Check(m.Verify(s.image), IsNil)
+
+	_, err = Inject(s.image, layout, map[string]string{"ver": "2024.07"})
+	c.Assert(err, IsNil)
+	c.Check(m.Verify(s.image), ErrorMatches, ".*/disk.img has SHA-256 [0-9a-f]+, the manifest expects [0-9a-f]+")
+}
+
+func (s *imagebuildTestSuite) TestManifestPlainFile(c *C) {
+	envFile := filepath.Join(s.dir, "uboot.env")
+	env, err := uenv.Create(envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("bootdelay", "3")
+	c.Assert(env.Save(), IsNil)
+
+	m, err := NewManifest(envFile, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(m.Layout, IsNil)
+	c.Check(m.Size, Equals, int64(4096))
+	c.Check(m.CRCs, HasLen, 1)
+	c.Check(m.Variables, Equals, 1)
+	c.Check(m.KeyVariables, IsNil)
+}
@@ -0,0 +1,110 @@
+package imagebuild
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Manifest describes an environment image produced by a build, for
+// the metadata of a release.
+type Manifest struct {
+	// File is the base name of the image
+	File string `json:"file"`
+	// Layout is where the environment is stored in the image, nil
+	// if the image is a plain environment file
+	Layout *Layout `json:"layout,omitempty"`
+	// Size is the size of the image in bytes
+	Size int64 `json:"size"`
+	// SHA256 is the hex encoded SHA-256 of the whole image
+	SHA256 string `json:"sha256"`
+	// CRCs are the CRCs in the headers of the environment copies,
+	// hex encoded like printed by U-Boot
+	CRCs []string `json:"crcs"`
+	// Variables is the number of variables in the environment
+	Variables int `json:"variables"`
+	// KeyVariables are the values of the variables that were asked
+	// for, e.g. a version, unset variables are left out
+	KeyVariables map[string]string `json:"key_variables,omitempty"`
+}
+
+// NewManifest returns the manifest of the image with the values of the
+// given key variables. The layout is nil for plain environment files.
+func NewManifest(image string, layout *Layout, keys []string) (*Manifest, error) {
+	f, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		File:   filepath.Base(image),
+		Layout: layout,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}
+
+	offsets := []int64{0}
+	var env *uenv.Env
+	if layout != nil {
+		offsets = layout.Offsets
+		env, err = uenv.OpenFromConfig(layout.config(image), uenv.Options{})
+	} else {
+		env, err = uenv.Open(image)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer env.Close()
+
+	for _, off := range offsets {
+		var header [4]byte
+		if _, err := f.ReadAt(header[:], off); err != nil {
+			return nil, fmt.Errorf("cannot read environment header at offset %d of %s: %v", off, image, err)
+		}
+		m.CRCs = append(m.CRCs, fmt.Sprintf("%08x", binary.LittleEndian.Uint32(header[:])))
+	}
+	env.Range(func(key, value string) bool {
+		m.Variables++
+		return true
+	})
+	for _, key := range keys {
+		if value := env.Get(key); value != "" {
+			if m.KeyVariables == nil {
+				m.KeyVariables = make(map[string]string)
+			}
+			m.KeyVariables[key] = value
+		}
+	}
+	return m, nil
+}
+
+// Verify checks that the image is the one described by the manifest.
+func (m *Manifest) Verify(image string) error {
+	keys := make([]string, 0, len(m.KeyVariables))
+	for key := range m.KeyVariables {
+		keys = append(keys, key)
+	}
+	actual, err := NewManifest(image, m.Layout, keys)
+	if err != nil {
+		return err
+	}
+	switch {
+	case actual.Size != m.Size:
+		return fmt.Errorf("%s has size %d, the manifest expects %d", image, actual.Size, m.Size)
+	case actual.SHA256 != m.SHA256:
+		return fmt.Errorf("%s has SHA-256 %s, the manifest expects %s", image, actual.SHA256, m.SHA256)
+	}
+	return nil
+}
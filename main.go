@@ -1,24 +1,114 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mvo5/uboot-go/imagebuild"
 	"github.com/mvo5/uboot-go/uenv"
+	"github.com/mvo5/uboot-go/uenv/yamlconfig"
+	"github.com/mvo5/uboot-go/uenvaudit"
+	"github.com/mvo5/uboot-go/uenvexporter"
+	"github.com/mvo5/uboot-go/uenvmonitor"
+	"github.com/mvo5/uboot-go/uenvnetboot"
+	"github.com/mvo5/uboot-go/uenvsign"
+	"github.com/mvo5/uboot-go/uenvwriter"
 )
 
+// boardPrefix selects the environment of a board profile instead of a
+// file, e.g. "board:imx8mm-evk". Without a name the board is detected
+// from its device tree.
+const boardPrefix = "board:"
+
+// auditSink returns the audit sink named by $UBOOT_GO_AUDIT, "syslog"
+// or "journald", or nil
+func auditSink() (uenv.AuditSink, error) {
+	switch name := os.Getenv("UBOOT_GO_AUDIT"); name {
+	case "":
+		return nil, nil
+	case "syslog":
+		return uenvaudit.NewSyslog("uboot-go")
+	case "journald":
+		return uenvaudit.NewJournald("uboot-go")
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q", name)
+	}
+}
+
+// configPrefix selects the environment described by a fw_env.config
+// file, e.g. "config:/etc/fw_env.config"
+const configPrefix = "config:"
+
+// openEnv opens the environment file, board profile or the environment
+// of a fw_env.config file. Additional profiles are loaded from the file
+// named by $UBOOT_GO_BOARDS, $UBOOT_GO_CHECKSUM selects the checksum,
+// e.g. "crc32-be", and $UBOOT_GO_FLAGS the flag scheme of redundant
+// environments, e.g. "boolean".
+func openEnv(envFile string) (*uenv.Env, error) {
+	audit, err := auditSink()
+	if err != nil {
+		return nil, err
+	}
+	// failures to write the audit records do not fail saves but
+	// are logged as warnings
+	opts := uenv.Options{
+		Audit:  audit,
+		Logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	}
+	if name := os.Getenv("UBOOT_GO_CHECKSUM"); name != "" {
+		if err := opts.Checksum.UnmarshalText([]byte(name)); err != nil {
+			return nil, err
+		}
+	}
+	if name := os.Getenv("UBOOT_GO_FLAGS"); name != "" {
+		if err := opts.FlagScheme.UnmarshalText([]byte(name)); err != nil {
+			return nil, err
+		}
+	}
+	if strings.HasPrefix(envFile, configPrefix) {
+		return uenv.OpenConfigFile(strings.TrimPrefix(envFile, configPrefix), opts)
+	}
+	if !strings.HasPrefix(envFile, boardPrefix) {
+		return uenv.OpenWithOptions(envFile, opts)
+	}
+	if fname := os.Getenv("UBOOT_GO_BOARDS"); fname != "" {
+		if err := yamlconfig.LoadBoardProfiles(fname); err != nil {
+			return nil, err
+		}
+	}
+	if envFile == boardPrefix {
+		return uenv.OpenDetectedBoard(opts)
+	}
+	return uenv.OpenBoard(strings.TrimPrefix(envFile, boardPrefix), opts)
+}
+
 func main() {
 	// FIXME: argsparse ftw!
+	if len(os.Args) == 2 {
+		// only a command, use the configuration of fw_printenv or
+		// the environment of the board
+		where := boardPrefix
+		if _, err := os.Stat(uenv.DefaultConfigFile); err == nil {
+			where = configPrefix + uenv.DefaultConfigFile
+		}
+		os.Args = []string{os.Args[0], where, os.Args[1]}
+	}
 	envFile := os.Args[1]
 	cmd := os.Args[2]
 
 	switch cmd {
 	case "print":
-		env, err := uenv.Open(envFile)
+		env, err := openEnv(envFile)
 		if err != nil {
-			log.Fatalf("uenv.Open failed for %s: %s", envFile, err)
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
 		}
 		fmt.Print(env)
 	case "create":
@@ -26,18 +116,29 @@ func main() {
 		if err != nil {
 			log.Fatalf("Atoi failed for %s: %s", envFile, err)
 		}
-		env, err := uenv.Create(envFile, size)
-		if err != nil {
+		if _, err := uenv.Create(envFile, size); err != nil {
 			log.Fatalf("uenv.Create failed for %s: %s", envFile, err)
 		}
+
+	case "set-header":
+		var layout uenv.HeaderLayout
+		if err := layout.UnmarshalText([]byte(os.Args[3])); err != nil {
+			log.Fatalf("%s", err)
+		}
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		if err := env.SetHeader(layout); err != nil {
+			log.Fatalf("cannot change header of %s: %s", envFile, err)
+		}
 		if err := env.Save(); err != nil {
-			log.Fatalf("env.Save failed: %s", err)
+			log.Fatalf("env.Save failed for %s: %s", envFile, err)
 		}
-
 	case "set":
-		env, err := uenv.Open(envFile)
+		env, err := openEnv(envFile)
 		if err != nil {
-			log.Fatalf("uenv.Open failed for %s: %s", envFile, err)
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
 		}
 		name := os.Args[3]
 		value := os.Args[4]
@@ -46,21 +147,344 @@ func main() {
 			log.Fatalf("env.Save failed for %s: %s", envFile, err)
 		}
 	case "import":
-		env, err := uenv.Open(envFile)
+		env, err := openEnv(envFile)
 		if err != nil {
-			log.Fatalf("uenv.Open failed for %s: %s", envFile, err)
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
 		}
 		fname := os.Args[3]
 		r, err := os.Open(fname)
 		if err != nil {
 			log.Fatalf("Open failed for %s: %s", fname, err)
 		}
-		if err := env.Import(r); err != nil {
+		if len(os.Args) > 4 {
+			// the values are templates filled from a JSON file
+			content, err := os.ReadFile(os.Args[4])
+			if err != nil {
+				log.Fatalf("ReadFile failed for %s: %s", os.Args[4], err)
+			}
+			var data map[string]interface{}
+			if err := json.Unmarshal(content, &data); err != nil {
+				log.Fatalf("cannot parse %s: %s", os.Args[4], err)
+			}
+			if err := env.ImportTemplate(r, data); err != nil {
+				log.Fatalf("env.ImportTemplate failed for %s: %s", envFile, err)
+			}
+		} else if err := env.Import(r); err != nil {
 			log.Fatalf("env.Import failed for %s: %s", envFile, err)
 		}
 		if err := env.Save(); err != nil {
 			log.Fatalf("env.Save failed for %s: %s", envFile, err)
 		}
+	case "import-text":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		profile, err := uenv.LookupTextProfile(os.Args[3])
+		if err != nil {
+			log.Fatalf("uenv.LookupTextProfile failed: %s", err)
+		}
+		r, err := os.Open(os.Args[4])
+		if err != nil {
+			log.Fatalf("Open failed for %s: %s", os.Args[4], err)
+		}
+		if err := env.ImportWithProfile(r, profile); err != nil {
+			log.Fatalf("env.ImportWithProfile failed for %s: %s", os.Args[4], err)
+		}
+		if err := env.Save(); err != nil {
+			log.Fatalf("env.Save failed for %s: %s", envFile, err)
+		}
+	case "import-console":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		r, err := os.Open(os.Args[3])
+		if err != nil {
+			log.Fatalf("Open failed for %s: %s", os.Args[3], err)
+		}
+		if err := env.ImportConsoleLog(r, uenv.ConsoleOptions{}); err != nil {
+			log.Fatalf("env.ImportConsoleLog failed for %s: %s", os.Args[3], err)
+		}
+		if err := env.Save(); err != nil {
+			log.Fatalf("env.Save failed for %s: %s", envFile, err)
+		}
+	case "import-signed":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		verifier, err := uenvsign.LoadVerifier(os.Args[3])
+		if err != nil {
+			log.Fatalf("uenvsign.LoadVerifier failed: %s", err)
+		}
+		if err := uenvsign.ImportVerified(env, os.Args[4], verifier); err != nil {
+			log.Fatalf("uenvsign.ImportVerified failed for %s: %s", os.Args[4], err)
+		}
+		if err := env.Save(); err != nil {
+			log.Fatalf("env.Save failed for %s: %s", envFile, err)
+		}
+	case "sign":
+		signer, err := uenvsign.LoadSigner(os.Args[3], []byte(os.Getenv("UBOOT_GO_KEY_PASSWORD")))
+		if err != nil {
+			log.Fatalf("uenvsign.LoadSigner failed: %s", err)
+		}
+		if _, err := uenvsign.SignFile(envFile, signer); err != nil {
+			log.Fatalf("uenvsign.SignFile failed for %s: %s", envFile, err)
+		}
+	case "verify":
+		verifier, err := uenvsign.LoadVerifier(os.Args[3])
+		if err != nil {
+			log.Fatalf("uenvsign.LoadVerifier failed: %s", err)
+		}
+		if _, err := uenvsign.ReadVerifiedFile(envFile, verifier); err != nil {
+			log.Fatalf("uenvsign.ReadVerifiedFile failed for %s: %s", envFile, err)
+		}
+	case "seed":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		dir := uenv.DefaultSeedDir
+		if len(os.Args) > 3 {
+			dir = os.Args[3]
+		}
+		if _, err := env.Seed(dir, uenv.DefaultSeedMarker); err != nil {
+			log.Fatalf("env.Seed failed for %s: %s", envFile, err)
+		}
+	case "inject":
+		layout, err := imagebuild.LoadLayout(os.Args[3])
+		if err != nil {
+			log.Fatalf("imagebuild.LoadLayout failed: %s", err)
+		}
+		vars, err := imagebuild.LoadVars(os.Args[4])
+		if err != nil {
+			log.Fatalf("imagebuild.LoadVars failed: %s", err)
+		}
+		changes, err := imagebuild.Inject(envFile, layout, vars)
+		if err != nil {
+			log.Fatalf("imagebuild.Inject failed for %s: %s", envFile, err)
+		}
+		for _, c := range changes {
+			if c.OldValue != "" {
+				fmt.Printf("-%s=%s\n", c.Name, c.OldValue)
+			}
+			if c.NewValue != "" {
+				fmt.Printf("+%s=%s\n", c.Name, c.NewValue)
+			}
+		}
+	case "manifest":
+		var layout *imagebuild.Layout
+		keys := os.Args[3:]
+		if len(keys) > 0 && strings.HasSuffix(keys[0], ".json") {
+			l, err := imagebuild.LoadLayout(keys[0])
+			if err != nil {
+				log.Fatalf("imagebuild.LoadLayout failed: %s", err)
+			}
+			layout, keys = l, keys[1:]
+		}
+		m, err := imagebuild.NewManifest(envFile, layout, keys)
+		if err != nil {
+			log.Fatalf("imagebuild.NewManifest failed for %s: %s", envFile, err)
+		}
+		out, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			log.Fatalf("json.MarshalIndent failed for %s: %s", envFile, err)
+		}
+		fmt.Println(string(out))
+	case "patch":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		newEnv, err := openEnv(os.Args[3])
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", os.Args[3], err)
+		}
+		text, err := uenv.DiffPatch(env, newEnv).MarshalText()
+		if err != nil {
+			log.Fatalf("patch.MarshalText failed for %s: %s", envFile, err)
+		}
+		os.Stdout.Write(text)
+	case "apply":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		text, err := os.ReadFile(os.Args[3])
+		if err != nil {
+			log.Fatalf("ReadFile failed for %s: %s", os.Args[3], err)
+		}
+		patch, err := uenv.ParsePatch(text)
+		if err != nil {
+			log.Fatalf("uenv.ParsePatch failed for %s: %s", os.Args[3], err)
+		}
+		if err := env.Apply(patch); err != nil {
+			log.Fatalf("env.Apply failed for %s: %s", envFile, err)
+		}
+		if err := env.Save(); err != nil {
+			log.Fatalf("env.Save failed for %s: %s", envFile, err)
+		}
+	case "recommend-size":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		var align int64
+		if len(os.Args) > 3 {
+			align, err = strconv.ParseInt(os.Args[3], 0, 0)
+			if err != nil {
+				log.Fatalf("ParseInt failed for %s: %s", os.Args[3], err)
+			}
+		}
+		fmt.Printf("0x%x\n", uenv.RecommendSize(env, int(align)))
+	case "backup":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		if err := env.WriteBackup(os.Args[3]); err != nil {
+			log.Fatalf("env.WriteBackup failed for %s: %s", os.Args[3], err)
+		}
+	case "script":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		command := ""
+		if len(os.Args) > 3 {
+			command = os.Args[3]
+		}
+		if err := env.WriteScript(os.Stdout, command); err != nil {
+			log.Fatalf("env.WriteScript failed for %s: %s", envFile, err)
+		}
+	case "export":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		format := uenv.ExportText
+		if len(os.Args) > 3 {
+			format = uenv.ExportFormat(os.Args[3])
+		}
+		if err := env.Export(os.Stdout, format); err != nil {
+			log.Fatalf("env.Export failed for %s: %s", envFile, err)
+		}
+	case "validate":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		schema, err := yamlconfig.LoadSchema(os.Args[3])
+		if err != nil {
+			log.Fatalf("yamlconfig.LoadSchema failed: %s", err)
+		}
+		violations, err := env.CheckSchema(schema)
+		if err != nil {
+			log.Fatalf("env.CheckSchema failed for %s: %s", envFile, err)
+		}
+		for _, v := range violations {
+			fmt.Println(v)
+		}
+		if len(violations) > 0 {
+			os.Exit(1)
+		}
+	case "netboot-check":
+		env, err := openEnv(envFile)
+		if err != nil {
+			log.Fatalf("openEnv failed for %s: %s", envFile, err)
+		}
+		checks, err := uenvnetboot.CheckEnv(env, uenvnetboot.Options{}, os.Args[3:]...)
+		if err != nil {
+			log.Fatalf("uenvnetboot.CheckEnv failed for %s: %s", envFile, err)
+		}
+		failed := false
+		for _, c := range checks {
+			switch {
+			case c.Err != nil:
+				fmt.Printf("%s %s: %s\n", c.Variable, c.File, c.Err)
+				failed = true
+			case c.Size >= 0:
+				fmt.Printf("%s %s: ok (%d bytes)\n", c.Variable, c.File, c.Size)
+			default:
+				fmt.Printf("%s %s: ok\n", c.Variable, c.File)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "scan":
+		var opts uenv.ScanOptions
+		if len(os.Args) > 3 {
+			n, err := strconv.Atoi(os.Args[3])
+			if err != nil {
+				log.Fatalf("Atoi failed for %s: %s", os.Args[3], err)
+			}
+			opts.Parallelism = n
+		}
+		results, err := uenv.ScanImage(envFile, opts)
+		if err != nil {
+			log.Fatalf("uenv.ScanImage failed for %s: %s", envFile, err)
+		}
+		for _, r := range results {
+			if r.Redundant {
+				fmt.Printf("offset 0x%x size 0x%x redundant\n", r.Offset, r.Size)
+			} else {
+				fmt.Printf("offset 0x%x size 0x%x\n", r.Offset, r.Size)
+			}
+		}
+	case "exporter":
+		addr := ":9813"
+		if len(os.Args) > 3 {
+			addr = os.Args[3]
+		}
+		http.Handle("/metrics", uenvexporter.New(func() (*uenv.Env, error) {
+			return openEnv(envFile)
+		}))
+		log.Fatal(http.ListenAndServe(addr, nil))
+	case "serve-writer":
+		l, err := uenvwriter.Listen(os.Args[3])
+		if err != nil {
+			log.Fatalf("uenvwriter.Listen failed for %s: %s", os.Args[3], err)
+		}
+		h := uenvwriter.NewHelper(func() (*uenv.Env, error) {
+			return openEnv(envFile)
+		})
+		h.Logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+		log.Fatal(h.Serve(l))
+	case "push":
+		img, err := os.ReadFile(envFile)
+		if err != nil {
+			log.Fatalf("ReadFile failed for %s: %s", envFile, err)
+		}
+		if err := uenvwriter.WriteImage(os.Args[3], img); err != nil {
+			log.Fatalf("uenvwriter.WriteImage failed for %s: %s", envFile, err)
+		}
+	case "monitor":
+		interval, err := time.ParseDuration(os.Args[3])
+		if err != nil {
+			log.Fatalf("ParseDuration failed for %s: %s", os.Args[3], err)
+		}
+		m := uenvmonitor.New(func() (*uenv.Env, error) {
+			return openEnv(envFile)
+		})
+		m.Logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+		m.Repair = len(os.Args) > 4 && os.Args[4] == "repair"
+		if interval == 0 {
+			// check once, the exit status tells the result
+			if !m.Check().Healthy() {
+				os.Exit(1)
+			}
+			return
+		}
+		m.Interval = interval
+		if len(os.Args) > 5 {
+			http.Handle("/metrics", m)
+			go func() {
+				log.Fatal(http.ListenAndServe(os.Args[5], nil))
+			}()
+		}
+		m.Run(context.Background())
 	default:
 		log.Fatalf("unknown command %s", cmd)
 	}
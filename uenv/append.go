@@ -0,0 +1,47 @@
+package uenv
+
+import (
+	"strings"
+)
+
+// AppendValue appends suffix to the value of the variable, separated
+// by sep, e.g. a parameter to bootargs or a target to boot_targets.
+// Nothing is changed if the value already contains suffix as one of
+// its sep separated elements or if suffix is empty. An empty sep
+// means a space. It returns true if the value was changed.
+func (env *Env) AppendValue(name, suffix, sep string) bool {
+	if suffix == "" {
+		return false
+	}
+	if sep == "" {
+		sep = " "
+	}
+	value := env.Get(name)
+	if value == "" {
+		env.Set(name, suffix)
+		return true
+	}
+	for _, elem := range strings.Split(value, sep) {
+		if elem == suffix {
+			return false
+		}
+	}
+	env.Set(name, value+sep+suffix)
+	return true
+}
+
+// AppendVariable locks the environment file like Update and appends
+// suffix to the stored value of the variable with AppendValue, so
+// that appends of concurrent agents are not lost. It returns true if
+// the value was changed.
+func AppendVariable(fname string, opts Options, name, suffix, sep string) (bool, error) {
+	changed := false
+	err := UpdateWithOptions(fname, opts, func(env *Env) error {
+		changed = env.AppendValue(name, suffix, sep)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return changed, nil
+}
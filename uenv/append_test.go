@@ -0,0 +1,50 @@
+package uenv
+
+import (
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestAppendValue(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	c.Check(env.AppendValue("bootargs", "console=ttyS0", ""), Equals, true)
+	c.Check(env.AppendValue("bootargs", "quiet", ""), Equals, true)
+	c.Check(env.AppendValue("bootargs", "quiet", " "), Equals, false)
+	c.Check(env.Get("bootargs"), Equals, "console=ttyS0 quiet")
+
+	env.Set("boot_targets", "mmc0,usb0")
+	c.Check(env.AppendValue("boot_targets", "pxe", ","), Equals, true)
+	c.Check(env.AppendValue("boot_targets", "usb0", ","), Equals, false)
+	c.Check(env.Get("boot_targets"), Equals, "mmc0,usb0,pxe")
+
+	c.Check(env.AppendValue("empty", "", ","), Equals, false)
+	c.Check(env.AppendValue("boot_targets", "", ","), Equals, false)
+	c.Check(env.AppendValue("bootargs", "", ""), Equals, false)
+	c.Check(env.String(), Equals, "boot_targets=mmc0,usb0,pxe\nbootargs=console=ttyS0 quiet\n")
+}
+
+func (u *uenvTestSuite) TestAppendVariable(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+
+	// no append of the concurrent agents is lost
+	var wg sync.WaitGroup
+	for _, target := range []string{"mmc0", "mmc1", "usb0", "pxe", "mmc0"} {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			_, err := AppendVariable(u.envFile, Options{}, "boot_targets", target, ",")
+			c.Check(err, IsNil)
+		}(target)
+	}
+	wg.Wait()
+
+	env, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("boot_targets"), HasLen, len("mmc0,mmc1,usb0,pxe"))
+	changed, err := AppendVariable(u.envFile, Options{}, "boot_targets", "pxe", ",")
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, false)
+}
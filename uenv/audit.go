@@ -0,0 +1,99 @@
+package uenv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AuditRecord describes the change of a variable by a save, e.g. for
+// the traceability requirements of regulated devices. The values are
+// not recorded, only hashes of them.
+type AuditRecord struct {
+	Time     time.Time
+	Variable string
+	// OldHash and NewHash are the hex encoded SHA-256 of the stored
+	// values, empty if the variable was not set before or after the
+	// save.
+	OldHash string
+	NewHash string
+	// Program, UID and PID identify the process that saved.
+	Program string
+	UID     int
+	PID     int
+	// Target describes where the environment was saved, e.g.
+	// "/dev/mmcblk0@0x3fc000".
+	Target string
+}
+
+// AuditSink records the changes of every save, see Options.Audit. The
+// package uenvaudit has sinks for syslog and journald.
+type AuditSink interface {
+	Audit(records []AuditRecord) error
+}
+
+func hashValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// audit passes the changes of a save to the audit sink
+func (env *Env) audit(changes []Change) error {
+	if env.opts.Audit == nil {
+		return nil
+	}
+	now := timeNow().UTC()
+	target := describeStorage(env.storage)
+	records := make([]AuditRecord, len(changes))
+	for i, c := range changes {
+		records[i] = AuditRecord{
+			Time:     now,
+			Variable: c.Name,
+			OldHash:  hashValue(c.OldValue),
+			NewHash:  hashValue(c.NewValue),
+			Program:  filepath.Base(os.Args[0]),
+			UID:      os.Getuid(),
+			PID:      os.Getpid(),
+			Target:   target,
+		}
+	}
+	if err := env.opts.Audit.Audit(records); err != nil {
+		return fmt.Errorf("cannot write audit records: %v", err)
+	}
+	return nil
+}
+
+// describeStorage returns where the storage keeps the environment
+func describeStorage(s Storage) string {
+	for {
+		w, ok := s.(storageWrapper)
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	switch s := s.(type) {
+	case *fileStorage:
+		return s.fname
+	case *mmapStorage:
+		return s.fname
+	case *regionStorage:
+		return fmt.Sprintf("%s@0x%x", s.path, s.offset)
+	case *alignedRegionStorage:
+		return fmt.Sprintf("%s@0x%x", s.path, s.offset)
+	case *fdStorage:
+		return fmt.Sprintf("%s@0x%x", s.f.Name(), s.offset)
+	case *efiStorage:
+		return s.path
+	case *redundantStorage:
+		return describeStorage(s.copies[0]) + "," + describeStorage(s.copies[1])
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", s), "*")
+}
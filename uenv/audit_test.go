@@ -0,0 +1,86 @@
+package uenv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type recordingAuditSink struct {
+	records []AuditRecord
+	err     error
+}
+
+func (s *recordingAuditSink) Audit(records []AuditRecord) error {
+	s.records = append(s.records, records...)
+	return s.err
+}
+
+func (u *uenvTestSuite) TestAudit(c *C) {
+	defer func(old func() time.Time) { timeNow = old }(timeNow)
+	timeNow = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	sink := &recordingAuditSink{}
+	env, err := OpenWithOptions(u.envFile, Options{Audit: sink, Redact: []string{"password"}})
+	c.Assert(err, IsNil)
+
+	env.Set("foo", "bar")
+	env.Set("password", "secret")
+	c.Assert(env.Save(), IsNil)
+	env.Set("foo", "")
+	c.Assert(env.Save(), IsNil)
+	// saves without changes are not recorded
+	c.Assert(env.Save(), IsNil)
+
+	record := func(name, old, new string) AuditRecord {
+		return AuditRecord{
+			Time:     timeNow(),
+			Variable: name,
+			OldHash:  old,
+			NewHash:  new,
+			Program:  filepath.Base(os.Args[0]),
+			UID:      os.Getuid(),
+			PID:      os.Getpid(),
+			Target:   u.envFile,
+		}
+	}
+	// sha256 of "bar" and "secret", redacted values are hashed too
+	bar := "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9"
+	secret := "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+	c.Check(sink.records, DeepEquals, []AuditRecord{
+		record("foo", "", bar),
+		record("password", "", secret),
+		record("foo", bar, ""),
+	})
+}
+
+func (u *uenvTestSuite) TestAuditFails(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	sink := &recordingAuditSink{err: errors.New("boom")}
+	env, err := OpenWithOptions(u.envFile, Options{Audit: sink})
+	c.Assert(err, IsNil)
+
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	c.Check(env.JournalError(), ErrorMatches, "cannot write audit records: boom")
+	c.Check(sink.records, HasLen, 1)
+
+	// the environment was saved anyway
+	env2, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env2.Get("foo"), Equals, "bar")
+}
+
+func (u *uenvTestSuite) TestDescribeStorage(c *C) {
+	c.Check(describeStorage(newStorage("/boot/uboot.env", Options{})), Equals, "/boot/uboot.env")
+	c.Check(describeStorage(&regionStorage{path: "/dev/mmcblk0", offset: 0x3fc000}), Equals, "/dev/mmcblk0@0x3fc000")
+	rs := newRedundantStorage(&regionStorage{path: "/dev/mtd1"}, &regionStorage{path: "/dev/mtd2"}, Options{})
+	c.Check(describeStorage(wrapStorage(rs, Options{Retry: &RetryPolicy{}})), Equals, "/dev/mtd1@0x0,/dev/mtd2@0x0")
+	c.Check(describeStorage(NewMemStorage(nil)), Equals, "uenv.MemStorage")
+}
@@ -0,0 +1,26 @@
+package uenv
+
+import (
+	"errors"
+)
+
+// BeginBatch starts a batch of changes. Until the matching EndBatch,
+// Save only records that a save is wanted, so that code paths that
+// save after every Set result in a single write. Batches may nest.
+func (env *Env) BeginBatch() {
+	env.batchDepth++
+}
+
+// EndBatch ends a batch started with BeginBatch. When the outermost
+// batch ends and Save was called during it, the environment is saved.
+func (env *Env) EndBatch() error {
+	if env.batchDepth == 0 {
+		return errors.New("EndBatch called without BeginBatch")
+	}
+	env.batchDepth--
+	if env.batchDepth > 0 || !env.batchSave {
+		return nil
+	}
+	env.batchSave = false
+	return env.Save()
+}
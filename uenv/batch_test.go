@@ -0,0 +1,44 @@
+package uenv
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestBatchCoalescesSaves(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+
+	env.BeginBatch()
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+	env.BeginBatch()
+	env.Set("b", "2")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.EndBatch(), IsNil)
+	env.Set("c", "3")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Stats().SavesAttempted, Equals, uint64(0))
+
+	c.Assert(env.EndBatch(), IsNil)
+	c.Assert(env.Stats().SavesAttempted, Equals, uint64(1))
+
+	env2, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env2.String(), Equals, "a=1\nb=2\nc=3\n")
+}
+
+func (u *uenvTestSuite) TestBatchWithoutSave(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+
+	env.BeginBatch()
+	env.Set("a", "1")
+	c.Assert(env.EndBatch(), IsNil)
+	c.Assert(env.Stats().SavesAttempted, Equals, uint64(0))
+}
+
+func (u *uenvTestSuite) TestEndBatchUnbalanced(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	c.Assert(env.EndBatch(), ErrorMatches, "EndBatch called without BeginBatch")
+}
@@ -0,0 +1,162 @@
+package uenv
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// BoardProfile describes where the environment of a board is stored
+// with the default U-Boot configuration of that board.
+type BoardProfile struct {
+	Name        string
+	Description string
+	Config      Config
+	// Limits are the limits of the board's U-Boot, OpenBoard uses
+	// them unless Options.Limits is set.
+	Limits Limits
+	// Compatible are the device tree compatible strings of the
+	// boards the profile is for, see DetectBoard.
+	Compatible []string
+	// Models are prefixes of the device tree models of the boards
+	// the profile is for, they are used if no compatible matches.
+	Models []string
+}
+
+// boardProfilesMu protects boardProfiles
+var boardProfilesMu sync.RWMutex
+
+// boardProfiles are the built-in and registered profiles. Device names
+// depend on the kernel and boot medium, the built-in profiles assume
+// booting from the medium named in the description.
+var boardProfiles = map[string]*BoardProfile{
+	"rpi-cm4": {
+		Name:        "rpi-cm4",
+		Description: "Raspberry Pi CM4 with pi-uboot, environment file on the boot partition",
+		Config: Config{
+			Size:    0x4000,
+			Devices: []DeviceConfig{{Path: "/boot/firmware/uboot.env"}},
+		},
+		Limits:     Limits{CommandBufferSize: 1024},
+		Compatible: []string{"raspberrypi,4-compute-module"},
+		Models:     []string{"Raspberry Pi Compute Module 4"},
+	},
+	"beaglebone-black": {
+		Name:        "beaglebone-black",
+		Description: "BeagleBone Black (am335x_evm), redundant environment on eMMC",
+		Config: Config{
+			Size: 0x20000,
+			Devices: []DeviceConfig{
+				{Path: "/dev/mmcblk1", Offset: 0x260000},
+				{Path: "/dev/mmcblk1", Offset: 0x280000},
+			},
+		},
+		Limits:     Limits{CommandBufferSize: 512},
+		Compatible: []string{"ti,am335x-bone-black"},
+		Models:     []string{"TI AM335x BeagleBone Black"},
+	},
+	"imx6q-sabresd": {
+		Name:        "imx6q-sabresd",
+		Description: "NXP i.MX6Q SABRE SD, environment on the SD card in slot 3",
+		Config: Config{
+			Size:    0x2000,
+			Devices: []DeviceConfig{{Path: "/dev/mmcblk3", Offset: 0xc0000}},
+		},
+		Limits:     Limits{CommandBufferSize: 512},
+		Compatible: []string{"fsl,imx6q-sabresd"},
+		Models:     []string{"Freescale i.MX6 Quad SABRE Smart Device Board"},
+	},
+	"imx8mm-evk": {
+		Name:        "imx8mm-evk",
+		Description: "NXP i.MX8MM EVK, environment on eMMC",
+		Config: Config{
+			Size:    0x4000,
+			Devices: []DeviceConfig{{Path: "/dev/mmcblk2", Offset: 0x400000}},
+		},
+		Limits:     Limits{CommandBufferSize: 2048},
+		Compatible: []string{"fsl,imx8mm-evk"},
+		Models:     []string{"NXP i.MX8MM EVK"},
+	},
+	"rk3399": {
+		Name:        "rk3399",
+		Description: "Rockchip RK3399 boards with the rockchip defaults, environment on the boot MMC",
+		Config: Config{
+			Size:    0x8000,
+			Devices: []DeviceConfig{{Path: "/dev/mmcblk0", Offset: 0x3f8000}},
+		},
+		Limits:     Limits{CommandBufferSize: 1024},
+		Compatible: []string{"rockchip,rk3399"},
+	},
+	"sunxi": {
+		Name:        "sunxi",
+		Description: "Allwinner boards with the sunxi defaults, environment on the SD card",
+		Config: Config{
+			Size:    0x20000,
+			Devices: []DeviceConfig{{Path: "/dev/mmcblk0", Offset: 0x88000}},
+		},
+		Limits: Limits{CommandBufferSize: 1024},
+		Compatible: []string{
+			"allwinner,sun4i-a10",
+			"allwinner,sun7i-a20",
+			"allwinner,sun8i-h3",
+			"allwinner,sun50i-a64",
+			"allwinner,sun50i-h5",
+			"allwinner,sun50i-h6",
+		},
+	},
+}
+
+// Boards returns the sorted names of the known board profiles.
+func Boards() []string {
+	boardProfilesMu.RLock()
+	defer boardProfilesMu.RUnlock()
+
+	names := make([]string, 0, len(boardProfiles))
+	for name := range boardProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupBoard returns the profile of the named board.
+func LookupBoard(name string) (*BoardProfile, error) {
+	boardProfilesMu.RLock()
+	defer boardProfilesMu.RUnlock()
+
+	profile, ok := boardProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown board %q", name)
+	}
+	return profile, nil
+}
+
+// RegisterBoard adds a board profile, e.g. for an in-house product, so
+// that it can be used like the built-in ones. A profile with the same
+// name is replaced.
+func RegisterBoard(profile *BoardProfile) error {
+	if profile.Name == "" {
+		return fmt.Errorf("cannot register board profile without name")
+	}
+	if err := profile.Config.Validate(); err != nil {
+		return fmt.Errorf("cannot register board %q: %v", profile.Name, err)
+	}
+
+	boardProfilesMu.Lock()
+	defer boardProfilesMu.Unlock()
+	boardProfiles[profile.Name] = profile
+	return nil
+}
+
+// OpenBoard opens the environment of the named board at the location
+// of its profile.
+func OpenBoard(name string, opts Options) (*Env, error) {
+	profile, err := LookupBoard(name)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limits == nil {
+		opts.Limits = &profile.Limits
+	}
+	return OpenFromConfig(&profile.Config, opts)
+}
@@ -0,0 +1,61 @@
+package uenv
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestBoardProfilesValid(c *C) {
+	names := Boards()
+	c.Assert(names, DeepEquals, []string{"beaglebone-black", "imx6q-sabresd", "imx8mm-evk", "rk3399", "rpi-cm4", "sunxi"})
+	for _, name := range names {
+		profile, err := LookupBoard(name)
+		c.Assert(err, IsNil)
+		c.Check(profile.Name, Equals, name)
+		c.Check(profile.Config.Validate(), IsNil, Commentf("board %s", name))
+	}
+}
+
+func (u *uenvTestSuite) TestOpenBoard(c *C) {
+	_, err := OpenBoard("no-such-board", Options{})
+	c.Assert(err, ErrorMatches, `unknown board "no-such-board"`)
+
+	fname := filepath.Join(c.MkDir(), "uboot.env")
+	env, err := Create(fname, 0x4000)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	boardProfiles["test-board"] = &BoardProfile{
+		Name:   "test-board",
+		Config: Config{Size: 0x4000, Devices: []DeviceConfig{{Path: fname}}},
+		Limits: Limits{CommandBufferSize: 16},
+	}
+	defer delete(boardProfiles, "test-board")
+	env, err = OpenBoard("test-board", Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "bar")
+
+	// the limits of the board apply
+	env.Set("bootcmd", "run distro_bootcmd")
+	c.Assert(env.Save(), ErrorMatches, `variable "bootcmd": value is 18 bytes long, the command buffer holds 15`)
+}
+
+func (u *uenvTestSuite) TestRegisterBoard(c *C) {
+	err := RegisterBoard(&BoardProfile{Config: Config{Size: 0x4000}})
+	c.Assert(err, ErrorMatches, "cannot register board profile without name")
+	err = RegisterBoard(&BoardProfile{Name: "acme", Config: Config{Size: 0x4000}})
+	c.Assert(err, ErrorMatches, `cannot register board "acme": invalid config: need one or two devices, got 0`)
+
+	profile := &BoardProfile{
+		Name:   "acme",
+		Config: Config{Size: 0x4000, Devices: []DeviceConfig{{Path: "/dev/mmcblk0"}}},
+	}
+	c.Assert(RegisterBoard(profile), IsNil)
+	defer delete(boardProfiles, "acme")
+	c.Assert(Boards(), HasLen, 7)
+	found, err := LookupBoard("acme")
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, profile)
+}
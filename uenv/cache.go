@@ -0,0 +1,97 @@
+package uenv
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ReadCache keeps the last image read from a storage for a while, so
+// that daemons that open the environment for every query, like the
+// exporter or a gRPC watcher, do not read slow SPI NOR flash every
+// time. It is shared by setting it in the Options of every open and
+// must only be used for one environment.
+type ReadCache struct {
+	// TTL is how long a read image is used, a zero TTL disables
+	// the cache.
+	TTL time.Duration
+
+	mu   sync.Mutex
+	img  []byte
+	read time.Time
+}
+
+// NewReadCache returns a cache that uses read images for the given
+// time.
+func NewReadCache(ttl time.Duration) *ReadCache {
+	return &ReadCache{TTL: ttl}
+}
+
+// Invalidate drops the cached image, e.g. when a watch reports that
+// the environment was changed by someone else. Saves through the cache
+// invalidate it themselves.
+func (c *ReadCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.img = nil
+}
+
+func (c *ReadCache) get() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.img == nil || timeNow().Sub(c.read) >= c.TTL {
+		return nil
+	}
+	return c.img
+}
+
+func (c *ReadCache) put(img []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// storages may reuse the slice for the next read
+	c.img = append([]byte(nil), img...)
+	c.read = timeNow()
+}
+
+// cachedStorage serves reads of the wrapped storage from a ReadCache
+type cachedStorage struct {
+	Storage
+	cache *ReadCache
+}
+
+func (s *cachedStorage) ReadImage() ([]byte, error) {
+	if img := s.cache.get(); img != nil {
+		return img, nil
+	}
+	img, err := s.Storage.ReadImage()
+	if err != nil {
+		return nil, err
+	}
+	s.cache.put(img)
+	return img, nil
+}
+
+func (s *cachedStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	defer s.cache.Invalidate()
+	return s.Storage.WriteImage(size, fill)
+}
+
+func (s *cachedStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+func (s *cachedStorage) Close() error {
+	if c, ok := s.Storage.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// readStored reads the image from the storage itself, bypassing a
+// ReadCache, for checks that must see what is stored right now
+func readStored(s Storage) ([]byte, error) {
+	if cs, ok := s.(*cachedStorage); ok {
+		return cs.Storage.ReadImage()
+	}
+	return s.ReadImage()
+}
@@ -0,0 +1,87 @@
+package uenv
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestReadCache(c *C) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	mem := NewMemStorage(nil)
+	env, err := CreateStorage(mem, 64, Options{})
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+
+	storage := &countingStorage{Storage: mem}
+	opts := Options{Cache: NewReadCache(time.Minute)}
+	for i := 0; i < 3; i++ {
+		env, err = OpenStorage(storage, opts)
+		c.Assert(err, IsNil)
+		c.Check(env.Get("a"), Equals, "1")
+	}
+	c.Check(storage.reads, Equals, 1)
+
+	// saves read the storage itself and invalidate the cache
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+	c.Check(storage.reads, Equals, 2)
+	env, err = OpenStorage(storage, opts)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "2")
+	c.Check(storage.reads, Equals, 3)
+
+	// changes by others are seen after the TTL or an invalidation
+	other, err := OpenStorage(mem, Options{})
+	c.Assert(err, IsNil)
+	other.Set("a", "3")
+	c.Assert(other.Save(), IsNil)
+	env, err = OpenStorage(storage, opts)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "2")
+	now = now.Add(time.Minute)
+	env, err = OpenStorage(storage, opts)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "3")
+
+	other.Set("a", "4")
+	c.Assert(other.Save(), IsNil)
+	opts.Cache.Invalidate()
+	env, err = OpenStorage(storage, opts)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "4")
+}
+
+func (u *uenvTestSuite) TestReadCacheCompareAndSwap(c *C) {
+	mem := NewMemStorage(nil)
+	_, err := CreateStorage(mem, 64, Options{})
+	c.Assert(err, IsNil)
+
+	opts := Options{Cache: NewReadCache(time.Hour), CompareAndSwap: true}
+	env, err := OpenStorage(mem, opts)
+	c.Assert(err, IsNil)
+	other, err := OpenStorage(mem, Options{})
+	c.Assert(err, IsNil)
+	other.Set("a", "1")
+	c.Assert(other.Save(), IsNil)
+
+	// the cached image does not hide the concurrent change
+	env.Set("b", "2")
+	c.Check(errors.Is(env.Save(), ErrConcurrentModification), Equals, true)
+}
+
+func (u *uenvTestSuite) TestReadCacheNoErrors(c *C) {
+	opts := Options{Cache: NewReadCache(time.Hour)}
+	storage := &countingStorage{Storage: NewMemStorage(nil)}
+	for i := 0; i < 2; i++ {
+		_, err := OpenStorage(storage, opts)
+		c.Check(err, NotNil)
+	}
+	// failed reads are not cached
+	c.Check(storage.reads, Equals, 2)
+}
@@ -0,0 +1,33 @@
+package uenv
+
+// CompareAndSet sets the variable to new if its current value is
+// expectedOld and returns true, otherwise it changes nothing and
+// returns false. An empty value stands for an unset variable.
+//
+// The comparison is done on the variables in memory. To coordinate
+// agents working on the same environment, use it inside Update or
+// use CompareAndSetVariable, which hold the lock while reading,
+// comparing and saving.
+func (env *Env) CompareAndSet(name, expectedOld, new string) bool {
+	if env.Get(name) != expectedOld {
+		return false
+	}
+	env.Set(name, new)
+	return true
+}
+
+// CompareAndSetVariable locks the environment file like Update and
+// sets the variable to new if its stored value is expectedOld, e.g. to
+// let only one of several agents claim upgrade_available. It returns
+// true if the variable was set and saved.
+func CompareAndSetVariable(fname string, opts Options, name, expectedOld, new string) (bool, error) {
+	swapped := false
+	err := UpdateWithOptions(fname, opts, func(env *Env) error {
+		swapped = env.CompareAndSet(name, expectedOld, new)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
@@ -0,0 +1,52 @@
+package uenv
+
+import (
+	"fmt"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestCompareAndSet(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	c.Check(env.CompareAndSet("upgrade_available", "1", "0"), Equals, false)
+	c.Check(env.CompareAndSet("upgrade_available", "", "1"), Equals, true)
+	c.Check(env.Get("upgrade_available"), Equals, "1")
+	c.Check(env.CompareAndSet("upgrade_available", "0", "2"), Equals, false)
+	c.Check(env.CompareAndSet("upgrade_available", "1", ""), Equals, true)
+	c.Check(env.String(), Equals, "")
+}
+
+func (u *uenvTestSuite) TestCompareAndSetVariable(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("upgrade_available", "1")
+	c.Assert(env.Save(), IsNil)
+
+	// only one of the agents claims the upgrade
+	var wg sync.WaitGroup
+	results := make([]bool, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			claimed, err := CompareAndSetVariable(u.envFile, Options{}, "upgrade_available", "1", fmt.Sprintf("claimed-by-%d", i))
+			c.Check(err, IsNil)
+			results[i] = claimed
+		}(i)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, claimed := range results {
+		if claimed {
+			c.Check(winner, Equals, -1)
+			winner = i
+		}
+	}
+	c.Assert(winner, Not(Equals), -1)
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("upgrade_available"), Equals, fmt.Sprintf("claimed-by-%d", winner))
+}
@@ -0,0 +1,135 @@
+package uenv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// Checksum selects the CRC stored in the header of the environment.
+// Both are computed with the SSE4.2, CLMUL or ARMv8 CRC instructions
+// where the CPU has them.
+type Checksum int
+
+const (
+	// ChecksumCRC32 is the IEEE CRC32 that U-Boot uses.
+	ChecksumCRC32 Checksum = iota
+	// ChecksumCRC32C is the Castagnoli CRC32 used by some vendor
+	// forks of U-Boot.
+	ChecksumCRC32C
+)
+
+// ChecksumBigEndian is combined with a checksum for environments of
+// big-endian boards like PowerPC and some MIPS, which store the CRC in
+// big-endian byte order, e.g. ChecksumCRC32|ChecksumBigEndian.
+const ChecksumBigEndian Checksum = 0x100
+
+// bigEndianSuffix marks big-endian checksums in their names
+const bigEndianSuffix = "-be"
+
+// crc returns the CRC without the byte order
+func (c Checksum) crc() Checksum {
+	return c &^ ChecksumBigEndian
+}
+
+// byteOrder returns the byte order of the CRC in the header
+func (c Checksum) byteOrder() binary.ByteOrder {
+	if c&ChecksumBigEndian != 0 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// readCRC returns the CRC stored in the header of an image
+func (c Checksum) readCRC(header []byte) uint32 {
+	return c.byteOrder().Uint32(header)
+}
+
+// putCRC stores the CRC in the header of an image
+func (c Checksum) putCRC(header []byte, crc uint32) {
+	c.byteOrder().PutUint32(header, crc)
+}
+
+func (c Checksum) String() string {
+	suffix := ""
+	if c&ChecksumBigEndian != 0 {
+		suffix = bigEndianSuffix
+	}
+	switch c.crc() {
+	case ChecksumCRC32:
+		return "crc32" + suffix
+	case ChecksumCRC32C:
+		return "crc32c" + suffix
+	}
+	return fmt.Sprintf("Checksum(%d)", int(c))
+}
+
+// MarshalText returns the name of the checksum.
+func (c Checksum) MarshalText() ([]byte, error) {
+	switch c.crc() {
+	case ChecksumCRC32, ChecksumCRC32C:
+		return []byte(c.String()), nil
+	}
+	return nil, fmt.Errorf("unknown checksum %d", int(c))
+}
+
+// UnmarshalText parses the name of a checksum, "crc32" or "crc32c",
+// with a "-be" suffix for big-endian CRCs.
+func (c *Checksum) UnmarshalText(text []byte) error {
+	name := strings.TrimSuffix(string(text), bigEndianSuffix)
+	var sum Checksum
+	switch name {
+	case "crc32":
+		sum = ChecksumCRC32
+	case "crc32c":
+		sum = ChecksumCRC32C
+	default:
+		return fmt.Errorf("unknown checksum %q", text)
+	}
+	if len(name) < len(text) {
+		sum |= ChecksumBigEndian
+	}
+	*c = sum
+	return nil
+}
+
+// DetectChecksum returns the checksum that matches the header of the
+// image, trying both CRCs in both byte orders, e.g. to open a dump of
+// an unknown board.
+func DetectChecksum(image []byte) (Checksum, error) {
+	sum, _, err := detectLayout(image)
+	return sum, err
+}
+
+// detectLayout returns the checksum and the header size that match
+// the image, with and without the flags byte of redundant copies
+func detectLayout(image []byte) (Checksum, int, error) {
+	var firstErr error
+	for _, sum := range []Checksum{
+		ChecksumCRC32,
+		ChecksumCRC32 | ChecksumBigEndian,
+		ChecksumCRC32C,
+		ChecksumCRC32C | ChecksumBigEndian,
+	} {
+		header, err := detectHeader(image, sum)
+		if err == nil {
+			return sum, header, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return 0, 0, firstErr
+}
+
+// castagnoliTable is made once, crc32 only uses the accelerated
+// implementation for tables of the Castagnoli polynomial
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (c Checksum) table() *crc32.Table {
+	if c.crc() == ChecksumCRC32C {
+		return castagnoliTable
+	}
+	return crc32.IEEETable
+}
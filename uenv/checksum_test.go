@@ -0,0 +1,133 @@
+package uenv
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestChecksumCRC32C(c *C) {
+	opts := Options{Checksum: ChecksumCRC32C}
+	env, err := CreateWithOptions(u.envFile, 64, opts)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(readUint32(content), Equals, crc32.Checksum(content[headerSize:], crc32.MakeTable(crc32.Castagnoli)))
+
+	env, err = OpenWithOptions(u.envFile, opts)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+
+	// the CRC32 of U-Boot does not match
+	_, err = Open(u.envFile)
+	c.Check(err, ErrorMatches, "bad CRC.*")
+}
+
+func (u *uenvTestSuite) TestChecksumSnapshot(c *C) {
+	env, err := CreateWithOptions(u.envFile, 64, Options{Checksum: ChecksumCRC32C})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	snap, err := env.Snapshot("before")
+	c.Assert(err, IsNil)
+	fname := filepath.Join(c.MkDir(), "snap.json")
+	c.Assert(snap.WriteFile(fname), IsNil)
+
+	snap, err = ReadSnapshot(fname)
+	c.Assert(err, IsNil)
+	c.Check(snap.Checksum, Equals, ChecksumCRC32C)
+	target, err := Create(filepath.Join(c.MkDir(), "uboot.env"), 64)
+	c.Assert(err, IsNil)
+	c.Assert(Restore(target, snap), IsNil)
+	c.Check(target.Get("foo"), Equals, "bar")
+}
+
+func (u *uenvTestSuite) TestChecksumText(c *C) {
+	var sum Checksum
+	c.Assert(sum.UnmarshalText([]byte("crc32c")), IsNil)
+	c.Check(sum, Equals, ChecksumCRC32C)
+	text, err := sum.MarshalText()
+	c.Assert(err, IsNil)
+	c.Check(string(text), Equals, "crc32c")
+	c.Check(sum.UnmarshalText([]byte("md5")), ErrorMatches, `unknown checksum "md5"`)
+}
+
+func (u *uenvTestSuite) TestChecksumBigEndian(c *C) {
+	opts := Options{Checksum: ChecksumCRC32 | ChecksumBigEndian}
+	env, err := CreateWithOptions(u.envFile, 64, opts)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(binary.BigEndian.Uint32(content), Equals, crc32.ChecksumIEEE(content[headerSize:]))
+
+	env, err = OpenWithOptions(u.envFile, opts)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+	env.Set("foo", "baz")
+	c.Assert(env.Save(), IsNil)
+
+	// the CRC is read in the wrong byte order
+	_, err = Open(u.envFile)
+	c.Check(err, ErrorMatches, "bad CRC.*")
+
+	content, err = os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	sum, err := DetectChecksum(content)
+	c.Assert(err, IsNil)
+	c.Check(sum, Equals, ChecksumCRC32|ChecksumBigEndian)
+}
+
+func (u *uenvTestSuite) TestDetectChecksum(c *C) {
+	for _, sum := range []Checksum{ChecksumCRC32, ChecksumCRC32C, ChecksumCRC32C | ChecksumBigEndian} {
+		env, err := NewEnv(64)
+		c.Assert(err, IsNil)
+		env.opts.Checksum = sum
+		env.Set("foo", "bar")
+		snap, err := env.Snapshot("test")
+		c.Assert(err, IsNil)
+		detected, err := DetectChecksum(snap.Image)
+		c.Assert(err, IsNil)
+		c.Check(detected, Equals, sum)
+	}
+
+	_, err := DetectChecksum(append(make([]byte, 8), "foo=bar\x00\x00"...))
+	c.Check(err, ErrorMatches, "bad CRC.*")
+}
+
+func (u *uenvTestSuite) TestChecksumTextBigEndian(c *C) {
+	var sum Checksum
+	c.Assert(sum.UnmarshalText([]byte("crc32c-be")), IsNil)
+	c.Check(sum, Equals, ChecksumCRC32C|ChecksumBigEndian)
+	text, err := sum.MarshalText()
+	c.Assert(err, IsNil)
+	c.Check(string(text), Equals, "crc32c-be")
+	c.Check(ChecksumBigEndian.String(), Equals, "crc32-be")
+	c.Check(sum.UnmarshalText([]byte("md5-be")), ErrorMatches, `unknown checksum "md5-be"`)
+}
+
+func (u *uenvTestSuite) benchmarkSave(c *C, sum Checksum) {
+	env, err := CreateWithOptions(u.envFile, 4<<20, Options{Checksum: sum, ForceWrite: true, Sync: SyncNone})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.SetBytes(4 << 20)
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		c.Assert(env.Save(), IsNil)
+	}
+}
+
+func (u *uenvTestSuite) BenchmarkSaveCRC32(c *C) {
+	u.benchmarkSave(c, ChecksumCRC32)
+}
+
+func (u *uenvTestSuite) BenchmarkSaveCRC32C(c *C) {
+	u.benchmarkSave(c, ChecksumCRC32C)
+}
@@ -0,0 +1,81 @@
+package uenv
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ReadCoalescer combines reads of a storage that arrive within a short
+// window into a single read, for local APIs that open the environment
+// for every query at a high rate. Unlike a ReadCache it never returns
+// an image read before the caller asked for it, at the price of the
+// window as added latency. It is shared by setting it in the Options
+// of every open and must only be used for one environment.
+type ReadCoalescer struct {
+	// Window is how long the first read waits for others to join.
+	Window time.Duration
+
+	mu      sync.Mutex
+	pending *coalescedRead
+}
+
+// coalescedRead is a read that callers can join until it starts
+type coalescedRead struct {
+	done chan struct{}
+	img  []byte
+	err  error
+}
+
+// NewReadCoalescer returns a coalescer that waits the given window
+// for reads to combine.
+func NewReadCoalescer(window time.Duration) *ReadCoalescer {
+	return &ReadCoalescer{Window: window}
+}
+
+func (c *ReadCoalescer) read(s Storage) ([]byte, error) {
+	c.mu.Lock()
+	if r := c.pending; r != nil {
+		c.mu.Unlock()
+		<-r.done
+		return r.img, r.err
+	}
+	r := &coalescedRead{done: make(chan struct{})}
+	c.pending = r
+	c.mu.Unlock()
+
+	timeSleep(c.Window)
+	// callers arriving from now on need a read of their own
+	c.mu.Lock()
+	c.pending = nil
+	c.mu.Unlock()
+
+	r.img, r.err = s.ReadImage()
+	if r.err == nil {
+		// storages may reuse the slice for the next read
+		r.img = append([]byte(nil), r.img...)
+	}
+	close(r.done)
+	return r.img, r.err
+}
+
+// coalescingStorage combines concurrent reads of the wrapped storage
+type coalescingStorage struct {
+	Storage
+	coalescer *ReadCoalescer
+}
+
+func (s *coalescingStorage) ReadImage() ([]byte, error) {
+	return s.coalescer.read(s.Storage)
+}
+
+func (s *coalescingStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+func (s *coalescingStorage) Close() error {
+	if c, ok := s.Storage.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
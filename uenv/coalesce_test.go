@@ -0,0 +1,54 @@
+package uenv
+
+import (
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestReadCoalescer(c *C) {
+	mem := NewMemStorage(nil)
+	env, err := CreateStorage(mem, 64, Options{})
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+
+	storage := &countingStorage{Storage: mem}
+	opts := Options{Coalesce: NewReadCoalescer(100 * time.Millisecond)}
+	var wg sync.WaitGroup
+	values := make([]string, 10)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			env, err := OpenStorage(storage, opts)
+			if err == nil {
+				values[i] = env.Get("a")
+			}
+		}(i)
+	}
+	wg.Wait()
+	c.Check(storage.reads, Equals, 1)
+	for _, v := range values {
+		c.Check(v, Equals, "1")
+	}
+
+	// later reads see later changes
+	env.Set("a", "2")
+	c.Assert(env.Save(), IsNil)
+	env, err = OpenStorage(storage, opts)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "2")
+	c.Check(storage.reads, Equals, 2)
+}
+
+func (u *uenvTestSuite) TestReadCoalescerError(c *C) {
+	delays, restore := mockSleep()
+	defer restore()
+
+	opts := Options{Coalesce: NewReadCoalescer(time.Millisecond)}
+	_, err := OpenStorage(NewMemStorage(nil), opts)
+	c.Check(err, NotNil)
+	c.Check(*delays, DeepEquals, []time.Duration{time.Millisecond})
+}
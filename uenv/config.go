@@ -0,0 +1,155 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Config describes where an environment is stored, like the
+// configuration files of the U-Boot userspace tools do.
+type Config struct {
+	// Size is the size of the environment in bytes.
+	Size int
+	// Lockfile is the file the tools sharing this configuration lock
+	// while accessing the environment.
+	Lockfile string
+	// Devices are the locations of the environment, a second device
+	// holds the redundant copy.
+	Devices []DeviceConfig
+}
+
+// DeviceConfig describes one location of an environment.
+type DeviceConfig struct {
+	// Path is the device or file the environment is stored in.
+	Path string
+	// Offset is the position of the environment within Path, a
+	// negative offset counts back from the end of Path like in
+	// fw_env.config.
+	Offset int64
+	// SectorSize is the erase block size of flash devices.
+	SectorSize int64
+	// Sectors is the number of erase blocks the environment spans,
+	// zero means as many as needed for the env size.
+	Sectors int64
+	// UnlockOffset is the offset used to unlock protected flash.
+	UnlockOffset int64
+	// DisableLock disables unlocking the flash before writing.
+	DisableLock bool
+}
+
+// Validate checks that the configuration describes one or two
+// devices with sane sizes and offsets.
+func (cfg *Config) Validate() error {
+	if len(cfg.Devices) != 1 && len(cfg.Devices) != 2 {
+		return fmt.Errorf("invalid config: need one or two devices, got %d", len(cfg.Devices))
+	}
+	if cfg.Size <= 0 {
+		return fmt.Errorf("invalid config: invalid env size %d", cfg.Size)
+	}
+	for _, dev := range cfg.Devices {
+		if dev.Path == "" {
+			return fmt.Errorf("invalid config: device without path")
+		}
+		if dev.SectorSize < 0 || dev.Sectors < 0 {
+			return fmt.Errorf("invalid config: invalid sectors for %s", dev.Path)
+		}
+	}
+	return nil
+}
+
+// OpenFromConfig opens the environment described by the configuration.
+// With two devices the environment is opened as redundant environment.
+// The lock file of the configuration is used if Options.Lockfile is not
+// set.
+func OpenFromConfig(cfg *Config, opts Options) (*Env, error) {
+	storages, err := cfg.storages(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Lockfile == "" {
+		opts.Lockfile = cfg.Lockfile
+	}
+	if len(storages) == 2 {
+		return OpenRedundantStorage(storages[0], storages[1], opts)
+	}
+	return OpenStorage(storages[0], opts)
+}
+
+// CreateFromConfig writes a new empty environment to the locations
+// described by the configuration, to both copies with two devices.
+func CreateFromConfig(cfg *Config, opts Options) (*Env, error) {
+	storages, err := cfg.storages(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(storages) == 2 {
+		return CreateStorage(newRedundantStorage(storages[0], storages[1], opts), cfg.Size, opts)
+	}
+	return CreateStorage(storages[0], cfg.Size, opts)
+}
+
+func (cfg *Config) storages(opts Options) ([]Storage, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	storages := make([]Storage, len(cfg.Devices))
+	for i, dev := range cfg.Devices {
+		storage, err := newDeviceStorage(dev, cfg.Size, opts)
+		if err != nil {
+			return nil, err
+		}
+		storages[i] = storage
+	}
+	return storages, nil
+}
+
+// newDeviceStorage returns the storage for the device, flash that
+// needs erasing for MTD devices
+func newDeviceStorage(dev DeviceConfig, size int, opts Options) (Storage, error) {
+	offset, err := deviceOffset(dev)
+	if err != nil {
+		return nil, err
+	}
+	dev.Offset = offset
+	if !isMTD(dev.Path) {
+		return newRegionStorage(dev.Path, dev.Offset, size, opts)
+	}
+	if size > opts.maxSize() {
+		return nil, fmt.Errorf("cannot use %s: env size %d is larger than the maximum env size of %d bytes", dev.Path, size, opts.maxSize())
+	}
+	return newMTDStorage(dev, size, opts), nil
+}
+
+// deviceOffset returns the offset of the environment within the
+// device, resolving negative offsets against the size of the device
+func deviceOffset(dev DeviceConfig) (int64, error) {
+	if dev.Offset >= 0 {
+		return dev.Offset, nil
+	}
+	f, err := os.Open(dev.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// works for files, block devices and MTD devices alike
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get size of %s: %v", dev.Path, err)
+	}
+	if size+dev.Offset < 0 {
+		return 0, fmt.Errorf("cannot use offset %d of %s: device has only %d bytes", dev.Offset, dev.Path, size)
+	}
+	return size + dev.Offset, nil
+}
+
+// OpenDevice opens the environment of the given size at an offset of
+// a block device, e.g. an eMMC, or of an MTD character device like
+// /dev/mtd1. The erase block size of MTD devices is taken from the
+// device, use OpenFromConfig to set it. A negative offset counts back
+// from the end of the device.
+func OpenDevice(path string, offset int64, size int, opts Options) (*Env, error) {
+	return OpenFromConfig(&Config{Size: size, Devices: []DeviceConfig{{Path: path, Offset: offset}}}, opts)
+}
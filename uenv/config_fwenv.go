@@ -0,0 +1,94 @@
+package uenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultConfigFile is where fw_printenv and fw_setenv read their
+// configuration from.
+const DefaultConfigFile = "/etc/fw_env.config"
+
+// OpenConfigFile opens the environment described by a fw_env.config
+// file, so that systems set up for fw_printenv and fw_setenv work
+// without further configuration.
+func OpenConfigFile(fname string, opts Options) (*Env, error) {
+	cfg, err := LoadConfig(fname)
+	if err != nil {
+		return nil, err
+	}
+	return OpenFromConfig(cfg, opts)
+}
+
+// LoadConfig reads a fw_env.config file as used by fw_printenv and
+// fw_setenv, e.g. DefaultConfigFile.
+func LoadConfig(fname string) (*Config, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := ReadConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", fname, err)
+	}
+	return cfg, nil
+}
+
+// ReadConfig reads a configuration in fw_env.config format. Every
+// non-comment line describes one device:
+//
+//	device offset env-size [sector-size [number-of-sectors]]
+//
+// A second line describes the redundant copy. Numbers are decimal or,
+// with a 0x prefix, hex, as written e.g. by OpenWrt's ubootenv uci
+// config.
+func ReadConfig(r io.Reader) (*Config, error) {
+	cfg := &Config{}
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 || len(fields) > 5 {
+			return nil, fmt.Errorf("line %d: need 3 to 5 fields, got %d", lineno, len(fields))
+		}
+
+		var nums [4]int64
+		for i, field := range fields[1:] {
+			n, err := strconv.ParseInt(field, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: cannot parse %q as number", lineno, field)
+			}
+			nums[i] = n
+		}
+		size := int(nums[1])
+		if cfg.Size != 0 && cfg.Size != size {
+			return nil, fmt.Errorf("line %d: env size %d differs from env size %d of the first device", lineno, size, cfg.Size)
+		}
+		cfg.Size = size
+		cfg.Devices = append(cfg.Devices, DeviceConfig{
+			Path:       fields[0],
+			Offset:     nums[0],
+			SectorSize: nums[2],
+			Sectors:    nums[3],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,235 @@
+package uenv
+
+import (
+	"bytes"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestOpenFromConfig(c *C) {
+	// an image with two env copies at an offset
+	img := filepath.Join(c.MkDir(), "disk.img")
+	c.Assert(os.WriteFile(img, bytes.Repeat([]byte{0xaa}, 4096), 0644), IsNil)
+	copy1 := filepath.Join(c.MkDir(), "copy1")
+	copy2 := filepath.Join(c.MkDir(), "copy2")
+	env, err := CreateRedundant(copy1, copy2, 512, Options{})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	f, err := os.OpenFile(img, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	for i, fname := range []string{copy1, copy2} {
+		content, err := os.ReadFile(fname)
+		c.Assert(err, IsNil)
+		_, err = f.WriteAt(content, int64(1024+i*1024))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(f.Close(), IsNil)
+
+	cfg := &Config{
+		Size: 512,
+		Devices: []DeviceConfig{
+			{Path: img, Offset: 1024},
+			{Path: img, Offset: 2048},
+		},
+	}
+	env, err = OpenFromConfig(cfg, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "bar")
+	status, ok := env.RedundancyStatus()
+	c.Assert(ok, Equals, true)
+	c.Assert(status.Active, Equals, 1)
+
+	env.Set("foo", "baz")
+	c.Assert(env.Save(), IsNil)
+
+	// only the env regions were touched
+	content, err := os.ReadFile(img)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, 4096)
+	c.Assert(content[:1024], DeepEquals, bytes.Repeat([]byte{0xaa}, 1024))
+	c.Assert(content[1536:2048], DeepEquals, bytes.Repeat([]byte{0xaa}, 512))
+	c.Assert(content[2560:], DeepEquals, bytes.Repeat([]byte{0xaa}, 4096-2560))
+
+	env, err = OpenFromConfig(&Config{Size: 512, Devices: cfg.Devices[:1]}, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "baz")
+}
+
+// mkenvimage returns an image like `mkenvimage -s size` writes it for
+// U-Boot without CONFIG_SYS_REDUNDAND_ENVIRONMENT: the CRC directly
+// followed by the variables
+func mkenvimage(size int, vars ...string) []byte {
+	payload := bytes.Repeat([]byte{0xff}, size-headerSize)
+	off := 0
+	for _, v := range vars {
+		off += copy(payload[off:], v+"\x00")
+	}
+	payload[off] = 0
+	if off == 0 {
+		payload[1] = 0
+	}
+	return append(writeUint32(crc32.ChecksumIEEE(payload)), payload...)
+}
+
+func (u *uenvTestSuite) TestOpenDeviceSingleCopy(c *C) {
+	img := filepath.Join(c.MkDir(), "disk.img")
+	disk := bytes.Repeat([]byte{0xaa}, 4096)
+	copy(disk[1024:], mkenvimage(512, "bootcmd=run distro_bootcmd", "bootdelay=3"))
+	c.Assert(os.WriteFile(img, disk, 0644), IsNil)
+
+	fname := filepath.Join(c.MkDir(), "fw_env.config")
+	c.Assert(os.WriteFile(fname, []byte(img+" 0x400 0x200\n"), 0644), IsNil)
+	env, err := OpenConfigFile(fname, Options{})
+	c.Assert(err, IsNil)
+	c.Check(env.Header(), Equals, HeaderSingle)
+	c.Check(env.Get("bootcmd"), Equals, "run distro_bootcmd")
+	c.Check(env.Get("bootdelay"), Equals, "3")
+
+	env, err = OpenDevice(img, 1024, 512, Options{})
+	c.Assert(err, IsNil)
+	c.Check(env.Header(), Equals, HeaderSingle)
+	env.Set("bootdelay", "5")
+	c.Assert(env.Save(), IsNil)
+
+	// the save is what mkenvimage writes for the new variables
+	content, err := os.ReadFile(img)
+	c.Assert(err, IsNil)
+	c.Assert(content[1024:1536], DeepEquals, mkenvimage(512, "bootcmd=run distro_bootcmd", "bootdelay=5"))
+	c.Assert(content[:1024], DeepEquals, bytes.Repeat([]byte{0xaa}, 1024))
+	c.Assert(content[1536:], DeepEquals, bytes.Repeat([]byte{0xaa}, 4096-1536))
+}
+
+func (u *uenvTestSuite) TestOpenDeviceNegativeOffset(c *C) {
+	img := filepath.Join(c.MkDir(), "disk.img")
+	disk := bytes.Repeat([]byte{0xaa}, 4096)
+	copy(disk[4096-1024:], mkenvimage(512, "bootdelay=3"))
+	c.Assert(os.WriteFile(img, disk, 0644), IsNil)
+
+	// like in fw_env.config the offset counts back from the end
+	fname := filepath.Join(c.MkDir(), "fw_env.config")
+	c.Assert(os.WriteFile(fname, []byte(img+" -0x400 0x200\n"), 0644), IsNil)
+	env, err := OpenConfigFile(fname, Options{})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("bootdelay"), Equals, "3")
+
+	env, err = OpenDevice(img, -1024, 512, Options{})
+	c.Assert(err, IsNil)
+	env.Set("bootdelay", "5")
+	c.Assert(env.Save(), IsNil)
+	content, err := os.ReadFile(img)
+	c.Assert(err, IsNil)
+	c.Check(content[4096-1024:4096-512], DeepEquals, mkenvimage(512, "bootdelay=5"))
+
+	_, err = OpenDevice(img, -8192, 512, Options{})
+	c.Check(err, ErrorMatches, "cannot use offset -8192 of .*/disk.img: device has only 4096 bytes")
+}
+
+func (u *uenvTestSuite) TestCreateFromConfigSingleCopy(c *C) {
+	img := filepath.Join(c.MkDir(), "disk.img")
+	c.Assert(os.WriteFile(img, make([]byte, 1024), 0644), IsNil)
+	_, err := CreateFromConfig(&Config{Size: 512, Devices: []DeviceConfig{{Path: img}}}, Options{})
+	c.Assert(err, IsNil)
+
+	content, err := os.ReadFile(img)
+	c.Assert(err, IsNil)
+	c.Assert(content[:512], DeepEquals, mkenvimage(512))
+}
+
+func (u *uenvTestSuite) TestOpenFromConfigInvalid(c *C) {
+	_, err := OpenFromConfig(&Config{Size: 512}, Options{})
+	c.Assert(err, ErrorMatches, "invalid config: need one or two devices, got 0")
+	_, err = OpenFromConfig(&Config{Size: 32 << 20, Devices: []DeviceConfig{{Path: "/dev/mtd0"}}}, Options{})
+	c.Assert(err, ErrorMatches, "cannot use /dev/mtd0: env size 33554432 is larger than the maximum env size of 16777216 bytes")
+}
+
+// as generated by OpenWrt from its ubootenv uci config
+const openWrtFwEnvConfig = `# MTD device name	Device offset	Env. size	Flash sector size	Number of sectors
+/dev/mtd1	0x0	0x10000	0x10000	1
+/dev/mtd2	0x0	0x10000	0x10000	1
+`
+
+func (u *uenvTestSuite) TestReadConfigOpenWrt(c *C) {
+	cfg, err := ReadConfig(strings.NewReader(openWrtFwEnvConfig))
+	c.Assert(err, IsNil)
+	c.Assert(cfg, DeepEquals, &Config{
+		Size: 0x10000,
+		Devices: []DeviceConfig{
+			{Path: "/dev/mtd1", SectorSize: 0x10000, Sectors: 1},
+			{Path: "/dev/mtd2", SectorSize: 0x10000, Sectors: 1},
+		},
+	})
+}
+
+func (u *uenvTestSuite) TestLoadConfig(c *C) {
+	fname := filepath.Join(c.MkDir(), "fw_env.config")
+	c.Assert(os.WriteFile(fname, []byte(`
+# Block device example
+/dev/mmcblk0		0xc0000		0x20000 # trailing comment
+`), 0644), IsNil)
+	cfg, err := LoadConfig(fname)
+	c.Assert(err, IsNil)
+	c.Assert(cfg, DeepEquals, &Config{
+		Size:    0x20000,
+		Devices: []DeviceConfig{{Path: "/dev/mmcblk0", Offset: 0xc0000}},
+	})
+}
+
+func (u *uenvTestSuite) TestOpenConfigFile(c *C) {
+	env, err := Create(u.envFile, 0x1000)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	fname := filepath.Join(c.MkDir(), "fw_env.config")
+	c.Assert(os.WriteFile(fname, []byte(u.envFile+" 0x0 0x1000\n"), 0644), IsNil)
+	env, err = OpenConfigFile(fname, Options{})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+
+	_, err = OpenConfigFile(filepath.Join(c.MkDir(), "missing"), Options{})
+	c.Check(err, ErrorMatches, "open .*/missing: no such file or directory")
+}
+
+func (u *uenvTestSuite) TestReadConfigErrors(c *C) {
+	for _, t := range []struct {
+		config string
+		err    string
+	}{
+		{"/dev/mtd1 0x0\n", "line 1: need 3 to 5 fields, got 2"},
+		{"/dev/mtd1 0x0 0x1000 0x1000 1 2\n", "line 1: need 3 to 5 fields, got 6"},
+		{"\n/dev/mtd1 0x0 64k\n", `line 2: cannot parse "64k" as number`},
+		{"/dev/mtd1 0x0 0x1000\n/dev/mtd2 0x0 0x2000\n", "line 2: env size 8192 differs from env size 4096 of the first device"},
+		{"# nothing\n", "invalid config: need one or two devices, got 0"},
+	} {
+		_, err := ReadConfig(strings.NewReader(t.config))
+		c.Check(err, ErrorMatches, t.err, Commentf("config %q", t.config))
+	}
+}
+
+func (u *uenvTestSuite) TestCreateFromConfig(c *C) {
+	img := filepath.Join(c.MkDir(), "disk.img")
+	c.Assert(os.WriteFile(img, make([]byte, 4096), 0644), IsNil)
+	cfg := &Config{
+		Size: 512,
+		Devices: []DeviceConfig{
+			{Path: img, Offset: 1024},
+			{Path: img, Offset: 2048},
+		},
+	}
+	env, err := CreateFromConfig(cfg, Options{})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenFromConfig(cfg, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "bar")
+	status, ok := env.RedundancyStatus()
+	c.Assert(ok, Equals, true)
+	c.Assert(status.Healthy(), Equals, true)
+}
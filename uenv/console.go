@@ -0,0 +1,130 @@
+package uenv
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConsoleOptions alter how a console log is read.
+type ConsoleOptions struct {
+	// WrapWidth is the width at which the terminal wrapped long
+	// lines, e.g. 80. A line following a line of this length is
+	// always joined to it. If zero, only lines that do not look like
+	// "name=value" are joined to the previous one.
+	WrapWidth int
+}
+
+var (
+	// consolePromptRe matches a line starting with a U-Boot prompt
+	// like "=> " or "U-Boot> "
+	consolePromptRe = regexp.MustCompile(`^(=>|\S*[>#])( |$)`)
+	// consolePrintenvRe matches the printenv command after a prompt
+	consolePrintenvRe = regexp.MustCompile(`^(=>|\S*[>#]) *printenv( .*)?$`)
+	// consoleSizeRe matches the trailer of printenv
+	consoleSizeRe = regexp.MustCompile(`^Environment size: \d+/(\d+) bytes`)
+	// consoleVarRe matches the start of a variable
+	consoleVarRe = regexp.MustCompile(`^[^\s=]+=`)
+)
+
+// ReadConsoleLog reads the variables printed by printenv from a log of
+// the U-Boot serial console, e.g. pasted into a support ticket. Only
+// the output of the last printenv command of the log is used if it
+// contains the command, otherwise the whole log is scanned. Prompts,
+// the "Environment size:" trailer and lines wrapped by the terminal
+// are handled. size is the environment size derived from the trailer
+// and zero if the log does not contain it.
+func ReadConsoleLog(r io.Reader, opts ConsoleOptions) (vars map[string]string, size int, err error) {
+	var lines []string
+	start := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, MaxSize)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		lines = append(lines, line)
+		if consolePrintenvRe.MatchString(line) {
+			start = len(lines)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	vars = make(map[string]string)
+	var name string
+	prevLen := 0
+	for _, line := range lines[start:] {
+		wrapped := opts.WrapWidth > 0 && prevLen >= opts.WrapWidth
+		prevLen = len(line)
+		switch {
+		case wrapped && name != "":
+			vars[name] += line
+		case line == "":
+			name = ""
+		case consoleSizeRe.MatchString(line):
+			m := consoleSizeRe.FindStringSubmatch(line)
+			// printenv shows the size without the header
+			if n, err := strconv.Atoi(m[1]); err == nil {
+				size = n + headerSize
+			}
+			name = ""
+		case consoleVarRe.MatchString(line):
+			// checked before the prompt as values like "run#"
+			// end like one
+			l := strings.SplitN(line, "=", 2)
+			name = l[0]
+			vars[name] = l[1]
+		case consolePromptRe.MatchString(line):
+			if start > 0 {
+				// the output of printenv ends at the next prompt
+				return consoleResult(vars, size)
+			}
+			name = ""
+		case name != "":
+			vars[name] += line
+		}
+	}
+	return consoleResult(vars, size)
+}
+
+func consoleResult(vars map[string]string, size int) (map[string]string, int, error) {
+	if len(vars) == 0 {
+		return nil, 0, errors.New("no printenv output found")
+	}
+	return vars, size, nil
+}
+
+// ImportConsoleLog sets the variables read from a console log with
+// ReadConsoleLog.
+func (env *Env) ImportConsoleLog(r io.Reader, opts ConsoleOptions) error {
+	vars, _, err := ReadConsoleLog(r, opts)
+	if err != nil {
+		return err
+	}
+	env.load()
+	for name, value := range vars {
+		env.recordUndo(name)
+		env.store(name, value)
+	}
+	return nil
+}
+
+// NewConsoleLogEnv returns an environment holding the variables of a
+// console log that is only kept in memory. A size of zero uses the
+// size shown by printenv.
+func NewConsoleLogEnv(r io.Reader, size int, opts ConsoleOptions) (*Env, error) {
+	vars, logSize, err := ReadConsoleLog(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		size = logSize
+	}
+	if size == 0 {
+		return nil, errors.New("cannot create environment from console log: unknown size")
+	}
+	return createStorage(NewMemStorage(nil), size, vars, Options{})
+}
@@ -0,0 +1,89 @@
+package uenv
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+const consoleLog = "U-Boot 2023.04 (Apr 03 2023 - 12:00:00 +0000)\r\n" +
+	"DRAM:  1 GiB\r\n" +
+	"Hit any key to stop autoboot:  0 \r\n" +
+	"=> printenv bootcmd\r\n" +
+	"bootcmd=run old\r\n" +
+	"=> printenv\r\n" +
+	"arch=arm\r\n" +
+	"bootargs=console=ttyS0,115200 root=/dev/mmcblk0p2 rootwait\r\n" +
+	"bootcmd=run distro_bootcmd\r\n" +
+	"distro_bootcmd=for target in ${boot_targets}; do run bootcmd_${target}; \r\n" +
+	"done\r\n" +
+	"\r\n" +
+	"Environment size: 145/16380 bytes\r\n" +
+	"=> reset\r\n" +
+	"foo=bar\r\n"
+
+func (u *uenvTestSuite) TestReadConsoleLog(c *C) {
+	vars, size, err := ReadConsoleLog(strings.NewReader(consoleLog), ConsoleOptions{})
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, 16384)
+	c.Check(vars, DeepEquals, map[string]string{
+		"arch":           "arm",
+		"bootargs":       "console=ttyS0,115200 root=/dev/mmcblk0p2 rootwait",
+		"bootcmd":        "run distro_bootcmd",
+		"distro_bootcmd": "for target in ${boot_targets}; do run bootcmd_${target}; done",
+	})
+}
+
+func (u *uenvTestSuite) TestReadConsoleLogWrapWidth(c *C) {
+	log := "bootargs=console=ttyS0,115200 \n" +
+		"root=/dev/mmcblk0p2\n" +
+		"bootdelay=3\n"
+	vars, size, err := ReadConsoleLog(strings.NewReader(log), ConsoleOptions{})
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, 0)
+	c.Check(vars, HasLen, 3)
+
+	// with the width known wrapped lines that look like variables
+	// are joined too
+	vars, _, err = ReadConsoleLog(strings.NewReader(log), ConsoleOptions{WrapWidth: 30})
+	c.Assert(err, IsNil)
+	c.Check(vars, DeepEquals, map[string]string{
+		"bootargs":  "console=ttyS0,115200 root=/dev/mmcblk0p2",
+		"bootdelay": "3",
+	})
+}
+
+func (u *uenvTestSuite) TestReadConsoleLogPromptLikeValues(c *C) {
+	log := "=> printenv\n" +
+		"bootcmd=run#\n" +
+		"foo=a>\n" +
+		"prompt=U-Boot> \n" +
+		"=> \n"
+	vars, _, err := ReadConsoleLog(strings.NewReader(log), ConsoleOptions{})
+	c.Assert(err, IsNil)
+	c.Check(vars, DeepEquals, map[string]string{
+		"bootcmd": "run#",
+		"foo":     "a>",
+		"prompt":  "U-Boot> ",
+	})
+}
+
+func (u *uenvTestSuite) TestReadConsoleLogEmpty(c *C) {
+	_, _, err := ReadConsoleLog(strings.NewReader("=> printenv\n=> \n"), ConsoleOptions{})
+	c.Check(err, ErrorMatches, "no printenv output found")
+}
+
+func (u *uenvTestSuite) TestNewConsoleLogEnv(c *C) {
+	env, err := NewConsoleLogEnv(strings.NewReader(consoleLog), 0, ConsoleOptions{})
+	c.Assert(err, IsNil)
+	c.Check(env.Size(), Equals, 16384)
+	c.Check(env.Get("arch"), Equals, "arm")
+
+	_, err = NewConsoleLogEnv(strings.NewReader("foo=bar\n"), 0, ConsoleOptions{})
+	c.Check(err, ErrorMatches, "cannot create environment from console log: unknown size")
+
+	env, err = Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	c.Assert(env.ImportConsoleLog(strings.NewReader("foo=bar\n"), ConsoleOptions{}), IsNil)
+	c.Check(env.String(), Equals, "foo=bar\n")
+}
@@ -0,0 +1,73 @@
+package uenv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrCorruptionNotAllowed is returned by SaveCorrupted unless
+// Options.AllowCorruption is set.
+var ErrCorruptionNotAllowed = errors.New("writing corrupted environments is not enabled")
+
+// Corruption describes how SaveCorrupted damages the written image.
+type Corruption struct {
+	// BadCRC stores a CRC that does not match the payload.
+	BadCRC bool
+	// SetFlags makes the flags byte Flags.
+	SetFlags bool
+	Flags    byte
+	// Truncate only writes the first Truncate bytes if not zero, the
+	// rest of the image is left erased (0xff) like after an
+	// interrupted flash write.
+	Truncate int
+	// Copy selects the copy of a redundant environment to write.
+	Copy int
+}
+
+// SaveCorrupted writes the environment with deliberate damage, so that
+// recovery logic can be tested end-to-end on real storage. It needs
+// Options.AllowCorruption. The environment should be reopened
+// afterwards.
+func (env *Env) SaveCorrupted(corruption Corruption) error {
+	if !env.opts.AllowCorruption {
+		return ErrCorruptionNotAllowed
+	}
+	if corruption.Truncate < 0 || corruption.Truncate > env.size {
+		return fmt.Errorf("cannot truncate environment of size %d to %d bytes", env.size, corruption.Truncate)
+	}
+
+	img := make([]byte, env.size)
+	if err := env.writeImage(sliceWriter(img)); err != nil {
+		return err
+	}
+	if corruption.BadCRC {
+		copy(img, writeUint32(^readUint32(img)))
+	}
+	if corruption.SetFlags {
+		if env.header <= flagsOffset {
+			return fmt.Errorf("cannot set flags of environment without flags byte")
+		}
+		img[flagsOffset] = corruption.Flags
+	}
+	if corruption.Truncate > 0 {
+		copy(img[corruption.Truncate:], bytes.Repeat([]byte{0xff}, env.size-corruption.Truncate))
+	}
+
+	// redundant environments are written to the selected copy
+	// directly, so that the flags are not replaced
+	storage := env.storage
+	if rs := env.redundantStorage(); rs != nil {
+		if corruption.Copy != 0 && corruption.Copy != 1 {
+			return fmt.Errorf("invalid copy %d", corruption.Copy)
+		}
+		storage = rs.copies[corruption.Copy]
+	} else if corruption.Copy != 0 {
+		return fmt.Errorf("cannot select copy %d of environment without redundancy", corruption.Copy)
+	}
+	return storage.WriteImage(len(img), func(w io.WriterAt) error {
+		_, err := w.WriteAt(img, 0)
+		return err
+	})
+}
@@ -0,0 +1,61 @@
+package uenv
+
+import (
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestSaveCorruptedNotAllowed(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	c.Assert(env.SaveCorrupted(Corruption{BadCRC: true}), Equals, ErrCorruptionNotAllowed)
+	_, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+}
+
+func (u *uenvTestSuite) TestSaveCorrupted(c *C) {
+	opts := Options{AllowCorruption: true}
+	env, err := CreateWithOptions(u.envFile, 64, opts)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+
+	c.Assert(env.SaveCorrupted(Corruption{BadCRC: true}), IsNil)
+	_, err = Open(u.envFile)
+	c.Assert(err, FitsTypeOf, &CRCError{})
+
+	c.Assert(env.SaveCorrupted(Corruption{Truncate: 8}), IsNil)
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(content[4:7]), Equals, "foo")
+	c.Assert(content[8:16], DeepEquals, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	_, err = Open(u.envFile)
+	c.Assert(err, FitsTypeOf, &CRCError{})
+
+	c.Assert(env.SaveCorrupted(Corruption{Truncate: 65}), ErrorMatches, "cannot truncate environment of size 64 to 65 bytes")
+	c.Assert(env.SaveCorrupted(Corruption{Copy: 1}), ErrorMatches, "cannot select copy 1 of environment without redundancy")
+}
+
+func (r *redundantTestSuite) TestSaveCorruptedCopy(c *C) {
+	opts := Options{AllowCorruption: true}
+	env, err := CreateRedundant(r.envFile1, r.envFile2, 64, opts)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	// both copies valid but with the same flags
+	c.Assert(env.SaveCorrupted(Corruption{Copy: 0, SetFlags: true, Flags: 7}), IsNil)
+	c.Assert(env.SaveCorrupted(Corruption{Copy: 1, SetFlags: true, Flags: 7}), IsNil)
+	flags1, flags2 := r.flags(c)
+	c.Assert(flags1, Equals, byte(7))
+	c.Assert(flags2, Equals, byte(7))
+
+	env, err = OpenRedundant(r.envFile1, r.envFile2, Options{})
+	c.Assert(err, IsNil)
+	status, _ := env.RedundancyStatus()
+	c.Assert(status.Healthy(), Equals, false)
+	c.Assert(env.Get("foo"), Equals, "bar")
+
+	env.opts.AllowCorruption = true
+	c.Assert(env.SaveCorrupted(Corruption{Copy: 2}), ErrorMatches, "invalid copy 2")
+}
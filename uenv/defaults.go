@@ -0,0 +1,67 @@
+package uenv
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+)
+
+// ReadDefaults reads a default environment shipped e.g. with embed.FS.
+// The file is either a binary environment image or a text file with
+// "key=value" lines as accepted by Import. size is the size of a
+// binary image and zero for text files.
+func ReadDefaults(fsys fs.FS, name string) (vars map[string]string, size int, err error) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// text files never contain \0
+	if bytes.IndexByte(content, 0) >= 0 {
+		header, err := detectHeader(content, ChecksumCRC32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot read defaults %s: %v", name, err)
+		}
+		data, _, err := parseImage(content, header, 0, ChecksumCRC32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot read defaults %s: %v", name, err)
+		}
+		return data, len(content), nil
+	}
+
+	env := &Env{data: make(map[string]string)}
+	if err := env.Import(bytes.NewReader(content)); err != nil {
+		return nil, 0, fmt.Errorf("cannot read defaults %s: %v", name, err)
+	}
+	return env.data, 0, nil
+}
+
+// CreateStorageWithDefaults writes a new environment holding the
+// defaults read with ReadDefaults to the storage, e.g. to do a
+// factory reset with defaults compiled into the binary:
+//
+//	//go:embed defaults.txt
+//	var defaults embed.FS
+//
+//	env, err := uenv.CreateStorageWithDefaults(storage, 0x4000, defaults, "defaults.txt", opts)
+//
+// A size of zero uses the size of a binary default image.
+func CreateStorageWithDefaults(storage Storage, size int, fsys fs.FS, name string, opts Options) (*Env, error) {
+	vars, defaultSize, err := ReadDefaults(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		if defaultSize == 0 {
+			return nil, fmt.Errorf("cannot create environment from %s: size needed for text defaults", name)
+		}
+		size = defaultSize
+	}
+	return createStorage(storage, size, vars, opts)
+}
+
+// NewDefaultEnv returns an environment holding the defaults that is
+// only kept in memory, see CreateStorageWithDefaults.
+func NewDefaultEnv(fsys fs.FS, name string, size int) (*Env, error) {
+	return CreateStorageWithDefaults(NewMemStorage(nil), size, fsys, name, Options{})
+}
@@ -0,0 +1,43 @@
+package uenv
+
+import (
+	"testing/fstest"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestDefaultsText(c *C) {
+	fsys := fstest.MapFS{"defaults.txt": &fstest.MapFile{Data: []byte("# factory\nbootdelay=3\nbootcmd=run distro_bootcmd\n")}}
+
+	env, err := NewDefaultEnv(fsys, "defaults.txt", 4096)
+	c.Assert(err, IsNil)
+	c.Assert(env.Size(), Equals, 4096)
+	c.Assert(env.String(), Equals, "bootcmd=run distro_bootcmd\nbootdelay=3\n")
+
+	_, err = NewDefaultEnv(fsys, "defaults.txt", 0)
+	c.Assert(err, ErrorMatches, "cannot create environment from defaults.txt: size needed for text defaults")
+	_, err = NewDefaultEnv(fsys, "defaults.txt", 16)
+	c.Assert(err, ErrorMatches, "environment too big: .*")
+}
+
+func (u *uenvTestSuite) TestDefaultsBinary(c *C) {
+	env, err := NewEnv(128)
+	c.Assert(err, IsNil)
+	env.Set("bootdelay", "0")
+	snap, err := env.Snapshot("")
+	c.Assert(err, IsNil)
+	fsys := fstest.MapFS{"uboot.env": &fstest.MapFile{Data: snap.Image}}
+
+	// the defaults are written to the storage right away
+	env, err = CreateStorageWithDefaults(NewMemStorage(nil), 0, fsys, "uboot.env", Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Size(), Equals, 128)
+	c.Assert(env.Reload(), IsNil)
+	c.Assert(env.String(), Equals, "bootdelay=0\n")
+	env.Set("bootdelay", "5")
+	c.Assert(env.Save(), IsNil)
+
+	snap.Image[20] ^= 0xff
+	_, err = NewDefaultEnv(fsys, "uboot.env", 0)
+	c.Assert(err, ErrorMatches, "cannot read defaults uboot.env: bad CRC: .*")
+}
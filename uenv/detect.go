@@ -0,0 +1,99 @@
+package uenv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DeviceTreeDir is where the kernel exposes the device tree the board
+// was booted with
+var DeviceTreeDir = "/proc/device-tree"
+
+// readDeviceTree returns the model and the compatible strings of the
+// running board, the most specific compatible first
+func readDeviceTree() (model string, compatible []string, err error) {
+	content, err := os.ReadFile(filepath.Join(DeviceTreeDir, "model"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, err
+	}
+	model = string(bytes.TrimRight(content, "\x00"))
+
+	content, err = os.ReadFile(filepath.Join(DeviceTreeDir, "compatible"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, err
+	}
+	// compatible is a list of NUL terminated strings
+	for _, s := range strings.Split(string(content), "\x00") {
+		if s != "" {
+			compatible = append(compatible, s)
+		}
+	}
+	if model == "" && len(compatible) == 0 {
+		return "", nil, fmt.Errorf("cannot detect board: no device tree in %s", DeviceTreeDir)
+	}
+	return model, compatible, nil
+}
+
+// MatchBoard returns the profile for a board with the given device
+// tree model and compatible strings. The compatible strings are tried
+// in order, so the most specific one should come first like in the
+// device tree. If none matches, the profile with the longest of its
+// Models that the model starts with is used.
+func MatchBoard(model string, compatible []string) (*BoardProfile, error) {
+	boardProfilesMu.RLock()
+	defer boardProfilesMu.RUnlock()
+
+	// iterate in name order to get the same profile every time if
+	// more than one claims a compatible
+	names := make([]string, 0, len(boardProfiles))
+	for name := range boardProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, c := range compatible {
+		for _, name := range names {
+			if containsString(boardProfiles[name].Compatible, c) {
+				return boardProfiles[name], nil
+			}
+		}
+	}
+	var best *BoardProfile
+	var bestLen int
+	for _, name := range names {
+		for _, prefix := range boardProfiles[name].Models {
+			if strings.HasPrefix(model, prefix) && len(prefix) > bestLen {
+				best, bestLen = boardProfiles[name], len(prefix)
+			}
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no board profile for %q (%s)", model, strings.Join(compatible, ", "))
+	}
+	return best, nil
+}
+
+// DetectBoard returns the profile of the board it runs on, found by
+// the model and compatible strings of the device tree in
+// DeviceTreeDir.
+func DetectBoard() (*BoardProfile, error) {
+	model, compatible, err := readDeviceTree()
+	if err != nil {
+		return nil, err
+	}
+	return MatchBoard(model, compatible)
+}
+
+// OpenDetectedBoard opens the environment of the board it runs on, see
+// DetectBoard.
+func OpenDetectedBoard(opts Options) (*Env, error) {
+	profile, err := DetectBoard()
+	if err != nil {
+		return nil, err
+	}
+	return OpenBoard(profile.Name, opts)
+}
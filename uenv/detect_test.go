@@ -0,0 +1,91 @@
+package uenv
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) mockDeviceTree(c *C, model, compatible string) (restore func()) {
+	old := DeviceTreeDir
+	DeviceTreeDir = c.MkDir()
+	if model != "" {
+		c.Assert(os.WriteFile(filepath.Join(DeviceTreeDir, "model"), []byte(model+"\x00"), 0644), IsNil)
+	}
+	if compatible != "" {
+		c.Assert(os.WriteFile(filepath.Join(DeviceTreeDir, "compatible"), []byte(compatible), 0644), IsNil)
+	}
+	return func() { DeviceTreeDir = old }
+}
+
+func (u *uenvTestSuite) TestMatchBoard(c *C) {
+	for _, t := range []struct {
+		model      string
+		compatible []string
+		board      string
+	}{
+		{"TI AM335x BeagleBone Black", []string{"ti,am335x-bone-black", "ti,am335x-bone", "ti,am33xx"}, "beaglebone-black"},
+		{"Pine64 RockPro64 v2.1", []string{"pine64,rockpro64-v2.1", "pine64,rockpro64", "rockchip,rk3399"}, "rk3399"},
+		{"Xunlong Orange Pi PC", []string{"xunlong,orangepi-pc", "allwinner,sun8i-h3"}, "sunxi"},
+		// the model is used without a matching compatible
+		{"Raspberry Pi Compute Module 4 Rev 1.1", []string{"raspberrypi,cm4-custom"}, "rpi-cm4"},
+		{"NXP i.MX8MM EVK board", nil, "imx8mm-evk"},
+	} {
+		profile, err := MatchBoard(t.model, t.compatible)
+		c.Assert(err, IsNil, Commentf("model %q", t.model))
+		c.Check(profile.Name, Equals, t.board)
+	}
+
+	_, err := MatchBoard("Acme Toaster", []string{"acme,toaster"})
+	c.Check(err, ErrorMatches, `no board profile for "Acme Toaster" \(acme,toaster\)`)
+}
+
+func (u *uenvTestSuite) TestMatchBoardMostSpecific(c *C) {
+	boardProfiles["rockpro64"] = &BoardProfile{
+		Name:       "rockpro64",
+		Config:     Config{Size: 0x8000, Devices: []DeviceConfig{{Path: "/dev/mmcblk1", Offset: 0x3f8000}}},
+		Compatible: []string{"pine64,rockpro64"},
+	}
+	defer delete(boardProfiles, "rockpro64")
+
+	profile, err := MatchBoard("", []string{"pine64,rockpro64-v2.1", "pine64,rockpro64", "rockchip,rk3399"})
+	c.Assert(err, IsNil)
+	c.Check(profile.Name, Equals, "rockpro64")
+}
+
+func (u *uenvTestSuite) TestDetectBoard(c *C) {
+	defer u.mockDeviceTree(c, "Freescale i.MX6 Quad SABRE Smart Device Board", "fsl,imx6q-sabresd\x00fsl,imx6q\x00")()
+
+	profile, err := DetectBoard()
+	c.Assert(err, IsNil)
+	c.Check(profile.Name, Equals, "imx6q-sabresd")
+}
+
+func (u *uenvTestSuite) TestDetectBoardNoDeviceTree(c *C) {
+	defer u.mockDeviceTree(c, "", "")()
+
+	_, err := DetectBoard()
+	c.Check(err, ErrorMatches, "cannot detect board: no device tree in .*")
+}
+
+func (u *uenvTestSuite) TestOpenDetectedBoard(c *C) {
+	defer u.mockDeviceTree(c, "Acme Gateway", "acme,gateway\x00")()
+
+	fname := filepath.Join(c.MkDir(), "uboot.env")
+	env, err := Create(fname, 0x4000)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	boardProfiles["acme-gateway"] = &BoardProfile{
+		Name:       "acme-gateway",
+		Config:     Config{Size: 0x4000, Devices: []DeviceConfig{{Path: fname}}},
+		Compatible: []string{"acme,gateway"},
+	}
+	defer delete(boardProfiles, "acme-gateway")
+
+	env, err = OpenDetectedBoard(Options{})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+}
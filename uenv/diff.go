@@ -0,0 +1,40 @@
+package uenv
+
+import (
+	"sort"
+)
+
+// Change describes a variable that differs between two environments.
+// An empty OldValue means the variable was added, an empty NewValue
+// that it was removed.
+type Change struct {
+	Name     string `json:"name"`
+	OldValue string `json:"old,omitempty"`
+	NewValue string `json:"new,omitempty"`
+}
+
+// Diff returns the changes that turn the variables of old into the
+// ones of new, sorted by name.
+func Diff(old, new *Env) []Change {
+	old.load()
+	new.load()
+	return diffData(old.data, new.data)
+}
+
+func diffData(old, new map[string]string) []Change {
+	var changes []Change
+	for k, v := range old {
+		if nv := new[k]; nv != v {
+			changes = append(changes, Change{Name: k, OldValue: v, NewValue: nv})
+		}
+	}
+	for k, v := range new {
+		if _, ok := old[k]; !ok {
+			changes = append(changes, Change{Name: k, NewValue: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
@@ -0,0 +1,42 @@
+package uenv
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestRange(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("c", "3")
+	env.Set("a", "1")
+	env.Set("b", "2")
+
+	var seen []string
+	env.Range(func(key, value string) bool {
+		seen = append(seen, key+"="+value)
+		return key != "b"
+	})
+	c.Assert(seen, DeepEquals, []string{"a=1", "b=2"})
+}
+
+func (u *uenvTestSuite) TestDiff(c *C) {
+	old, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	old.Set("same", "x")
+	old.Set("changed", "1")
+	old.Set("removed", "y")
+	new, err := Create(filepath.Join(c.MkDir(), "new.env"), 64)
+	c.Assert(err, IsNil)
+	new.Set("same", "x")
+	new.Set("changed", "2")
+	new.Set("added", "z")
+
+	c.Assert(Diff(old, new), DeepEquals, []Change{
+		{Name: "added", NewValue: "z"},
+		{Name: "changed", OldValue: "1", NewValue: "2"},
+		{Name: "removed", OldValue: "y"},
+	})
+	c.Assert(Diff(old, old), HasLen, 0)
+}
@@ -0,0 +1,33 @@
+package uenv
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// directBlockSize is the granularity O_DIRECT transfers must have
+const directBlockSize = 512
+
+// directAlign is the alignment used for O_DIRECT buffers, it is large
+// enough for devices with 4k logical blocks
+const directAlign = 4096
+
+func openDirect(fname string, flag int) (*os.File, error) {
+	return os.OpenFile(fname, flag|syscall.O_DIRECT, 0)
+}
+
+// alignedBuffer returns a buffer of the given size that is suitable
+// for O_DIRECT transfers
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directAlign)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % directAlign); rem != 0 {
+		off = directAlign - rem
+	}
+	return buf[off : off+size]
+}
+
+func fdatasync(f *os.File) error {
+	return syscall.Fdatasync(int(f.Fd()))
+}
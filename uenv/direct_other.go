@@ -0,0 +1,22 @@
+//go:build !linux
+
+package uenv
+
+import (
+	"fmt"
+	"os"
+)
+
+const directBlockSize = 512
+
+func openDirect(fname string, flag int) (*os.File, error) {
+	return nil, fmt.Errorf("cannot open %s: O_DIRECT is not supported on this platform", fname)
+}
+
+func alignedBuffer(size int) []byte {
+	return make([]byte, size)
+}
+
+func fdatasync(f *os.File) error {
+	return f.Sync()
+}
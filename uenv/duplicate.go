@@ -0,0 +1,61 @@
+package uenv
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DuplicateKey describes a variable that is stored more than once in
+// the payload, e.g. because of corruption or buggy tooling. Like U-Boot
+// the last occurrence wins.
+type DuplicateKey struct {
+	Name string
+	// Offsets are the payload offsets of all occurrences in order.
+	Offsets []int
+	// Values are the values of the occurrences, the last one is the
+	// one returned by Get.
+	Values []string
+}
+
+func (d *DuplicateKey) String() string {
+	offsets := make([]string, len(d.Offsets))
+	for i, off := range d.Offsets {
+		offsets[i] = fmt.Sprintf("0x%x", off)
+	}
+	return fmt.Sprintf("variable %q is stored %d times at offsets %s", d.Name, len(d.Offsets), strings.Join(offsets, ", "))
+}
+
+// addDuplicate records another occurrence of key at pos, the first
+// occurrence is searched in data when the key is seen twice
+func addDuplicate(duplicates []*DuplicateKey, data []byte, key, old string, pos int, value string) []*DuplicateKey {
+	for _, d := range duplicates {
+		if d.Name == key {
+			d.Offsets = append(d.Offsets, pos)
+			d.Values = append(d.Values, value)
+			return duplicates
+		}
+	}
+	first := 0
+	prefix := []byte(key + "=")
+	for off := 0; off < pos; {
+		if bytes.HasPrefix(data[off:], prefix) {
+			first = off
+			break
+		}
+		i := bytes.IndexByte(data[off:], 0)
+		if i < 0 {
+			break
+		}
+		off += i + 1
+	}
+	return append(duplicates, &DuplicateKey{Name: key, Offsets: []int{first, pos}, Values: []string{old, value}})
+}
+
+// Duplicates returns the variables that were stored more than once
+// when the environment was last read. Saving the environment stores
+// every variable once.
+func (env *Env) Duplicates() []*DuplicateKey {
+	env.load()
+	return env.duplicates
+}
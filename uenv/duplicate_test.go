@@ -0,0 +1,33 @@
+package uenv
+
+import (
+	"hash/crc32"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestDuplicates(c *C) {
+	payload := []byte("a=1\x00b=2\x00a=3\x00c=4\x00a=5\x00b=6\x00\x00")
+	img := make([]byte, headerSize+64)
+	copy(img[headerSize:], payload)
+	copy(img, writeUint32(crc32.ChecksumIEEE(img[headerSize:])))
+
+	for _, flags := range []OpenFlags{0, OpenLazy} {
+		env, err := OpenStorage(NewMemStorage(img), Options{Flags: flags})
+		c.Assert(err, IsNil)
+		c.Check(env.Get("a"), Equals, "5")
+		c.Check(env.Get("b"), Equals, "6")
+		c.Check(env.Duplicates(), DeepEquals, []*DuplicateKey{
+			{Name: "a", Offsets: []int{0, 8, 16}, Values: []string{"1", "3", "5"}},
+			{Name: "b", Offsets: []int{4, 20}, Values: []string{"2", "6"}},
+		})
+		c.Check(env.Duplicates()[1].String(), Equals, `variable "b" is stored 2 times at offsets 0x4, 0x14`)
+	}
+
+	env, err := OpenStorage(NewMemStorage(append([]byte(nil), img...)), Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Reload(), IsNil)
+	c.Check(env.Duplicates(), HasLen, 0)
+	c.Check(env.String(), Equals, "a=5\nb=6\nc=4\n")
+}
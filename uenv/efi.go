@@ -0,0 +1,107 @@
+package uenv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// EFIVarsDir is the mount point of efivarfs
+var EFIVarsDir = "/sys/firmware/efi/efivars"
+
+// EFIVariable names the EFI variable an environment is stored in
+type EFIVariable struct {
+	Name string
+	// GUID is the vendor GUID in its usual text form, e.g.
+	// "8a12d51e-6f1d-4f4a-8c2e-1e9a7b2e0d3c"
+	GUID string
+}
+
+// EFI variable attributes, see the UEFI specification
+const (
+	EFIVariableNonVolatile       = 0x1
+	EFIVariableBootServiceAccess = 0x2
+	EFIVariableRuntimeAccess     = 0x4
+)
+
+// efiDefaultAttributes are used when creating a new variable
+const efiDefaultAttributes = EFIVariableNonVolatile | EFIVariableBootServiceAccess | EFIVariableRuntimeAccess
+
+// efiAttrSize is the size of the attributes efivarfs puts in front of
+// the variable data
+const efiAttrSize = 4
+
+func (v EFIVariable) path() string {
+	return filepath.Join(EFIVarsDir, v.Name+"-"+v.GUID)
+}
+
+// OpenEFI opens an environment stored in an EFI variable, as written
+// by U-Boot with CONFIG_ENV_IS_IN_EFI
+func OpenEFI(v EFIVariable, opts Options) (*Env, error) {
+	return OpenStorage(newEFIStorage(v, opts), opts)
+}
+
+// CreateEFI creates a new empty environment of the given size in an
+// EFI variable
+func CreateEFI(v EFIVariable, size int, opts Options) (*Env, error) {
+	return CreateStorage(newEFIStorage(v, opts), size, opts)
+}
+
+// efiStorage keeps the environment in an EFI variable through efivarfs
+type efiStorage struct {
+	path    string
+	maxSize int
+	// attrs are the attributes of the variable, they are kept when
+	// the variable is written
+	attrs uint32
+}
+
+func newEFIStorage(v EFIVariable, opts Options) *efiStorage {
+	return &efiStorage{
+		path:    v.path(),
+		maxSize: opts.maxSize(),
+		attrs:   efiDefaultAttributes,
+	}
+}
+
+func (s *efiStorage) ReadImage() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < efiAttrSize {
+		return nil, fmt.Errorf("cannot read %s: short EFI variable of %d bytes", s.path, len(data))
+	}
+	if len(data)-efiAttrSize > s.maxSize {
+		return nil, fmt.Errorf("cannot read %s: larger than the maximum env size of %d bytes", s.path, s.maxSize)
+	}
+	s.attrs = binary.LittleEndian.Uint32(data)
+	return data[efiAttrSize:], nil
+}
+
+func (s *efiStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	buf := make([]byte, efiAttrSize+size)
+	binary.LittleEndian.PutUint32(buf, s.attrs)
+	if err := fill(sliceWriter(buf[efiAttrSize:])); err != nil {
+		return err
+	}
+
+	// efivarfs marks most variables immutable to protect them from
+	// accidental writes
+	if err := clearImmutable(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot make %s writable: %v", s.path, err)
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	// efivarfs needs the attributes and the data in a single write
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
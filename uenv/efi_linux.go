@@ -0,0 +1,42 @@
+package uenv
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ioctls to get and set the inode flags, _IOR('f', 1, long) and
+// _IOW('f', 2, long)
+const (
+	fsIocGetFlags = 2<<30 | unsafe.Sizeof(uintptr(0))<<16 | 'f'<<8 | 1
+	fsIocSetFlags = 1<<30 | unsafe.Sizeof(uintptr(0))<<16 | 'f'<<8 | 2
+
+	fsImmutableFl = 0x10
+)
+
+// clearImmutable removes the immutable flag from the given file
+func clearImmutable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var flags int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocGetFlags, uintptr(unsafe.Pointer(&flags))); errno != 0 {
+		// filesystems without inode flags cannot have immutable files
+		if errno == syscall.ENOTTY || errno == syscall.EINVAL {
+			return nil
+		}
+		return errno
+	}
+	if flags&fsImmutableFl == 0 {
+		return nil
+	}
+	flags &^= fsImmutableFl
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocSetFlags, uintptr(unsafe.Pointer(&flags))); errno != 0 {
+		return errno
+	}
+	return nil
+}
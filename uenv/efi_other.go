@@ -0,0 +1,8 @@
+//go:build !linux
+
+package uenv
+
+// clearImmutable does nothing, efivarfs only exists on Linux
+func clearImmutable(path string) error {
+	return nil
+}
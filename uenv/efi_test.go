@@ -0,0 +1,62 @@
+package uenv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+var testEFIVariable = EFIVariable{Name: "UBootEnv", GUID: "8a12d51e-6f1d-4f4a-8c2e-1e9a7b2e0d3c"}
+
+func (u *uenvTestSuite) mockEFIVarsDir(c *C) (restore func()) {
+	old := EFIVarsDir
+	EFIVarsDir = c.MkDir()
+	return func() { EFIVarsDir = old }
+}
+
+func (u *uenvTestSuite) TestEFICreateOpen(c *C) {
+	defer u.mockEFIVarsDir(c)()
+
+	env, err := CreateEFI(testEFIVariable, 32, Options{})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := ioutil.ReadFile(filepath.Join(EFIVarsDir, "UBootEnv-8a12d51e-6f1d-4f4a-8c2e-1e9a7b2e0d3c"))
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, efiAttrSize+32)
+	// non volatile, boot service and runtime access
+	c.Assert(content[:efiAttrSize], DeepEquals, []byte{0x07, 0, 0, 0})
+
+	env, err = OpenEFI(testEFIVariable, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "foo=bar\n")
+}
+
+func (u *uenvTestSuite) TestEFIKeepsAttributes(c *C) {
+	defer u.mockEFIVarsDir(c)()
+
+	img := append([]byte{0x03, 0, 0, 0}, validImage([]byte("a=b\x00\x00"))...)
+	path := filepath.Join(EFIVarsDir, "UBootEnv-8a12d51e-6f1d-4f4a-8c2e-1e9a7b2e0d3c")
+	c.Assert(ioutil.WriteFile(path, img, 0644), IsNil)
+
+	env, err := OpenEFI(testEFIVariable, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("a"), Equals, "b")
+	env.Set("a", "c")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(content[:efiAttrSize], DeepEquals, []byte{0x03, 0, 0, 0})
+}
+
+func (u *uenvTestSuite) TestEFIShortVariable(c *C) {
+	defer u.mockEFIVarsDir(c)()
+
+	path := filepath.Join(EFIVarsDir, "UBootEnv-8a12d51e-6f1d-4f4a-8c2e-1e9a7b2e0d3c")
+	c.Assert(ioutil.WriteFile(path, []byte{7, 0}, 0644), IsNil)
+	_, err := OpenEFI(testEFIVariable, Options{})
+	c.Assert(err, ErrorMatches, "cannot read .*: short EFI variable of 2 bytes")
+}
@@ -3,25 +3,89 @@ package uenv
 import (
 	"bufio"
 	"bytes"
+	"crypto/cipher"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
-	"io/ioutil"
+	"log/slog"
 	"os"
 	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
-// FIXME: add config option for that so that the user can select if
-//        he/she wants env with or without flags
-var headerSize = 4
+// MaxSize is the largest environment that is read or created unless
+// Options.MaxSize says otherwise. It protects against allocating huge
+// amounts of memory when e.g. a wrong offset points into a large
+// partition.
+var MaxSize = 16 << 20
+
+// minSize returns the size of the smallest possible environment with
+// the given header size, the header followed by the double \0
+// terminator
+func minSize(header int) int {
+	return header + 2
+}
 
 // Env contains the data of the uboot environment
 type Env struct {
-	fname string
-	size  int
-	data  map[string]string
+	storage Storage
+	size    int
+	data    map[string]string
+	opts    Options
+	// header is the size of the header, headerSize or
+	// redundantHeaderSize
+	header int
+
+	// warnings are the problems skipped when the env was last read
+	// with OpenBestEffort
+	warnings []*ParseError
+	// duplicates are the variables stored more than once when the
+	// env was last read
+	duplicates []*DuplicateKey
+
+	// raw is the payload as read with OpenLazy until the variables
+	// are parsed, data is nil until then
+	raw []byte
+
+	// journaled are the variables as last read or journaled, only
+	// kept with Options.Journal or Options.Audit
+	journaled map[string]string
+	// journalErr is the error of recording the changes of the last
+	// save, see JournalError
+	journalErr error
+
+	// undo are the changes made since the env was read, the last
+	// one at the end
+	undo []mutation
+
+	// aead encrypts the variables selected by Options.Secrets
+	aead cipher.AEAD
+
+	// crc is the checksum found on the storage when the env was
+	// last read or written, haveCRC is false for newly created envs
+	crc     uint32
+	haveCRC bool
+
+	stats stats
+
+	// batchDepth is the number of open batches, batchSave is set
+	// when Save was called during a batch
+	batchDepth int
+	batchSave  bool
+
+	// lastSave is the time Save last wrote the env, for
+	// Options.MinSaveInterval
+	lastSave time.Time
+
+	// lock is held during Update, Reload and Save, so that nested
+	// calls do not lock again
+	lock *Lock
+
+	bw *bufio.Writer
 }
 
 // little endian helpers
@@ -38,18 +102,67 @@ func writeUint32(u uint32) []byte {
 	return buf.Bytes()
 }
 
-// Create a new empty uboot env file with the given size
+// Create a new empty uboot env file with the given size. The file
+// contains a valid empty environment afterwards.
 func Create(fname string, size int) (*Env, error) {
+	return CreateWithOptions(fname, size, Options{})
+}
+
+// CreateWithOptions creates a new empty uboot env file with the given
+// size, using the given options for writing it and for subsequent
+// saves.
+func CreateWithOptions(fname string, size int, opts Options) (*Env, error) {
+	if min := minSize(opts.Header.size()); size < min || size > opts.maxSize() {
+		return nil, fmt.Errorf("invalid env size %d: must be between %d and %d", size, min, opts.maxSize())
+	}
 	f, err := os.Create(fname)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	f.Close()
+
+	return CreateStorage(newStorage(fname, opts), size, opts)
+}
+
+// CreateStorage writes a new empty uboot env of the given size to the
+// given storage.
+func CreateStorage(storage Storage, size int, opts Options) (*Env, error) {
+	return createStorage(storage, size, nil, opts)
+}
+
+// createStorage writes a new env with the given variables to the
+// storage
+func createStorage(storage Storage, size int, vars map[string]string, opts Options) (*Env, error) {
+	if err := opts.checkDirect(); err != nil {
+		return nil, err
+	}
+	header, err := opts.headerFor(storage)
+	if err != nil {
+		return nil, err
+	}
+	if min := minSize(header); size < min || size > opts.maxSize() {
+		return nil, fmt.Errorf("invalid env size %d: must be between %d and %d", size, min, opts.maxSize())
+	}
 
 	env := &Env{
-		fname: fname,
-		size:  size,
-		data:  make(map[string]string),
+		storage: wrapStorage(storage, opts),
+		size:    size,
+		data:    make(map[string]string, len(vars)),
+		opts:    opts,
+		header:  header,
+	}
+	if err := env.setupSecrets(); err != nil {
+		return nil, err
+	}
+	for k, v := range vars {
+		env.store(k, v)
+	}
+	if need, avail := env.payloadSize(), env.size-env.header; need > avail {
+		return nil, fmt.Errorf("environment too big: %d bytes needed, %d available", need, avail)
+	}
+	if err := env.write(); err != nil {
+		env.Close()
+		return nil, err
 	}
 
 	return env, nil
@@ -61,8 +174,155 @@ type OpenFlags int
 const (
 	// OpenBestEffort instructs OpenWithFlags to skip malformed data without returning an error.
 	OpenBestEffort OpenFlags = 1 << iota
+	// OpenLazy verifies the CRC when the environment is read but
+	// parses the variables only when they are first used. Malformed
+	// data is then skipped like with OpenBestEffort.
+	OpenLazy
+)
+
+// WriteStrategy selects how Save puts the environment onto the filesystem.
+type WriteStrategy int
+
+const (
+	// WriteInPlace overwrites the existing file without truncating
+	// it. This minimizes the writes on e.g. FAT partitions but a crash
+	// in the middle of a write leaves a corrupted file behind.
+	WriteInPlace WriteStrategy = iota
+	// WriteRename writes the environment to a temporary file next to
+	// the original one and renames it into place.
+	WriteRename
+	// WriteRenameSyncDir is like WriteRename but also syncs the
+	// directory so that the rename itself is durable.
+	WriteRenameSyncDir
+	// WriteChangedRanges is like WriteInPlace but only writes the
+	// 512 byte sectors of the file that changed, with the header
+	// last. This further reduces the writes on FAT partitions.
+	WriteChangedRanges
 )
 
+// Options alter how an environment is opened and saved.
+type Options struct {
+	// Flags alter how the environment data is parsed.
+	Flags OpenFlags
+	// Checksum selects the CRC of the header, U-Boot's CRC32 by
+	// default.
+	Checksum Checksum
+	// Header selects the layout of the header, by default only the
+	// copies of redundant environments have the flags byte and the
+	// layout of a single copy is detected when it is read.
+	Header HeaderLayout
+	// FlagScheme selects how the copies of a redundant environment
+	// are told apart, FlagsCounter by default.
+	FlagScheme FlagScheme
+	// WriteStrategy selects how Save writes the environment.
+	WriteStrategy WriteStrategy
+	// Mmap maps the environment file into memory instead of reading
+	// it, which makes repeated Reload calls cheap. Saves are written
+	// through the mapping in place, WriteStrategy is ignored.
+	Mmap bool
+	// Sync selects how saved data is flushed to the storage.
+	Sync SyncMode
+	// Direct writes in place with O_DIRECT, bypassing the page cache,
+	// where the platform supports it. The env size must be a multiple
+	// of 512 bytes. Opening fails if it is combined with Mmap or a
+	// WriteStrategy other than WriteInPlace.
+	Direct bool
+	// Retry makes reads and writes of the storage retry transient
+	// errors if set.
+	Retry *RetryPolicy
+	// MaxSize is the largest environment that is read or created, if
+	// zero the package wide MaxSize is used.
+	MaxSize int
+	// ForceWrite makes Save write the environment even if the storage
+	// already holds identical content.
+	ForceWrite bool
+	// CompareAndSwap makes Save check that the stored environment is
+	// still the one that was read and return ErrConcurrentModification
+	// instead of overwriting changes made by someone else.
+	CompareAndSwap bool
+	// Verify makes Save read the environment back after writing it
+	// and return ErrVerifyFailed if it differs.
+	Verify bool
+	// Timeout makes storage reads and writes that take longer fail
+	// with an error wrapping ErrTimeout if not zero. Useful for hung
+	// devices or network file systems. The operation cannot be
+	// aborted, so a write that timed out may still complete later.
+	// Reads and writes fail until it has finished.
+	Timeout time.Duration
+	// Cache serves reads from memory for a while if set, see
+	// ReadCache.
+	Cache *ReadCache
+	// Coalesce combines reads that arrive at about the same time
+	// into one if set, see ReadCoalescer.
+	Coalesce *ReadCoalescer
+	// AutoRepair makes opening a redundant environment rewrite a
+	// broken copy from the valid one right away.
+	AutoRepair bool
+	// Logger receives debug events about reads, saves, erases and
+	// retries if set.
+	Logger *slog.Logger
+	// Tracer receives the timing of the read, serialize, erase,
+	// write and sync phases if set.
+	Tracer Tracer
+	// Journal is a file that every save appends its changes to if
+	// set, giving a history of the changes of the environment. Saves
+	// do not fail if it cannot be written, see Env.JournalError.
+	Journal string
+	// Audit receives a record for every variable changed by a save
+	// if set, see AuditRecord. Saves do not fail if the sink fails,
+	// see Env.JournalError.
+	Audit AuditSink
+	// Secrets selects variables that are stored encrypted if set.
+	Secrets *Secrets
+	// Redact are path.Match patterns of variables like passwords
+	// whose values are shown as Redacted by String, in logs and in
+	// the journal. The stored values are not affected.
+	Redact []string
+	// Limits makes Save fail with a *LimitError for variables the
+	// board's U-Boot cannot handle if set.
+	Limits *Limits
+	// AllowCorruption enables SaveCorrupted, which is only meant
+	// for testing recovery logic.
+	AllowCorruption bool
+	// MirrorOnSave makes Save of a redundant environment also
+	// rewrite the other copy, so that both copies hold the current
+	// variables and the redundancy does not fall back to an old
+	// state if the active copy breaks later.
+	MirrorOnSave bool
+	// Lockfile is the file Reload, Save and Update lock, e.g. the
+	// lock file of the U-Boot userspace tools, so that other
+	// processes never see a half written environment. Environment
+	// files are locked with a ".lock" file next to them if it is not
+	// set.
+	Lockfile string
+	// NoLock makes Reload and Save not take the lock, e.g. for
+	// processes that do their own locking. Update always locks.
+	NoLock bool
+	// Progress is called while the storage is read and while the
+	// environment is written, so that reads of large environments
+	// from slow devices can show progress. Reads report progress for
+	// files, devices and file descriptors.
+	Progress func(Progress)
+	// MinSaveInterval protects the flash from callers that save in
+	// a loop. Save fails with ErrSaveRateLimited if the environment
+	// was written by this Env less than the interval ago if set.
+	// Saves that do not change anything are not limited.
+	MinSaveInterval time.Duration
+	// DelaySaves makes Save wait until MinSaveInterval passed instead
+	// of failing.
+	DelaySaves bool
+	// Migrations are applied when the environment is opened, see
+	// Env.Migrate.
+	Migrations []Migration
+}
+
+func (opts *Options) maxSize() int {
+	if opts.MaxSize > 0 {
+		return opts.MaxSize
+	}
+	return MaxSize
+}
+
 // Open opens a existing uboot env file
 func Open(fname string) (*Env, error) {
 	return OpenWithFlags(fname, OpenFlags(0))
@@ -70,74 +330,317 @@ func Open(fname string) (*Env, error) {
 
 // OpenWithFlags opens a existing uboot env file, passing additional flags.
 func OpenWithFlags(fname string, flags OpenFlags) (*Env, error) {
-	f, err := os.Open(fname)
+	return OpenWithOptions(fname, Options{Flags: flags})
+}
+
+// OpenWithOptions opens a existing uboot env file with the given options.
+func OpenWithOptions(fname string, opts Options) (*Env, error) {
+	return openWithOptions(fname, opts, nil)
+}
+
+// openWithOptions opens the env file, lock is the lock the caller
+// already holds or nil
+func openWithOptions(fname string, opts Options, lock *Lock) (*Env, error) {
+	storage := newStorage(fname, opts)
+	env, err := openStorage(storage, opts, lock)
 	if err != nil {
+		if c, ok := storage.(io.Closer); ok {
+			c.Close()
+		}
 		return nil, err
 	}
-	defer f.Close()
+	return env, nil
+}
+
+// OpenStorage opens the uboot env kept on the given storage.
+func OpenStorage(storage Storage, opts Options) (*Env, error) {
+	return openStorage(storage, opts, nil)
+}
 
-	contentWithHeader, err := ioutil.ReadAll(f)
+func openStorage(storage Storage, opts Options, lock *Lock) (*Env, error) {
+	if err := opts.checkDirect(); err != nil {
+		return nil, err
+	}
+	header, err := opts.headerFor(storage)
 	if err != nil {
 		return nil, err
 	}
-	crc := readUint32(contentWithHeader)
+	env := &Env{
+		storage: wrapStorage(storage, opts),
+		opts:    opts,
+		header:  header,
+		lock:    lock,
+	}
+	if err := env.setupSecrets(); err != nil {
+		return nil, err
+	}
+	if err := env.Reload(); err != nil {
+		return nil, err
+	}
+	if len(opts.Migrations) > 0 {
+		if _, _, err := env.Migrate(opts.Migrations); err != nil {
+			return nil, err
+		}
+	}
 
-	payload := contentWithHeader[headerSize:]
-	actualCRC := crc32.ChecksumIEEE(payload)
-	if crc != actualCRC {
-		return nil, fmt.Errorf("bad CRC: %v != %v", crc, actualCRC)
+	return env, nil
+}
+
+// Reload re-reads the environment from its storage, discarding all
+// changes that were not saved.
+func (env *Env) Reload() error {
+	return env.withIOLock(env.reload)
+}
+
+func (env *Env) reload() error {
+	start := time.Now()
+	contentWithHeader, err := env.storage.ReadImage()
+	trace(env.opts.Tracer, PhaseRead, start, len(contentWithHeader), err)
+	if err != nil {
+		env.opts.logger().Debug("cannot read environment", "err", err)
+		return err
+	}
+	if len(contentWithHeader) > env.opts.maxSize() {
+		return fmt.Errorf("env too large: %d bytes, the maximum is %d", len(contentWithHeader), env.opts.maxSize())
+	}
+	var data map[string]string
+	var warnings []*ParseError
+	var duplicates []*DuplicateKey
+	var raw []byte
+	err = verifyImage(contentWithHeader, env.header, env.opts.Checksum)
+	if err != nil && env.detectsHeader() {
+		// keep the layout of single copies written with the flags
+		// byte, e.g. by snapd
+		if header, derr := detectHeader(contentWithHeader, env.opts.Checksum); derr == nil {
+			env.header, err = header, nil
+		}
+	}
+	if err == nil && env.opts.Flags&OpenLazy != 0 {
+		// storages may reuse the slice for the next read
+		raw = append([]byte(nil), contentWithHeader[env.header:]...)
+	} else if err == nil {
+		data, warnings, duplicates, err = parsePayload(contentWithHeader[env.header:], env.opts.Flags)
+	}
+	if err != nil {
+		env.opts.logger().Debug("cannot parse environment", "size", len(contentWithHeader), "err", err)
+		return err
+	}
+
+	env.size = len(contentWithHeader)
+	env.data = data
+	env.raw = raw
+	env.warnings = warnings
+	env.duplicates = duplicates
+	env.crc = env.opts.Checksum.readCRC(contentWithHeader)
+	env.haveCRC = true
+	env.undo = nil
+	env.snapshot()
+	env.opts.logger().Debug("read environment", "size", env.size, "crc", fmt.Sprintf("%08x", env.crc), "vars", len(env.data))
+
+	return nil
+}
+
+// load parses the variables of an environment opened with OpenLazy
+func (env *Env) load() {
+	if env.raw == nil {
+		return
+	}
+	data, warnings, duplicates, err := parsePayload(env.raw, env.opts.Flags|OpenBestEffort)
+	if err != nil {
+		// best effort parsing does not fail
+		panic(err)
+	}
+	env.data = data
+	env.warnings = warnings
+	env.duplicates = duplicates
+	env.raw = nil
+}
+
+// RawPayload returns the payload of the environment image, which is
+// everything after the header: the key=value pairs, the terminator and
+// the padding. With OpenLazy it is the payload as read as long as no
+// variables were used, so that environments can be copied and verified
+// without parsing them. The returned slice must not be modified.
+func (env *Env) RawPayload() []byte {
+	if env.raw != nil {
+		return env.raw
+	}
+	var buf bytes.Buffer
+	env.writePayload(&buf)
+	return buf.Bytes()
+}
+
+// ParseWarnings returns the malformed data that was skipped when the
+// environment was last read with OpenBestEffort.
+func (env *Env) ParseWarnings() []*ParseError {
+	env.load()
+	return env.warnings
+}
+
+// Close releases the resources held by the storage of the environment.
+func (env *Env) Close() error {
+	if c, ok := env.storage.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// parseImage verifies the CRC of the given environment image and
+// parses its payload. The image comes from storage that may have been
+// tampered with, so nothing in it is trusted.
+//
+// With OpenBestEffort malformed data is skipped and returned as
+// warnings instead of failing.
+func parseImage(contentWithHeader []byte, header int, flags OpenFlags, sum Checksum) (map[string]string, []*ParseError, error) {
+	if err := verifyImage(contentWithHeader, header, sum); err != nil {
+		return nil, nil, err
 	}
+	data, warnings, _, err := parsePayload(contentWithHeader[header:], flags)
+	return data, warnings, err
+}
+
+// parsePayload parses the payload of an image that was verified
+func parsePayload(payload []byte, flags OpenFlags) (map[string]string, []*ParseError, []*DuplicateKey, error) {
+	var warnings []*ParseError
 	eof := bytes.Index(payload, []byte{0, 0})
+	if eof < 0 {
+		perr := newParseError(payload, len(payload), "cannot find end of environment marker")
+		if flags&OpenBestEffort == 0 {
+			return nil, nil, nil, perr
+		}
+		warnings = append(warnings, perr)
+		eof = len(payload)
+	}
 
-	data, err := parseData(payload[:eof], flags)
+	data, dataWarnings, duplicates, err := parseData(payload, eof, flags)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
+	return data, append(warnings, dataWarnings...), duplicates, nil
+}
 
-	env := &Env{
-		fname: fname,
-		size:  len(contentWithHeader),
-		data:  data,
+// verifyImage checks that the image with a header of the given size
+// has a sane size and a valid CRC
+func verifyImage(contentWithHeader []byte, header int, sum Checksum) error {
+	if len(contentWithHeader) < minSize(header) {
+		return fmt.Errorf("env too small: %d bytes, need at least %d", len(contentWithHeader), minSize(header))
 	}
+	if fill, ok := uniformFill(contentWithHeader); ok && (fill == 0xff || fill == 0) {
+		return &UninitializedError{Fill: fill}
+	}
+	crc := sum.readCRC(contentWithHeader)
 
-	return env, nil
+	payload := contentWithHeader[header:]
+	actualCRC := crc32.Checksum(payload, sum.table())
+	if crc != actualCRC {
+		return &CRCError{Stored: crc, Actual: actualCRC}
+	}
+	return nil
+}
+
+// uniformFill returns the byte the data consists of, ok is false if
+// the data contains different bytes
+func uniformFill(data []byte) (fill byte, ok bool) {
+	if len(data) == 0 {
+		return 0, false
+	}
+	for _, b := range data {
+		if b != data[0] {
+			return 0, false
+		}
+	}
+	return data[0], true
+}
+
+// UninitializedError is returned when the environment region was
+// never written, it consists only of erased flash (0xff) or of zeros.
+// Such an environment needs to be created rather than repaired.
+type UninitializedError struct {
+	// Fill is the byte the region is filled with.
+	Fill byte
+}
+
+func (e *UninitializedError) Error() string {
+	return fmt.Sprintf("environment is not initialized: all bytes are 0x%02x", e.Fill)
+}
+
+// CRCError is returned when the checksum in the header does not match
+// the payload, i.e. the environment is corrupted.
+type CRCError struct {
+	// Stored is the checksum found in the header.
+	Stored uint32
+	// Actual is the checksum of the payload.
+	Actual uint32
+}
+
+func (e *CRCError) Error() string {
+	return fmt.Sprintf("bad CRC: %v != %v", e.Stored, e.Actual)
 }
 
-func parseData(data []byte, flags OpenFlags) (map[string]string, error) {
+// parseData parses the key=value pairs in the first eof bytes of the
+// payload, the rest of the payload is only used for error context. The
+// last occurrence of keys that are stored more than once wins.
+func parseData(payload []byte, eof int, flags OpenFlags) (map[string]string, []*ParseError, []*DuplicateKey, error) {
 	out := make(map[string]string)
+	var warnings []*ParseError
+	var duplicates []*DuplicateKey
 
-	for _, envStr := range bytes.Split(data, []byte{0}) {
-		if len(envStr) == 0 || envStr[0] == 0 || envStr[0] == 255 {
+	data := payload[:eof]
+	for pos := 0; len(data) > 0; pos = eof - len(data) {
+		envStr := data
+		data = nil
+		if i := bytes.IndexByte(envStr, 0); i >= 0 {
+			envStr, data = envStr[:i], envStr[i+1:]
+		}
+		if len(envStr) == 0 || envStr[0] == 255 {
 			continue
 		}
-		l := strings.SplitN(string(envStr), "=", 2)
-		if len(l) != 2 {
+		i := bytes.IndexByte(envStr, '=')
+		if i <= 0 {
+			perr := newParseError(payload, pos, "cannot parse line %q as key=value pair", envStr)
 			if flags&OpenBestEffort == OpenBestEffort {
+				warnings = append(warnings, perr)
 				continue
 			}
-			return nil, fmt.Errorf("cannot parse line %q as key=value pair", envStr)
+			return nil, nil, nil, perr
+		}
+		key := string(envStr[:i])
+		value := string(envStr[i+1:])
+		if old, ok := out[key]; ok {
+			duplicates = addDuplicate(duplicates, payload[:eof], key, old, pos, value)
 		}
-		key := l[0]
-		value := l[1]
 		out[key] = value
 	}
 
-	return out, nil
+	return out, warnings, duplicates, nil
 }
 
+// String returns the variables as "key=value" lines, the values of
+// redacted variables are replaced with Redacted.
 func (env *Env) String() string {
 	out := ""
 
 	env.iterEnv(func(key, value string) {
-		out += fmt.Sprintf("%s=%s\n", key, value)
+		out += fmt.Sprintf("%s=%s\n", key, env.displayValue(key, value))
 	})
 
 	return out
 }
 
-// Get the value of the environment variable
+// Get the value of the environment variable. Secret variables are
+// decrypted, Get returns "" if that fails.
 func (env *Env) Get(name string) string {
-	return env.data[name]
+	env.load()
+	value := env.data[name]
+	if !env.isSecret(name) {
+		return value
+	}
+	plain, err := env.decrypt(name, value)
+	if err != nil {
+		env.opts.logger().Debug("cannot get secret variable", "name", name, "err", err)
+		return ""
+	}
+	return plain
 }
 
 // Set an environment name to the given value, if the value is empty
@@ -146,16 +649,41 @@ func (env *Env) Set(name, value string) {
 	if name == "" {
 		panic(fmt.Sprintf("Set() can not be called with empty key for value: %q", value))
 	}
+	env.load()
+	if _, ok := env.data[name]; (!ok && value == "") || (ok && value != "" && env.Get(name) == value) {
+		return
+	}
+	env.recordUndo(name)
 	if value == "" {
 		delete(env.data, name)
 		return
 	}
+	env.store(name, value)
+}
+
+// store sets the variable, encrypting it if it is secret
+func (env *Env) store(name, value string) {
+	if env.isSecret(name) {
+		value = env.encrypt(name, value)
+	}
 	env.data[name] = value
 }
 
+// Range calls f for every variable in sorted order until f returns
+// false
+func (env *Env) Range(f func(key, value string) bool) {
+	done := false
+	env.iterEnv(func(key, value string) {
+		if !done && !f(key, value) {
+			done = true
+		}
+	})
+}
+
 // iterEnv calls the passed function f with key, value for environment
 // vars. The order is guaranteed (unlike just iterating over the map)
 func (env *Env) iterEnv(f func(key, value string)) {
+	env.load()
 	keys := make([]string, 0, len(env.data))
 	for k := range env.data {
 		keys = append(keys, k)
@@ -171,86 +699,335 @@ func (env *Env) iterEnv(f func(key, value string)) {
 	}
 }
 
-// Save will write out the environment data
-func (env *Env) Save() error {
-	w := bytes.NewBuffer(nil)
-	// will panic if the buffer can't grow, all writes to
-	// the buffer will be ok because we sized it correctly
-	w.Grow(env.size - headerSize)
+// Size returns the size of the environment in bytes, including the
+// header
+func (env *Env) Size() int {
+	return env.size
+}
+
+// Free returns the number of bytes that are still available for new
+// variables
+func (env *Env) Free() int {
+	return env.size - env.header - env.payloadSize()
+}
+
+// payloadSize returns the number of bytes needed for the key=value
+// pairs including the terminating double \0
+func (env *Env) payloadSize() int {
+	env.load()
+	size := 1
+	for k, v := range env.data {
+		size += len(k) + 1 + len(v) + 1
+	}
+	// no keys, so the terminator needs both \0
+	if len(env.data) == 0 {
+		size++
+	}
+	return size
+}
+
+// ffChunk is used to write the padding after the payload
+var ffChunk = bytes.Repeat([]byte{0xff}, 4096)
+
+// writePayload streams the key=value pairs, the terminator and the 0xff
+// padding that fills the environment up to its size into w
+func (env *Env) writePayload(w io.Writer) error {
+	env.load()
+	var err error
+	write := func(b []byte) {
+		if err == nil {
+			_, err = w.Write(b)
+		}
+	}
+	writeString := func(s string) {
+		if err == nil {
+			_, err = io.WriteString(w, s)
+		}
+	}
 
 	// write the payload
 	env.iterEnv(func(key, value string) {
-		w.Write([]byte(fmt.Sprintf("%s=%s", key, value)))
-		w.Write([]byte{0})
+		writeString(key)
+		writeString("=")
+		writeString(value)
+		write([]byte{0})
 	})
 
 	// write double \0 to mark the end of the env
-	w.Write([]byte{0})
+	write([]byte{0})
 
 	// no keys, so no previous \0 was written so we write one here
 	if len(env.data) == 0 {
-		w.Write([]byte{0})
+		write([]byte{0})
 	}
 
 	// write ff into the remaining parts
-	writtenSoFar := w.Len()
-	for i := 0; i < env.size-headerSize-writtenSoFar; i++ {
-		w.Write([]byte{0xff})
-	}
-
-	// checksum
-	crc := crc32.ChecksumIEEE(w.Bytes())
-
-	// Note that we overwrite the existing file and do not do
-	// the usual write-rename. The rationale is that we want to
-	// minimize the amount of writes happening on a potential
-	// FAT partition where the env is loaded from. The file will
-	// always be of a fixed size so we know the writes will not
-	// fail because of ENOSPC.
-	//
-	// The size of the env file never changes so we do not
-	// truncate it.
-	//
-	// We also do not O_TRUNC to avoid reallocations on the FS
-	// to minimize risk of fs corruption.
-	f, err := os.OpenFile(env.fname, os.O_WRONLY, 0666)
-	if err != nil {
-		return err
+	for pad := env.size - env.header - env.payloadSize(); pad > 0 && err == nil; {
+		n := len(ffChunk)
+		if pad < n {
+			n = pad
+		}
+		write(ffChunk[:n])
+		pad -= n
 	}
-	defer f.Close()
 
-	if _, err := f.Write(writeUint32(crc)); err != nil {
+	return err
+}
+
+// writeImage streams the environment into w. The payload is written
+// after the header space while its CRC is computed on the fly, the
+// header is written last.
+func (env *Env) writeImage(w io.WriterAt) error {
+	crc := crc32.New(env.opts.Checksum.table())
+	// the buffered writer is kept to not allocate one on every save
+	if env.bw == nil {
+		env.bw = bufio.NewWriter(nil)
+	}
+	bw := env.bw
+	bw.Reset(io.NewOffsetWriter(w, int64(env.header)))
+	defer bw.Reset(nil)
+	if err := env.writePayload(io.MultiWriter(crc, bw)); err != nil {
 		return err
 	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
 	// padding bytes (e.g. for redundant header)
-	pad := make([]byte, headerSize-binary.Size(crc))
-	if _, err := f.Write(pad); err != nil {
+	header := make([]byte, env.header)
+	env.opts.Checksum.putCRC(header, crc.Sum32())
+	_, err := w.WriteAt(header, 0)
+	return err
+}
+
+// checksum returns the CRC of the payload Save would write
+func (env *Env) checksum() uint32 {
+	crc := crc32.New(env.opts.Checksum.table())
+	env.writePayload(crc)
+	return crc.Sum32()
+}
+
+// ErrConcurrentModification is returned by Save when the stored
+// environment changed since it was read and Options.CompareAndSwap is
+// set. The environment should be reloaded and the changes reapplied.
+var ErrConcurrentModification = errors.New("environment was modified concurrently")
+
+// ErrVerifyFailed is returned by Save when Options.Verify is set and
+// the written environment does not read back correctly.
+var ErrVerifyFailed = errors.New("written environment does not read back correctly")
+
+// Save will write out the environment data. During a batch the write
+// is deferred until EndBatch.
+func (env *Env) Save() error {
+	if env.batchDepth > 0 {
+		env.batchSave = true
+		return nil
+	}
+
+	atomic.AddUint64(&env.stats.savesAttempted, 1)
+	if err := env.withIOLock(env.save); err != nil {
+		atomic.AddUint64(&env.stats.saveErrors, 1)
+		env.opts.logger().Debug("cannot save environment", "err", err)
 		return err
 	}
-	if _, err := f.Write(w.Bytes()); err != nil {
+	return nil
+}
+
+func (env *Env) save() error {
+	env.journalErr = nil
+	if need, avail := env.payloadSize(), env.size-env.header; need > avail {
+		return fmt.Errorf("environment too big: %d bytes needed, %d available", need, avail)
+	}
+	if env.opts.Limits != nil {
+		if errs := env.CheckLimits(*env.opts.Limits); len(errs) > 0 {
+			return errs[0]
+		}
+	}
+
+	if env.opts.CompareAndSwap || !env.opts.ForceWrite {
+		stored, err := readStored(env.storage)
+		if env.opts.CompareAndSwap {
+			if err != nil {
+				return err
+			}
+			if env.haveCRC && (len(stored) < env.header || env.opts.Checksum.readCRC(stored) != env.crc) {
+				return ErrConcurrentModification
+			}
+		}
+		// do not wear out the flash if nothing changed
+		if !env.opts.ForceWrite && err == nil && env.unchanged(stored) {
+			atomic.AddUint64(&env.stats.savesSkipped, 1)
+			env.opts.logger().Debug("skipped save of unchanged environment")
+			return nil
+		}
+	}
+
+	if err := env.rateLimit(); err != nil {
+		return err
+	}
+	if err := env.write(); err != nil {
+		return err
+	}
+	env.lastSave = timeNow()
+	return nil
+}
+
+// write writes the environment to the storage unconditionally
+func (env *Env) write() error {
+	// fill may be called more than once, e.g. by retries
+	var serialize time.Duration
+	fill := func(w io.WriterAt) error {
+		start := time.Now()
+		w = &countingWriterAt{w: w, n: &env.stats.bytesWritten}
+		if env.opts.Progress != nil {
+			w = &progressWriterAt{w: w, total: env.size, progress: env.opts.Progress}
+		}
+		err := env.writeImage(w)
+		serialize += time.Since(start)
+		return err
+	}
+	erased := env.sectorsErased()
+	start := time.Now()
+	err := env.storage.WriteImage(env.size, fill)
+	if env.opts.Tracer != nil {
+		total := time.Since(start)
+		env.opts.Tracer.TracePhase(PhaseSerialize, serialize, env.size, nil)
+		env.opts.Tracer.TracePhase(PhaseWrite, total-serialize, env.size, err)
+	}
+	if err != nil {
 		return err
 	}
+	if env.opts.MirrorOnSave {
+		if rs := env.redundantStorage(); rs != nil {
+			if err := rs.mirror(env.size, fill); err != nil {
+				return err
+			}
+		}
+	}
+	env.opts.logger().Debug("wrote environment", "size", env.size, "sectors_erased", env.sectorsErased()-erased)
+	if env.opts.Verify {
+		stored, err := readStored(env.storage)
+		if err != nil {
+			return err
+		}
+		if !env.unchanged(stored) {
+			atomic.AddUint64(&env.stats.verifyFailures, 1)
+			env.opts.logger().Debug("environment did not read back correctly")
+			return ErrVerifyFailed
+		}
+	}
+	env.crc = env.checksum()
+	env.haveCRC = true
+
+	// the environment is saved even if the journal or the audit
+	// records cannot be written, so the save does not fail for them
+	if env.journalErr = env.journal(); env.journalErr != nil {
+		env.opts.logger().Warn("cannot record the changes of the saved environment", "err", env.journalErr)
+	}
+	return nil
+}
+
+// JournalError returns the error of writing the journal or the audit
+// records of the last Save, or nil if they were written. Save does not
+// fail for them as the environment itself was saved, the error is also
+// logged to Options.Logger.
+func (env *Env) JournalError() error {
+	return env.journalErr
+}
+
+// unchanged returns true if the stored image is exactly the image that
+// Save would write.
+func (env *Env) unchanged(stored []byte) bool {
+	if len(stored) != env.size {
+		return false
+	}
+	cmp := &compareWriter{stored: stored, flags: env.header > flagsOffset}
+	if err := env.writeImage(cmp); err != nil {
+		return false
+	}
+	return !cmp.differs
+}
 
-	return f.Sync()
+// compareWriter compares everything written to it with the stored
+// image instead of writing it. The flags byte of redundant
+// environments is not compared, it is owned by their storage.
+type compareWriter struct {
+	stored  []byte
+	flags   bool
+	differs bool
+}
+
+func (w *compareWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(w.stored)) {
+		w.differs = true
+		return len(p), nil
+	}
+	stored := w.stored[off : off+int64(len(p))]
+	if i := flagsOffset - off; w.flags && i >= 0 && i < int64(len(p)) {
+		if !bytes.Equal(stored[:i], p[:i]) || !bytes.Equal(stored[i+1:], p[i+1:]) {
+			w.differs = true
+		}
+	} else if !bytes.Equal(stored, p) {
+		w.differs = true
+	}
+	return len(p), nil
 }
 
 // Import is a helper that imports a given text file that contains
 // "key=value" paris into the uboot env. Lines starting with ^# are
-// ignored (like the input file on mkenvimage)
+// ignored and a backslash at the end of a line continues the value on
+// the next line with a newline (like the input file on mkenvimage)
 func (env *Env) Import(r io.Reader) error {
+	return env.importLines(r, func(lineno int, value string) (string, error) {
+		return value, nil
+	})
+}
+
+// importLines imports the "key=value" lines of r, passing each value
+// through expand
+func (env *Env) importLines(r io.Reader, expand func(lineno int, value string) (string, error)) error {
+	env.load()
 	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#") || len(line) == 0 {
+	// line is the variable read so far and start its first line
+	var line string
+	var start int
+	for lineno := 1; scanner.Scan(); lineno++ {
+		text := scanner.Text()
+		if strings.HasPrefix(text, "#") || len(text) == 0 {
 			continue
 		}
-		l := strings.SplitN(line, "=", 2)
-		if len(l) == 1 {
-			return fmt.Errorf("Invalid line: %q", line)
+		if line == "" {
+			start = lineno
 		}
-		env.data[l[0]] = l[1]
-
+		line += text
+		if strings.HasSuffix(line, "\\") {
+			line = strings.TrimSuffix(line, "\\") + "\n"
+			continue
+		}
+		if err := env.importLine(start, line, expand); err != nil {
+			return err
+		}
+		line = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if line != "" {
+		return env.importLine(start, line, expand)
 	}
+	return nil
+}
 
-	return scanner.Err()
+func (env *Env) importLine(lineno int, line string, expand func(lineno int, value string) (string, error)) error {
+	l := strings.SplitN(line, "=", 2)
+	if len(l) == 1 || l[0] == "" {
+		return fmt.Errorf("Invalid line: %q", line)
+	}
+	value, err := expand(lineno, l[1])
+	if err != nil {
+		return err
+	}
+	env.recordUndo(l[0])
+	env.store(l[0], value)
+	return nil
 }
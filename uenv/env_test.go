@@ -2,6 +2,7 @@ package uenv
 
 import (
 	"bytes"
+	"fmt"
 	"hash/crc32"
 	"io/ioutil"
 	"os"
@@ -93,7 +94,6 @@ func (u *uenvTestSuite) makeUbootEnvFromData(c *C, mockData []byte) {
 	w := bytes.NewBuffer(nil)
 	crc := crc32.ChecksumIEEE(mockData)
 	w.Write(writeUint32(crc))
-	w.Write([]byte{0})
 	w.Write(mockData)
 
 	f, err := os.Create(u.envFile)
@@ -134,7 +134,7 @@ func (u *uenvTestSuite) TestErrorOnMalformedData(c *C) {
 	u.makeUbootEnvFromData(c, mockData)
 
 	env, err := Open(u.envFile)
-	c.Assert(err, ErrorMatches, `cannot parse line "foo" as key=value pair`)
+	c.Assert(err, ErrorMatches, `cannot parse line "foo" as key=value pair at offset 0: .*`)
 	c.Assert(env, IsNil)
 }
 
@@ -184,13 +184,11 @@ func (u *uenvTestSuite) TestWritesEmptyFileWithDoubleNewline(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(content, DeepEquals, []byte{
 		// crc
-		0x11, 0x38, 0xb3, 0x89,
-		// redundant
-		0x0,
+		0xca, 0x93, 0x39, 0x95,
 		// eof
 		0x0, 0x0,
 		// footer
-		0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
 	})
 
 	env, err = Open(u.envFile)
@@ -215,9 +213,7 @@ func (u *uenvTestSuite) TestWritesContentCorrectly(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(content, DeepEquals, []byte{
 		// crc
-		0xc7, 0xd9, 0x6b, 0xc5,
-		// redundant
-		0x0,
+		0xca, 0x3c, 0xc5, 0xfa,
 		// a=b
 		0x61, 0x3d, 0x62,
 		// eol
@@ -227,7 +223,7 @@ func (u *uenvTestSuite) TestWritesContentCorrectly(c *C) {
 		// eof
 		0x0, 0x0,
 		// footer
-		0xff, 0xff,
+		0xff, 0xff, 0xff,
 	})
 
 	env, err = Open(u.envFile)
@@ -235,3 +231,215 @@ func (u *uenvTestSuite) TestWritesContentCorrectly(c *C) {
 	c.Assert(env.String(), Equals, "a=b\nc=d\n")
 	c.Assert(env.size, Equals, totalSize)
 }
+
+func (u *uenvTestSuite) TestSaveRename(c *C) {
+	for _, strategy := range []WriteStrategy{WriteRename, WriteRenameSyncDir} {
+		env, err := CreateWithOptions(u.envFile, 16, Options{WriteStrategy: strategy})
+		c.Assert(err, IsNil)
+		env.Set("a", "b")
+		env.Set("c", "d")
+		err = env.Save()
+		c.Assert(err, IsNil)
+
+		// no temporary files are left behind, only the lock file
+		entries, err := ioutil.ReadDir(filepath.Dir(u.envFile))
+		c.Assert(err, IsNil)
+		c.Assert(entries, HasLen, 2)
+		c.Assert(entries[0].Name(), Equals, filepath.Base(u.envFile))
+		c.Assert(entries[0].Size(), Equals, int64(16))
+		c.Assert(entries[1].Name(), Equals, filepath.Base(u.envFile)+".lock")
+
+		env, err = Open(u.envFile)
+		c.Assert(err, IsNil)
+		c.Assert(env.String(), Equals, "a=b\nc=d\n")
+	}
+}
+
+func (u *uenvTestSuite) TestSaveUnknownStrategy(c *C) {
+	_, err := CreateWithOptions(u.envFile, 16, Options{WriteStrategy: WriteStrategy(42)})
+	c.Assert(err, ErrorMatches, "unknown write strategy 42")
+}
+
+func (u *uenvTestSuite) TestSaveTooBig(c *C) {
+	env, err := Create(u.envFile, 12)
+	c.Assert(err, IsNil)
+	env.Set("foo", "barbaz")
+	err = env.Save()
+	c.Assert(err, ErrorMatches, `environment too big: 12 bytes needed, 8 available`)
+}
+
+func (u *uenvTestSuite) TestSaveLargePadding(c *C) {
+	totalSize := 3*len(ffChunk) + 17
+	env, err := Create(u.envFile, totalSize)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	err = env.Save()
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, totalSize)
+	c.Assert(readUint32(content), Equals, crc32.ChecksumIEEE(content[headerSize:]))
+	c.Assert(content[totalSize-1], Equals, byte(0xff))
+}
+
+func (u *uenvTestSuite) TestOpenTooSmall(c *C) {
+	err := ioutil.WriteFile(u.envFile, []byte{1, 2, 3}, 0644)
+	c.Assert(err, IsNil)
+	_, err = Open(u.envFile)
+	c.Assert(err, ErrorMatches, "env too small: 3 bytes, need at least 6")
+}
+
+func (u *uenvTestSuite) TestOpenNoTerminator(c *C) {
+	u.makeUbootEnvFromData(c, []byte("foo=bar"))
+
+	_, err := Open(u.envFile)
+	c.Assert(err, ErrorMatches, `cannot find end of environment marker at offset 7: 00000000  66 6f 6f 3d 62 61 72  \|foo=bar\|`)
+
+	env, err := OpenWithFlags(u.envFile, OpenBestEffort)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "foo=bar\n")
+	c.Assert(env.ParseWarnings(), HasLen, 1)
+	c.Assert(env.ParseWarnings()[0].Offset, Equals, 7)
+}
+
+func (u *uenvTestSuite) TestOpenEmptyKey(c *C) {
+	u.makeUbootEnvFromData(c, []byte("=bar\x00\x00"))
+
+	_, err := Open(u.envFile)
+	c.Assert(err, ErrorMatches, `cannot parse line "=bar" as key=value pair at offset 0: .*`)
+}
+
+func (u *uenvTestSuite) TestImportEmptyKey(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	err = env.Import(strings.NewReader("=foo\n"))
+	c.Assert(err, ErrorMatches, `Invalid line: "=foo"`)
+}
+
+func (u *uenvTestSuite) TestCreateInvalidSize(c *C) {
+	_, err := Create(u.envFile, 3)
+	c.Assert(err, ErrorMatches, "invalid env size 3: must be between 6 and 16777216")
+	_, err = Create(u.envFile, 1<<40)
+	c.Assert(err, ErrorMatches, "invalid env size 1099511627776: must be between 6 and 16777216")
+}
+
+func (u *uenvTestSuite) TestMaxSize(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	_, err = OpenWithOptions(u.envFile, Options{MaxSize: 1024})
+	c.Assert(err, ErrorMatches, `cannot read .*/uboot.env: larger than the maximum env size of 1024 bytes`)
+	_, err = OpenWithOptions(u.envFile, Options{MaxSize: 1024, Mmap: true})
+	c.Assert(err, ErrorMatches, `cannot map .*/uboot.env: larger than the maximum env size of 1024 bytes`)
+
+	_, err = CreateWithOptions(u.envFile, 4096, Options{MaxSize: 1024})
+	c.Assert(err, ErrorMatches, "invalid env size 4096: must be between 6 and 1024")
+
+	oldMaxSize := MaxSize
+	MaxSize = 1024
+	defer func() { MaxSize = oldMaxSize }()
+	_, err = Open(u.envFile)
+	c.Assert(err, ErrorMatches, `cannot read .*/uboot.env: larger than the maximum env size of 1024 bytes`)
+	env, err = OpenWithOptions(u.envFile, Options{MaxSize: 4096})
+	c.Assert(err, IsNil)
+}
+
+func (u *uenvTestSuite) TestCompareAndSwap(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	env1, err := OpenWithOptions(u.envFile, Options{CompareAndSwap: true})
+	c.Assert(err, IsNil)
+	env2, err := OpenWithOptions(u.envFile, Options{CompareAndSwap: true})
+	c.Assert(err, IsNil)
+
+	env1.Set("foo", "one")
+	c.Assert(env1.Save(), IsNil)
+	// saving again after our own save is fine
+	env1.Set("bar", "one")
+	c.Assert(env1.Save(), IsNil)
+
+	env2.Set("foo", "two")
+	c.Assert(env2.Save(), Equals, ErrConcurrentModification)
+
+	// reload, reapply and retry
+	c.Assert(env2.Reload(), IsNil)
+	env2.Set("foo", "two")
+	c.Assert(env2.Save(), IsNil)
+
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "bar=one\nfoo=two\n")
+}
+
+func (u *uenvTestSuite) TestCreateWritesValidEnv(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+
+	st, err := os.Stat(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(st.Size(), Equals, int64(4096))
+
+	env, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "")
+	c.Assert(env.size, Equals, 4096)
+}
+
+func (u *uenvTestSuite) TestOpenUninitialized(c *C) {
+	for _, fill := range []byte{0xff, 0} {
+		err := ioutil.WriteFile(u.envFile, bytes.Repeat([]byte{fill}, 64), 0644)
+		c.Assert(err, IsNil)
+
+		_, err = Open(u.envFile)
+		c.Assert(err, FitsTypeOf, &UninitializedError{})
+		c.Assert(err.(*UninitializedError).Fill, Equals, fill)
+		c.Assert(err, ErrorMatches, fmt.Sprintf("environment is not initialized: all bytes are 0x%02x", fill))
+	}
+}
+
+func (u *uenvTestSuite) TestOpenCorrupted(c *C) {
+	_, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	content[10] = 'x'
+	c.Assert(ioutil.WriteFile(u.envFile, content, 0644), IsNil)
+
+	_, err = Open(u.envFile)
+	c.Assert(err, FitsTypeOf, &CRCError{})
+	crcErr := err.(*CRCError)
+	c.Assert(crcErr.Stored, Equals, readUint32(content))
+	c.Assert(crcErr.Actual, Equals, crc32.ChecksumIEEE(content[headerSize:]))
+}
+
+func (u *uenvTestSuite) TestSizeFree(c *C) {
+	env, err := Create(u.envFile, 32)
+	c.Assert(err, IsNil)
+	c.Assert(env.Size(), Equals, 32)
+	// header and the double \0 terminator
+	c.Assert(env.Free(), Equals, 32-headerSize-2)
+	env.Set("foo", "bar")
+	c.Assert(env.Free(), Equals, 32-headerSize-len("foo=bar\x00\x00"))
+}
+
+func (u *uenvTestSuite) TestParseErrorContext(c *C) {
+	payload := []byte("first=1\x00second=2\x00broken\x00third=3\x00\x00\xff\xff\xff")
+	u.makeUbootEnvFromData(c, payload)
+
+	_, err := Open(u.envFile)
+	c.Assert(err, ErrorMatches, `cannot parse line "broken" as key=value pair at offset 17: 00000009  65 63 6f 6e 64 3d 32 00 62 72 6f 6b 65 6e 00 74  \|econd=2.broken.t\|`)
+	perr, ok := err.(*ParseError)
+	c.Assert(ok, Equals, true)
+	c.Assert(perr.Offset, Equals, 17)
+	c.Assert(perr.ContextOffset, Equals, 9)
+
+	env, err := OpenWithFlags(u.envFile, OpenBestEffort)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "first=1\nsecond=2\nthird=3\n")
+	c.Assert(env.ParseWarnings(), DeepEquals, []*ParseError{perr})
+}
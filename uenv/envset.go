@@ -0,0 +1,134 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// EnvSet is a named set of independent environments of one board,
+// e.g. the main environment, the recovery one and one per SoC, that
+// are changed together and saved all-or-nothing.
+type EnvSet struct {
+	envs map[string]*Env
+}
+
+// NewEnvSet returns a set of the given environments.
+func NewEnvSet(envs map[string]*Env) *EnvSet {
+	set := &EnvSet{envs: make(map[string]*Env, len(envs))}
+	for name, env := range envs {
+		set.envs[name] = env
+	}
+	return set
+}
+
+// OpenEnvSet opens the environments of the given configurations, e.g.
+// the namespaces of a libubootenv YAML configuration, as a set.
+func OpenEnvSet(configs map[string]*Config, opts Options) (*EnvSet, error) {
+	set := &EnvSet{envs: make(map[string]*Env, len(configs))}
+	for name, cfg := range configs {
+		env, err := OpenFromConfig(cfg, opts)
+		if err != nil {
+			set.Close()
+			return nil, fmt.Errorf("cannot open environment %q: %v", name, err)
+		}
+		set.envs[name] = env
+	}
+	return set, nil
+}
+
+// Names returns the sorted names of the environments in the set.
+func (set *EnvSet) Names() []string {
+	names := make([]string, 0, len(set.envs))
+	for name := range set.envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Env returns the environment of the given name or nil.
+func (set *EnvSet) Env(name string) *Env {
+	return set.envs[name]
+}
+
+// Update applies coordinated changes to the environments of the set.
+// If f returns an error, all changes f made are undone and the error
+// is returned.
+func (set *EnvSet) Update(f func(set *EnvSet) error) error {
+	marks := make(map[string]int, len(set.envs))
+	for name, env := range set.envs {
+		marks[name] = env.UndoLen()
+	}
+	if err := f(set); err != nil {
+		for name, env := range set.envs {
+			env.Undo(env.UndoLen() - marks[name])
+		}
+		return err
+	}
+	return nil
+}
+
+// Save saves all environments of the set in the order of their names.
+// If one of them cannot be saved, the ones that were already written
+// get their previous content back, so that either all or none of the
+// environments change. The changes are kept in memory in this case.
+func (set *EnvSet) Save() error {
+	names := set.Names()
+	// fail early for problems that would be found during the saves
+	for _, name := range names {
+		env := set.envs[name]
+		if need, avail := env.payloadSize(), env.size-env.header; need > avail {
+			return fmt.Errorf("cannot save environment %q: %d bytes needed, %d available", name, need, avail)
+		}
+	}
+
+	previous := make(map[string][]byte, len(names))
+	for i, name := range names {
+		env := set.envs[name]
+		img, err := readStored(env.storage)
+		if err == nil {
+			// storages may reuse the slice for the next write
+			previous[name] = append([]byte(nil), img...)
+		}
+		if err := env.Save(); err != nil {
+			err = fmt.Errorf("cannot save environment %q: %v", name, err)
+			return set.rollback(names[:i], previous, err)
+		}
+	}
+	return nil
+}
+
+// rollback writes the previous images back to the given environments
+func (set *EnvSet) rollback(names []string, previous map[string][]byte, saveErr error) error {
+	for _, name := range names {
+		env, img := set.envs[name], previous[name]
+		if img == nil {
+			return fmt.Errorf("%v (cannot roll back environment %q: previous content unknown)", saveErr, name)
+		}
+		err := env.storage.WriteImage(len(img), func(w io.WriterAt) error {
+			_, err := w.WriteAt(img, 0)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%v (cannot roll back environment %q: %v)", saveErr, name, err)
+		}
+		env.crc = env.opts.Checksum.readCRC(img)
+		if env.journaled != nil {
+			// the next save journals the changes again
+			env.journaled, _, _ = parseImage(img, env.header, env.opts.Flags, env.opts.Checksum)
+		}
+	}
+	return saveErr
+}
+
+// Close closes all environments of the set.
+func (set *EnvSet) Close() error {
+	var firstErr error
+	for _, env := range set.envs {
+		if err := env.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
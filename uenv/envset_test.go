@@ -0,0 +1,129 @@
+package uenv
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+// readOnlyStorage fails all writes
+type readOnlyStorage struct {
+	Storage
+}
+
+func (s *readOnlyStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	return errors.New("read-only storage")
+}
+
+func (u *uenvTestSuite) newEnvSet(c *C, names ...string) *EnvSet {
+	envs := make(map[string]*Env)
+	for _, name := range names {
+		env, err := NewEnv(64)
+		c.Assert(err, IsNil)
+		env.Set("name", name)
+		c.Assert(env.Save(), IsNil)
+		c.Assert(env.Reload(), IsNil)
+		envs[name] = env
+	}
+	return NewEnvSet(envs)
+}
+
+func (u *uenvTestSuite) TestEnvSetSave(c *C) {
+	set := u.newEnvSet(c, "main", "recovery")
+	c.Assert(set.Names(), DeepEquals, []string{"main", "recovery"})
+	c.Assert(set.Env("missing"), IsNil)
+
+	err := set.Update(func(set *EnvSet) error {
+		set.Env("main").Set("slot", "b")
+		set.Env("recovery").Set("slot", "b")
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(set.Save(), IsNil)
+
+	for _, name := range set.Names() {
+		env := set.Env(name)
+		c.Assert(env.Reload(), IsNil)
+		c.Check(env.Get("slot"), Equals, "b")
+	}
+}
+
+func (u *uenvTestSuite) TestEnvSetUpdateError(c *C) {
+	set := u.newEnvSet(c, "main", "recovery")
+	set.Env("main").Set("keep", "1")
+
+	err := set.Update(func(set *EnvSet) error {
+		set.Env("main").Set("slot", "b")
+		set.Env("recovery").Set("name", "")
+		return errors.New("boom")
+	})
+	c.Assert(err, ErrorMatches, "boom")
+	// only the changes of the update are undone
+	c.Assert(set.Env("main").String(), Equals, "keep=1\nname=main\n")
+	c.Assert(set.Env("recovery").String(), Equals, "name=recovery\n")
+}
+
+func (u *uenvTestSuite) TestEnvSetSaveRollback(c *C) {
+	set := u.newEnvSet(c, "a", "b", "c")
+	b := set.Env("b")
+	b.storage = &readOnlyStorage{b.storage}
+	for _, name := range set.Names() {
+		set.Env(name).Set("slot", "b")
+	}
+
+	err := set.Save()
+	c.Assert(err, ErrorMatches, `cannot save environment "b": read-only storage`)
+
+	// "a" was written and rolled back, "c" was never written
+	for _, name := range []string{"a", "c"} {
+		env := set.Env(name)
+		c.Check(env.Get("slot"), Equals, "b")
+		c.Assert(env.Reload(), IsNil)
+		c.Check(env.String(), Equals, "name="+name+"\n")
+	}
+
+	// a later save writes the changes again
+	b.storage = b.storage.(*readOnlyStorage).Storage
+	for _, name := range set.Names() {
+		set.Env(name).Set("slot", "b")
+	}
+	c.Assert(set.Save(), IsNil)
+	c.Assert(set.Env("a").Reload(), IsNil)
+	c.Assert(set.Env("a").Get("slot"), Equals, "b")
+}
+
+func (u *uenvTestSuite) TestEnvSetSaveTooBig(c *C) {
+	set := u.newEnvSet(c, "a", "b")
+	set.Env("a").Set("slot", "b")
+	set.Env("b").Set("big", string(make([]byte, 100)))
+
+	err := set.Save()
+	c.Assert(err, ErrorMatches, `cannot save environment "b": 113 bytes needed, 60 available`)
+	c.Assert(set.Env("a").Reload(), IsNil)
+	c.Assert(set.Env("a").Get("slot"), Equals, "")
+}
+
+func (u *uenvTestSuite) TestOpenEnvSet(c *C) {
+	dir := c.MkDir()
+	configs := make(map[string]*Config)
+	for _, name := range []string{"uboot", "appvar"} {
+		fname := filepath.Join(dir, name+".env")
+		env, err := Create(fname, 512)
+		c.Assert(err, IsNil)
+		env.Set("ns", name)
+		c.Assert(env.Save(), IsNil)
+		configs[name] = &Config{Size: 512, Devices: []DeviceConfig{{Path: fname}}}
+	}
+
+	set, err := OpenEnvSet(configs, Options{})
+	c.Assert(err, IsNil)
+	defer set.Close()
+	c.Assert(set.Names(), DeepEquals, []string{"appvar", "uboot"})
+	c.Assert(set.Env("uboot").Get("ns"), Equals, "uboot")
+
+	configs["missing"] = &Config{Size: 512, Devices: []DeviceConfig{{Path: filepath.Join(dir, "missing.env")}}}
+	_, err = OpenEnvSet(configs, Options{})
+	c.Assert(err, ErrorMatches, `cannot open environment "missing": .*`)
+}
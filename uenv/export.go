@@ -0,0 +1,76 @@
+package uenv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExportFormat selects the format Export writes.
+type ExportFormat string
+
+const (
+	// ExportText writes "key=value" lines like the input of
+	// mkenvimage and Import, newlines in values are written as a
+	// backslash at the end of the line.
+	ExportText ExportFormat = "text"
+	// ExportJSON writes a JSON object of the variables.
+	ExportJSON ExportFormat = "json"
+)
+
+// Export writes the variables sorted by name in the given format, so
+// that environments can be kept in version control and imported again.
+// The values of redacted variables are replaced with Redacted and
+// secret variables stay encrypted. Nothing is written if a variable
+// cannot be written in the format.
+func (env *Env) Export(w io.Writer, format ExportFormat) error {
+	var buf bytes.Buffer
+	switch format {
+	case ExportText:
+		var err error
+		env.iterEnv(func(key, value string) {
+			if err != nil {
+				return
+			}
+			value = env.displayValue(key, value)
+			if err = checkExportText(key, value); err == nil {
+				fmt.Fprintf(&buf, "%s=%s\n", key, strings.ReplaceAll(value, "\n", "\\\n"))
+			}
+		})
+		if err != nil {
+			return err
+		}
+	case ExportJSON:
+		vars := make(map[string]string)
+		env.iterEnv(func(key, value string) {
+			vars[key] = env.displayValue(key, value)
+		})
+		content, err := json.MarshalIndent(vars, "", "  ")
+		if err != nil {
+			return err
+		}
+		buf.Write(content)
+		buf.WriteByte('\n')
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// checkExportText returns an error if the variable cannot be written
+// so that mkenvimage reads it back: lines starting with "#" are
+// comments and a backslash at the end of a line continues the value
+func checkExportText(key, value string) error {
+	switch {
+	case strings.HasPrefix(key, "#") || strings.ContainsAny(key, "\n="):
+		return fmt.Errorf("cannot export variable %q as text: invalid name", key)
+	case strings.Contains(value, "\n#"):
+		return fmt.Errorf("cannot export variable %q as text: line of the value starts with \"#\"", key)
+	case strings.HasSuffix(value, "\\") || strings.HasSuffix(value, "\n"):
+		return fmt.Errorf("cannot export variable %q as text: value ends with %q", key, value[len(value)-1:])
+	}
+	return nil
+}
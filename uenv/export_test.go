@@ -0,0 +1,74 @@
+package uenv
+
+import (
+	"bytes"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestExportText(c *C) {
+	env, err := NewEnv(4096)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	env.Set("bootcmd", "echo one\necho two\n\necho three")
+
+	var buf bytes.Buffer
+	c.Assert(env.Export(&buf, ExportText), IsNil)
+	c.Assert(buf.String(), Equals, "bootcmd=echo one\\\necho two\\\n\\\necho three\nfoo=bar\n")
+
+	// the export imports again
+	env2, err := NewEnv(4096)
+	c.Assert(err, IsNil)
+	c.Assert(env2.Import(&buf), IsNil)
+	c.Assert(env2.Get("bootcmd"), Equals, env.Get("bootcmd"))
+	c.Assert(env2.String(), Equals, env.String())
+}
+
+func (u *uenvTestSuite) TestExportTextErrors(c *C) {
+	for _, t := range []struct {
+		key, value, err string
+	}{
+		{"a", "x\n#y", `cannot export variable "a" as text: line of the value starts with "#"`},
+		{"a", "x\\", `cannot export variable "a" as text: value ends with "\\\\"`},
+		{"a", "x\n", `cannot export variable "a" as text: value ends with "\\n"`},
+		{"#a", "x", `cannot export variable "#a" as text: invalid name`},
+		{"a=b", "x", `cannot export variable "a=b" as text: invalid name`},
+	} {
+		env, err := NewEnv(4096)
+		c.Assert(err, IsNil)
+		env.Set("b", "ok")
+		env.Set(t.key, t.value)
+		var buf bytes.Buffer
+		c.Check(env.Export(&buf, ExportText), ErrorMatches, t.err)
+		c.Check(buf.Len(), Equals, 0)
+	}
+}
+
+func (u *uenvTestSuite) TestExportJSON(c *C) {
+	env, err := CreateStorage(NewMemStorage(nil), 4096, Options{Redact: []string{"*_psk"}})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	env.Set("wifi_psk", "hunter2")
+	env.Set("bootcmd", "a\nb")
+
+	var buf bytes.Buffer
+	c.Assert(env.Export(&buf, ExportJSON), IsNil)
+	c.Assert(buf.String(), Equals, `{
+  "bootcmd": "a\nb",
+  "foo": "bar",
+  "wifi_psk": "****"
+}
+`)
+
+	c.Assert(env.Export(&buf, "yaml"), ErrorMatches, `unknown export format "yaml"`)
+}
+
+func (u *uenvTestSuite) TestImportContinuation(c *C) {
+	env, err := NewEnv(4096)
+	c.Assert(err, IsNil)
+	err = env.Import(strings.NewReader("a=one\\\n# comment\ntwo\\\n\nb=x\nc=end\\"))
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("a"), Equals, "one\ntwo\nb=x")
+	c.Assert(env.Get("c"), Equals, "end\n")
+}
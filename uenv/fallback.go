@@ -0,0 +1,40 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+)
+
+// FallbackReport describes which file OpenWithFallback read the
+// environment from.
+type FallbackReport struct {
+	// Used is the file the environment was read from.
+	Used string
+	// PrimaryErr is why the primary file was not used, nil if it was.
+	PrimaryErr error
+}
+
+// OpenWithFallback opens the environment in primary and falls back to
+// secondary, e.g. a backup copy, if primary cannot be read or fails
+// its CRC check. Unlike OpenRedundant the two files are not written
+// alternately: saves always go to primary, so the first save after a
+// fallback repairs it.
+func OpenWithFallback(primary, secondary string, opts Options) (*Env, *FallbackReport, error) {
+	env, primaryErr := OpenWithOptions(primary, opts)
+	if primaryErr == nil {
+		return env, &FallbackReport{Used: primary}, nil
+	}
+
+	env, err := OpenWithOptions(secondary, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open %s: %v, fallback %s: %v", primary, primaryErr, secondary, err)
+	}
+	opts.logger().Debug("using fallback environment", "primary", primary, "fallback", secondary, "err", primaryErr)
+	if c, ok := env.storage.(io.Closer); ok {
+		c.Close()
+	}
+	env.storage = wrapStorage(newStorage(primary, opts), opts)
+	// the primary file does not hold what was read
+	env.haveCRC = false
+	return env, &FallbackReport{Used: secondary, PrimaryErr: primaryErr}, nil
+}
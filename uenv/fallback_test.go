@@ -0,0 +1,50 @@
+package uenv
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestOpenWithFallback(c *C) {
+	backup := filepath.Join(c.MkDir(), "uboot.env.bak")
+	for _, fname := range []string{u.envFile, backup} {
+		env, err := Create(fname, 64)
+		c.Assert(err, IsNil)
+		env.Set("file", filepath.Base(fname))
+		c.Assert(env.Save(), IsNil)
+	}
+
+	env, report, err := OpenWithFallback(u.envFile, backup, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(report, DeepEquals, &FallbackReport{Used: u.envFile})
+	c.Assert(env.Get("file"), Equals, "uboot.env")
+
+	// corrupt the primary file
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	content[10] ^= 0xff
+	c.Assert(os.WriteFile(u.envFile, content, 0644), IsNil)
+
+	env, report, err = OpenWithFallback(u.envFile, backup, Options{CompareAndSwap: true})
+	c.Assert(err, IsNil)
+	c.Assert(report.Used, Equals, backup)
+	c.Assert(report.PrimaryErr, FitsTypeOf, &CRCError{})
+	c.Assert(env.Get("file"), Equals, "uboot.env.bak")
+
+	// saving repairs the primary file
+	env.Set("repaired", "1")
+	c.Assert(env.Save(), IsNil)
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "file=uboot.env.bak\nrepaired=1\n")
+	env, err = Open(backup)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "file=uboot.env.bak\n")
+}
+
+func (u *uenvTestSuite) TestOpenWithFallbackBothBroken(c *C) {
+	_, _, err := OpenWithFallback(u.envFile+".missing", u.envFile+".missing2", Options{})
+	c.Assert(err, ErrorMatches, `cannot open .*/uboot.env.missing: .*, fallback .*/uboot.env.missing2: .*`)
+}
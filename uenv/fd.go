@@ -0,0 +1,64 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// OpenFile opens the environment kept in an already opened file, e.g.
+// a device whose file descriptor was passed by a privileged parent, so
+// that no access to its path is needed. The whole file is used and
+// saves write in place. The file is not closed by the environment.
+func OpenFile(f *os.File, opts Options) (*Env, error) {
+	return OpenFileRegion(f, 0, 0, opts)
+}
+
+// OpenFileRegion opens the environment of the given size at offset in
+// an already opened file, see OpenFile. A size of zero means up to the
+// end of the file.
+func OpenFileRegion(f *os.File, offset int64, size int, opts Options) (*Env, error) {
+	if size == 0 {
+		end, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if end-offset > int64(opts.maxSize()) {
+			return nil, fmt.Errorf("cannot read %s: larger than the maximum env size of %d bytes", f.Name(), opts.maxSize())
+		}
+		size = int(end - offset)
+	}
+	if size > opts.maxSize() {
+		return nil, fmt.Errorf("cannot use %s: env size %d is larger than the maximum env size of %d bytes", f.Name(), size, opts.maxSize())
+	}
+	return OpenStorage(&fdStorage{f: f, offset: offset, size: size, sync: opts.Sync, tracer: opts.Tracer, progress: opts.Progress}, opts)
+}
+
+// fdStorage keeps the environment in a region of a file that was
+// opened by someone else
+type fdStorage struct {
+	f        *os.File
+	offset   int64
+	size     int
+	sync     SyncMode
+	tracer   Tracer
+	progress func(Progress)
+}
+
+func (s *fdStorage) ReadImage() ([]byte, error) {
+	img := make([]byte, s.size)
+	if _, err := readAt(s.f, img, s.offset, s.progress); err != nil {
+		return nil, fmt.Errorf("cannot read %d bytes at offset %d of %s: %v", s.size, s.offset, s.f.Name(), err)
+	}
+	return img, nil
+}
+
+func (s *fdStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if size != s.size {
+		return fmt.Errorf("cannot write env of size %d to region of size %d", size, s.size)
+	}
+	if err := fill(io.NewOffsetWriter(s.f, s.offset)); err != nil {
+		return err
+	}
+	return traceSync(s.tracer, s.f, s.sync)
+}
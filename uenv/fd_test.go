@@ -0,0 +1,59 @@
+package uenv
+
+import (
+	"bytes"
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestOpenFile(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	f, err := os.OpenFile(u.envFile, os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	// the path is gone, the descriptor is all we have
+	c.Assert(os.Remove(u.envFile), IsNil)
+
+	env, err = OpenFile(f, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Size(), Equals, 64)
+	c.Assert(env.Get("foo"), Equals, "bar")
+	env.Set("foo", "baz")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Close(), IsNil)
+
+	// the file is still open
+	env, err = OpenFile(f, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "baz")
+}
+
+func (u *uenvTestSuite) TestOpenFileRegion(c *C) {
+	c.Assert(os.WriteFile(u.envFile, bytes.Repeat([]byte{0xaa}, 1024), 0644), IsNil)
+	f, err := os.OpenFile(u.envFile, os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	_, err = OpenFileRegion(f, 256, 128, Options{})
+	c.Assert(err, FitsTypeOf, &CRCError{})
+
+	env, err := CreateStorage(&fdStorage{f: f, offset: 256, size: 128}, 128, Options{})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenFileRegion(f, 256, 128, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "bar")
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content[:256], DeepEquals, bytes.Repeat([]byte{0xaa}, 256))
+	c.Assert(content[384:], DeepEquals, bytes.Repeat([]byte{0xaa}, 640))
+
+	_, err = OpenFileRegion(f, 0, 0, Options{MaxSize: 512})
+	c.Assert(err, ErrorMatches, "cannot read .*: larger than the maximum env size of 512 bytes")
+}
@@ -0,0 +1,39 @@
+package uenv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// ErrReadOnly is returned when saving an environment that was opened
+// from a read-only source.
+var ErrReadOnly = errors.New("environment is read-only")
+
+// OpenFS opens the environment in the named file of fsys, e.g. a zip
+// archive, embedded test fixtures or any other fs.FS. The environment
+// is read-only, Save fails with ErrReadOnly if there are changes.
+func OpenFS(fsys fs.FS, name string, opts Options) (*Env, error) {
+	return OpenStorage(&fsStorage{fsys: fsys, name: name, maxSize: opts.maxSize()}, opts)
+}
+
+// fsStorage reads the environment from a file of an fs.FS
+type fsStorage struct {
+	fsys    fs.FS
+	name    string
+	maxSize int
+}
+
+func (s *fsStorage) ReadImage() ([]byte, error) {
+	f, err := s.fsys.Open(s.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readImage(f, s.name, s.maxSize)
+}
+
+func (s *fsStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	return fmt.Errorf("cannot write %s: %w", s.name, ErrReadOnly)
+}
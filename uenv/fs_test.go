@@ -0,0 +1,36 @@
+package uenv
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing/fstest"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestOpenFS(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+
+	fsys := fstest.MapFS{"boot/uboot.env": &fstest.MapFile{Data: content}}
+	env, err = OpenFS(fsys, "boot/uboot.env", Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "bar")
+
+	// saving without changes is fine, changes cannot be saved
+	c.Assert(env.Save(), IsNil)
+	env.Set("foo", "baz")
+	err = env.Save()
+	c.Assert(err, ErrorMatches, "cannot write boot/uboot.env: environment is read-only")
+	c.Assert(errors.Is(err, ErrReadOnly), Equals, true)
+
+	_, err = OpenFS(fsys, "missing", Options{})
+	c.Assert(errors.Is(err, fs.ErrNotExist), Equals, true)
+	_, err = OpenFS(fsys, "boot/uboot.env", Options{MaxSize: 32})
+	c.Assert(err, ErrorMatches, "cannot read boot/uboot.env: larger than the maximum env size of 32 bytes")
+}
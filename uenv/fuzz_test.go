@@ -0,0 +1,61 @@
+package uenv
+
+import (
+	"bytes"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+func validImage(payload []byte) []byte {
+	img := make([]byte, headerSize, headerSize+len(payload))
+	copy(img, writeUint32(crc32.ChecksumIEEE(payload)))
+	return append(img, payload...)
+}
+
+func FuzzParseImage(f *testing.F) {
+	// most random images have a bad CRC, so the fuzzer mostly
+	// provides payloads that get a valid header
+	f.Add([]byte{}, true)
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8}, true)
+	f.Add([]byte{0, 0}, false)
+	f.Add([]byte("foo=bar\x00\x00\xff\xff"), false)
+	f.Add([]byte("foo=bar\x00baz\x00=x\x00\x00"), false)
+	f.Add([]byte("foo=bar"), false)
+	f.Add(bytes.Repeat([]byte{0xff}, 64), false)
+
+	f.Fuzz(func(t *testing.T, data []byte, raw bool) {
+		img := data
+		if !raw {
+			img = validImage(data)
+		}
+		for _, flags := range []OpenFlags{0, OpenBestEffort} {
+			data, _, err := parseImage(img, headerSize, flags, ChecksumCRC32)
+			if err != nil {
+				continue
+			}
+
+			// whatever parses must survive a save and parse again
+			env := &Env{size: len(img), data: data, header: headerSize}
+			if env.payloadSize() > env.size-headerSize {
+				// best effort parsing accepts envs that lack the
+				// terminator, those do not fit without it
+				if flags == OpenBestEffort {
+					continue
+				}
+				t.Fatalf("parsed data does not fit into the env it came from")
+			}
+			out := make(sliceWriter, len(img))
+			if err := env.writeImage(out); err != nil {
+				t.Fatalf("cannot write parsed env: %v", err)
+			}
+			again, _, err := parseImage(out, headerSize, 0, ChecksumCRC32)
+			if err != nil {
+				t.Fatalf("cannot parse written env: %v", err)
+			}
+			if !reflect.DeepEqual(again, data) {
+				t.Fatalf("round trip mismatch: %q != %q", again, data)
+			}
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package uenv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isGzipName returns true for file names of compressed dumps
+func isGzipName(fname string) bool {
+	return strings.HasSuffix(fname, ".gz")
+}
+
+// gunzipImage returns the decompressed image if img is gzip
+// compressed, e.g. a `dd | gzip` dump from the field. ok is false if
+// img is not compressed, which includes images whose CRC happens to
+// look like the gzip magic.
+func gunzipImage(img []byte, name string, maxSize int) (data []byte, ok bool, err error) {
+	if !bytes.HasPrefix(img, gzipMagic) {
+		return nil, false, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(img))
+	if err != nil {
+		return nil, false, nil
+	}
+	data, err = io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, false, nil
+	}
+	if len(data) > maxSize {
+		return nil, false, fmt.Errorf("cannot read %s: larger than the maximum env size of %d bytes", name, maxSize)
+	}
+	return data, true, nil
+}
+
+func gzipImage(img []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(img); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeCompressed replaces the file by the gzip compressed image, the
+// compressed size changes with every write so the file is always
+// replaced by a rename
+func (s *fileStorage) writeCompressed(size int, fill func(w io.WriterAt) error) error {
+	img := make([]byte, size)
+	if err := fill(sliceWriter(img)); err != nil {
+		return err
+	}
+	compressed, err := gzipImage(img)
+	if err != nil {
+		return err
+	}
+	return s.writeRename(func(w io.WriterAt) error {
+		_, err := w.WriteAt(compressed, 0)
+		return err
+	}, s.strategy == WriteRenameSyncDir)
+}
+
+// WriteBackup writes the image of the environment as it is in memory
+// to the given file, gzip compressed if the name ends in ".gz". Such
+// backups can be opened like any environment file.
+func (env *Env) WriteBackup(fname string) error {
+	snap, err := env.Snapshot("")
+	if err != nil {
+		return err
+	}
+	img := snap.Image
+	if isGzipName(fname) {
+		if img, err = gzipImage(img); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(fname, img, 0600)
+}
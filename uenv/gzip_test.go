@@ -0,0 +1,99 @@
+package uenv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func gunzip(c *C, fname string) []byte {
+	f, err := os.Open(fname)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(r)
+	c.Assert(err, IsNil)
+	return buf.Bytes()
+}
+
+func (u *uenvTestSuite) TestOpenGzipDump(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	img, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+
+	// a dump from the field, the name does not matter
+	dump := filepath.Join(c.MkDir(), "dump.bin")
+	compressed, err := gzipImage(img)
+	c.Assert(err, IsNil)
+	c.Assert(os.WriteFile(dump, compressed, 0644), IsNil)
+
+	env, err = Open(dump)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+	c.Check(env.Size(), Equals, 4096)
+
+	// it stays compressed when saved
+	env.Set("foo", "baz")
+	c.Assert(env.Save(), IsNil)
+	env, err = Open(dump)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "baz")
+	c.Check(gunzip(c, dump), HasLen, 4096)
+}
+
+func (u *uenvTestSuite) TestCreateGzip(c *C) {
+	fname := filepath.Join(c.MkDir(), "uboot.env.gz")
+	env, err := Create(fname, 4096)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	c.Assert(env.Save(), IsNil)
+	st, err := os.Stat(fname)
+	c.Assert(err, IsNil)
+	c.Check(st.Size() < 4096, Equals, true)
+
+	env, err = Open(fname)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("a"), Equals, "1")
+}
+
+func (u *uenvTestSuite) TestGzipTooLarge(c *C) {
+	compressed, err := gzipImage(make([]byte, 8192))
+	c.Assert(err, IsNil)
+	c.Assert(os.WriteFile(u.envFile, compressed, 0644), IsNil)
+	_, err = OpenWithOptions(u.envFile, Options{MaxSize: 4096})
+	c.Check(err, ErrorMatches, "cannot read .*: larger than the maximum env size of 4096 bytes")
+}
+
+func (u *uenvTestSuite) TestGzipMagicInCRC(c *C) {
+	// a raw image whose CRC starts like gzip is read as it is
+	img := append([]byte{0x1f, 0x8b, 0x08, 0x00, 0x00}, "a=1\x00\x00"...)
+	_, ok, err := gunzipImage(img, "env", 4096)
+	c.Check(err, IsNil)
+	c.Check(ok, Equals, false)
+}
+
+func (u *uenvTestSuite) TestWriteBackup(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+	dir := c.MkDir()
+
+	c.Assert(env.WriteBackup(filepath.Join(dir, "backup.env")), IsNil)
+	c.Assert(env.WriteBackup(filepath.Join(dir, "backup.env.gz")), IsNil)
+	plain, err := os.ReadFile(filepath.Join(dir, "backup.env"))
+	c.Assert(err, IsNil)
+	c.Check(plain, HasLen, 4096)
+	c.Check(gunzip(c, filepath.Join(dir, "backup.env.gz")), DeepEquals, plain)
+
+	backup, err := Open(filepath.Join(dir, "backup.env.gz"))
+	c.Assert(err, IsNil)
+	c.Check(backup.Get("a"), Equals, "1")
+}
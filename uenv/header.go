@@ -0,0 +1,154 @@
+package uenv
+
+import (
+	"fmt"
+)
+
+// headerSize is the size of the header in front of the variables of
+// an environment with a single copy, which is just the CRC.
+const headerSize = 4
+
+// redundantHeaderSize is the size of the header of the copies of a
+// CONFIG_SYS_REDUNDAND_ENVIRONMENT, the CRC is followed by the flags
+// byte that selects the active copy.
+const redundantHeaderSize = flagsOffset + 1
+
+// HeaderLayout selects the header in front of the variables.
+type HeaderLayout int
+
+const (
+	// HeaderAuto uses HeaderSingle for environments with one copy
+	// and HeaderRedundant for the copies of redundant environments,
+	// like U-Boot and mkenvimage do. Existing environments with one
+	// copy keep the layout they were found in.
+	HeaderAuto HeaderLayout = iota
+	// HeaderSingle is the header of an environment with a single
+	// copy: the CRC of the variables.
+	HeaderSingle
+	// HeaderRedundant is the header of the copies of a redundant
+	// environment: the CRC followed by the flags byte. Some tools,
+	// like snapd, also use it for environments with one copy.
+	HeaderRedundant
+)
+
+func (h HeaderLayout) String() string {
+	switch h {
+	case HeaderAuto:
+		return "auto"
+	case HeaderSingle:
+		return "single"
+	case HeaderRedundant:
+		return "redundant"
+	}
+	return fmt.Sprintf("HeaderLayout(%d)", int(h))
+}
+
+// MarshalText returns the name of the header layout.
+func (h HeaderLayout) MarshalText() ([]byte, error) {
+	switch h {
+	case HeaderAuto, HeaderSingle, HeaderRedundant:
+		return []byte(h.String()), nil
+	}
+	return nil, fmt.Errorf("unknown header layout %d", int(h))
+}
+
+// UnmarshalText parses the name of a header layout, "auto", "single"
+// or "redundant".
+func (h *HeaderLayout) UnmarshalText(text []byte) error {
+	for _, layout := range []HeaderLayout{HeaderAuto, HeaderSingle, HeaderRedundant} {
+		if string(text) == layout.String() {
+			*h = layout
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown header layout %q", text)
+}
+
+// size returns the size of the header
+func (h HeaderLayout) size() int {
+	if h == HeaderRedundant {
+		return redundantHeaderSize
+	}
+	return headerSize
+}
+
+// headerFor returns the size of the header of the environment kept on
+// the given storage
+func (opts Options) headerFor(s Storage) (int, error) {
+	redundant := findRedundant(s) != nil
+	switch opts.Header {
+	case HeaderAuto:
+		if redundant {
+			return redundantHeaderSize, nil
+		}
+		return headerSize, nil
+	case HeaderSingle:
+		if redundant {
+			return 0, fmt.Errorf("cannot use header without flags byte for redundant environment")
+		}
+		return headerSize, nil
+	case HeaderRedundant:
+		return redundantHeaderSize, nil
+	}
+	return 0, fmt.Errorf("unknown header layout %d", int(opts.Header))
+}
+
+// SetHeader changes the layout of the header that the next Save
+// writes, e.g. to convert an environment that has the flags byte
+// although it has one copy to the layout U-Boot and mkenvimage use.
+func (env *Env) SetHeader(layout HeaderLayout) error {
+	opts := env.opts
+	opts.Header = layout
+	header, err := opts.headerFor(env.storage)
+	if err != nil {
+		return err
+	}
+	if need, avail := env.payloadSize(), env.size-header; need > avail {
+		return fmt.Errorf("environment too big: %d bytes needed, %d available", need, avail)
+	}
+	env.opts.Header = layout
+	env.header = header
+	return nil
+}
+
+// detectsHeader returns whether the layout of the header is detected
+// when the environment is read
+func (env *Env) detectsHeader() bool {
+	return env.opts.Header == HeaderAuto && findRedundant(env.storage) == nil
+}
+
+// Header returns the layout of the header of the environment, either
+// HeaderSingle or HeaderRedundant.
+func (env *Env) Header() HeaderLayout {
+	if env.header == redundantHeaderSize {
+		return HeaderRedundant
+	}
+	return HeaderSingle
+}
+
+// DetectHeader returns the header layout that matches the image with
+// the given checksum, e.g. to find out if a dump is a copy of a
+// redundant environment.
+func DetectHeader(image []byte, sum Checksum) (HeaderLayout, error) {
+	header, err := detectHeader(image, sum)
+	if err != nil {
+		return HeaderAuto, err
+	}
+	if header == redundantHeaderSize {
+		return HeaderRedundant, nil
+	}
+	return HeaderSingle, nil
+}
+
+// detectHeader verifies an image of an unknown layout and returns the
+// size of its header, the header of a single copy is tried first
+func detectHeader(contentWithHeader []byte, sum Checksum) (int, error) {
+	err := verifyImage(contentWithHeader, headerSize, sum)
+	if err == nil {
+		return headerSize, nil
+	}
+	if verifyImage(contentWithHeader, redundantHeaderSize, sum) == nil {
+		return redundantHeaderSize, nil
+	}
+	return 0, err
+}
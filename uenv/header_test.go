@@ -0,0 +1,67 @@
+package uenv
+
+import (
+	"hash/crc32"
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestOpenDetectsFlagsByte(c *C) {
+	env, err := CreateWithOptions(u.envFile, 32, Options{Header: HeaderRedundant})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.Header(), Equals, HeaderRedundant)
+	c.Assert(env.Get("foo"), Equals, "bar")
+
+	// saves keep the layout
+	env.Set("foo", "baz")
+	c.Assert(env.Save(), IsNil)
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(string(content[redundantHeaderSize:redundantHeaderSize+7]), Equals, "foo=baz")
+}
+
+func (u *uenvTestSuite) TestSetHeader(c *C) {
+	env, err := CreateWithOptions(u.envFile, 32, Options{Header: HeaderRedundant})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.SetHeader(HeaderSingle), IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, 32)
+	c.Assert(readUint32(content), Equals, crc32.ChecksumIEEE(content[headerSize:]))
+	c.Assert(string(content[headerSize:headerSize+7]), Equals, "foo=bar")
+
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.Header(), Equals, HeaderSingle)
+	c.Assert(env.Get("foo"), Equals, "bar")
+}
+
+func (u *uenvTestSuite) TestSetHeaderTooBig(c *C) {
+	env, err := Create(u.envFile, 12)
+	c.Assert(err, IsNil)
+	env.Set("a", "bcde")
+	c.Assert(env.Save(), IsNil)
+
+	c.Assert(env.SetHeader(HeaderRedundant), ErrorMatches, "environment too big: 8 bytes needed, 7 available")
+	c.Assert(env.Header(), Equals, HeaderSingle)
+}
+
+func (r *redundantTestSuite) TestSetHeaderRedundant(c *C) {
+	env, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Header(), Equals, HeaderRedundant)
+	c.Assert(env.SetHeader(HeaderSingle), ErrorMatches, "cannot use header without flags byte for redundant environment")
+}
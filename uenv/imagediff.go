@@ -0,0 +1,177 @@
+package uenv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Region is a part of an environment image.
+type Region int
+
+const (
+	// RegionCRC is the checksum at the start of the header.
+	RegionCRC Region = iota
+	// RegionFlags is the flags byte of the header.
+	RegionFlags
+	// RegionPayload are the variables including the terminating
+	// double \0.
+	RegionPayload
+	// RegionPadding is the space after the payload.
+	RegionPadding
+	// RegionMissing is beyond the end of the shorter image.
+	RegionMissing
+)
+
+func (r Region) String() string {
+	switch r {
+	case RegionCRC:
+		return "crc"
+	case RegionFlags:
+		return "flags"
+	case RegionPayload:
+		return "payload"
+	case RegionPadding:
+		return "padding"
+	case RegionMissing:
+		return "missing"
+	}
+	return fmt.Sprintf("Region(%d)", int(r))
+}
+
+// ByteRange is a range of bytes that differs between two images.
+type ByteRange struct {
+	Offset int
+	// A and B are the bytes of the range in each image, they are
+	// shorter if the image ends within the range.
+	A, B []byte
+	// RegionA and RegionB are the regions the range lies in.
+	RegionA, RegionB Region
+}
+
+// imageLayout finds the regions of an image, the image is not
+// required to be valid
+type imageLayout struct {
+	size       int
+	header     int
+	payloadEnd int
+}
+
+func newImageLayout(img []byte, header int) imageLayout {
+	l := imageLayout{size: len(img), header: header, payloadEnd: len(img)}
+	if len(img) > l.header {
+		if eof := bytes.Index(img[l.header:], []byte{0, 0}); eof >= 0 {
+			l.payloadEnd = l.header + eof + 2
+		}
+	}
+	return l
+}
+
+func (l imageLayout) region(off int) Region {
+	switch {
+	case off >= l.size:
+		return RegionMissing
+	case off < flagsOffset:
+		return RegionCRC
+	case off < l.header:
+		return RegionFlags
+	case off < l.payloadEnd:
+		return RegionPayload
+	default:
+		return RegionPadding
+	}
+}
+
+// imageHeader returns the size of the header of an image, images that
+// cannot be verified are taken as single copies
+func imageHeader(img []byte) (int, error) {
+	_, header, err := detectLayout(img)
+	if err != nil {
+		return headerSize, err
+	}
+	return header, nil
+}
+
+// DiffImages compares two environment images byte by byte, e.g. to
+// find out why two environments with the same variables differ. The
+// differing bytes are returned as ranges that each lie within one
+// region of both images.
+func DiffImages(a, b []byte) []ByteRange {
+	ha, errA := imageHeader(a)
+	hb, errB := imageHeader(b)
+	// a broken image is taken to have the layout of the other one
+	switch {
+	case errA != nil && errB == nil:
+		ha = hb
+	case errB != nil && errA == nil:
+		hb = ha
+	}
+	la, lb := newImageLayout(a, ha), newImageLayout(b, hb)
+	size := len(a)
+	if len(b) > size {
+		size = len(b)
+	}
+	byteAt := func(img []byte, off int) (byte, bool) {
+		if off < len(img) {
+			return img[off], true
+		}
+		return 0, false
+	}
+
+	var ranges []ByteRange
+	var cur *ByteRange
+	for off := 0; off < size; off++ {
+		ba, oka := byteAt(a, off)
+		bb, okb := byteAt(b, off)
+		if oka == okb && ba == bb {
+			cur = nil
+			continue
+		}
+		ra, rb := la.region(off), lb.region(off)
+		if cur == nil || cur.RegionA != ra || cur.RegionB != rb {
+			ranges = append(ranges, ByteRange{Offset: off, RegionA: ra, RegionB: rb})
+			cur = &ranges[len(ranges)-1]
+		}
+		if oka {
+			cur.A = append(cur.A, ba)
+		}
+		if okb {
+			cur.B = append(cur.B, bb)
+		}
+	}
+	return ranges
+}
+
+// FormatImageDiff writes the ranges as annotated hexdumps, the bytes
+// of the first image prefixed with "-" and the ones of the second
+// with "+".
+func FormatImageDiff(w io.Writer, ranges []ByteRange) error {
+	for _, r := range ranges {
+		region := r.RegionA.String()
+		if r.RegionB != r.RegionA {
+			region += "/" + r.RegionB.String()
+		}
+		n := len(r.A)
+		if len(r.B) > n {
+			n = len(r.B)
+		}
+		if _, err := fmt.Fprintf(w, "%s at 0x%x, %d bytes:\n", region, r.Offset, n); err != nil {
+			return err
+		}
+		for _, side := range []struct {
+			prefix string
+			data   []byte
+		}{{"-", r.A}, {"+", r.B}} {
+			for i := 0; i < len(side.data); i += 16 {
+				end := i + 16
+				if end > len(side.data) {
+					end = len(side.data)
+				}
+				if _, err := fmt.Fprintf(w, "%s %s\n", side.prefix, hexdumpLine(r.Offset+i, side.data[i:end])); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
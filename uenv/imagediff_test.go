@@ -0,0 +1,68 @@
+package uenv
+
+import (
+	"bytes"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestDiffImagesIdentical(c *C) {
+	env, err := NewEnv(64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	snap, err := env.Snapshot("")
+	c.Assert(err, IsNil)
+	c.Assert(DiffImages(snap.Image, snap.Image), HasLen, 0)
+}
+
+func (u *uenvTestSuite) TestDiffImages(c *C) {
+	env, err := CreateStorage(NewMemStorage(nil), 32, Options{Header: HeaderRedundant})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	snap, err := env.Snapshot("")
+	c.Assert(err, IsNil)
+	a := snap.Image
+
+	// same variables, different flags and padding and one more byte
+	b := append(append([]byte(nil), a...), 0xff)
+	b[flagsOffset] = 1
+	for i := redundantHeaderSize + 10; i < 20; i++ {
+		b[i] = 0
+	}
+	ranges := DiffImages(a, b)
+	c.Assert(ranges, DeepEquals, []ByteRange{
+		{Offset: 4, A: []byte{0}, B: []byte{1}, RegionA: RegionFlags, RegionB: RegionFlags},
+		{Offset: 15, A: []byte{0xff, 0xff, 0xff, 0xff, 0xff}, B: []byte{0, 0, 0, 0, 0}, RegionA: RegionPadding, RegionB: RegionPadding},
+		{Offset: 32, B: []byte{0xff}, RegionA: RegionMissing, RegionB: RegionPadding},
+	})
+
+	var buf bytes.Buffer
+	c.Assert(FormatImageDiff(&buf, ranges), IsNil)
+	c.Assert(buf.String(), Equals, `flags at 0x4, 1 bytes:
+- 00000004  00  |.|
++ 00000004  01  |.|
+padding at 0xf, 5 bytes:
+- 0000000f  ff ff ff ff ff  |.....|
++ 0000000f  00 00 00 00 00  |.....|
+missing/padding at 0x20, 1 bytes:
++ 00000020  ff  |.|
+`)
+}
+
+func (u *uenvTestSuite) TestDiffImagesPayload(c *C) {
+	env, err := NewEnv(32)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	a, err := env.Snapshot("")
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar2")
+	b, err := env.Snapshot("")
+	c.Assert(err, IsNil)
+
+	ranges := DiffImages(a.Image, b.Image)
+	c.Assert(ranges, HasLen, 3)
+	c.Check(ranges[0].RegionA, Equals, RegionCRC)
+	c.Check(ranges[1], DeepEquals, ByteRange{Offset: 11, A: []byte{0}, B: []byte{'2'}, RegionA: RegionPayload, RegionB: RegionPayload})
+	// the terminator moved into the padding of the first image
+	c.Check(ranges[2], DeepEquals, ByteRange{Offset: 13, A: []byte{0xff}, B: []byte{0}, RegionA: RegionPadding, RegionB: RegionPayload})
+}
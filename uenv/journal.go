@@ -0,0 +1,109 @@
+package uenv
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// JournalEntry is one saved change-set in a journal file
+type JournalEntry struct {
+	Time time.Time `json:"time"`
+	// Program and UID identify who saved the changes
+	Program string   `json:"program"`
+	UID     int      `json:"uid"`
+	Changes []Change `json:"changes"`
+}
+
+var timeNow = time.Now
+
+// snapshot remembers the variables as stored, so that the changes of
+// the next save can be journaled
+func (env *Env) snapshot() {
+	if env.opts.Journal == "" && env.opts.Audit == nil {
+		return
+	}
+	env.load()
+	env.journaled = make(map[string]string, len(env.data))
+	for k, v := range env.data {
+		env.journaled[k] = v
+	}
+}
+
+// journal appends the changes since the last snapshot to the journal
+// and passes them to the audit sink
+func (env *Env) journal() error {
+	if env.opts.Journal == "" && env.opts.Audit == nil {
+		return nil
+	}
+	env.load()
+	changes := diffData(env.journaled, env.data)
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := env.writeJournal(changes); err != nil {
+		return err
+	}
+	// the changes are not recorded again if the audit sink fails
+	err := env.audit(changes)
+	env.snapshot()
+	return err
+}
+
+// writeJournal appends the changes to the journal file
+func (env *Env) writeJournal(changes []Change) error {
+	if env.opts.Journal == "" {
+		return nil
+	}
+	// the values are redacted in a copy, the audit sink hashes the
+	// real ones
+	changes = append([]Change(nil), changes...)
+	for i := range changes {
+		c := &changes[i]
+		c.OldValue = env.displayValue(c.Name, c.OldValue)
+		c.NewValue = env.displayValue(c.Name, c.NewValue)
+	}
+	entry := JournalEntry{
+		Time:    timeNow().UTC(),
+		Program: filepath.Base(os.Args[0]),
+		UID:     os.Getuid(),
+		Changes: changes,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(env.opts.Journal, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write journal: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("cannot write journal: %v", err)
+	}
+	return nil
+}
+
+// ReadJournal reads all entries of a journal file
+func ReadJournal(fname string) ([]JournalEntry, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("cannot read %s line %d: %v", fname, lineno, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
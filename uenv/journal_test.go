@@ -0,0 +1,70 @@
+package uenv
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestJournal(c *C) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	journal := filepath.Join(c.MkDir(), "uboot.env.journal")
+	opts := Options{Journal: journal}
+	env, err := CreateWithOptions(u.envFile, 64, opts)
+	c.Assert(err, IsNil)
+	env.Set("bootcount", "0")
+	env.Set("upgrade_available", "1")
+	c.Assert(env.Save(), IsNil)
+	// saves without changes are not journaled
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenWithOptions(u.envFile, opts)
+	c.Assert(err, IsNil)
+	env.Set("bootcount", "1")
+	env.Set("upgrade_available", "")
+	c.Assert(env.Save(), IsNil)
+
+	entries, err := ReadJournal(journal)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Time.Equal(now), Equals, true)
+	c.Assert(entries[0].Program, Equals, filepath.Base(os.Args[0]))
+	c.Assert(entries[0].UID, Equals, os.Getuid())
+	c.Assert(entries[0].Changes, DeepEquals, []Change{
+		{Name: "bootcount", NewValue: "0"},
+		{Name: "upgrade_available", NewValue: "1"},
+	})
+	c.Assert(entries[1].Changes, DeepEquals, []Change{
+		{Name: "bootcount", OldValue: "0", NewValue: "1"},
+		{Name: "upgrade_available", OldValue: "1"},
+	})
+
+	content, err := os.ReadFile(journal)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Matches, `(?s)\{"time":"2026-10-16T12:00:00Z","program":".*","uid":[-0-9]+,"changes":\[\{"name":"bootcount","new":"0"\},.*\n.*\n`)
+}
+
+func (u *uenvTestSuite) TestJournalUnwritable(c *C) {
+	opts := Options{Journal: filepath.Join(c.MkDir(), "missing-dir", "journal")}
+	env, err := CreateWithOptions(u.envFile, 64, opts)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.JournalError(), ErrorMatches, "cannot write journal: .*")
+
+	// the environment itself was saved
+	env2, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env2.Get("foo"), Equals, "bar")
+
+	// the error is reset by the next save
+	c.Assert(os.Mkdir(filepath.Dir(opts.Journal), 0755), IsNil)
+	env.Set("foo", "baz")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.JournalError(), IsNil)
+}
@@ -0,0 +1,85 @@
+package uenv
+
+import (
+	"bytes"
+	"hash/crc32"
+	"os"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestOpenLazy(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	img, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+
+	env, err = OpenWithFlags(u.envFile, OpenLazy)
+	c.Assert(err, IsNil)
+	c.Check(env.data, IsNil)
+	c.Check(env.RawPayload(), DeepEquals, img[headerSize:])
+	c.Check(env.data, IsNil)
+
+	c.Check(env.Get("foo"), Equals, "bar")
+	c.Check(env.raw, IsNil)
+	c.Check(env.RawPayload(), DeepEquals, img[headerSize:])
+
+	env.Set("foo", "baz")
+	c.Check(bytes.HasPrefix(env.RawPayload(), []byte("foo=baz\x00\x00")), Equals, true)
+}
+
+func (u *uenvTestSuite) TestOpenLazyVerifiesCRC(c *C) {
+	_, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	img, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	img[10] ^= 0xff
+	c.Assert(os.WriteFile(u.envFile, img, 0644), IsNil)
+
+	_, err = OpenWithFlags(u.envFile, OpenLazy)
+	c.Check(err, ErrorMatches, "bad CRC: .*")
+}
+
+func (u *uenvTestSuite) TestOpenLazyMalformed(c *C) {
+	mem := NewMemStorage(nil)
+	env, err := CreateStorage(mem, 64, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	// a line without "=" and a valid CRC
+	img := mem.Bytes()
+	copy(img[headerSize:], "garbage\x00a=1\x00\x00")
+	copy(img, writeUint32(crc32.ChecksumIEEE(img[headerSize:])))
+
+	_, err = OpenStorage(mem, Options{})
+	c.Check(err, NotNil)
+
+	env, err = OpenStorage(mem, Options{Flags: OpenLazy})
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "a=1\n")
+	c.Check(env.ParseWarnings(), HasLen, 1)
+}
+
+func (u *uenvTestSuite) TestOpenLazyImport(c *C) {
+	env, err := Create(u.envFile, 128)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenWithFlags(u.envFile, OpenLazy)
+	c.Assert(err, IsNil)
+	c.Assert(env.Import(strings.NewReader("baz=1\n")), IsNil)
+	c.Check(env.String(), Equals, "baz=1\nfoo=bar\n")
+
+	env, err = OpenWithFlags(u.envFile, OpenLazy)
+	c.Assert(err, IsNil)
+	c.Assert(env.ImportConsoleLog(strings.NewReader("=> printenv\nbaz=2\n=> "), ConsoleOptions{}), IsNil)
+	c.Check(env.String(), Equals, "baz=2\nfoo=bar\n")
+
+	env, err = OpenWithFlags(u.envFile, OpenLazy)
+	c.Assert(err, IsNil)
+	c.Check(env.Undo(1), Equals, 0)
+	c.Check(env.Get("foo"), Equals, "bar")
+}
@@ -0,0 +1,63 @@
+package uenv
+
+import (
+	"fmt"
+)
+
+// Limits describe what a board's U-Boot accepts, so that environments
+// that are fine here do not fail on the board.
+type Limits struct {
+	// CommandBufferSize is CONFIG_SYS_CBSIZE of the board, the size
+	// of the console buffer including the terminating \0. Values
+	// that do not fit cannot be run. Zero disables the check.
+	CommandBufferSize int
+	// MaxNameLength is the longest allowed variable name, zero
+	// means names are only limited by CommandBufferSize.
+	MaxNameLength int
+}
+
+// DefaultLimits are the limits of U-Boot's default configuration.
+var DefaultLimits = Limits{CommandBufferSize: 256}
+
+// LimitError describes a variable that U-Boot cannot handle.
+type LimitError struct {
+	Name string
+	Msg  string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("variable %q: %s", e.Name, e.Msg)
+}
+
+// checkName returns why U-Boot cannot handle a variable of the given
+// name or ""
+func (l *Limits) checkName(name string) string {
+	for _, r := range name {
+		if r == '=' || r < 0x20 || r == 0x7f {
+			return fmt.Sprintf("name contains invalid character %q", r)
+		}
+	}
+	if l.MaxNameLength > 0 && len(name) > l.MaxNameLength {
+		return fmt.Sprintf("name is %d bytes long, the limit is %d", len(name), l.MaxNameLength)
+	}
+	if l.CommandBufferSize > 0 && len(name) >= l.CommandBufferSize {
+		return fmt.Sprintf("name is %d bytes long, the command buffer holds %d", len(name), l.CommandBufferSize-1)
+	}
+	return ""
+}
+
+// CheckLimits returns the variables that violate the given limits in
+// sorted order.
+func (env *Env) CheckLimits(limits Limits) []*LimitError {
+	var errs []*LimitError
+	env.iterEnv(func(key, value string) {
+		msg := limits.checkName(key)
+		if msg == "" && limits.CommandBufferSize > 0 && len(value) >= limits.CommandBufferSize {
+			msg = fmt.Sprintf("value is %d bytes long, the command buffer holds %d", len(value), limits.CommandBufferSize-1)
+		}
+		if msg != "" {
+			errs = append(errs, &LimitError{Name: key, Msg: msg})
+		}
+	})
+	return errs
+}
@@ -0,0 +1,42 @@
+package uenv
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestCheckLimits(c *C) {
+	env, err := NewEnv(8192)
+	c.Assert(err, IsNil)
+	env.Set("bootcmd", strings.Repeat("x", 255))
+	env.Set("bootargs", strings.Repeat("x", 256))
+	env.Set("a=b", "1")
+	env.Set("tab\tname", "1")
+	env.Set(strings.Repeat("n", 40), "1")
+	c.Assert(env.CheckLimits(Limits{}), HasLen, 2)
+
+	errs := env.CheckLimits(Limits{CommandBufferSize: 256, MaxNameLength: 32})
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	c.Assert(msgs, DeepEquals, []string{
+		`variable "a=b": name contains invalid character '='`,
+		`variable "bootargs": value is 256 bytes long, the command buffer holds 255`,
+		`variable "nnnnnnnnnnnnnnnnnnnnnnnnnnnnnnnnnnnnnnnn": name is 40 bytes long, the limit is 32`,
+		`variable "tab\tname": name contains invalid character '\t'`,
+	})
+}
+
+func (u *uenvTestSuite) TestSaveWithLimits(c *C) {
+	env, err := CreateWithOptions(u.envFile, 4096, Options{Limits: &DefaultLimits})
+	c.Assert(err, IsNil)
+	env.Set("bootcmd", strings.Repeat("x", 300))
+	err = env.Save()
+	c.Assert(err, ErrorMatches, `variable "bootcmd": value is 300 bytes long, the command buffer holds 255`)
+	c.Assert(err, FitsTypeOf, &LimitError{})
+
+	env.Set("bootcmd", "boot")
+	c.Assert(env.Save(), IsNil)
+}
@@ -0,0 +1,195 @@
+package uenv
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// ErrLocked is returned by TryLockFile if the file is locked by
+// someone else.
+var ErrLocked = errors.New("environment is locked by another process")
+
+// Lock is an advisory lock on a file, like the lock the U-Boot
+// userspace tools take before accessing the environment.
+type Lock struct {
+	f *os.File
+}
+
+// LockFile waits until it holds an exclusive lock on the given file,
+// the file is created if needed. On platforms without file locking the
+// file is only created and the lock does not exclude anyone.
+func LockFile(fname string) (*Lock, error) {
+	return lockFile(fname, true)
+}
+
+// TryLockFile takes an exclusive lock on the given file like LockFile
+// but returns ErrLocked instead of waiting.
+func TryLockFile(fname string) (*Lock, error) {
+	return lockFile(fname, false)
+}
+
+func lockFile(fname string, wait bool) (*Lock, error) {
+	f, err := os.OpenFile(fname, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f, wait); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	return l.f.Close()
+}
+
+// Update locks the environment file, opens it, applies f and saves the
+// result in one go. The environment is not saved if f returns an
+// error.
+func Update(fname string, f func(env *Env) error) error {
+	return UpdateWithOptions(fname, Options{}, f)
+}
+
+// UpdateWithOptions is Update with the given options. The lock is
+// taken on Options.Lockfile, or on a lock file next to the environment
+// file if that is not set.
+func UpdateWithOptions(fname string, opts Options, f func(env *Env) error) error {
+	return update(fname, opts, LockFile, f)
+}
+
+func update(fname string, opts Options, lockFile func(string) (*Lock, error), f func(env *Env) error) error {
+	lockfile := opts.Lockfile
+	if lockfile == "" {
+		// do not leave a lock file behind for a missing env
+		if _, err := os.Stat(fname); err != nil {
+			return err
+		}
+		lockfile = sidecarLockfile(fname)
+	}
+	lock, err := lockFile(lockfile)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	env, err := openWithOptions(fname, opts, lock)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	if err := f(env); err != nil {
+		return err
+	}
+	return env.Save()
+}
+
+// Update locks the environment, reads it again so that changes saved
+// by other processes in the meantime are not lost, applies f and saves
+// the result in one go. Changes that were not saved before are
+// discarded. If f returns an error the environment is not saved and
+// holds the stored variables again. Options.Cache is not used for the
+// read under the lock.
+//
+// The lock is taken on Options.Lockfile, or on a lock file next to the
+// environment file if that is not set. Environments on other storages
+// need Options.Lockfile.
+func (env *Env) Update(f func(env *Env) error) error {
+	lockfile := env.lockfile()
+	if lockfile == "" && env.lock == nil {
+		return errors.New("cannot lock environment without Options.Lockfile")
+	}
+	return env.withLock(lockfile, func() error {
+		// a cached image may predate the save of someone else
+		if env.opts.Cache != nil {
+			env.opts.Cache.Invalidate()
+		}
+		if err := env.reload(); err != nil {
+			return err
+		}
+		if err := f(env); err != nil {
+			env.reload()
+			return err
+		}
+		return env.Save()
+	})
+}
+
+// lockfile returns the file Update locks or "" if there is none
+func (env *Env) lockfile() string {
+	if env.opts.Lockfile != "" {
+		return env.opts.Lockfile
+	}
+	s := env.storage
+	for {
+		w, ok := s.(storageWrapper)
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	switch s := s.(type) {
+	case *fileStorage:
+		return sidecarLockfile(s.fname)
+	case *mmapStorage:
+		return sidecarLockfile(s.fname)
+	}
+	return ""
+}
+
+// sidecarLockfile returns the lock file of an environment file. The
+// environment file itself cannot be locked as saves with WriteRename
+// and of gzip files replace it.
+func sidecarLockfile(fname string) string {
+	return fname + ".lock"
+}
+
+// withLock calls f while holding the lock on the given file. Nothing
+// is locked if the file is "" or the env holds its lock already.
+func (env *Env) withLock(lockfile string, f func() error) error {
+	if lockfile == "" || env.lock != nil {
+		return f()
+	}
+	lock, err := LockFile(lockfile)
+	if err != nil {
+		return err
+	}
+	return env.holding(lock, f)
+}
+
+// withIOLock calls f while holding the lock of Reload and Save unless
+// Options.NoLock is set. The lock file next to an environment file is
+// left out if it cannot be created, e.g. on a read-only file system.
+func (env *Env) withIOLock(f func() error) error {
+	if env.opts.NoLock || env.lock != nil {
+		return f()
+	}
+	lockfile := env.lockfile()
+	if lockfile == "" {
+		return f()
+	}
+	lock, err := LockFile(lockfile)
+	if err != nil && env.opts.Lockfile == "" && (errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS)) {
+		env.opts.logger().Debug("cannot create lock file", "lockfile", lockfile, "err", err)
+		return f()
+	}
+	if err != nil {
+		return err
+	}
+	return env.holding(lock, f)
+}
+
+// holding calls f with the lock set as the lock of the env and
+// releases it afterwards
+func (env *Env) holding(lock *Lock, f func() error) error {
+	env.lock = lock
+	defer func() {
+		env.lock = nil
+		lock.Unlock()
+	}()
+	return f()
+}
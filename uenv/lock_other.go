@@ -0,0 +1,12 @@
+//go:build !unix
+
+package uenv
+
+import (
+	"os"
+)
+
+// flock does nothing, file locking is not supported on this platform
+func flock(f *os.File, wait bool) error {
+	return nil
+}
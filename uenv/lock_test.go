@@ -0,0 +1,311 @@
+package uenv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestUpdate(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+
+	// concurrent updates do not lose increments
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Update(u.envFile, func(env *Env) error {
+				n, _ := strconv.Atoi(env.Get("bootcount"))
+				env.Set("bootcount", strconv.Itoa(n+1))
+				return nil
+			})
+			c.Check(err, IsNil)
+		}()
+	}
+	wg.Wait()
+
+	env, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("bootcount"), Equals, "10")
+}
+
+func (u *uenvTestSuite) TestUpdateRename(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+
+	// the lock survives the renames of the saves
+	opts := Options{WriteStrategy: WriteRename}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				err := UpdateWithOptions(u.envFile, opts, func(env *Env) error {
+					n, _ := strconv.Atoi(env.Get("bootcount"))
+					env.Set("bootcount", strconv.Itoa(n+1))
+					return nil
+				})
+				c.Check(err, IsNil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	env, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("bootcount"), Equals, "40")
+}
+
+func (u *uenvTestSuite) TestUpdateMissing(c *C) {
+	err := Update(u.envFile, func(env *Env) error { return nil })
+	c.Assert(err, ErrorMatches, "stat .*/uboot.env: no such file or directory")
+	_, err = os.Stat(u.envFile)
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = os.Stat(u.envFile + ".lock")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (u *uenvTestSuite) TestUpdateError(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+
+	lockfile := filepath.Join(c.MkDir(), "fw_printenv.lock")
+	err = UpdateWithOptions(u.envFile, Options{Lockfile: lockfile}, func(env *Env) error {
+		env.Set("foo", "bar")
+		return errors.New("boom")
+	})
+	c.Assert(err, ErrorMatches, "boom")
+	env, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "")
+
+	// the lock was released
+	lock, err := LockFile(lockfile)
+	c.Assert(err, IsNil)
+	c.Assert(lock.Unlock(), IsNil)
+}
+
+func (u *uenvTestSuite) TestTryLockFile(c *C) {
+	lockfile := filepath.Join(c.MkDir(), "lock")
+	lock, err := TryLockFile(lockfile)
+	c.Assert(err, IsNil)
+	_, err = TryLockFile(lockfile)
+	c.Assert(err, Equals, ErrLocked)
+	c.Assert(lock.Unlock(), IsNil)
+	lock, err = TryLockFile(lockfile)
+	c.Assert(err, IsNil)
+	c.Assert(lock.Unlock(), IsNil)
+}
+
+func (u *uenvTestSuite) TestUpdateWithRetry(c *C) {
+	delays, restore := mockSleep()
+	defer restore()
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+
+	// someone else holds the lock for the first two attempts
+	lock, err := LockFile(u.envFile + ".lock")
+	c.Assert(err, IsNil)
+	timeSleep = func(d time.Duration) {
+		*delays = append(*delays, d)
+		if len(*delays) == 2 {
+			lock.Unlock()
+		}
+	}
+	policy := &RetryPolicy{Attempts: 5, Backoff: time.Millisecond, Retryable: IsBusyError}
+	err = UpdateWithRetry(u.envFile, Options{}, policy, func(env *Env) error {
+		env.Set("foo", "bar")
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(*delays, DeepEquals, []time.Duration{time.Millisecond, 2 * time.Millisecond})
+
+	env, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "bar")
+}
+
+func (u *uenvTestSuite) TestEnvUpdate(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+
+	// long-lived envs, like the one of a daemon, do not lose the
+	// increments of others
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		env, err := Open(u.envFile)
+		c.Assert(err, IsNil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer env.Close()
+			err := env.Update(func(env *Env) error {
+				n, _ := strconv.Atoi(env.Get("bootcount"))
+				env.Set("bootcount", strconv.Itoa(n+1))
+				return nil
+			})
+			c.Check(err, IsNil)
+		}()
+	}
+	wg.Wait()
+
+	env, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("bootcount"), Equals, "10")
+}
+
+func (u *uenvTestSuite) TestEnvUpdateRename(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		env, err := OpenWithOptions(u.envFile, Options{WriteStrategy: WriteRename})
+		c.Assert(err, IsNil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer env.Close()
+			for j := 0; j < 20; j++ {
+				err := env.Update(func(env *Env) error {
+					n, _ := strconv.Atoi(env.Get("bootcount"))
+					env.Set("bootcount", strconv.Itoa(n+1))
+					return nil
+				})
+				c.Check(err, IsNil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	env, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("bootcount"), Equals, "40")
+}
+
+func (u *uenvTestSuite) TestEnvUpdateCache(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env, err := OpenWithOptions(u.envFile, Options{Cache: NewReadCache(time.Hour)})
+	c.Assert(err, IsNil)
+
+	// saved by someone else while the image is cached
+	other, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	other.Set("foo", "bar")
+	c.Assert(other.Save(), IsNil)
+
+	err = env.Update(func(env *Env) error {
+		env.Set("bootcount", "1")
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	other, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(other.Get("foo"), Equals, "bar")
+	c.Check(other.Get("bootcount"), Equals, "1")
+}
+
+func (u *uenvTestSuite) TestEnvUpdateError(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	err = env.Update(func(env *Env) error {
+		env.Set("foo", "baz")
+		return errors.New("boom")
+	})
+	c.Assert(err, ErrorMatches, "boom")
+	c.Assert(env.Get("foo"), Equals, "bar")
+
+	// nested saves do not lock again
+	err = env.Update(func(env *Env) error {
+		env.Set("foo", "baz")
+		return env.Save()
+	})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "baz")
+}
+
+func (u *uenvTestSuite) TestEnvUpdateNeedsLockfile(c *C) {
+	env, err := CreateStorage(NewMemStorage(nil), 4096, Options{})
+	c.Assert(err, IsNil)
+	err = env.Update(func(env *Env) error { return nil })
+	c.Assert(err, ErrorMatches, "cannot lock environment without Options.Lockfile")
+}
+
+func (u *uenvTestSuite) TestSaveHoldsLockfile(c *C) {
+	lockfile := filepath.Join(c.MkDir(), "fw_printenv.lock")
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env, err := OpenWithOptions(u.envFile, Options{Lockfile: lockfile})
+	c.Assert(err, IsNil)
+
+	lock, err := LockFile(lockfile)
+	c.Assert(err, IsNil)
+	saved := make(chan error)
+	go func() {
+		env.Set("foo", "bar")
+		saved <- env.Save()
+	}()
+	select {
+	case <-saved:
+		c.Fatal("save did not wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Assert(lock.Unlock(), IsNil)
+	c.Assert(<-saved, IsNil)
+
+	// the lock is released after the save
+	lock, err = TryLockFile(lockfile)
+	c.Assert(err, IsNil)
+	c.Assert(lock.Unlock(), IsNil)
+}
+
+func (u *uenvTestSuite) TestSaveHoldsSidecarLock(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+
+	// without Options.Lockfile the file next to the env is locked
+	lock, err := LockFile(u.envFile + ".lock")
+	c.Assert(err, IsNil)
+	saved := make(chan error)
+	go func() {
+		env.Set("foo", "bar")
+		saved <- env.Save()
+	}()
+	select {
+	case <-saved:
+		c.Fatal("save did not wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Assert(lock.Unlock(), IsNil)
+	c.Assert(<-saved, IsNil)
+}
+
+func (u *uenvTestSuite) TestSaveNoLock(c *C) {
+	_, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	lock, err := LockFile(u.envFile + ".lock")
+	c.Assert(err, IsNil)
+	defer lock.Unlock()
+
+	env, err := OpenWithOptions(u.envFile, Options{NoLock: true})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Reload(), IsNil)
+	c.Assert(env.Get("foo"), Equals, "bar")
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package uenv
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func flock(f *os.File, wait bool) error {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EWOULDBLOCK && !wait {
+			return ErrLocked
+		}
+		if err != nil {
+			return fmt.Errorf("cannot lock %s: %v", f.Name(), err)
+		}
+		return nil
+	}
+}
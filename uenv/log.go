@@ -0,0 +1,23 @@
+package uenv
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler drops all records, it is used when no logger is set
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discardLogger = slog.New(discardHandler{})
+
+func (opts *Options) logger() *slog.Logger {
+	if opts.Logger != nil {
+		return opts.Logger
+	}
+	return discardLogger
+}
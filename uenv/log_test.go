@@ -0,0 +1,55 @@
+package uenv
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func testLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+func (u *uenvTestSuite) TestLogger(c *C) {
+	var buf bytes.Buffer
+	opts := Options{Logger: testLogger(&buf)}
+	env, err := CreateWithOptions(u.envFile, 32, opts)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Reload(), IsNil)
+
+	c.Assert(buf.String(), Equals, `level=DEBUG msg="wrote environment" size=32 sectors_erased=0
+level=DEBUG msg="skipped save of unchanged environment"
+level=DEBUG msg="wrote environment" size=32 sectors_erased=0
+level=DEBUG msg="read environment" size=32 crc=`+fmt.Sprintf("%08x", env.crc)+` vars=1
+`)
+}
+
+func (u *uenvTestSuite) TestLoggerErrorsAndRetries(c *C) {
+	_, restore := mockSleep()
+	defer restore()
+	_, err := Create(u.envFile, 32)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	flaky := &flakyStorage{Storage: newStorage(u.envFile, Options{}), failures: 3, err: syscall.EIO}
+	_, err = OpenStorage(flaky, Options{Logger: testLogger(&buf), Retry: &RetryPolicy{Attempts: 2, Backoff: time.Millisecond}})
+	c.Assert(err, NotNil)
+	c.Assert(buf.String(), Equals, `level=DEBUG msg="retrying storage operation" op=read attempt=1 backoff=1ms err="input/output error"
+level=DEBUG msg="cannot read environment" err="read failed after 2 attempts: input/output error"
+`)
+}
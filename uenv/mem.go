@@ -0,0 +1,114 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// MemStorage keeps an environment image in memory. It is useful for
+// tests and simulations of code that works with environments.
+type MemStorage struct {
+	image []byte
+}
+
+// NewMemStorage returns a storage that initially holds the given image,
+// which may be nil for an empty storage.
+func NewMemStorage(image []byte) *MemStorage {
+	return &MemStorage{image: image}
+}
+
+// Bytes returns the stored image. The slice is reused by later writes
+// of the same size.
+func (s *MemStorage) Bytes() []byte {
+	return s.image
+}
+
+func (s *MemStorage) ReadImage() ([]byte, error) {
+	if s.image == nil {
+		return nil, os.ErrNotExist
+	}
+	return s.image, nil
+}
+
+func (s *MemStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if len(s.image) != size {
+		s.image = make([]byte, size)
+	}
+	return fill(sliceWriter(s.image))
+}
+
+func (s *MemStorage) patchFlags(flags byte) error {
+	if len(s.image) <= flagsOffset {
+		return fmt.Errorf("cannot write flags of image of size %d", len(s.image))
+	}
+	s.image[flagsOffset] = flags
+	return nil
+}
+
+// NewEnv creates a new empty environment of the given size that is
+// only kept in memory, e.g. to assemble a firmware image. Its image is
+// written out with WriteTo.
+func NewEnv(size int) (*Env, error) {
+	return CreateStorage(NewMemStorage(nil), size, Options{})
+}
+
+// NewMemEnv is the same as NewEnv.
+//
+// Deprecated: Use NewEnv.
+func NewMemEnv(size int) (*Env, error) {
+	return NewEnv(size)
+}
+
+// ReadEnv reads an environment image of the given size from r, e.g.
+// from a pipe or from the middle of a larger firmware image, and keeps
+// it in memory. Nothing after the image is read. If size is 0 r is
+// read up to EOF.
+func ReadEnv(r io.Reader, size int) (*Env, error) {
+	return ReadEnvWithOptions(r, size, Options{})
+}
+
+// ReadEnvWithOptions is ReadEnv with the given options.
+func ReadEnvWithOptions(r io.Reader, size int, opts Options) (*Env, error) {
+	var img []byte
+	var err error
+	switch {
+	case size < 0 || size > opts.maxSize():
+		return nil, fmt.Errorf("invalid env size %d: must be between %d and %d", size, minSize(headerSize), opts.maxSize())
+	case size == 0:
+		img, err = io.ReadAll(io.LimitReader(r, int64(opts.maxSize())+1))
+		if err == nil && len(img) > opts.maxSize() {
+			err = fmt.Errorf("env too large: the maximum is %d bytes", opts.maxSize())
+		}
+	default:
+		img = make([]byte, size)
+		_, err = io.ReadFull(r, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read env: %v", err)
+	}
+	return OpenStorage(NewMemStorage(img), opts)
+}
+
+// image returns the image Save would write, including the header
+func (env *Env) image() ([]byte, error) {
+	if need, avail := env.payloadSize(), env.size-env.header; need > avail {
+		return nil, fmt.Errorf("environment too big: %d bytes needed, %d available", need, avail)
+	}
+	img := make([]byte, env.size)
+	if err := env.writeImage(sliceWriter(img)); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// WriteTo writes the image of the environment to w, as Save would
+// write it to the storage. Changes that were not saved are included.
+func (env *Env) WriteTo(w io.Writer) (int64, error) {
+	img, err := env.image()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(img)
+	return int64(n), err
+}
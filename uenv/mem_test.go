@@ -0,0 +1,88 @@
+package uenv
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestNewEnv(c *C) {
+	env, err := NewEnv(64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Reload(), IsNil)
+	c.Assert(env.String(), Equals, "foo=bar\n")
+
+	_, err = NewEnv(2)
+	c.Assert(err, ErrorMatches, "invalid env size 2: .*")
+
+	// the old name still works
+	env, err = NewMemEnv(64)
+	c.Assert(err, IsNil)
+	c.Assert(env.Size(), Equals, 64)
+}
+
+func (u *uenvTestSuite) TestMemStorage(c *C) {
+	_, err := OpenStorage(NewMemStorage(nil), Options{})
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	storage := NewMemStorage(validImage([]byte("a=b\x00\x00\xff\xff")))
+	env, err := OpenStorage(storage, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("a"), Equals, "b")
+	env.Set("a", "c")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(storage.Bytes(), DeepEquals, validImage([]byte("a=c\x00\x00\xff\xff")))
+}
+
+func (u *uenvTestSuite) TestNewEnvWriteTo(c *C) {
+	env, err := NewEnv(64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+
+	// unsaved changes are written too
+	var buf bytes.Buffer
+	n, err := env.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(64))
+	c.Assert(verifyImage(buf.Bytes(), headerSize, ChecksumCRC32), IsNil)
+
+	env2, err := ReadEnv(&buf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(env2.String(), Equals, "foo=bar\n")
+}
+
+func (u *uenvTestSuite) TestReadEnvEmbedded(c *C) {
+	img := validImage([]byte("a=b\x00\x00\xff\xff"))
+	firmware := append(append([]byte("header"), img...), "trailer"...)
+	r := bytes.NewReader(firmware)
+	r.Seek(6, io.SeekStart)
+
+	env, err := ReadEnv(r, len(img))
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("a"), Equals, "b")
+	// nothing after the env was read
+	rest, err := io.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(rest), Equals, "trailer")
+
+	// saves stay in memory
+	env.Set("a", "c")
+	c.Assert(env.Save(), IsNil)
+	var buf bytes.Buffer
+	_, err = env.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.Bytes(), DeepEquals, validImage([]byte("a=c\x00\x00\xff\xff")))
+}
+
+func (u *uenvTestSuite) TestReadEnvErrors(c *C) {
+	_, err := ReadEnv(bytes.NewReader(make([]byte, 10)), 64)
+	c.Assert(err, ErrorMatches, "cannot read env: unexpected EOF")
+	_, err = ReadEnv(bytes.NewReader(nil), -1)
+	c.Assert(err, ErrorMatches, "invalid env size -1: .*")
+	_, err = ReadEnv(bytes.NewReader(make([]byte, 64)), 64)
+	c.Assert(err, ErrorMatches, "environment is not initialized: .*")
+}
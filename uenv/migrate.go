@@ -0,0 +1,90 @@
+package uenv
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// VersionVariable holds the layout version of an environment that
+// Migrations bring up to date. A missing variable means version 0.
+const VersionVariable = "env_version"
+
+// Migration changes the layout of an environment from version From to
+// version From+1, e.g. by renaming variables or recomputing bootcmd.
+type Migration struct {
+	From    int
+	Migrate func(env *Env) error
+}
+
+// RenameVariables returns a migration function that renames the
+// variables given as old to new names, keeping their values.
+func RenameVariables(renames map[string]string) func(env *Env) error {
+	return func(env *Env) error {
+		env.load()
+		for old, new := range renames {
+			if _, ok := env.data[old]; !ok {
+				continue
+			}
+			if _, ok := env.data[new]; ok {
+				return fmt.Errorf("cannot rename %q to %q: variable exists", old, new)
+			}
+			env.Set(new, env.Get(old))
+			env.Set(old, "")
+		}
+		return nil
+	}
+}
+
+// Version returns the layout version stored in VersionVariable.
+func (env *Env) Version() (int, error) {
+	value := env.Get(VersionVariable)
+	if value == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %s %q: %v", VersionVariable, value, err)
+	}
+	return version, nil
+}
+
+// Migrate applies the migrations starting at the version of the
+// environment one after the other and saves the result in a single
+// write. If a migration fails the environment is left unchanged. It
+// returns the versions before and after the migration.
+func (env *Env) Migrate(migrations []Migration) (from, to int, err error) {
+	from, err = env.Version()
+	if err != nil {
+		return 0, 0, err
+	}
+	steps := make(map[int]Migration, len(migrations))
+	for _, m := range migrations {
+		if _, ok := steps[m.From]; ok {
+			return from, from, fmt.Errorf("more than one migration from version %d", m.From)
+		}
+		steps[m.From] = m
+	}
+
+	to = from
+	for {
+		m, ok := steps[to]
+		if !ok {
+			break
+		}
+		if err := m.Migrate(env); err != nil {
+			env.Reload()
+			return from, from, fmt.Errorf("cannot migrate environment from version %d: %v", to, err)
+		}
+		to++
+		env.Set(VersionVariable, strconv.Itoa(to))
+	}
+	if to == from {
+		return from, to, nil
+	}
+	if err := env.Save(); err != nil {
+		env.Reload()
+		return from, from, fmt.Errorf("cannot save migrated environment: %v", err)
+	}
+	env.opts.logger().Debug("migrated environment", "from", from, "to", to)
+	return from, to, nil
+}
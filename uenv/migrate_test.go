@@ -0,0 +1,74 @@
+package uenv
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+var testMigrations = []Migration{
+	{From: 0, Migrate: RenameVariables(map[string]string{"kernel_addr": "kernel_addr_r"})},
+	{From: 1, Migrate: func(env *Env) error {
+		env.Set("bootcmd", "bootm ${kernel_addr_r}")
+		return nil
+	}},
+}
+
+func (u *uenvTestSuite) TestMigrateOnOpen(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("kernel_addr", "0x82000000")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenWithOptions(u.envFile, Options{Migrations: testMigrations})
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "bootcmd=bootm ${kernel_addr_r}\nenv_version=2\nkernel_addr_r=0x82000000\n")
+
+	// the migrated environment was saved
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get(VersionVariable), Equals, "2")
+	from, to, err := env.Migrate(testMigrations)
+	c.Assert(err, IsNil)
+	c.Check(from, Equals, 2)
+	c.Check(to, Equals, 2)
+}
+
+func (u *uenvTestSuite) TestMigrateFails(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("kernel_addr", "0x82000000")
+	c.Assert(env.Save(), IsNil)
+
+	migrations := append(testMigrations, Migration{From: 2, Migrate: func(env *Env) error {
+		return errors.New("boom")
+	}})
+	_, err = OpenWithOptions(u.envFile, Options{Migrations: migrations})
+	c.Check(err, ErrorMatches, "cannot migrate environment from version 2: boom")
+
+	// nothing was changed
+	from, to, err := env.Migrate(migrations)
+	c.Check(err, ErrorMatches, "cannot migrate environment from version 2: boom")
+	c.Check(from, Equals, 0)
+	c.Check(to, Equals, 0)
+	c.Check(env.String(), Equals, "kernel_addr=0x82000000\n")
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.String(), Equals, "kernel_addr=0x82000000\n")
+}
+
+func (u *uenvTestSuite) TestMigrateErrors(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("kernel_addr", "1")
+	env.Set("kernel_addr_r", "2")
+	_, _, err = env.Migrate(testMigrations)
+	c.Check(err, ErrorMatches, `cannot migrate environment from version 0: cannot rename "kernel_addr" to "kernel_addr_r": variable exists`)
+
+	_, _, err = env.Migrate([]Migration{testMigrations[0], testMigrations[0]})
+	c.Check(err, ErrorMatches, "more than one migration from version 0")
+
+	env.Set(VersionVariable, "two")
+	_, _, err = env.Migrate(testMigrations)
+	c.Check(err, ErrorMatches, `cannot parse env_version "two": .*`)
+}
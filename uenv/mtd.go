@@ -0,0 +1,176 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// isMTD returns true for MTD character devices like /dev/mtd1, the
+// /dev/mtdblockN devices are block devices
+func isMTD(path string) bool {
+	return strings.HasPrefix(path, "/dev/mtd") && !strings.HasPrefix(path, "/dev/mtdblock")
+}
+
+// the MTD ioctls, they can be mocked in tests
+var (
+	mtdEraseSize   = mtdEraseSizeIoctl
+	mtdIsBadBlock  = mtdIsBadBlockIoctl
+	mtdUnlock      = mtdUnlockIoctl
+	mtdEraseBlocks = mtdEraseIoctl
+)
+
+// mtdStorage keeps the environment on raw NOR or NAND flash. Flash
+// has to be erased in whole erase blocks before it is written, so the
+// blocks covering the environment are read, modified, erased and
+// written back like fw_setenv does.
+type mtdStorage struct {
+	path   string
+	offset int64
+	size   int
+	// sectorSize is the erase block size, the device is asked if
+	// it is zero
+	sectorSize int64
+	// sectors is the number of erase blocks the environment spans,
+	// as many as needed if zero
+	sectors int64
+	// unlock unlocks the erase blocks before erasing them
+	unlock   bool
+	tracer   Tracer
+	progress func(Progress)
+	erased   uint64
+}
+
+func newMTDStorage(dev DeviceConfig, size int, opts Options) *mtdStorage {
+	return &mtdStorage{
+		path:       dev.Path,
+		offset:     dev.Offset,
+		size:       size,
+		sectorSize: dev.SectorSize,
+		sectors:    dev.Sectors,
+		unlock:     !dev.DisableLock,
+		tracer:     opts.Tracer,
+		progress:   opts.Progress,
+	}
+}
+
+func (s *mtdStorage) ReadImage() ([]byte, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img := make([]byte, s.size)
+	if _, err := readAt(f, img, s.offset, s.progress); err != nil {
+		return nil, fmt.Errorf("cannot read %d bytes at offset %d of %s: %v", s.size, s.offset, s.path, err)
+	}
+	return img, nil
+}
+
+// span returns the start and the length of the erase blocks covering
+// the environment
+func (s *mtdStorage) span(f *os.File) (start, length, sectorSize int64, err error) {
+	sectorSize = s.sectorSize
+	if sectorSize == 0 {
+		sectorSize, err = mtdEraseSize(f)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("cannot get erase block size of %s: %v", s.path, err)
+		}
+	}
+	if sectorSize <= 0 {
+		return 0, 0, 0, fmt.Errorf("invalid erase block size %d of %s", sectorSize, s.path)
+	}
+	start = s.offset / sectorSize * sectorSize
+	end := (s.offset + int64(s.size) + sectorSize - 1) / sectorSize * sectorSize
+	length = end - start
+	if s.sectors > 0 {
+		if s.sectors*sectorSize < length {
+			return 0, 0, 0, fmt.Errorf("env of size %d at offset %d does not fit into %d erase blocks of %d bytes", s.size, s.offset, s.sectors, sectorSize)
+		}
+		length = s.sectors * sectorSize
+	}
+	return start, length, sectorSize, nil
+}
+
+func (s *mtdStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if size != s.size {
+		return fmt.Errorf("cannot write env of size %d to region of size %d", size, s.size)
+	}
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	start, length, sectorSize, err := s.span(f)
+	if err != nil {
+		return err
+	}
+	for block := start; block < start+length; block += sectorSize {
+		bad, err := mtdIsBadBlock(f, block)
+		if err != nil {
+			return fmt.Errorf("cannot check erase block at offset %d of %s: %v", block, s.path, err)
+		}
+		if bad {
+			return fmt.Errorf("erase block at offset %d of %s is bad", block, s.path)
+		}
+	}
+
+	// the bytes around the environment in the erase blocks must be
+	// kept
+	buf := make([]byte, length)
+	if _, err := readAt(f, buf, start, nil); err != nil {
+		return fmt.Errorf("cannot read %d bytes at offset %d of %s: %v", length, start, s.path, err)
+	}
+	if err := fill(sliceWriter(buf[s.offset-start:][:s.size])); err != nil {
+		return err
+	}
+
+	if s.unlock {
+		// most flash is not locked and does not support locking
+		mtdUnlock(f, start, length)
+	}
+	eraseStart := time.Now()
+	err = mtdEraseBlocks(f, start, length)
+	trace(s.tracer, PhaseErase, eraseStart, int(length), err)
+	if err != nil {
+		return fmt.Errorf("cannot erase %d bytes at offset %d of %s: %v", length, start, s.path, err)
+	}
+	atomic.AddUint64(&s.erased, uint64(length/sectorSize))
+
+	if _, err := f.WriteAt(buf, start); err != nil {
+		return fmt.Errorf("cannot write %d bytes at offset %d of %s: %v", length, start, s.path, err)
+	}
+	return nil
+}
+
+// patchFlags writes the flags byte of the stored image without erasing,
+// like fw_setenv marks the previous copy obsolete on NOR flash
+func (s *mtdStorage) patchFlags(flags byte) error {
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if s.unlock {
+		start, length, _, err := s.span(f)
+		if err != nil {
+			return err
+		}
+		mtdUnlock(f, start, length)
+	}
+	if _, err := f.WriteAt([]byte{flags}, s.offset+flagsOffset); err != nil {
+		return fmt.Errorf("cannot write flags at offset %d of %s: %v", s.offset+flagsOffset, s.path, err)
+	}
+	return nil
+}
+
+// SectorsErased implements EraseCounter
+func (s *mtdStorage) SectorsErased() uint64 {
+	return atomic.LoadUint64(&s.erased)
+}
@@ -0,0 +1,93 @@
+package uenv
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// mtdInfoUser is struct mtd_info_user of <mtd/mtd-abi.h>
+type mtdInfoUser struct {
+	Type      uint8
+	_         [3]byte
+	Flags     uint32
+	Size      uint32
+	EraseSize uint32
+	WriteSize uint32
+	OobSize   uint32
+	Padding   uint64
+}
+
+// eraseInfoUser is struct erase_info_user of <mtd/mtd-abi.h>
+type eraseInfoUser struct {
+	Start  uint32
+	Length uint32
+}
+
+// ioctlDir returns the direction bits of ioctl numbers, mips and
+// powerpc use a different encoding than the other architectures
+func ioctlDir(write bool) uintptr {
+	switch runtime.GOARCH {
+	case "mips", "mipsle", "mips64", "mips64le", "ppc64", "ppc64le":
+		if write {
+			return 4 << 29
+		}
+		return 2 << 29
+	}
+	if write {
+		return 1 << 30
+	}
+	return 2 << 30
+}
+
+// mtdIoctl returns the number of the MTD ioctl nr with an argument of
+// the given size, like _IOW('M', nr, size) and _IOR('M', nr, size)
+func mtdIoctl(write bool, nr, size uintptr) uintptr {
+	return ioctlDir(write) | size<<16 | 'M'<<8 | nr
+}
+
+var (
+	memGetInfo     = mtdIoctl(false, 1, unsafe.Sizeof(mtdInfoUser{}))
+	memErase       = mtdIoctl(true, 2, unsafe.Sizeof(eraseInfoUser{}))
+	memUnlock      = mtdIoctl(true, 6, unsafe.Sizeof(eraseInfoUser{}))
+	memGetBadBlock = mtdIoctl(true, 11, unsafe.Sizeof(int64(0)))
+)
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) (uintptr, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
+
+func mtdEraseSizeIoctl(f *os.File) (int64, error) {
+	var info mtdInfoUser
+	if _, err := ioctl(f, memGetInfo, unsafe.Pointer(&info)); err != nil {
+		return 0, err
+	}
+	return int64(info.EraseSize), nil
+}
+
+func mtdIsBadBlockIoctl(f *os.File, offset int64) (bool, error) {
+	r, err := ioctl(f, memGetBadBlock, unsafe.Pointer(&offset))
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		// NOR flash has no bad blocks
+		return false, nil
+	}
+	return r > 0, err
+}
+
+func mtdUnlockIoctl(f *os.File, start, length int64) error {
+	arg := eraseInfoUser{Start: uint32(start), Length: uint32(length)}
+	_, err := ioctl(f, memUnlock, unsafe.Pointer(&arg))
+	return err
+}
+
+func mtdEraseIoctl(f *os.File, start, length int64) error {
+	arg := eraseInfoUser{Start: uint32(start), Length: uint32(length)}
+	_, err := ioctl(f, memErase, unsafe.Pointer(&arg))
+	return err
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package uenv
+
+import (
+	"fmt"
+	"os"
+)
+
+var errMTDNotSupported = fmt.Errorf("MTD devices are only supported on Linux")
+
+func mtdEraseSizeIoctl(f *os.File) (int64, error) {
+	return 0, errMTDNotSupported
+}
+
+func mtdIsBadBlockIoctl(f *os.File, offset int64) (bool, error) {
+	return false, errMTDNotSupported
+}
+
+func mtdUnlockIoctl(f *os.File, start, length int64) error {
+	return errMTDNotSupported
+}
+
+func mtdEraseIoctl(f *os.File, start, length int64) error {
+	return errMTDNotSupported
+}
@@ -0,0 +1,162 @@
+package uenv
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+// fakeMTD mocks the MTD ioctls on a regular file, erasing fills the
+// file with 0xff like flash
+type fakeMTD struct {
+	eraseSize int64
+	bad       map[int64]bool
+	erases    [][2]int64
+	unlocks   [][2]int64
+}
+
+func (u *uenvTestSuite) mockMTD(c *C, m *fakeMTD) (restore func()) {
+	oldEraseSize, oldIsBad, oldUnlock, oldErase := mtdEraseSize, mtdIsBadBlock, mtdUnlock, mtdEraseBlocks
+	mtdEraseSize = func(f *os.File) (int64, error) {
+		return m.eraseSize, nil
+	}
+	mtdIsBadBlock = func(f *os.File, offset int64) (bool, error) {
+		return m.bad[offset], nil
+	}
+	mtdUnlock = func(f *os.File, start, length int64) error {
+		m.unlocks = append(m.unlocks, [2]int64{start, length})
+		return fmt.Errorf("not supported")
+	}
+	mtdEraseBlocks = func(f *os.File, start, length int64) error {
+		m.erases = append(m.erases, [2]int64{start, length})
+		_, err := f.WriteAt(bytes.Repeat([]byte{0xff}, int(length)), start)
+		return err
+	}
+	return func() {
+		mtdEraseSize, mtdIsBadBlock, mtdUnlock, mtdEraseBlocks = oldEraseSize, oldIsBad, oldUnlock, oldErase
+	}
+}
+
+func (u *uenvTestSuite) TestIsMTD(c *C) {
+	c.Check(isMTD("/dev/mtd1"), Equals, true)
+	c.Check(isMTD("/dev/mtdblock1"), Equals, false)
+	c.Check(isMTD("/dev/mmcblk0"), Equals, false)
+}
+
+func (u *uenvTestSuite) TestMTDStorage(c *C) {
+	m := &fakeMTD{eraseSize: 0x1000}
+	defer u.mockMTD(c, m)()
+	c.Assert(os.WriteFile(u.envFile, bytes.Repeat([]byte{0xaa}, 0x4000), 0644), IsNil)
+
+	// the environment starts in the middle of an erase block and
+	// spans two of them
+	storage := newMTDStorage(DeviceConfig{Path: u.envFile, Offset: 0x1800}, 0x1000, Options{})
+	env, err := CreateStorage(storage, 0x1000, Options{})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	c.Check(m.erases, DeepEquals, [][2]int64{{0x1000, 0x2000}, {0x1000, 0x2000}})
+	c.Check(m.unlocks, HasLen, 2)
+	c.Check(env.Stats().SectorsErased, Equals, uint64(4))
+
+	// the data around the environment is kept
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(content[:0x1800], DeepEquals, bytes.Repeat([]byte{0xaa}, 0x1800))
+	c.Check(content[0x2800:], DeepEquals, bytes.Repeat([]byte{0xaa}, 0x1800))
+
+	env, err = OpenStorage(newMTDStorage(DeviceConfig{Path: u.envFile, Offset: 0x1800}, 0x1000, Options{}), Options{})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+}
+
+func (u *uenvTestSuite) TestMTDStorageSectors(c *C) {
+	m := &fakeMTD{}
+	defer u.mockMTD(c, m)()
+	c.Assert(os.WriteFile(u.envFile, bytes.Repeat([]byte{0xaa}, 0x4000), 0644), IsNil)
+
+	// the configured sector size and count are used, the lock is
+	// left alone
+	dev := DeviceConfig{Path: u.envFile, SectorSize: 0x1000, Sectors: 2, DisableLock: true}
+	_, err := CreateStorage(newMTDStorage(dev, 0x800, Options{}), 0x800, Options{})
+	c.Assert(err, IsNil)
+	c.Check(m.erases, DeepEquals, [][2]int64{{0, 0x2000}})
+	c.Check(m.unlocks, HasLen, 0)
+
+	dev.Sectors = 1
+	_, err = CreateStorage(newMTDStorage(dev, 0x2000, Options{}), 0x2000, Options{})
+	c.Check(err, ErrorMatches, "env of size 8192 at offset 0 does not fit into 1 erase blocks of 4096 bytes")
+}
+
+func (u *uenvTestSuite) TestMTDStorageBadBlock(c *C) {
+	m := &fakeMTD{eraseSize: 0x1000, bad: map[int64]bool{0x2000: true}}
+	defer u.mockMTD(c, m)()
+	c.Assert(os.WriteFile(u.envFile, bytes.Repeat([]byte{0xaa}, 0x4000), 0644), IsNil)
+
+	_, err := CreateStorage(newMTDStorage(DeviceConfig{Path: u.envFile, Offset: 0x1000}, 0x2000, Options{}), 0x2000, Options{})
+	c.Check(err, ErrorMatches, "erase block at offset 8192 of .* is bad")
+	c.Check(m.erases, HasLen, 0)
+}
+
+func (u *uenvTestSuite) TestMTDStorageHeader(c *C) {
+	m := &fakeMTD{eraseSize: 0x1000}
+	defer u.mockMTD(c, m)()
+	disk := bytes.Repeat([]byte{0xff}, 0x4000)
+	copy(disk, mkenvimage(0x1000, "bootdelay=3"))
+	c.Assert(os.WriteFile(u.envFile, disk, 0644), IsNil)
+
+	// a single copy has the header of mkenvimage
+	env, err := OpenStorage(newMTDStorage(DeviceConfig{Path: u.envFile}, 0x1000, Options{}), Options{})
+	c.Assert(err, IsNil)
+	c.Check(env.Header(), Equals, HeaderSingle)
+	c.Check(env.Get("bootdelay"), Equals, "3")
+	env.Set("bootdelay", "5")
+	c.Assert(env.Save(), IsNil)
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(content[:0x1000], DeepEquals, mkenvimage(0x1000, "bootdelay=5"))
+
+	// the copies of a redundant environment have the flags byte
+	copy1 := newMTDStorage(DeviceConfig{Path: u.envFile, Offset: 0x2000}, 0x1000, Options{})
+	copy2 := newMTDStorage(DeviceConfig{Path: u.envFile, Offset: 0x3000}, 0x1000, Options{})
+	env, err = CreateStorage(newRedundantStorage(copy1, copy2, Options{}), 0x1000, Options{})
+	c.Assert(err, IsNil)
+	c.Check(env.Header(), Equals, HeaderRedundant)
+	// the erases of both copies are counted
+	c.Check(env.Stats().SectorsErased, Equals, uint64(2))
+	content, err = os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check([]byte{content[0x2000+flagsOffset], content[0x3000+flagsOffset]}, DeepEquals, []byte{1, 0})
+	c.Check(readUint32(content[0x2000:]), Equals, crc32.ChecksumIEEE(content[0x2000+redundantHeaderSize:0x3000]))
+}
+
+func (u *uenvTestSuite) TestMTDStorageBooleanFlags(c *C) {
+	m := &fakeMTD{eraseSize: 0x1000}
+	defer u.mockMTD(c, m)()
+	c.Assert(os.WriteFile(u.envFile, bytes.Repeat([]byte{0xff}, 0x2000), 0644), IsNil)
+
+	opts := Options{FlagScheme: FlagsBoolean}
+	copy1 := newMTDStorage(DeviceConfig{Path: u.envFile}, 0x1000, opts)
+	copy2 := newMTDStorage(DeviceConfig{Path: u.envFile, Offset: 0x1000}, 0x1000, opts)
+	env, err := CreateStorage(newRedundantStorage(copy1, copy2, opts), 0x1000, opts)
+	c.Assert(err, IsNil)
+	m.erases = nil
+
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	// only the new copy is erased, the previous one is marked
+	// obsolete by clearing its flags byte
+	c.Check(m.erases, DeepEquals, [][2]int64{{0x1000, 0x1000}})
+	content, err := os.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check([]byte{content[flagsOffset], content[0x1000+flagsOffset]}, DeepEquals, []byte{0, 1})
+	c.Check(readUint32(content), Equals, crc32.ChecksumIEEE(content[redundantHeaderSize:0x1000]))
+
+	env, err = OpenStorage(newRedundantStorage(copy1, copy2, opts), opts)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("foo"), Equals, "bar")
+}
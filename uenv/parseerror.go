@@ -0,0 +1,73 @@
+package uenv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseErrorContext is the number of payload bytes shown in a
+// ParseError
+const parseErrorContext = 16
+
+// ParseError describes malformed data in the payload of an
+// environment. Its message contains the offset and a hexdump of the
+// surrounding bytes, so that corrupted dumps can be diagnosed from a
+// log line alone.
+type ParseError struct {
+	// Msg describes the problem.
+	Msg string
+	// Offset is the position of the malformed data in the payload.
+	Offset int
+	// Context are the payload bytes around Offset.
+	Context []byte
+	// ContextOffset is the payload offset of the first byte of
+	// Context.
+	ContextOffset int
+}
+
+func newParseError(payload []byte, offset int, format string, a ...interface{}) *ParseError {
+	start := offset - parseErrorContext/2
+	if start+parseErrorContext > len(payload) {
+		start = len(payload) - parseErrorContext
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + parseErrorContext
+	if end > len(payload) {
+		end = len(payload)
+	}
+	return &ParseError{
+		Msg:           fmt.Sprintf(format, a...),
+		Offset:        offset,
+		Context:       append([]byte(nil), payload[start:end]...),
+		ContextOffset: start,
+	}
+}
+
+// hexdump formats the context like "hexdump -C" does for one line
+func (e *ParseError) hexdump() string {
+	return hexdumpLine(e.ContextOffset, e.Context)
+}
+
+// hexdumpLine formats data at the given offset like "hexdump -C" does
+// for one line
+func hexdumpLine(offset int, data []byte) string {
+	var hex, ascii strings.Builder
+	for i, b := range data {
+		if i > 0 {
+			hex.WriteByte(' ')
+		}
+		fmt.Fprintf(&hex, "%02x", b)
+		if b >= 0x20 && b < 0x7f {
+			ascii.WriteByte(b)
+		} else {
+			ascii.WriteByte('.')
+		}
+	}
+	return fmt.Sprintf("%08x  %s  |%s|", offset, hex.String(), ascii.String())
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at offset %d: %s", e.Msg, e.Offset, e.hexdump())
+}
@@ -0,0 +1,197 @@
+package uenv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrPatchConflict is returned by Apply when the environment is not
+// the one the patch was made against.
+var ErrPatchConflict = errors.New("patch does not apply to environment")
+
+// patchHeader starts every patch, the number is the format version
+const patchHeader = "uenv-patch 1"
+
+// Patch is a compact set of changes between two versions of an
+// environment, e.g. for OTA updates that ship only the changed
+// variables. Its text form, see MarshalText, is stable so that it can
+// be signed:
+//
+//	uenv-patch 1
+//	base-crc 1a2b3c4d
+//	set bootdelay=0
+//	unset bootcmd_old
+type Patch struct {
+	// BaseCRC is the CRC of the environment the patch was made
+	// against, Apply refuses environments with a different CRC. A
+	// zero BaseCRC applies to any environment.
+	BaseCRC uint32
+	// Changes are the variables to set, an empty NewValue removes
+	// the variable. OldValue is not part of the patch.
+	Changes []Change
+}
+
+// DiffPatch returns the patch that turns old into new. It only applies
+// to environments that are exactly like old, including the size.
+func DiffPatch(old, new *Env) *Patch {
+	changes := Diff(old, new)
+	for i := range changes {
+		changes[i].OldValue = ""
+	}
+	return &Patch{BaseCRC: old.checksum(), Changes: changes}
+}
+
+// Apply makes the changes of the patch to the environment, which then
+// needs to be saved. Nothing is changed if the patch is for another
+// environment.
+func (env *Env) Apply(p *Patch) error {
+	if p.BaseCRC != 0 {
+		if crc := env.checksum(); crc != p.BaseCRC {
+			return fmt.Errorf("%w: environment has CRC %08x, patch expects %08x", ErrPatchConflict, crc, p.BaseCRC)
+		}
+	}
+	for _, c := range p.Changes {
+		if err := checkPatchName(c.Name); err != nil {
+			return err
+		}
+	}
+	env.load()
+	for _, c := range p.Changes {
+		// the values are stored as they are, secret ones are
+		// already encrypted
+		if value, ok := env.data[c.Name]; value == c.NewValue && (ok || c.NewValue == "") {
+			continue
+		}
+		env.recordUndo(c.Name)
+		if c.NewValue == "" {
+			delete(env.data, c.Name)
+		} else {
+			env.data[c.Name] = c.NewValue
+		}
+	}
+	return nil
+}
+
+func checkPatchName(name string) error {
+	if name == "" || strings.ContainsAny(name, "=\n\x00") {
+		return fmt.Errorf("invalid variable name in patch: %q", name)
+	}
+	return nil
+}
+
+// escapePatchValue keeps values on one line
+var escapePatchValue = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+func unescapePatchValue(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("invalid escape at end of %q", s)
+		}
+		switch s[i] {
+		case '\\':
+			out.WriteByte('\\')
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		default:
+			return "", fmt.Errorf("invalid escape \\%c in %q", s[i], s)
+		}
+	}
+	return out.String(), nil
+}
+
+// MarshalText returns the text form of the patch.
+func (p *Patch) MarshalText() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(patchHeader + "\n")
+	if p.BaseCRC != 0 {
+		fmt.Fprintf(&buf, "base-crc %08x\n", p.BaseCRC)
+	}
+	for _, c := range p.Changes {
+		if err := checkPatchName(c.Name); err != nil {
+			return nil, err
+		}
+		if c.NewValue == "" {
+			fmt.Fprintf(&buf, "unset %s\n", c.Name)
+		} else {
+			fmt.Fprintf(&buf, "set %s=%s\n", c.Name, escapePatchValue.Replace(c.NewValue))
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalText reads the text form of a patch.
+func (p *Patch) UnmarshalText(text []byte) error {
+	var patch Patch
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	scanner.Buffer(nil, MaxSize)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		if lineno == 1 {
+			if line != patchHeader {
+				return fmt.Errorf("cannot read patch: unknown format %q", line)
+			}
+			continue
+		}
+		op, arg, _ := strings.Cut(line, " ")
+		var err error
+		switch op {
+		case "base-crc":
+			var crc uint64
+			crc, err = strconv.ParseUint(arg, 16, 32)
+			patch.BaseCRC = uint32(crc)
+		case "set":
+			name, value, ok := strings.Cut(arg, "=")
+			if !ok || value == "" {
+				err = errors.New("set needs a non-empty value")
+				break
+			}
+			if value, err = unescapePatchValue(value); err == nil {
+				patch.Changes = append(patch.Changes, Change{Name: name, NewValue: value})
+			}
+		case "unset":
+			patch.Changes = append(patch.Changes, Change{Name: arg})
+		default:
+			err = fmt.Errorf("unknown operation %q", op)
+		}
+		if err == nil && op != "base-crc" {
+			err = checkPatchName(patch.Changes[len(patch.Changes)-1].Name)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read patch: line %d: %v", lineno, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if lineno == 0 {
+		return errors.New("cannot read patch: empty")
+	}
+	*p = patch
+	return nil
+}
+
+// ParsePatch reads the text form of a patch.
+func ParsePatch(text []byte) (*Patch, error) {
+	var p Patch
+	if err := p.UnmarshalText(text); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,91 @@
+package uenv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestPatchRoundTrip(c *C) {
+	old, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	old.Set("bootcmd", "run distro_bootcmd")
+	old.Set("bootdelay", "3")
+	old.Set("obsolete", "1")
+	c.Assert(old.Save(), IsNil)
+
+	new, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	new.Set("bootdelay", "0")
+	new.Set("obsolete", "")
+	new.Set("script", "echo a\\b\necho c")
+
+	patch := DiffPatch(old, new)
+	text, err := patch.MarshalText()
+	c.Assert(err, IsNil)
+	c.Check(string(text), Equals, `uenv-patch 1
+base-crc `+fmt.Sprintf("%08x", old.crc)+`
+set bootdelay=0
+unset obsolete
+set script=echo a\\b\necho c
+`)
+
+	parsed, err := ParsePatch(text)
+	c.Assert(err, IsNil)
+	c.Check(parsed, DeepEquals, patch)
+
+	device, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(device.Apply(parsed), IsNil)
+	c.Check(Diff(device, new), HasLen, 0)
+	c.Assert(device.Save(), IsNil)
+
+	// the patch does not apply twice
+	err = device.Apply(parsed)
+	c.Check(errors.Is(err, ErrPatchConflict), Equals, true)
+	c.Check(err, ErrorMatches, "patch does not apply to environment: environment has CRC [0-9a-f]{8}, patch expects "+fmt.Sprintf("%08x", old.crc))
+}
+
+func (u *uenvTestSuite) TestPatchWithoutBase(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("a", "1")
+
+	patch, err := ParsePatch([]byte("uenv-patch 1\nset b=2\nunset a\nunset missing\n"))
+	c.Assert(err, IsNil)
+	c.Assert(env.Apply(patch), IsNil)
+	c.Check(env.String(), Equals, "b=2\n")
+	c.Check(env.Undo(2), Equals, 2)
+	c.Check(env.String(), Equals, "a=1\n")
+}
+
+func (u *uenvTestSuite) TestParsePatchErrors(c *C) {
+	for _, t := range []struct {
+		text, err string
+	}{
+		{"", "cannot read patch: empty"},
+		{"uenv-patch 2\n", `cannot read patch: unknown format "uenv-patch 2"`},
+		{"uenv-patch 1\nbase-crc xyz\n", `cannot read patch: line 2: .*invalid syntax`},
+		{"uenv-patch 1\nset a\n", `cannot read patch: line 2: set needs a non-empty value`},
+		{"uenv-patch 1\nset =1\n", `cannot read patch: line 2: invalid variable name in patch: ""`},
+		{"uenv-patch 1\nset a=\\x\n", `cannot read patch: line 2: invalid escape \\x in "\\\\x"`},
+		{"uenv-patch 1\nrename a b\n", `cannot read patch: line 2: unknown operation "rename"`},
+	} {
+		_, err := ParsePatch([]byte(t.text))
+		c.Check(err, ErrorMatches, t.err, Commentf("%q", t.text))
+	}
+}
+
+func (u *uenvTestSuite) TestDiffPatchOtherSize(c *C) {
+	small, err := Create(u.envFile, 1024)
+	c.Assert(err, IsNil)
+	big, err := Create(filepath.Join(c.MkDir(), "big.env"), 4096)
+	c.Assert(err, IsNil)
+	big.Set("a", "1")
+
+	// the CRC covers the padding, so patches are bound to the size
+	err = small.Apply(DiffPatch(big, big))
+	c.Check(errors.Is(err, ErrPatchConflict), Equals, true)
+}
@@ -0,0 +1,62 @@
+package uenv
+
+import (
+	"io"
+)
+
+// Progress describes how far a long running read or write of the
+// storage got, e.g. for a progress bar when reading a multi-megabyte
+// environment from a slow SPI flash.
+type Progress struct {
+	// Phase is PhaseRead or PhaseWrite.
+	Phase Phase
+	// Done is the number of bytes read or written so far.
+	Done int
+	// Total is the number of bytes of the whole operation.
+	Total int
+}
+
+// progressChunkSize is the amount of data read between two progress
+// reports
+const progressChunkSize = 64 * 1024
+
+// readAt is ReadAt of r but reads in chunks and reports the progress
+// after every chunk
+func readAt(r io.ReaderAt, buf []byte, off int64, progress func(Progress)) (int, error) {
+	if progress == nil {
+		return r.ReadAt(buf, off)
+	}
+	var done int
+	for done < len(buf) {
+		end := done + progressChunkSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		n, err := r.ReadAt(buf[done:end], off+int64(done))
+		done += n
+		if n > 0 {
+			progress(Progress{Phase: PhaseRead, Done: done, Total: len(buf)})
+		}
+		if err != nil && (err != io.EOF || done < end) {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// progressWriterAt reports the bytes written through it
+type progressWriterAt struct {
+	w        io.WriterAt
+	done     int
+	total    int
+	progress func(Progress)
+}
+
+func (p *progressWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	n, err := p.w.WriteAt(b, off)
+	if n > 0 {
+		p.done += n
+		p.progress(Progress{Phase: PhaseWrite, Done: p.done, Total: p.total})
+	}
+	return n, err
+}
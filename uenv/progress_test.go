@@ -0,0 +1,44 @@
+package uenv
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestProgress(c *C) {
+	var reports []Progress
+	size := 2*progressChunkSize + 100
+	env, err := CreateWithOptions(u.envFile, size, Options{
+		Progress: func(p Progress) { reports = append(reports, p) },
+	})
+	c.Assert(err, IsNil)
+
+	// writes are reported as the buffered payload is flushed, the
+	// header comes last
+	c.Assert(len(reports) > 1, Equals, true)
+	last := reports[len(reports)-1]
+	c.Check(last, Equals, Progress{Phase: PhaseWrite, Done: size, Total: size})
+	for i := 1; i < len(reports); i++ {
+		c.Check(reports[i].Done > reports[i-1].Done, Equals, true)
+	}
+
+	reports = nil
+	c.Assert(env.Reload(), IsNil)
+	c.Check(reports, DeepEquals, []Progress{
+		{PhaseRead, progressChunkSize, size},
+		{PhaseRead, 2 * progressChunkSize, size},
+		{PhaseRead, size, size},
+	})
+}
+
+func (u *uenvTestSuite) TestProgressRegion(c *C) {
+	var reports []Progress
+	_, err := CreateWithOptions(u.envFile, 4096, Options{})
+	c.Assert(err, IsNil)
+	storage, err := newRegionStorage(u.envFile, 1024, 1024, Options{
+		Progress: func(p Progress) { reports = append(reports, p) },
+	})
+	c.Assert(err, IsNil)
+	_, err = storage.ReadImage()
+	c.Assert(err, IsNil)
+	c.Check(reports, DeepEquals, []Progress{{PhaseRead, 1024, 1024}})
+}
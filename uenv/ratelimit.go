@@ -0,0 +1,30 @@
+package uenv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSaveRateLimited is returned by Save when the environment was
+// written less than Options.MinSaveInterval ago and Options.DelaySaves
+// is not set.
+var ErrSaveRateLimited = errors.New("environment saved too often")
+
+// rateLimit enforces Options.MinSaveInterval before a write, either by
+// failing or by waiting until the interval passed
+func (env *Env) rateLimit() error {
+	interval := env.opts.MinSaveInterval
+	if interval <= 0 || env.lastSave.IsZero() {
+		return nil
+	}
+	wait := env.lastSave.Add(interval).Sub(timeNow())
+	if wait <= 0 {
+		return nil
+	}
+	if !env.opts.DelaySaves {
+		return fmt.Errorf("%w: next save possible in %v", ErrSaveRateLimited, wait)
+	}
+	env.opts.logger().Debug("delaying save", "delay", wait)
+	timeSleep(wait)
+	return nil
+}
@@ -0,0 +1,51 @@
+package uenv
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestMinSaveInterval(c *C) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	env, err := CreateWithOptions(u.envFile, 64, Options{MinSaveInterval: time.Minute})
+	c.Assert(err, IsNil)
+	env.Set("bootcount", "0")
+	c.Assert(env.Save(), IsNil)
+
+	// unchanged saves are not limited
+	c.Assert(env.Save(), IsNil)
+
+	now = now.Add(10 * time.Second)
+	env.Set("bootcount", "1")
+	err = env.Save()
+	c.Check(errors.Is(err, ErrSaveRateLimited), Equals, true)
+	c.Check(err, ErrorMatches, "environment saved too often: next save possible in 50s")
+
+	now = now.Add(50 * time.Second)
+	c.Assert(env.Save(), IsNil)
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("bootcount"), Equals, "1")
+}
+
+func (u *uenvTestSuite) TestMinSaveIntervalDelay(c *C) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+	delays, restore := mockSleep()
+	defer restore()
+
+	env, err := CreateWithOptions(u.envFile, 64, Options{MinSaveInterval: time.Minute, DelaySaves: true})
+	c.Assert(err, IsNil)
+	env.Set("bootcount", "0")
+	c.Assert(env.Save(), IsNil)
+	now = now.Add(15 * time.Second)
+	env.Set("bootcount", "1")
+	c.Assert(env.Save(), IsNil)
+	c.Check(*delays, DeepEquals, []time.Duration{45 * time.Second})
+}
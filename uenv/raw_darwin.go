@@ -0,0 +1,42 @@
+package uenv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// rawDeviceAlignment returns the alignment that reads and writes of
+// the given path need, zero if any is fine. The character devices
+// /dev/rdiskN only allow whole sectors, unlike the buffered /dev/diskN
+// ones. 4096 bytes covers disks with 512 and 4096 byte sectors.
+func rawDeviceAlignment(path string) int {
+	if strings.HasPrefix(path, "/dev/rdisk") {
+		return 4096
+	}
+	return 0
+}
+
+// rawDeviceOpenError returns the error to report when opening the raw
+// device at path failed. macOS mounts SD cards as soon as they are
+// inserted, so say how to get them out of the way.
+func rawDeviceOpenError(path string, err error) error {
+	switch {
+	case errors.Is(err, syscall.EBUSY):
+		return fmt.Errorf("%w (unmount it first with \"diskutil unmountDisk %s\")", err, diskName(path))
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return fmt.Errorf("%w (raw disks need root and full disk access)", err)
+	}
+	return err
+}
+
+// diskName returns the whole disk of a raw device, e.g. /dev/disk4 for
+// /dev/rdisk4s1
+func diskName(path string) string {
+	name := "/dev/disk" + strings.TrimPrefix(path, "/dev/rdisk")
+	if i := strings.LastIndexByte(name, 's'); i > len("/dev/disk") {
+		name = name[:i]
+	}
+	return name
+}
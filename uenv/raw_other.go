@@ -0,0 +1,15 @@
+//go:build !windows && !darwin
+
+package uenv
+
+// rawDeviceAlignment returns the alignment that reads and writes of
+// the given path need, zero if any is fine
+func rawDeviceAlignment(path string) int {
+	return 0
+}
+
+// rawDeviceOpenError returns the error to report when opening the raw
+// device at path failed
+func rawDeviceOpenError(path string, err error) error {
+	return err
+}
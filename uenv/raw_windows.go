@@ -0,0 +1,22 @@
+package uenv
+
+import (
+	"strings"
+)
+
+// rawDeviceAlignment returns the alignment that reads and writes of
+// the given path need, zero if any is fine. Raw disks and volumes
+// like \\.\PhysicalDrive1 or \\.\E: only allow whole sectors. 4096
+// bytes covers disks with 512 and 4096 byte sectors.
+func rawDeviceAlignment(path string) int {
+	if strings.HasPrefix(path, `\\.\`) {
+		return 4096
+	}
+	return 0
+}
+
+// rawDeviceOpenError returns the error to report when opening the raw
+// device at path failed
+func rawDeviceOpenError(path string, err error) error {
+	return err
+}
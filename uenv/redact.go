@@ -0,0 +1,39 @@
+package uenv
+
+import (
+	"log/slog"
+	"path"
+)
+
+// Redacted is shown instead of the values of redacted variables.
+const Redacted = "****"
+
+// redacted returns true if the value of the variable must not be shown
+func (env *Env) redacted(name string) bool {
+	for _, pattern := range env.opts.Redact {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// displayValue returns the value to show for a variable in
+// human-facing output
+func (env *Env) displayValue(name, value string) string {
+	if value != "" && env.redacted(name) {
+		return Redacted
+	}
+	return value
+}
+
+// LogValue implements slog.LogValuer, the variables are logged as a
+// group with the values of redacted variables replaced.
+func (env *Env) LogValue() slog.Value {
+	env.load()
+	attrs := make([]slog.Attr, 0, len(env.data))
+	env.iterEnv(func(key, value string) {
+		attrs = append(attrs, slog.String(key, env.displayValue(key, value)))
+	})
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,41 @@
+package uenv
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestRedact(c *C) {
+	journal := filepath.Join(c.MkDir(), "journal")
+	opts := Options{Redact: []string{"*_password", "token"}, Journal: journal}
+	env, err := CreateWithOptions(u.envFile, 4096, opts)
+	c.Assert(err, IsNil)
+	env.Set("root_password", "hunter2")
+	env.Set("token", "s3cr3t")
+	env.Set("bootdelay", "0")
+	c.Assert(env.Save(), IsNil)
+
+	c.Assert(env.String(), Equals, "bootdelay=0\nroot_password=****\ntoken=****\n")
+	c.Assert(env.Get("root_password"), Equals, "hunter2")
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("current", "env", env)
+	c.Assert(buf.String(), Matches, `.* msg=current env.bootdelay=0 env.root_password=\*\*\*\* env.token=\*\*\*\*\n`)
+
+	entries, err := ReadJournal(journal)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Changes, DeepEquals, []Change{
+		{Name: "bootdelay", NewValue: "0"},
+		{Name: "root_password", NewValue: Redacted},
+		{Name: "token", NewValue: Redacted},
+	})
+
+	// the stored values are intact
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "bootdelay=0\nroot_password=hunter2\ntoken=s3cr3t\n")
+}
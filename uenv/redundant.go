@@ -0,0 +1,476 @@
+package uenv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// flagsOffset is the position of the flags byte in the header, it
+// follows the CRC
+const flagsOffset = 4
+
+// FlagScheme selects how the flags bytes of a redundant environment
+// tell which copy is the current one.
+type FlagScheme int
+
+const (
+	// FlagsCounter counts the flags up with every save, the copy with
+	// the newer counter is current. U-Boot uses it for environments
+	// on block devices and NAND flash.
+	FlagsCounter FlagScheme = iota
+	// FlagsBoolean marks the current copy active (1) and the other
+	// one obsolete (0). U-Boot uses it for environments on NOR flash,
+	// where bits can be cleared without erasing: saves write the
+	// other copy active and then mark the previous one obsolete.
+	FlagsBoolean
+)
+
+// the flags of FlagsBoolean
+const (
+	flagObsolete byte = 0
+	flagActive   byte = 1
+)
+
+func (f FlagScheme) String() string {
+	switch f {
+	case FlagsCounter:
+		return "counter"
+	case FlagsBoolean:
+		return "boolean"
+	}
+	return fmt.Sprintf("FlagScheme(%d)", int(f))
+}
+
+// MarshalText returns the name of the flag scheme.
+func (f FlagScheme) MarshalText() ([]byte, error) {
+	switch f {
+	case FlagsCounter, FlagsBoolean:
+		return []byte(f.String()), nil
+	}
+	return nil, fmt.Errorf("unknown flag scheme %d", int(f))
+}
+
+// UnmarshalText parses the name of a flag scheme, "counter" or
+// "boolean".
+func (f *FlagScheme) UnmarshalText(text []byte) error {
+	for _, scheme := range []FlagScheme{FlagsCounter, FlagsBoolean} {
+		if string(text) == scheme.String() {
+			*f = scheme
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown flag scheme %q", text)
+}
+
+// newer returns true if a copy with flags a is newer than one with
+// flags b
+func (f FlagScheme) newer(a, b byte) bool {
+	if f != FlagsBoolean {
+		return newer(a, b)
+	}
+	// like fw_env.c: an active copy wins over an obsolete one, an
+	// erased flags byte over any other value
+	switch {
+	case a == b:
+		return false
+	case a == flagActive && b == flagObsolete:
+		return true
+	case a == flagObsolete && b == flagActive:
+		return false
+	case b == 0xff:
+		return false
+	default:
+		return a == 0xff
+	}
+}
+
+// next returns the flags of a copy that replaces the one with the
+// given flags
+func (f FlagScheme) next(flags byte) byte {
+	if f == FlagsBoolean {
+		return flagActive
+	}
+	return flags + 1
+}
+
+// older returns the flags of a copy that is older than the one with
+// the given flags
+func (f FlagScheme) older(flags byte) byte {
+	if f == FlagsBoolean {
+		return flagObsolete
+	}
+	return flags - 1
+}
+
+// CopyStatus describes one copy of a redundant environment.
+type CopyStatus struct {
+	// Valid is true if the copy has a good CRC.
+	Valid bool
+	// Flags is the value of the flags byte of the copy.
+	Flags byte
+	// Err is the reason the copy is not valid.
+	Err error
+}
+
+// RepairReport describes a copy of a redundant environment that was
+// rewritten from the other one.
+type RepairReport struct {
+	// Copy is the index of the rewritten copy.
+	Copy int
+	// Problem is what was wrong with the copy.
+	Problem error
+}
+
+// RedundancyStatus describes the state of both copies of a redundant
+// environment as found when it was last read.
+type RedundancyStatus struct {
+	Copies [2]CopyStatus
+	// Active is the index of the copy the environment was read from.
+	Active int
+	// Repaired is set if a copy was repaired since the environment
+	// was opened.
+	Repaired *RepairReport
+}
+
+// Healthy returns true if both copies are valid and agree on which of
+// them is the current one.
+func (st *RedundancyStatus) Healthy() bool {
+	return st.Copies[0].Valid && st.Copies[1].Valid && st.Copies[0].Flags != st.Copies[1].Flags
+}
+
+// OpenRedundant opens an environment that is kept in two copies, like
+// U-Boot does with CONFIG_SYS_REDUNDAND_ENVIRONMENT. The copy with a
+// valid CRC and the newer flags is used, saves alternate between the
+// copies so that an interrupted save never destroys both.
+func OpenRedundant(fname1, fname2 string, opts Options) (*Env, error) {
+	storage1, storage2 := newStorage(fname1, opts), newStorage(fname2, opts)
+	env, err := OpenRedundantStorage(storage1, storage2, opts)
+	if err != nil {
+		newRedundantStorage(storage1, storage2, opts).Close()
+		return nil, err
+	}
+	return env, nil
+}
+
+// OpenRedundantStorage opens an environment kept in two copies on the
+// given storages. See OpenRedundant.
+func OpenRedundantStorage(storage1, storage2 Storage, opts Options) (*Env, error) {
+	env, err := OpenStorage(newRedundantStorage(storage1, storage2, opts), opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.AutoRepair {
+		if _, err := env.Repair(); err != nil {
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+// CreateRedundant creates a new empty redundant environment of the
+// given size in two files. Both copies are written.
+func CreateRedundant(fname1, fname2 string, size int, opts Options) (*Env, error) {
+	for _, fname := range []string{fname1, fname2} {
+		f, err := os.Create(fname)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+	return CreateStorage(newRedundantStorage(newStorage(fname1, opts), newStorage(fname2, opts), opts), size, opts)
+}
+
+// RedundancyStatus returns the state of the copies of a redundant
+// environment, ok is false for environments without redundancy.
+func (env *Env) RedundancyStatus() (status RedundancyStatus, ok bool) {
+	rs := env.redundantStorage()
+	if rs == nil {
+		return RedundancyStatus{}, false
+	}
+	return rs.status, true
+}
+
+// Repair rewrites a copy of a redundant environment that has a bad CRC
+// or flags that conflict with the other copy from the valid copy. It
+// returns nil if there was nothing to repair.
+func (env *Env) Repair() (*RepairReport, error) {
+	rs := env.redundantStorage()
+	if rs == nil {
+		return nil, fmt.Errorf("cannot repair environment without redundancy")
+	}
+	return rs.repair()
+}
+
+func (env *Env) redundantStorage() *redundantStorage {
+	return findRedundant(env.storage)
+}
+
+// findRedundant returns the redundant storage s is or wraps, or nil
+func findRedundant(s Storage) *redundantStorage {
+	for s != nil {
+		if rs, ok := s.(*redundantStorage); ok {
+			return rs
+		}
+		w, ok := s.(storageWrapper)
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	return nil
+}
+
+// redundantStorage keeps an environment in two copies and selects the
+// active one by their CRC and flags like U-Boot does
+type redundantStorage struct {
+	copies   [2]Storage
+	checksum Checksum
+	scheme   FlagScheme
+	status   RedundancyStatus
+	// fresh is set until a valid copy was read or written
+	fresh bool
+}
+
+func newRedundantStorage(storage1, storage2 Storage, opts Options) *redundantStorage {
+	return &redundantStorage{copies: [2]Storage{storage1, storage2}, checksum: opts.Checksum, scheme: opts.FlagScheme, fresh: true}
+}
+
+// newer returns true if a copy with flags a is newer than one with
+// flags b, the counter wraps around
+func newer(a, b byte) bool {
+	if a == 0 && b == 255 {
+		return true
+	}
+	if a == 255 && b == 0 {
+		return false
+	}
+	return a > b
+}
+
+// readCopies reads and verifies both copies. This is done
+// concurrently as both copies usually live on slow storage.
+func (s *redundantStorage) readCopies() (images [2][]byte, status RedundancyStatus) {
+	var wg sync.WaitGroup
+	for i := range s.copies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			img, err := s.copies[i].ReadImage()
+			if err == nil {
+				err = verifyImage(img, redundantHeaderSize, s.checksum)
+			}
+			st := CopyStatus{Valid: err == nil, Err: err}
+			if len(img) > flagsOffset {
+				st.Flags = img[flagsOffset]
+			}
+			images[i] = img
+			status.Copies[i] = st
+		}(i)
+	}
+	wg.Wait()
+
+	return images, status
+}
+
+// selectActive picks the copy to use following the rules of U-Boot:
+// a valid copy wins over an invalid one, among two valid copies the
+// one with the newer flags wins and the first one on a tie
+func (f FlagScheme) selectActive(status *RedundancyStatus) (int, error) {
+	c0, c1 := status.Copies[0], status.Copies[1]
+	switch {
+	case !c0.Valid && !c1.Valid:
+		var u0, u1 *UninitializedError
+		if errors.As(c0.Err, &u0) && errors.As(c1.Err, &u1) {
+			// keep it detectable that the environment needs to
+			// be created
+			return 0, fmt.Errorf("no valid copy of the environment: copy 0: %w, copy 1: %v", c0.Err, c1.Err)
+		}
+		return 0, fmt.Errorf("no valid copy of the environment: copy 0: %v, copy 1: %v", c0.Err, c1.Err)
+	case !c0.Valid:
+		return 1, nil
+	case !c1.Valid:
+		return 0, nil
+	case f.newer(c1.Flags, c0.Flags):
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (s *redundantStorage) ReadImage() ([]byte, error) {
+	images, status := s.readCopies()
+	active, err := s.scheme.selectActive(&status)
+	if err != nil {
+		return nil, err
+	}
+	if len(images[0]) != len(images[1]) && status.Copies[0].Valid && status.Copies[1].Valid {
+		return nil, fmt.Errorf("copies of the environment differ in size: %d != %d", len(images[0]), len(images[1]))
+	}
+	status.Active = active
+	status.Repaired = s.status.Repaired
+	s.status = status
+	s.fresh = false
+
+	return images[active], nil
+}
+
+// writeCopy writes the image produced by fill to the given copy with
+// the given flags
+func (s *redundantStorage) writeCopy(i int, flags byte, size int, fill func(w io.WriterAt) error) error {
+	err := s.copies[i].WriteImage(size, func(w io.WriterAt) error {
+		return fill(&flagsWriter{w: w, flags: flags})
+	})
+	if err != nil {
+		return err
+	}
+	s.status.Copies[i] = CopyStatus{Valid: true, Flags: flags}
+	s.status.Active = i
+	return nil
+}
+
+func (s *redundantStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if s.fresh {
+		// initialize both copies, the first one ends up active
+		if err := s.writeCopy(1, flagObsolete, size, fill); err != nil {
+			return err
+		}
+		if err := s.writeCopy(0, flagActive, size, fill); err != nil {
+			return err
+		}
+		s.fresh = false
+		return nil
+	}
+
+	// always write the copy that is not in use so that the active
+	// one stays intact if the write is interrupted
+	active := s.status.Active
+	if err := s.writeCopy(1-active, s.scheme.next(s.status.Copies[active].Flags), size, fill); err != nil {
+		return err
+	}
+	if s.scheme == FlagsBoolean {
+		return s.markObsolete(active)
+	}
+	return nil
+}
+
+// flagsPatcher is implemented by storage that can overwrite the flags
+// byte of the stored image on its own, like NOR flash where bits can
+// be cleared without erasing
+type flagsPatcher interface {
+	patchFlags(flags byte) error
+}
+
+// markObsolete sets the obsolete flag of the given copy after the
+// other copy was written active. Only the flags byte is written if the
+// storage supports it, otherwise the copy is rewritten.
+func (s *redundantStorage) markObsolete(i int) error {
+	st := s.status.Copies[i]
+	var err error
+	if p, ok := s.copies[i].(flagsPatcher); ok {
+		err = p.patchFlags(flagObsolete)
+	} else {
+		err = s.rewriteFlags(i, flagObsolete)
+	}
+	// the copy is not active and an invalid one stays invalid
+	st.Flags = flagObsolete
+	s.status.Copies[i] = st
+	s.status.Active = 1 - i
+	if err != nil {
+		return fmt.Errorf("cannot mark copy %d obsolete: %v", i, err)
+	}
+	return nil
+}
+
+// rewriteFlags writes the stored image of the given copy again with
+// the given flags
+func (s *redundantStorage) rewriteFlags(i int, flags byte) error {
+	img, err := s.copies[i].ReadImage()
+	if err != nil {
+		return err
+	}
+	return s.copies[i].WriteImage(len(img), func(w io.WriterAt) error {
+		_, err := (&flagsWriter{w: w, flags: flags}).WriteAt(img, 0)
+		return err
+	})
+}
+
+// mirror writes the image to the copy that is not active with older
+// flags, so that both copies hold the same variables
+func (s *redundantStorage) mirror(size int, fill func(w io.WriterAt) error) error {
+	active := s.status.Active
+	err := s.writeCopy(1-active, s.scheme.older(s.status.Copies[active].Flags), size, fill)
+	// writeCopy marks the written copy active, it is not
+	s.status.Active = active
+	return err
+}
+
+func (s *redundantStorage) repair() (*RepairReport, error) {
+	images, status := s.readCopies()
+	active, err := s.scheme.selectActive(&status)
+	if err != nil {
+		return nil, err
+	}
+	status.Active = active
+	status.Repaired = s.status.Repaired
+	s.status = status
+	if status.Healthy() {
+		return nil, nil
+	}
+
+	other := 1 - active
+	problem := status.Copies[other].Err
+	if problem == nil {
+		problem = fmt.Errorf("both copies have flags %d", status.Copies[active].Flags)
+	}
+	// the repaired copy gets older flags so that the active copy
+	// stays active
+	img := images[active]
+	err = s.writeCopy(other, s.scheme.older(status.Copies[active].Flags), len(img), func(w io.WriterAt) error {
+		_, err := w.WriteAt(img, 0)
+		return err
+	})
+	// writeCopy marks the written copy active, it is not
+	s.status.Active = active
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RepairReport{Copy: other, Problem: problem}
+	s.status.Repaired = report
+	return report, nil
+}
+
+// SectorsErased implements EraseCounter, it sums up the sectors erased
+// for both copies
+func (s *redundantStorage) SectorsErased() uint64 {
+	return sectorsErased(s.copies[0]) + sectorsErased(s.copies[1])
+}
+
+func (s *redundantStorage) Close() error {
+	var firstErr error
+	for _, c := range s.copies {
+		if cl, ok := c.(io.Closer); ok {
+			if err := cl.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// flagsWriter sets the flags byte in the header written through it
+type flagsWriter struct {
+	w     io.WriterAt
+	flags byte
+}
+
+func (f *flagsWriter) WriteAt(p []byte, off int64) (int, error) {
+	if i := flagsOffset - off; i >= 0 && i < int64(len(p)) {
+		p = append([]byte(nil), p...)
+		p[i] = f.flags
+	}
+	return f.w.WriteAt(p, off)
+}
@@ -0,0 +1,358 @@
+package uenv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type redundantTestSuite struct {
+	envFile1, envFile2 string
+}
+
+var _ = Suite(&redundantTestSuite{})
+
+func (r *redundantTestSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	r.envFile1 = filepath.Join(dir, "uboot.env")
+	r.envFile2 = filepath.Join(dir, "uboot-redund.env")
+}
+
+func (r *redundantTestSuite) flags(c *C) (byte, byte) {
+	content1, err := ioutil.ReadFile(r.envFile1)
+	c.Assert(err, IsNil)
+	content2, err := ioutil.ReadFile(r.envFile2)
+	c.Assert(err, IsNil)
+	return content1[flagsOffset], content2[flagsOffset]
+}
+
+func (r *redundantTestSuite) corrupt(c *C, fname string) {
+	content, err := ioutil.ReadFile(fname)
+	c.Assert(err, IsNil)
+	content[10] ^= 0xff
+	c.Assert(ioutil.WriteFile(fname, content, 0644), IsNil)
+}
+
+func (r *redundantTestSuite) TestCreateWritesBothCopies(c *C) {
+	_, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+
+	for _, fname := range []string{r.envFile1, r.envFile2} {
+		env, err := Open(fname)
+		c.Assert(err, IsNil)
+		c.Assert(env.String(), Equals, "")
+	}
+	f1, f2 := r.flags(c)
+	c.Assert(f1, Equals, byte(1))
+	c.Assert(f2, Equals, byte(0))
+
+	env, err := OpenRedundant(r.envFile1, r.envFile2, Options{})
+	c.Assert(err, IsNil)
+	status, ok := env.RedundancyStatus()
+	c.Assert(ok, Equals, true)
+	c.Assert(status.Active, Equals, 0)
+	c.Assert(status.Healthy(), Equals, true)
+}
+
+func (r *redundantTestSuite) TestSaveAlternates(c *C) {
+	env, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+
+	env.Set("foo", "1")
+	c.Assert(env.Save(), IsNil)
+	f1, f2 := r.flags(c)
+	c.Assert([]byte{f1, f2}, DeepEquals, []byte{1, 2})
+
+	env.Set("foo", "2")
+	c.Assert(env.Save(), IsNil)
+	f1, f2 = r.flags(c)
+	c.Assert([]byte{f1, f2}, DeepEquals, []byte{3, 2})
+
+	// the older copy still has the previous content
+	env2, err := Open(r.envFile2)
+	c.Assert(err, IsNil)
+	c.Assert(env2.Get("foo"), Equals, "1")
+
+	env, err = OpenRedundant(r.envFile1, r.envFile2, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "2")
+}
+
+func (r *redundantTestSuite) TestFlagsWrapAround(c *C) {
+	status := RedundancyStatus{Copies: [2]CopyStatus{{Valid: true, Flags: 255}, {Valid: true, Flags: 0}}}
+	active, err := FlagsCounter.selectActive(&status)
+	c.Assert(err, IsNil)
+	c.Assert(active, Equals, 1)
+
+	status.Copies[0].Flags, status.Copies[1].Flags = 0, 255
+	active, err = FlagsCounter.selectActive(&status)
+	c.Assert(err, IsNil)
+	c.Assert(active, Equals, 0)
+
+	status.Copies[0].Flags, status.Copies[1].Flags = 7, 7
+	active, err = FlagsCounter.selectActive(&status)
+	c.Assert(err, IsNil)
+	c.Assert(active, Equals, 0)
+}
+
+func (r *redundantTestSuite) TestBooleanFlags(c *C) {
+	opts := Options{FlagScheme: FlagsBoolean}
+	env, err := CreateRedundant(r.envFile1, r.envFile2, 64, opts)
+	c.Assert(err, IsNil)
+	f1, f2 := r.flags(c)
+	c.Assert([]byte{f1, f2}, DeepEquals, []byte{1, 0})
+
+	env.Set("foo", "1")
+	c.Assert(env.Save(), IsNil)
+	f1, f2 = r.flags(c)
+	c.Assert([]byte{f1, f2}, DeepEquals, []byte{0, 1})
+	status, ok := env.RedundancyStatus()
+	c.Assert(ok, Equals, true)
+	c.Assert(status.Active, Equals, 1)
+	c.Assert(status.Healthy(), Equals, true)
+
+	env.Set("foo", "2")
+	c.Assert(env.Save(), IsNil)
+	f1, f2 = r.flags(c)
+	c.Assert([]byte{f1, f2}, DeepEquals, []byte{1, 0})
+
+	// the obsolete copy still has the previous content
+	env2, err := Open(r.envFile2)
+	c.Assert(err, IsNil)
+	c.Assert(env2.Get("foo"), Equals, "1")
+
+	env, err = OpenRedundant(r.envFile1, r.envFile2, opts)
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "2")
+	status, _ = env.RedundancyStatus()
+	c.Assert(status.Active, Equals, 0)
+}
+
+func (r *redundantTestSuite) TestBooleanFlagsSelect(c *C) {
+	for _, t := range []struct {
+		f0, f1 byte
+		active int
+	}{
+		{1, 0, 0},
+		{0, 1, 1},
+		{1, 1, 0},
+		{0, 0, 0},
+		{0xff, 1, 0},
+		{0xff, 0, 0},
+		{1, 0xff, 1},
+		{0, 0xff, 1},
+		{7, 9, 0},
+	} {
+		status := RedundancyStatus{Copies: [2]CopyStatus{{Valid: true, Flags: t.f0}, {Valid: true, Flags: t.f1}}}
+		active, err := FlagsBoolean.selectActive(&status)
+		c.Assert(err, IsNil)
+		c.Check(active, Equals, t.active, Commentf("flags %d %d", t.f0, t.f1))
+	}
+}
+
+func (r *redundantTestSuite) TestFlagSchemeText(c *C) {
+	var f FlagScheme
+	c.Assert(f.UnmarshalText([]byte("boolean")), IsNil)
+	c.Assert(f, Equals, FlagsBoolean)
+	text, err := f.MarshalText()
+	c.Assert(err, IsNil)
+	c.Assert(string(text), Equals, "boolean")
+	c.Assert(f.UnmarshalText([]byte("bits")), ErrorMatches, `unknown flag scheme "bits"`)
+}
+
+func (r *redundantTestSuite) TestTornWriteFallsBackAndRepairs(c *C) {
+	env, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+	env.Set("foo", "old")
+	c.Assert(env.Save(), IsNil)
+	env.Set("foo", "new")
+	c.Assert(env.Save(), IsNil)
+
+	// the newest copy is torn
+	r.corrupt(c, r.envFile1)
+
+	env, err = OpenRedundant(r.envFile1, r.envFile2, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "old")
+	status, _ := env.RedundancyStatus()
+	c.Assert(status.Active, Equals, 1)
+	c.Assert(status.Healthy(), Equals, false)
+	c.Assert(status.Copies[0].Err, FitsTypeOf, &CRCError{})
+
+	report, err := env.Repair()
+	c.Assert(err, IsNil)
+	c.Assert(report.Copy, Equals, 0)
+	c.Assert(report.Problem, FitsTypeOf, &CRCError{})
+
+	status, _ = env.RedundancyStatus()
+	c.Assert(status.Healthy(), Equals, true)
+	c.Assert(status.Active, Equals, 1)
+	c.Assert(status.Repaired, Equals, report)
+
+	// nothing to do anymore
+	report, err = env.Repair()
+	c.Assert(err, IsNil)
+	c.Assert(report, IsNil)
+
+	// both copies have the same content now and the next save goes
+	// to the repaired copy
+	env1, err := Open(r.envFile1)
+	c.Assert(err, IsNil)
+	c.Assert(env1.Get("foo"), Equals, "old")
+	env.Set("foo", "newer")
+	c.Assert(env.Save(), IsNil)
+	env1, err = Open(r.envFile1)
+	c.Assert(err, IsNil)
+	c.Assert(env1.Get("foo"), Equals, "newer")
+}
+
+func (r *redundantTestSuite) TestAutoRepair(c *C) {
+	_, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+	r.corrupt(c, r.envFile2)
+
+	env, err := OpenRedundant(r.envFile1, r.envFile2, Options{AutoRepair: true})
+	c.Assert(err, IsNil)
+	status, _ := env.RedundancyStatus()
+	c.Assert(status.Healthy(), Equals, true)
+	c.Assert(status.Repaired, NotNil)
+	c.Assert(status.Repaired.Copy, Equals, 1)
+}
+
+func (r *redundantTestSuite) TestRepairConflictingFlags(c *C) {
+	_, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(r.envFile2)
+	c.Assert(err, IsNil)
+	content[flagsOffset] = 1
+	c.Assert(ioutil.WriteFile(r.envFile2, content, 0644), IsNil)
+
+	env, err := OpenRedundant(r.envFile1, r.envFile2, Options{})
+	c.Assert(err, IsNil)
+	report, err := env.Repair()
+	c.Assert(err, IsNil)
+	c.Assert(report.Copy, Equals, 1)
+	c.Assert(report.Problem, ErrorMatches, "both copies have flags 1")
+	f1, f2 := r.flags(c)
+	c.Assert([]byte{f1, f2}, DeepEquals, []byte{1, 0})
+}
+
+func (r *redundantTestSuite) TestBothCopiesBroken(c *C) {
+	_, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+	r.corrupt(c, r.envFile1)
+	r.corrupt(c, r.envFile2)
+
+	_, err = OpenRedundant(r.envFile1, r.envFile2, Options{})
+	c.Assert(err, ErrorMatches, "no valid copy of the environment: copy 0: bad CRC: .*, copy 1: bad CRC: .*")
+}
+
+func (r *redundantTestSuite) TestRepairWithoutRedundancy(c *C) {
+	env, err := Create(r.envFile1, 64)
+	c.Assert(err, IsNil)
+	_, ok := env.RedundancyStatus()
+	c.Assert(ok, Equals, false)
+	_, err = env.Repair()
+	c.Assert(err, ErrorMatches, "cannot repair environment without redundancy")
+}
+
+// barrierStorage blocks reads until all storages sharing the barrier
+// are reading
+type barrierStorage struct {
+	Storage
+	barrier *sync.WaitGroup
+}
+
+func (s *barrierStorage) ReadImage() ([]byte, error) {
+	s.barrier.Done()
+	s.barrier.Wait()
+	return s.Storage.ReadImage()
+}
+
+func (r *redundantTestSuite) TestCopiesAreReadConcurrently(c *C) {
+	_, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+	storage1 := &barrierStorage{Storage: newStorage(r.envFile1, Options{}), barrier: &barrier}
+	storage2 := &barrierStorage{Storage: newStorage(r.envFile2, Options{}), barrier: &barrier}
+
+	// reading one copy after the other would never finish
+	_, err = OpenRedundantStorage(storage1, storage2, Options{Timeout: 5 * time.Second})
+	c.Assert(err, IsNil)
+}
+
+func (r *redundantTestSuite) TestMirrorOnSave(c *C) {
+	opts := Options{MirrorOnSave: true}
+	env, err := CreateRedundant(r.envFile1, r.envFile2, 64, opts)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	status, _ := env.RedundancyStatus()
+	c.Assert(status.Healthy(), Equals, true)
+	active := status.Active
+	flags1, flags2 := r.flags(c)
+	c.Assert(flags1-flags2 == 1 || flags2-flags1 == 1, Equals, true)
+
+	// breaking the active copy falls back to the same variables
+	r.corrupt(c, []string{r.envFile1, r.envFile2}[active])
+	env, err = OpenRedundant(r.envFile1, r.envFile2, Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("foo"), Equals, "bar")
+	status, _ = env.RedundancyStatus()
+	c.Assert(status.Active, Equals, 1-active)
+}
+
+func (r *redundantTestSuite) TestSaveSafe(c *C) {
+	env, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	result, err := env.SaveSafe()
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, &SaveResult{Written: true, Copy: 1, Flags: 2, Verified: true})
+
+	// nothing to write
+	result, err = env.SaveSafe()
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, &SaveResult{Copy: 1, Flags: 2, Verified: true})
+
+	env.opts.MirrorOnSave = true
+	env.Set("foo", "baz")
+	result, err = env.SaveSafe()
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, &SaveResult{Written: true, Copy: 0, Flags: 3, Verified: true, Mirrored: true})
+	for _, fname := range []string{r.envFile1, r.envFile2} {
+		env, err := Open(fname)
+		c.Assert(err, IsNil)
+		c.Check(env.Get("foo"), Equals, "baz")
+	}
+}
+
+func (r *redundantTestSuite) TestSaveSafeVerifyFails(c *C) {
+	env, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+	rs := env.redundantStorage()
+	rs.copies[1] = &corruptingStorage{Storage: rs.copies[1]}
+	env.Set("foo", "bar")
+	_, err = env.SaveSafe()
+	c.Assert(err, Equals, ErrVerifyFailed)
+
+	// the active copy is intact
+	env, err = OpenRedundant(r.envFile1, r.envFile2, Options{})
+	c.Assert(err, IsNil)
+	status, _ := env.RedundancyStatus()
+	c.Assert(status.Active, Equals, 0)
+	c.Assert(status.Copies[1].Valid, Equals, false)
+}
+
+func (u *uenvTestSuite) TestSaveSafeWithoutRedundancy(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	_, err = env.SaveSafe()
+	c.Assert(err, ErrorMatches, "cannot save safely: environment has no redundancy")
+}
@@ -0,0 +1,163 @@
+package uenv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy describes how storage reads and writes that failed with
+// a transient error are retried.
+type RetryPolicy struct {
+	// Attempts is the total number of attempts made, values below
+	// one mean a single attempt.
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles with
+	// every further retry.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries if not zero.
+	MaxBackoff time.Duration
+	// Jitter randomizes every delay by up to this fraction of it in
+	// either direction, e.g. 0.2 for +/- 20%, so that services that
+	// were started together do not retry in lockstep.
+	Jitter float64
+	// Retryable decides if an error is transient. If nil, EINTR,
+	// EAGAIN and EIO are retried.
+	Retryable func(err error) bool
+}
+
+// IsTransientError returns true for errors that flash devices are known
+// to return occasionally without being broken.
+func IsTransientError(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EIO)
+}
+
+// IsBusyError returns true for errors that mean someone else is using
+// the environment right now, e.g. another service at boot.
+func IsBusyError(err error) bool {
+	return errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, ErrLocked) || errors.Is(err, ErrConcurrentModification)
+}
+
+// BusyRetryPolicy returns a policy that retries busy errors for about
+// ten seconds.
+func BusyRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Attempts:   20,
+		Backoff:    10 * time.Millisecond,
+		MaxBackoff: time.Second,
+		Jitter:     0.2,
+		Retryable:  IsBusyError,
+	}
+}
+
+var timeSleep = time.Sleep
+
+var randFloat64 = rand.Float64
+
+// delay returns the jittered delay for the given backoff
+func (p *RetryPolicy) delay(backoff time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return backoff
+	}
+	return time.Duration(float64(backoff) * (1 + p.Jitter*(2*randFloat64()-1)))
+}
+
+func (p *RetryPolicy) do(op string, log *slog.Logger, f func() error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsTransientError
+	}
+	backoff := p.Backoff
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		err = f()
+		if err == nil || attempt >= p.Attempts || !retryable(err) {
+			break
+		}
+		delay := p.delay(backoff)
+		log.Debug("retrying storage operation", "op", op, "attempt", attempt, "backoff", delay, "err", err)
+		timeSleep(delay)
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+	if err != nil && attempt > 1 {
+		return fmt.Errorf("%s failed after %d attempts: %w", op, attempt, err)
+	}
+	return err
+}
+
+// retryStorage retries the operations of the wrapped storage
+// according to a retry policy
+type retryStorage struct {
+	Storage
+	policy *RetryPolicy
+	log    *slog.Logger
+}
+
+func (s *retryStorage) ReadImage() (img []byte, err error) {
+	err = s.policy.do("read", s.log, func() error {
+		img, err = s.Storage.ReadImage()
+		return err
+	})
+	return img, err
+}
+
+func (s *retryStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	return s.policy.do("write", s.log, func() error {
+		return s.Storage.WriteImage(size, fill)
+	})
+}
+
+func (s *retryStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+func (s *retryStorage) Close() error {
+	if c, ok := s.Storage.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// OpenWithRetry opens the environment file, retrying according to the
+// policy, e.g. BusyRetryPolicy, if that fails.
+func OpenWithRetry(fname string, opts Options, policy *RetryPolicy) (env *Env, err error) {
+	err = policy.do("open", opts.logger(), func() error {
+		env, err = OpenWithOptions(fname, opts)
+		return err
+	})
+	return env, err
+}
+
+// SaveWithRetry saves the environment, retrying according to the
+// policy if that fails. ErrConcurrentModification is not retried as
+// the environment needs to be reloaded first, see UpdateWithRetry.
+func (env *Env) SaveWithRetry(policy *RetryPolicy) error {
+	p := *policy
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsTransientError
+	}
+	p.Retryable = func(err error) bool {
+		return !errors.Is(err, ErrConcurrentModification) && retryable(err)
+	}
+	return p.do("save", env.opts.logger(), env.Save)
+}
+
+// UpdateWithRetry is UpdateWithOptions but does not wait for the lock.
+// Instead the whole update is retried according to the policy while
+// the lock is held by someone else or the update fails with another
+// retryable error.
+func UpdateWithRetry(fname string, opts Options, policy *RetryPolicy, f func(env *Env) error) error {
+	return policy.do("update", opts.logger(), func() error {
+		return update(fname, opts, TryLockFile, f)
+	})
+}
@@ -0,0 +1,129 @@
+package uenv
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// flakyStorage fails the first failures operations with err
+type flakyStorage struct {
+	Storage
+	failures int
+	err      error
+	calls    int
+}
+
+func (s *flakyStorage) fail() error {
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+	return nil
+}
+
+func (s *flakyStorage) ReadImage() ([]byte, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	return s.Storage.ReadImage()
+}
+
+func (s *flakyStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.Storage.WriteImage(size, fill)
+}
+
+func mockSleep() (delays *[]time.Duration, restore func()) {
+	delays = &[]time.Duration{}
+	timeSleep = func(d time.Duration) { *delays = append(*delays, d) }
+	return delays, func() { timeSleep = time.Sleep }
+}
+
+func (u *uenvTestSuite) TestRetryTransientErrors(c *C) {
+	delays, restore := mockSleep()
+	defer restore()
+	env, err := Create(u.envFile, 32)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	flaky := &flakyStorage{Storage: newStorage(u.envFile, Options{}), failures: 3, err: syscall.EIO}
+	policy := &RetryPolicy{Attempts: 5, Backoff: time.Millisecond, MaxBackoff: 3 * time.Millisecond}
+	env, err = OpenStorage(flaky, Options{Retry: policy, ForceWrite: true})
+	c.Assert(err, IsNil)
+	c.Assert(*delays, DeepEquals, []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond})
+
+	flaky.calls = 0
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(flaky.calls, Equals, 4)
+}
+
+func (u *uenvTestSuite) TestRetryGivesUp(c *C) {
+	_, restore := mockSleep()
+	defer restore()
+	flaky := &flakyStorage{failures: 10, err: syscall.EAGAIN}
+	_, err := OpenStorage(flaky, Options{Retry: &RetryPolicy{Attempts: 3}})
+	c.Assert(err, ErrorMatches, "read failed after 3 attempts: .*")
+	c.Assert(errors.Is(err, syscall.EAGAIN), Equals, true)
+	c.Assert(flaky.calls, Equals, 3)
+}
+
+func (u *uenvTestSuite) TestRetryPermanentError(c *C) {
+	_, restore := mockSleep()
+	defer restore()
+	flaky := &flakyStorage{failures: 10, err: syscall.ENOENT}
+	_, err := OpenStorage(flaky, Options{Retry: &RetryPolicy{Attempts: 3}})
+	c.Assert(err, Equals, syscall.ENOENT)
+	c.Assert(flaky.calls, Equals, 1)
+}
+
+func (u *uenvTestSuite) TestRetryJitter(c *C) {
+	delays, restore := mockSleep()
+	defer restore()
+	randFloat64 = func() float64 { return 1 }
+	defer func() { randFloat64 = rand.Float64 }()
+
+	flaky := &flakyStorage{failures: 10, err: syscall.EBUSY}
+	policy := &RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond, Jitter: 0.5, Retryable: IsBusyError}
+	_, err := OpenStorage(flaky, Options{Retry: policy})
+	c.Assert(err, ErrorMatches, "read failed after 3 attempts: device or resource busy")
+	c.Assert(*delays, DeepEquals, []time.Duration{15 * time.Millisecond, 30 * time.Millisecond})
+}
+
+func (u *uenvTestSuite) TestOpenWithRetry(c *C) {
+	_, restore := mockSleep()
+	defer restore()
+	_, err := OpenWithRetry(u.envFile, Options{}, BusyRetryPolicy())
+	// a missing file is not busy
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	_, err = Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env, err := OpenWithRetry(u.envFile, Options{}, BusyRetryPolicy())
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.SaveWithRetry(BusyRetryPolicy()), IsNil)
+}
+
+func (u *uenvTestSuite) TestSaveWithRetryConcurrentModification(c *C) {
+	_, restore := mockSleep()
+	defer restore()
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.opts.CompareAndSwap = true
+	other, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	other.Set("a", "b")
+	c.Assert(other.Save(), IsNil)
+
+	env.Set("foo", "bar")
+	c.Assert(env.SaveWithRetry(BusyRetryPolicy()), Equals, ErrConcurrentModification)
+}
@@ -0,0 +1,72 @@
+package uenv
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// SaveResult describes what SaveSafe did.
+type SaveResult struct {
+	// Written is false if the storage already held the environment.
+	Written bool
+	// Copy is the index of the copy that was written and is active
+	// now.
+	Copy int
+	// Flags are the flags the written copy got.
+	Flags byte
+	// Verified is set when the written copy was read back correctly.
+	Verified bool
+	// Mirrored is set when the other copy was rewritten as well
+	// because of Options.MirrorOnSave.
+	Mirrored bool
+}
+
+// SaveSafe saves a redundant environment with the guarantee that at no
+// point during the operation both stored copies are invalid: only the
+// inactive copy is written, it is read back and verified before it is
+// used, and with Options.MirrorOnSave the other copy is only rewritten
+// after that. SaveSafe fails for environments without redundancy, for
+// which no such guarantee can be given.
+func (env *Env) SaveSafe() (*SaveResult, error) {
+	rs := env.redundantStorage()
+	if rs == nil {
+		return nil, errors.New("cannot save safely: environment has no redundancy")
+	}
+	if env.batchDepth > 0 {
+		return nil, errors.New("cannot save safely during a batch")
+	}
+
+	verify, mirror := env.opts.Verify, env.opts.MirrorOnSave
+	env.opts.Verify, env.opts.MirrorOnSave = true, false
+	skipped := atomic.LoadUint64(&env.stats.savesSkipped)
+	err := env.Save()
+	env.opts.Verify, env.opts.MirrorOnSave = verify, mirror
+	if err != nil {
+		return nil, err
+	}
+
+	active := rs.status.Active
+	result := &SaveResult{
+		Written:  atomic.LoadUint64(&env.stats.savesSkipped) == skipped,
+		Copy:     active,
+		Flags:    rs.status.Copies[active].Flags,
+		Verified: true,
+	}
+	if !result.Written || !mirror {
+		return result, nil
+	}
+
+	if err := rs.mirror(env.size, env.writeImage); err != nil {
+		return result, fmt.Errorf("cannot mirror copy %d: %v", active, err)
+	}
+	img, err := rs.copies[1-active].ReadImage()
+	if err == nil {
+		err = verifyImage(img, env.header, env.opts.Checksum)
+	}
+	if err != nil {
+		return result, fmt.Errorf("cannot verify mirrored copy %d: %v", 1-active, err)
+	}
+	result.Mirrored = true
+	return result, nil
+}
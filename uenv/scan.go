@@ -0,0 +1,162 @@
+package uenv
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// DefaultScanSizes are the environment sizes ScanImage looks for if
+// none are given, the usual values of CONFIG_ENV_SIZE.
+var DefaultScanSizes = []int{0x1000, 0x2000, 0x4000, 0x8000, 0x10000, 0x20000, 0x40000}
+
+// scanChunkSize is the amount of the image a worker scans at once
+var scanChunkSize int64 = 64 << 20
+
+// scanNameLen is how far into the payload the "=" of the first
+// variable is looked for
+const scanNameLen = 64
+
+// ScanOptions alter how ScanImage searches an image.
+type ScanOptions struct {
+	// Sizes are the environment sizes to look for, DefaultScanSizes
+	// if empty.
+	Sizes []int
+	// Align is the alignment of the offsets that are searched, 512
+	// if zero.
+	Align int64
+	// Parallelism is the number of workers scanning the image,
+	// GOMAXPROCS if zero.
+	Parallelism int
+	// Checksum is the CRC of the environments to look for.
+	Checksum Checksum
+}
+
+// ScanResult is an environment found by ScanImage.
+type ScanResult struct {
+	Offset int64
+	Size   int
+	// Redundant is set for a copy of a redundant environment, its
+	// header has the flags byte.
+	Redundant bool
+}
+
+// ScanImage searches a disk image or block device for environments
+// with a valid CRC, e.g. to find out where the environment of an
+// unknown board lives. The image is split into chunks that are scanned
+// concurrently. Environments without any variables are not found.
+func ScanImage(fname string, opts ScanOptions) ([]ScanResult, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// Stat returns zero for block devices
+	total, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := append([]int(nil), opts.Sizes...)
+	if len(sizes) == 0 {
+		sizes = append(sizes, DefaultScanSizes...)
+	}
+	sort.Ints(sizes)
+	align := opts.Align
+	if align <= 0 {
+		align = 512
+	}
+	workers := opts.Parallelism
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var (
+		mu      sync.Mutex
+		results []ScanResult
+		scanErr error
+		wg      sync.WaitGroup
+	)
+	chunks := make(chan int64)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// environments starting in a chunk may extend into the
+			// next one
+			buf := make([]byte, scanChunkSize+int64(sizes[len(sizes)-1]))
+			for start := range chunks {
+				found, err := scanChunk(f, buf, start, total, align, sizes, opts.Checksum)
+				mu.Lock()
+				results = append(results, found...)
+				if err != nil && scanErr == nil {
+					scanErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for start := int64(0); start < total; start += scanChunkSize {
+		chunks <- start
+	}
+	close(chunks)
+	wg.Wait()
+	if scanErr != nil {
+		return nil, scanErr
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Offset < results[j].Offset
+	})
+	return results, nil
+}
+
+// scanChunk returns the environments starting in the chunk at start
+func scanChunk(r io.ReaderAt, buf []byte, start, total, align int64, sizes []int, sum Checksum) ([]ScanResult, error) {
+	if n := total - start; n < int64(len(buf)) {
+		buf = buf[:n]
+	}
+	n, err := r.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	data := buf[:n]
+
+	var found []ScanResult
+	end := start + scanChunkSize
+	for off := (start + align - 1) / align * align; off < end && off-start < int64(len(data)); off += align {
+		img := data[off-start:]
+	headers:
+		for _, header := range []int{headerSize, redundantHeaderSize} {
+			if len(img) <= header || !startsWithVariable(img[header:]) {
+				continue
+			}
+			for _, size := range sizes {
+				if size <= len(img) && verifyImage(img[:size], header, sum) == nil {
+					found = append(found, ScanResult{Offset: off, Size: size, Redundant: header == redundantHeaderSize})
+					break headers
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+// startsWithVariable is a cheap check that the payload starts with
+// "name=", so that the CRC is only computed for likely candidates
+func startsWithVariable(payload []byte) bool {
+	if len(payload) > scanNameLen {
+		payload = payload[:scanNameLen]
+	}
+	for i, b := range payload {
+		if b == '=' {
+			return i > 0
+		}
+		if b <= ' ' || b > '~' {
+			return false
+		}
+	}
+	return false
+}
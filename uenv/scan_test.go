@@ -0,0 +1,60 @@
+package uenv
+
+import (
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestScanImage(c *C) {
+	oldChunkSize := scanChunkSize
+	scanChunkSize = 0x10000
+	defer func() { scanChunkSize = oldChunkSize }()
+
+	c.Assert(os.WriteFile(u.envFile, make([]byte, 0x80000), 0644), IsNil)
+	// the second environment crosses a chunk boundary, the empty
+	// one is not found
+	for _, r := range []ScanResult{{Offset: 0x2000, Size: 0x2000}, {Offset: 0x1e000, Size: 0x4000}, {Offset: 0x40200, Size: 0x1000}, {Offset: 0x60000, Size: 0x2000}} {
+		storage, err := newRegionStorage(u.envFile, r.Offset, r.Size, Options{})
+		c.Assert(err, IsNil)
+		env, err := CreateStorage(storage, r.Size, Options{})
+		c.Assert(err, IsNil)
+		if r.Offset != 0x60000 {
+			env.Set("bootcmd", "run distro_bootcmd")
+			c.Assert(env.Save(), IsNil)
+		}
+	}
+	// both copies of a redundant environment are found
+	copy1, err := newRegionStorage(u.envFile, 0x70000, 0x1000, Options{})
+	c.Assert(err, IsNil)
+	copy2, err := newRegionStorage(u.envFile, 0x71000, 0x1000, Options{})
+	c.Assert(err, IsNil)
+	env, err := CreateStorage(newRedundantStorage(copy1, copy2, Options{}), 0x1000, Options{})
+	c.Assert(err, IsNil)
+	env.Set("bootcmd", "run distro_bootcmd")
+	c.Assert(env.Save(), IsNil)
+	env.Set("bootdelay", "3")
+	c.Assert(env.Save(), IsNil)
+
+	for _, parallelism := range []int{1, 3, 0} {
+		results, err := ScanImage(u.envFile, ScanOptions{Parallelism: parallelism})
+		c.Assert(err, IsNil)
+		c.Check(results, DeepEquals, []ScanResult{
+			{Offset: 0x2000, Size: 0x2000},
+			{Offset: 0x1e000, Size: 0x4000},
+			{Offset: 0x40200, Size: 0x1000},
+			{Offset: 0x70000, Size: 0x1000, Redundant: true},
+			{Offset: 0x71000, Size: 0x1000, Redundant: true},
+		})
+	}
+
+	// only the given sizes are found
+	results, err := ScanImage(u.envFile, ScanOptions{Sizes: []int{0x4000}})
+	c.Assert(err, IsNil)
+	c.Check(results, DeepEquals, []ScanResult{{Offset: 0x1e000, Size: 0x4000}})
+}
+
+func (u *uenvTestSuite) TestScanImageNotFound(c *C) {
+	_, err := ScanImage(u.envFile+".missing", ScanOptions{})
+	c.Check(os.IsNotExist(err), Equals, true)
+}
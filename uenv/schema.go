@@ -0,0 +1,201 @@
+package uenv
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VariableType is the kind of value a variable of a Schema holds.
+type VariableType string
+
+const (
+	// TypeString allows any value, it is the default.
+	TypeString VariableType = "string"
+	// TypeInt allows decimal, hex ("0x") and octal numbers.
+	TypeInt VariableType = "int"
+	// TypeHex allows hex numbers with or without "0x", the way
+	// U-Boot reads addresses like loadaddr.
+	TypeHex VariableType = "hex"
+	// TypeBool allows the yes/no values U-Boot understands, like
+	// "1", "0", "y", "n", "true" and "false".
+	TypeBool VariableType = "bool"
+)
+
+// VariableSchema describes the allowed values of a variable.
+type VariableSchema struct {
+	// Required makes a missing variable a violation.
+	Required bool `yaml:"required,omitempty"`
+	// Type is the kind of value, TypeString if empty.
+	Type VariableType `yaml:"type,omitempty"`
+	// Pattern is a regular expression the whole value must match
+	// if set.
+	Pattern string `yaml:"pattern,omitempty"`
+	// Enum are the allowed values if not empty.
+	Enum []string `yaml:"enum,omitempty"`
+}
+
+// Schema describes what an environment must look like, e.g. to check
+// a provisioned environment in CI before it is flashed.
+type Schema struct {
+	// Variables describes the variables by name.
+	Variables map[string]VariableSchema `yaml:"variables"`
+	// Groups are variables that need to be set together, if one
+	// of a group is set all of them must be.
+	Groups [][]string `yaml:"groups,omitempty"`
+	// Strict makes variables that are not in Variables a
+	// violation.
+	Strict bool `yaml:"strict,omitempty"`
+}
+
+// SchemaViolation describes a variable that does not match a Schema.
+type SchemaViolation struct {
+	Name string `json:"name"`
+	Msg  string `json:"msg"`
+}
+
+func (v *SchemaViolation) Error() string {
+	return fmt.Sprintf("variable %q: %s", v.Name, v.Msg)
+}
+
+// Validate checks that the types of the variables are known and that
+// their patterns compile.
+func (s *Schema) Validate() error {
+	_, err := s.patterns()
+	return err
+}
+
+// patterns compiles the patterns of the variables and checks their
+// types
+func (s *Schema) patterns() (map[string]*regexp.Regexp, error) {
+	patterns := make(map[string]*regexp.Regexp)
+	for name, vs := range s.Variables {
+		switch vs.Type {
+		case "", TypeString, TypeInt, TypeHex, TypeBool:
+		default:
+			return nil, fmt.Errorf("variable %q: unknown type %q", name, vs.Type)
+		}
+		if vs.Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + vs.Pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: cannot compile pattern: %v", name, err)
+		}
+		patterns[name] = re
+	}
+	return patterns, nil
+}
+
+// checkType returns why the value is not of the given type or ""
+func checkType(typ VariableType, value string) string {
+	switch typ {
+	case TypeInt:
+		if _, err := strconv.ParseInt(value, 0, 64); err != nil {
+			return fmt.Sprintf("value %q is not a number", value)
+		}
+	case TypeHex:
+		if _, err := ParseHex(value); err != nil {
+			return fmt.Sprintf("value %q is not a hex number", value)
+		}
+	case TypeBool:
+		if _, err := ParseBool(value); err != nil {
+			return fmt.Sprintf("value %q is not a boolean", value)
+		}
+	}
+	return ""
+}
+
+// ParseHex parses a hex number with or without "0x" like U-Boot does.
+func ParseHex(value string) (uint64, error) {
+	hex := strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+	return strconv.ParseUint(hex, 16, 64)
+}
+
+// ParseBool parses the yes/no values U-Boot understands, like "1",
+// "0", "y", "n", "true" and "false".
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "y", "yes", "true":
+		return true, nil
+	case "0", "n", "no", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", value)
+}
+
+// CheckSchema returns the violations of the schema sorted by variable
+// name. The error is only set if the schema itself is broken.
+func (env *Env) CheckSchema(schema *Schema) ([]*SchemaViolation, error) {
+	patterns, err := schema.patterns()
+	if err != nil {
+		return nil, err
+	}
+
+	env.load()
+	var violations []*SchemaViolation
+	add := func(name, format string, a ...interface{}) {
+		violations = append(violations, &SchemaViolation{Name: name, Msg: fmt.Sprintf(format, a...)})
+	}
+	for name, vs := range schema.Variables {
+		value, ok := env.data[name]
+		if !ok {
+			if vs.Required {
+				add(name, "required variable is not set")
+			}
+			continue
+		}
+		value = env.Get(name)
+		if msg := checkType(vs.Type, value); msg != "" {
+			add(name, "%s", msg)
+		}
+		if re := patterns[name]; re != nil && !re.MatchString(value) {
+			add(name, "value %q does not match %q", value, vs.Pattern)
+		}
+		if len(vs.Enum) > 0 && !containsString(vs.Enum, value) {
+			add(name, "value %q is not one of %s", value, strings.Join(vs.Enum, ", "))
+		}
+	}
+	for _, group := range schema.Groups {
+		var set, unset []string
+		for _, name := range group {
+			if _, ok := env.data[name]; ok {
+				set = append(set, name)
+			} else {
+				unset = append(unset, name)
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+		for _, name := range unset {
+			add(name, "must be set together with %s", strings.Join(set, ", "))
+		}
+	}
+	if schema.Strict {
+		for name := range env.data {
+			if _, ok := schema.Variables[name]; !ok {
+				add(name, "variable is not in the schema")
+			}
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Name != violations[j].Name {
+			return violations[i].Name < violations[j].Name
+		}
+		return violations[i].Msg < violations[j].Msg
+	})
+	return violations, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package uenv
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestCheckSchema(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("bootdelay", "three")
+	env.Set("boot_mode", "normal")
+	env.Set("serial#", "0123456789ab")
+	env.Set("upgrade_available", "1")
+	env.Set("ipaddr", "192.168.0.2")
+	env.Set("unknown", "x")
+	env.Set("loadaddr", "82000000")
+
+	schema := &Schema{
+		Variables: map[string]VariableSchema{
+			"bootdelay":         {Type: TypeInt},
+			"loadaddr":          {Type: TypeHex},
+			"boot_mode":         {Enum: []string{"normal", "recovery"}},
+			"serial#":           {Required: true, Pattern: "[0-9A-F]{12}"},
+			"upgrade_available": {Type: TypeBool},
+			"bootcmd":           {Required: true},
+			"ipaddr":            {},
+			"netmask":           {},
+		},
+		Groups: [][]string{{"ipaddr", "netmask"}, {"serverip", "gatewayip"}},
+	}
+	violations, err := env.CheckSchema(schema)
+	c.Assert(err, IsNil)
+	c.Check(violations, DeepEquals, []*SchemaViolation{
+		{Name: "bootcmd", Msg: "required variable is not set"},
+		{Name: "bootdelay", Msg: `value "three" is not a number`},
+		{Name: "netmask", Msg: "must be set together with ipaddr"},
+		{Name: "serial#", Msg: `value "0123456789ab" does not match "[0-9A-F]{12}"`},
+	})
+
+	schema.Strict = true
+	env.Set("boot_mode", "fastboot")
+	env.Set("bootdelay", "0x3")
+	violations, err = env.CheckSchema(schema)
+	c.Assert(err, IsNil)
+	c.Check(violations, HasLen, 5)
+	c.Check(violations[0].Error(), Equals, `variable "boot_mode": value "fastboot" is not one of normal, recovery`)
+	c.Check(violations[4].Error(), Equals, `variable "unknown": variable is not in the schema`)
+}
+
+func (u *uenvTestSuite) TestCheckSchemaBroken(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	_, err = env.CheckSchema(&Schema{Variables: map[string]VariableSchema{"foo": {Pattern: "("}}})
+	c.Check(err, ErrorMatches, `variable "foo": cannot compile pattern: .*`)
+	_, err = env.CheckSchema(&Schema{Variables: map[string]VariableSchema{"foo": {Type: "float"}}})
+	c.Check(err, ErrorMatches, `variable "foo": unknown type "float"`)
+}
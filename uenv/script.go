@@ -0,0 +1,39 @@
+package uenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultScriptCommand is the command WriteScript uses if none is
+// given.
+const DefaultScriptCommand = "fw_setenv"
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.,:/@%+=") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// WriteScript writes a shell script that sets all variables of the
+// environment with the given command, e.g. "fw_setenv" or
+// "uboot-go /boot/uboot.env set", to reproduce the environment on
+// another device. The variables are sorted so that the same
+// environment always gives the same script. Values are written as
+// stored, so secret variables stay encrypted. Variables of the target
+// that are not in the environment are kept.
+func (env *Env) WriteScript(w io.Writer, command string) error {
+	if command == "" {
+		command = DefaultScriptCommand
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#!/bin/sh\nset -e\n")
+	env.iterEnv(func(key, value string) {
+		fmt.Fprintf(bw, "%s %s %s\n", command, shellQuote(key), shellQuote(value))
+	})
+	return bw.Flush()
+}
@@ -0,0 +1,53 @@
+package uenv
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestWriteScript(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("bootcmd", "run distro_bootcmd; echo 'failed'")
+	env.Set("bootdelay", "3")
+	env.Set("serial#", "0123")
+
+	var buf bytes.Buffer
+	c.Assert(env.WriteScript(&buf, ""), IsNil)
+	c.Check(buf.String(), Equals, `#!/bin/sh
+set -e
+fw_setenv bootcmd 'run distro_bootcmd; echo '\''failed'\'''
+fw_setenv bootdelay 3
+fw_setenv 'serial#' 0123
+`)
+}
+
+func (u *uenvTestSuite) TestWriteScriptRuns(c *C) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		c.Skip("no shell")
+	}
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("bootcmd", "run distro_bootcmd; echo 'failed' $x \"y\"\\")
+	env.Set("bootargs", "console=ttyS0,115200\nquiet")
+
+	// a fake fw_setenv that records its arguments
+	dir := c.MkDir()
+	out := filepath.Join(dir, "out")
+	cmd := filepath.Join(dir, "setenv")
+	c.Assert(os.WriteFile(cmd, []byte("#!/bin/sh\nprintf '%s=%s\\n' \"$1\" \"$2\" >> "+out+"\n"), 0755), IsNil)
+
+	script := filepath.Join(dir, "script.sh")
+	var buf bytes.Buffer
+	c.Assert(env.WriteScript(&buf, cmd), IsNil)
+	c.Assert(os.WriteFile(script, buf.Bytes(), 0755), IsNil)
+	c.Assert(exec.Command("sh", script).Run(), IsNil)
+
+	content, err := os.ReadFile(out)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, env.String())
+}
@@ -0,0 +1,99 @@
+package uenv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Secrets selects variables that are stored encrypted, e.g. Wi-Fi keys
+// or provisioning tokens. They are encrypted with AES-GCM when set and
+// decrypted by Get, everything else, including String and Range, sees
+// the encrypted value.
+type Secrets struct {
+	// Key is the AES key, 16, 24 or 32 bytes long.
+	Key []byte
+	// Names are the variables to encrypt, as path.Match patterns
+	// like "wifi_*".
+	Names []string
+}
+
+// secretPrefix marks encrypted values, it is followed by the base64
+// encoded nonce and ciphertext
+const secretPrefix = "aesgcm:"
+
+func (s *Secrets) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets key: %v", err)
+	}
+	for _, pattern := range s.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid secret name pattern %q: %v", pattern, err)
+		}
+	}
+	return cipher.NewGCM(block)
+}
+
+// setupSecrets prepares the encryption of secret variables
+func (env *Env) setupSecrets() error {
+	if env.opts.Secrets == nil {
+		return nil
+	}
+	aead, err := env.opts.Secrets.aead()
+	if err != nil {
+		return err
+	}
+	env.aead = aead
+	return nil
+}
+
+// isSecret returns true if the variable is stored encrypted
+func (env *Env) isSecret(name string) bool {
+	if env.aead == nil {
+		return false
+	}
+	for _, pattern := range env.opts.Secrets.Names {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// encrypt encrypts the value of a secret variable, the name is
+// authenticated so that values cannot be swapped between variables
+func (env *Env) encrypt(name, value string) string {
+	nonce := make([]byte, env.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("cannot read random nonce: %v", err))
+	}
+	sealed := env.aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return secretPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// decrypt returns the plain value of a secret variable. Values that
+// are not encrypted, e.g. because they were set before the variable
+// became secret, are returned as they are.
+func (env *Env) decrypt(name, value string) (string, error) {
+	if !strings.HasPrefix(value, secretPrefix) {
+		return value, nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(value[len(secretPrefix):])
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt %s: %v", name, err)
+	}
+	if len(sealed) < env.aead.NonceSize() {
+		return "", fmt.Errorf("cannot decrypt %s: value too short", name)
+	}
+	nonce, ciphertext := sealed[:env.aead.NonceSize()], sealed[env.aead.NonceSize():]
+	plain, err := env.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt %s: %v", name, err)
+	}
+	return string(plain), nil
+}
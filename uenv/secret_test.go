@@ -0,0 +1,80 @@
+package uenv
+
+import (
+	"bytes"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+var testSecrets = &Secrets{
+	Key:   bytes.Repeat([]byte{0x42}, 32),
+	Names: []string{"wifi_*", "token"},
+}
+
+func (u *uenvTestSuite) TestSecrets(c *C) {
+	env, err := CreateWithOptions(u.envFile, 4096, Options{Secrets: testSecrets})
+	c.Assert(err, IsNil)
+	env.Set("wifi_psk", "hunter2")
+	env.Set("token", "s3cr3t")
+	env.Set("bootdelay", "0")
+	c.Assert(env.Get("wifi_psk"), Equals, "hunter2")
+	c.Assert(env.Save(), IsNil)
+
+	// without the key the values are encrypted
+	plain, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(plain.Get("bootdelay"), Equals, "0")
+	c.Assert(plain.Get("wifi_psk"), Matches, "aesgcm:.*")
+	c.Assert(strings.Contains(plain.String(), "hunter2"), Equals, false)
+
+	env, err = OpenWithOptions(u.envFile, Options{Secrets: testSecrets})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("wifi_psk"), Equals, "hunter2")
+	c.Assert(env.Get("token"), Equals, "s3cr3t")
+
+	// setting the same value again is not a change
+	env.Set("wifi_psk", "hunter2")
+	c.Assert(env.UndoLen(), Equals, 0)
+	env.Set("wifi_psk", "")
+	c.Assert(env.Get("wifi_psk"), Equals, "")
+}
+
+func (u *uenvTestSuite) TestSecretsImportAndPlainValues(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("wifi_ssid", "home")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenWithOptions(u.envFile, Options{Secrets: testSecrets})
+	c.Assert(err, IsNil)
+	// values set before they became secret are used as they are
+	c.Assert(env.Get("wifi_ssid"), Equals, "home")
+	c.Assert(env.Import(strings.NewReader("wifi_psk=hunter2\n")), IsNil)
+	c.Assert(env.String(), Matches, "(?s).*wifi_psk=aesgcm:.*")
+	c.Assert(env.Get("wifi_psk"), Equals, "hunter2")
+}
+
+func (u *uenvTestSuite) TestSecretsWrongKeyOrTampered(c *C) {
+	env, err := NewEnv(4096)
+	c.Assert(err, IsNil)
+	env.opts.Secrets = testSecrets
+	c.Assert(env.setupSecrets(), IsNil)
+	env.Set("token", "s3cr3t")
+
+	// values cannot be moved to another secret variable
+	env.data["wifi_psk"] = env.data["token"]
+	c.Assert(env.Get("wifi_psk"), Equals, "")
+	_, err = env.decrypt("wifi_psk", env.data["token"])
+	c.Assert(err, ErrorMatches, "cannot decrypt wifi_psk: cipher: message authentication failed")
+
+	_, err = env.decrypt("token", "aesgcm:AAAA")
+	c.Assert(err, ErrorMatches, "cannot decrypt token: value too short")
+}
+
+func (u *uenvTestSuite) TestSecretsInvalidOptions(c *C) {
+	_, err := CreateWithOptions(u.envFile, 4096, Options{Secrets: &Secrets{Key: []byte("short")}})
+	c.Assert(err, ErrorMatches, "invalid secrets key: crypto/aes: invalid key size 5")
+	_, err = CreateWithOptions(u.envFile, 4096, Options{Secrets: &Secrets{Key: testSecrets.Key, Names: []string{"["}}})
+	c.Assert(err, ErrorMatches, `invalid secret name pattern "\[": syntax error in pattern`)
+}
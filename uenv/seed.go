@@ -0,0 +1,55 @@
+package uenv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultSeedDir is where first-boot seed files are looked for
+const DefaultSeedDir = "/boot/uenv-seed.d"
+
+// DefaultSeedMarker is the variable that records that the seed was
+// applied
+const DefaultSeedMarker = "uenv_seeded"
+
+// Seed applies the seed files in dir once: all "*.txt" files are
+// imported in lexical order, marker is set to "1" and the environment
+// is saved with a single write. If marker is already set nothing is
+// done. Seed reports whether the seed was applied.
+func (env *Env) Seed(dir, marker string) (bool, error) {
+	if env.Get(marker) != "" {
+		return false, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return false, err
+	}
+	sort.Strings(files)
+	for _, fname := range files {
+		if err := env.importFile(fname); err != nil {
+			return false, err
+		}
+	}
+	env.Set(marker, "1")
+	if err := env.Save(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (env *Env) importFile(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := env.Import(f); err != nil {
+		return fmt.Errorf("cannot import %s: %v", fname, err)
+	}
+	return nil
+}
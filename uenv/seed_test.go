@@ -0,0 +1,44 @@
+package uenv
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestSeed(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.WriteFile(filepath.Join(dir, "10-base.txt"), []byte("# base\nbootdelay=3\nserial=unset\n"), 0644), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "20-device.txt"), []byte("serial=1234\n"), 0644), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "README"), []byte("not a seed\n"), 0644), IsNil)
+
+	env, err := Create(u.envFile, 128)
+	c.Assert(err, IsNil)
+	applied, err := env.Seed(dir, DefaultSeedMarker)
+	c.Assert(err, IsNil)
+	c.Assert(applied, Equals, true)
+
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "bootdelay=3\nserial=1234\nuenv_seeded=1\n")
+
+	// the seed is applied only once
+	env.Set("serial", "5678")
+	c.Assert(env.Save(), IsNil)
+	applied, err = env.Seed(dir, DefaultSeedMarker)
+	c.Assert(err, IsNil)
+	c.Assert(applied, Equals, false)
+	c.Assert(env.Get("serial"), Equals, "5678")
+}
+
+func (u *uenvTestSuite) TestSeedInvalidFile(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.WriteFile(filepath.Join(dir, "bad.txt"), []byte("novalue\n"), 0644), IsNil)
+
+	env, err := Create(u.envFile, 128)
+	c.Assert(err, IsNil)
+	_, err = env.Seed(dir, DefaultSeedMarker)
+	c.Assert(err, ErrorMatches, `cannot import .*/bad.txt: Invalid line: "novalue"`)
+	c.Assert(env.Get(DefaultSeedMarker), Equals, "")
+}
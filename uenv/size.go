@@ -0,0 +1,15 @@
+package uenv
+
+// RecommendSize returns the size an environment should have to hold the
+// variables of env: the header and the payload plus a margin of a
+// quarter of the payload for variables added later, rounded up to a
+// multiple of alignTo, e.g. the erase block size of the flash. An
+// alignTo below two does not round.
+func RecommendSize(env *Env, alignTo int) int {
+	payload := env.payloadSize()
+	size := env.header + payload + payload/4
+	if alignTo > 1 {
+		size = (size + alignTo - 1) / alignTo * alignTo
+	}
+	return size
+}
@@ -0,0 +1,30 @@
+package uenv
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestRecommendSize(c *C) {
+	env, err := NewEnv(0x4000)
+	c.Assert(err, IsNil)
+	c.Check(RecommendSize(env, 0), Equals, headerSize+2)
+
+	// 1000 bytes of payload
+	env.Set("bootcmd", strings.Repeat("x", 1000-len("bootcmd=")-2))
+	c.Assert(env.payloadSize(), Equals, 1000)
+	c.Check(RecommendSize(env, 0), Equals, headerSize+1000+250)
+	c.Check(RecommendSize(env, 1), Equals, headerSize+1000+250)
+	c.Check(RecommendSize(env, 512), Equals, 1536)
+	c.Check(RecommendSize(env, 0x10000), Equals, 0x10000)
+
+	// the recommended size fits the environment
+	small, err := CreateStorage(NewMemStorage(nil), RecommendSize(env, 0), Options{})
+	c.Assert(err, IsNil)
+	env.Range(func(key, value string) bool {
+		small.Set(key, value)
+		return true
+	})
+	c.Check(small.Save(), IsNil)
+}
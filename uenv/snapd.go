@@ -0,0 +1,22 @@
+package uenv
+
+// SnapdBootSelSize is the size snapd creates boot.sel files with
+const SnapdBootSelSize = 4096
+
+// SnapdOptions returns the options that make this package read and
+// write environment files like snapd's ubootenv package does for
+// boot.sel and uboot.env: a header with flags byte, sorted keys, 0xff
+// padding, malformed entries skipped on open and every save written
+// and fsynced in place, even when nothing changed.
+//
+// Files written with these options are byte-identical to the ones
+// snapd writes.
+func SnapdOptions() Options {
+	return Options{
+		Flags:         OpenBestEffort,
+		Header:        HeaderRedundant,
+		WriteStrategy: WriteInPlace,
+		Sync:          SyncFsync,
+		ForceWrite:    true,
+	}
+}
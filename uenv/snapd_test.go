@@ -0,0 +1,73 @@
+package uenv
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// snapdImage builds an image the way snapd's ubootenv Save does
+func snapdImage(size int, vars map[string]string) []byte {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w := bytes.NewBuffer(nil)
+	for _, k := range keys {
+		w.WriteString(k + "=" + vars[k])
+		w.WriteByte(0)
+	}
+	w.WriteByte(0)
+	if len(vars) == 0 {
+		w.WriteByte(0)
+	}
+	for w.Len() < size-5 {
+		w.WriteByte(0xff)
+	}
+	return append(append(writeUint32(crc32.ChecksumIEEE(w.Bytes())), 0), w.Bytes()...)
+}
+
+func (u *uenvTestSuite) TestSnapdByteIdentical(c *C) {
+	env, err := CreateWithOptions(u.envFile, SnapdBootSelSize, SnapdOptions())
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content, DeepEquals, snapdImage(SnapdBootSelSize, nil))
+
+	vars := map[string]string{
+		"snap_kernel":     "pi-kernel_1.snap",
+		"snap_try_kernel": "pi-kernel_2.snap",
+		"kernel_status":   "try",
+	}
+	for k, v := range vars {
+		env.Set(k, v)
+	}
+	c.Assert(env.Save(), IsNil)
+	content, err = ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content, DeepEquals, snapdImage(SnapdBootSelSize, vars))
+}
+
+func (u *uenvTestSuite) TestSnapdOpensSnapdFiles(c *C) {
+	img := snapdImage(SnapdBootSelSize, map[string]string{"snap_mode": "try"})
+	c.Assert(ioutil.WriteFile(u.envFile, img, 0644), IsNil)
+
+	env, err := OpenWithOptions(u.envFile, SnapdOptions())
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("snap_mode"), Equals, "try")
+
+	// snapd writes even when nothing changed
+	old := time.Unix(0, 0)
+	c.Assert(os.Chtimes(u.envFile, old, old), IsNil)
+	c.Assert(env.Save(), IsNil)
+	st, err := os.Stat(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(st.ModTime().Equal(old), Equals, false)
+}
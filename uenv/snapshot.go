@@ -0,0 +1,79 @@
+package uenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Snapshot is a full binary copy of an environment, e.g. taken before
+// a risky firmware update so that the environment can be restored if
+// the update fails.
+type Snapshot struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	// Image is the environment image including its header
+	Image []byte `json:"image"`
+	// Checksum is the CRC used in the header of Image
+	Checksum Checksum `json:"checksum,omitempty"`
+}
+
+// Snapshot returns a snapshot of the variables of the environment as
+// they are in memory, which includes changes that were not saved yet.
+func (env *Env) Snapshot(name string) (*Snapshot, error) {
+	img, err := env.image()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Name: name, Time: timeNow().UTC(), Image: img, Checksum: env.opts.Checksum}, nil
+}
+
+// Restore replaces all variables of target with the ones of the
+// snapshot and saves target. The snapshot may come from an environment
+// of a different size as long as its variables fit into target.
+// Earlier changes of target can no longer be undone afterwards.
+func Restore(target *Env, snap *Snapshot) error {
+	header, err := detectHeader(snap.Image, snap.Checksum)
+	if err != nil {
+		return fmt.Errorf("cannot restore snapshot %q: %v", snap.Name, err)
+	}
+	data, _, err := parseImage(snap.Image, header, 0, snap.Checksum)
+	if err != nil {
+		return fmt.Errorf("cannot restore snapshot %q: %v", snap.Name, err)
+	}
+	target.load()
+	old := target.data
+	target.data = data
+	if need, avail := target.payloadSize(), target.size-target.header; need > avail {
+		target.data = old
+		return fmt.Errorf("cannot restore snapshot %q: %d bytes needed, %d available", snap.Name, need, avail)
+	}
+	target.undo = nil
+	return target.Save()
+}
+
+// WriteFile stores the snapshot with its metadata in the given file.
+func (snap *Snapshot) WriteFile(fname string) error {
+	content, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fname, content, 0600)
+}
+
+// ReadSnapshot reads a snapshot stored with Snapshot.WriteFile.
+func ReadSnapshot(fname string) (*Snapshot, error) {
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(content, &snap); err != nil {
+		return nil, fmt.Errorf("cannot read snapshot %s: %v", fname, err)
+	}
+	if _, err := detectHeader(snap.Image, snap.Checksum); err != nil {
+		return nil, fmt.Errorf("cannot read snapshot %s: %v", fname, err)
+	}
+	return &snap, nil
+}
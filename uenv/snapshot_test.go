@@ -0,0 +1,77 @@
+package uenv
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestSnapshotRestore(c *C) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("bootcmd", "run mmcboot")
+	env.Set("slot", "a")
+	c.Assert(env.Save(), IsNil)
+
+	snap, err := env.Snapshot("pre-update")
+	c.Assert(err, IsNil)
+	c.Assert(snap.Name, Equals, "pre-update")
+	c.Assert(snap.Time, Equals, now)
+	c.Assert(snap.Image, HasLen, 4096)
+
+	fname := filepath.Join(c.MkDir(), "pre-update.snap")
+	c.Assert(snap.WriteFile(fname), IsNil)
+
+	// the update goes wrong
+	env.Set("slot", "b")
+	env.Set("bootcmd", "")
+	env.Set("upgrade_available", "1")
+	c.Assert(env.Save(), IsNil)
+
+	snap, err = ReadSnapshot(fname)
+	c.Assert(err, IsNil)
+	c.Assert(snap.Name, Equals, "pre-update")
+	c.Assert(Restore(env, snap), IsNil)
+
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "bootcmd=run mmcboot\nslot=a\n")
+}
+
+func (u *uenvTestSuite) TestRestoreTooBig(c *C) {
+	big, err := NewEnv(4096)
+	c.Assert(err, IsNil)
+	big.Set("foo", "a-long-value-that-does-not-fit-into-the-small-environment")
+	snap, err := big.Snapshot("big")
+	c.Assert(err, IsNil)
+
+	small, err := NewEnv(32)
+	c.Assert(err, IsNil)
+	small.Set("bar", "1")
+	err = Restore(small, snap)
+	c.Assert(err, ErrorMatches, `cannot restore snapshot "big": 63 bytes needed, 28 available`)
+	c.Assert(small.String(), Equals, "bar=1\n")
+}
+
+func (u *uenvTestSuite) TestReadSnapshotCorrupt(c *C) {
+	env, err := NewEnv(64)
+	c.Assert(err, IsNil)
+	snap, err := env.Snapshot("x")
+	c.Assert(err, IsNil)
+	snap.Image[10] ^= 0xff
+	fname := filepath.Join(c.MkDir(), "x.snap")
+	c.Assert(snap.WriteFile(fname), IsNil)
+
+	_, err = ReadSnapshot(fname)
+	c.Assert(err, ErrorMatches, "cannot read snapshot .*/x.snap: bad CRC: .*")
+
+	c.Assert(os.WriteFile(fname, []byte("garbage"), 0600), IsNil)
+	_, err = ReadSnapshot(fname)
+	c.Assert(err, ErrorMatches, "cannot read snapshot .*/x.snap: invalid character .*")
+}
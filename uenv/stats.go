@@ -0,0 +1,101 @@
+package uenv
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Stats are the write statistics of an environment.
+type Stats struct {
+	// SavesAttempted counts the calls to Save.
+	SavesAttempted uint64
+	// SavesSkipped counts the saves that did not write because the
+	// storage already held identical content.
+	SavesSkipped uint64
+	// SaveErrors counts the saves that failed.
+	SaveErrors uint64
+	// BytesWritten is the number of bytes written to the storage,
+	// including retried writes.
+	BytesWritten uint64
+	// SectorsErased is the number of flash sectors erased, it stays
+	// zero for storage that does not need erasing.
+	SectorsErased uint64
+	// VerifyFailures counts writes that did not read back correctly
+	// when Options.Verify is set.
+	VerifyFailures uint64
+}
+
+// StatsReporter is implemented by everything that keeps write
+// statistics, so that they can be exported e.g. as metrics.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// EraseCounter is implemented by storage that erases sectors before
+// writing them.
+type EraseCounter interface {
+	// SectorsErased returns the number of sectors erased so far.
+	SectorsErased() uint64
+}
+
+// storageWrapper is implemented by storage that adds behavior on top
+// of another storage
+type storageWrapper interface {
+	Unwrap() Storage
+}
+
+// stats are the live counters of an env, they may be read while a
+// save is in progress
+type stats struct {
+	savesAttempted uint64
+	savesSkipped   uint64
+	saveErrors     uint64
+	bytesWritten   uint64
+	verifyFailures uint64
+}
+
+// Stats returns the write statistics of the environment.
+func (env *Env) Stats() Stats {
+	st := Stats{
+		SavesAttempted: atomic.LoadUint64(&env.stats.savesAttempted),
+		SavesSkipped:   atomic.LoadUint64(&env.stats.savesSkipped),
+		SaveErrors:     atomic.LoadUint64(&env.stats.saveErrors),
+		BytesWritten:   atomic.LoadUint64(&env.stats.bytesWritten),
+		VerifyFailures: atomic.LoadUint64(&env.stats.verifyFailures),
+		SectorsErased:  env.sectorsErased(),
+	}
+	return st
+}
+
+// sectorsErased returns the erase count of the storage, zero if it
+// does not erase
+func (env *Env) sectorsErased() uint64 {
+	return sectorsErased(env.storage)
+}
+
+// sectorsErased returns the erase count of s or the storage it wraps
+func sectorsErased(s Storage) uint64 {
+	for s != nil {
+		if ec, ok := s.(EraseCounter); ok {
+			return ec.SectorsErased()
+		}
+		w, ok := s.(storageWrapper)
+		if !ok {
+			break
+		}
+		s = w.Unwrap()
+	}
+	return 0
+}
+
+// countingWriterAt counts the bytes written through it
+type countingWriterAt struct {
+	w io.WriterAt
+	n *uint64
+}
+
+func (c *countingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n, err := c.w.WriteAt(p, off)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
@@ -0,0 +1,101 @@
+package uenv
+
+import (
+	"io"
+
+	. "gopkg.in/check.v1"
+)
+
+type erasingStorage struct {
+	Storage
+	erased uint64
+}
+
+func (s *erasingStorage) SectorsErased() uint64 {
+	return s.erased
+}
+
+// corruptingStorage flips a bit in everything it writes
+type corruptingStorage struct {
+	Storage
+}
+
+type corruptingWriter struct {
+	w io.WriterAt
+}
+
+func (c corruptingWriter) WriteAt(p []byte, off int64) (int, error) {
+	q := append([]byte(nil), p...)
+	q[0] ^= 1
+	return c.w.WriteAt(q, off)
+}
+
+func (s *corruptingStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	return s.Storage.WriteImage(size, func(w io.WriterAt) error {
+		return fill(corruptingWriter{w})
+	})
+}
+
+func (u *uenvTestSuite) TestStats(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Save(), IsNil)
+	env.Set("foo", "bar-bar-bar-bar-bar-bar-bar-bar-bar-bar-bar-bar-bar-bar-bar")
+	c.Assert(env.Save(), NotNil)
+
+	var reporter StatsReporter = env
+	c.Assert(reporter.Stats(), DeepEquals, Stats{
+		SavesAttempted: 3,
+		SavesSkipped:   1,
+		SaveErrors:     1,
+		// one write from Create and one from Save
+		BytesWritten: 128,
+	})
+}
+
+func (u *uenvTestSuite) TestStatsSectorsErased(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	erasing := &erasingStorage{Storage: newStorage(u.envFile, Options{}), erased: 3}
+	env, err = OpenStorage(erasing, Options{Retry: &RetryPolicy{}})
+	c.Assert(err, IsNil)
+	c.Assert(env.Stats().SectorsErased, Equals, uint64(3))
+}
+
+func (r *redundantTestSuite) TestStatsSectorsErased(c *C) {
+	_, err := CreateRedundant(r.envFile1, r.envFile2, 64, Options{})
+	c.Assert(err, IsNil)
+
+	erasing1 := &erasingStorage{Storage: newStorage(r.envFile1, Options{}), erased: 3}
+	erasing2 := &erasingStorage{Storage: newStorage(r.envFile2, Options{}), erased: 2}
+	env, err := OpenRedundantStorage(erasing1, erasing2, Options{Retry: &RetryPolicy{}})
+	c.Assert(err, IsNil)
+	c.Assert(env.Stats().SectorsErased, Equals, uint64(5))
+}
+
+func (u *uenvTestSuite) TestVerify(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	c.Assert(env.Save(), IsNil)
+
+	corrupting := &corruptingStorage{Storage: newStorage(u.envFile, Options{})}
+	env, err = OpenStorage(corrupting, Options{Verify: true})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), Equals, ErrVerifyFailed)
+	c.Assert(env.Stats().VerifyFailures, Equals, uint64(1))
+
+	// the corrupted write is really on disk
+	_, err = Open(u.envFile)
+	c.Assert(err, ErrorMatches, "bad CRC: .*")
+
+	env, err = CreateWithOptions(u.envFile, 64, Options{Verify: true})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Stats().VerifyFailures, Equals, uint64(0))
+}
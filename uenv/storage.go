@@ -0,0 +1,418 @@
+package uenv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Storage is the medium an environment image is kept on.
+type Storage interface {
+	// ReadImage returns the complete environment image including
+	// the header. The returned slice must not be modified and may be
+	// reused by the next call.
+	ReadImage() ([]byte, error)
+	// WriteImage replaces the stored image by a new image of the
+	// given size. The image is produced by fill, which may be called
+	// more than once and must write the same data on every call.
+	WriteImage(size int, fill func(w io.WriterAt) error) error
+}
+
+func newStorage(fname string, opts Options) Storage {
+	if opts.Mmap {
+		return newMmapStorage(fname, opts)
+	}
+	return &fileStorage{
+		fname:    fname,
+		strategy: opts.WriteStrategy,
+		sync:     opts.Sync,
+		tracer:   opts.Tracer,
+		progress: opts.Progress,
+		direct:   opts.Direct,
+		maxSize:  opts.maxSize(),
+		// new files with this name are written compressed
+		compressed: isGzipName(fname),
+	}
+}
+
+// checkDirect returns an error if Options.Direct is combined with
+// options that do not write the file in place, Direct would be ignored
+// for them
+func (opts *Options) checkDirect() error {
+	switch {
+	case !opts.Direct:
+		return nil
+	case opts.Mmap:
+		return errors.New("cannot use Options.Direct with Options.Mmap")
+	case opts.WriteStrategy != WriteInPlace:
+		return errors.New("cannot use Options.Direct with a write strategy other than WriteInPlace")
+	}
+	return nil
+}
+
+// wrapStorage adds the behavior requested in the options on top of
+// the given storage
+func wrapStorage(s Storage, opts Options) Storage {
+	if opts.Timeout > 0 {
+		s = &timeoutStorage{Storage: s, timeout: opts.Timeout}
+	}
+	if opts.Retry != nil {
+		s = &retryStorage{Storage: s, policy: opts.Retry, log: opts.logger()}
+	}
+	if opts.Coalesce != nil && opts.Coalesce.Window > 0 {
+		s = &coalescingStorage{Storage: s, coalescer: opts.Coalesce}
+	}
+	if opts.Cache != nil && opts.Cache.TTL > 0 {
+		s = &cachedStorage{Storage: s, cache: opts.Cache}
+	}
+	return s
+}
+
+// fileStorage keeps the environment in a regular file
+type fileStorage struct {
+	fname    string
+	strategy WriteStrategy
+	sync     SyncMode
+	tracer   Tracer
+	progress func(Progress)
+	direct   bool
+	maxSize  int
+	// compressed is set when the file is a gzip compressed image,
+	// it is then written compressed as well
+	compressed bool
+
+	// buffers reused between reads and direct writes
+	readBuf   []byte
+	directBuf []byte
+}
+
+func (s *fileStorage) ReadImage() ([]byte, error) {
+	f, err := os.Open(s.fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !st.Mode().IsRegular() {
+		return readImage(f, s.fname, s.maxSize)
+	}
+	if st.Size() > int64(s.maxSize) {
+		return nil, fmt.Errorf("cannot read %s: larger than the maximum env size of %d bytes", s.fname, s.maxSize)
+	}
+
+	// regular files are read into the same buffer every time to not
+	// allocate a full env for every read
+	size := int(st.Size())
+	if cap(s.readBuf) < size {
+		s.readBuf = make([]byte, size)
+	}
+	n, err := readAt(f, s.readBuf[:size], 0, s.progress)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	img, compressed, err := gunzipImage(s.readBuf[:n], s.fname, s.maxSize)
+	if err != nil {
+		return nil, err
+	}
+	s.compressed = compressed
+	if compressed {
+		return img, nil
+	}
+	return s.readBuf[:n], nil
+}
+
+// readImage reads a complete image from r but refuses to read
+// more than maxSize bytes.
+func readImage(r io.Reader, name string, maxSize int) ([]byte, error) {
+	img, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(img) > maxSize {
+		return nil, fmt.Errorf("cannot read %s: larger than the maximum env size of %d bytes", name, maxSize)
+	}
+	return img, nil
+}
+
+func (s *fileStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if s.compressed {
+		if s.direct {
+			return fmt.Errorf("cannot use O_DIRECT with compressed file %s", s.fname)
+		}
+		return s.writeCompressed(size, fill)
+	}
+	switch s.strategy {
+	case WriteInPlace:
+		if s.direct {
+			return s.writeDirect(size, fill)
+		}
+		return s.writeInPlace(fill)
+	case WriteRename, WriteRenameSyncDir:
+		return s.writeRename(fill, s.strategy == WriteRenameSyncDir)
+	case WriteChangedRanges:
+		return s.writeChangedRanges(size, fill)
+	default:
+		return fmt.Errorf("unknown write strategy %v", s.strategy)
+	}
+}
+
+func (s *fileStorage) writeInPlace(fill func(w io.WriterAt) error) error {
+	// Note that we overwrite the existing file and do not do
+	// the usual write-rename. The rationale is that we want to
+	// minimize the amount of writes happening on a potential
+	// FAT partition where the env is loaded from. The file will
+	// always be of a fixed size so we know the writes will not
+	// fail because of ENOSPC.
+	//
+	// The size of the env file never changes so we do not
+	// truncate it.
+	//
+	// We also do not O_TRUNC to avoid reallocations on the FS
+	// to minimize risk of fs corruption.
+	f, err := os.OpenFile(s.fname, os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := fill(f); err != nil {
+		return err
+	}
+
+	return traceSync(s.tracer, f, s.sync)
+}
+
+// changedRangeBlockSize is the granularity of WriteChangedRanges, the
+// sector size of FAT file systems
+const changedRangeBlockSize = 512
+
+// changedRanges returns the [start, end) ranges of the blocks of the
+// given size that differ between old and new. Everything differs if
+// the sizes of old and new differ.
+func changedRanges(old, new []byte, blockSize int) [][2]int {
+	var ranges [][2]int
+	for start := 0; start < len(new); start += blockSize {
+		end := start + blockSize
+		if end > len(new) {
+			end = len(new)
+		}
+		if len(old) == len(new) && bytes.Equal(old[start:end], new[start:end]) {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1][1] == start {
+			ranges[n-1][1] = end
+			continue
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges
+}
+
+// writeChangedRanges writes only the parts of the file that change
+func (s *fileStorage) writeChangedRanges(size int, fill func(w io.WriterAt) error) error {
+	img := make([]byte, size)
+	if err := fill(sliceWriter(img)); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.fname, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var old []byte
+	if st.Size() == int64(size) {
+		old = make([]byte, size)
+		if _, err := io.ReadFull(f, old); err != nil {
+			return err
+		}
+	}
+
+	// the ranges are written backwards so that the header, which
+	// holds the CRC, is written last
+	ranges := changedRanges(old, img, changedRangeBlockSize)
+	for i := len(ranges) - 1; i >= 0; i-- {
+		r := ranges[i]
+		if _, err := f.WriteAt(img[r[0]:r[1]], int64(r[0])); err != nil {
+			return err
+		}
+	}
+
+	return traceSync(s.tracer, f, s.sync)
+}
+
+// writeDirect writes the environment in place bypassing the page
+// cache. O_DIRECT needs aligned buffers so the image is assembled in
+// memory first.
+func (s *fileStorage) writeDirect(size int, fill func(w io.WriterAt) error) error {
+	if size%directBlockSize != 0 {
+		return fmt.Errorf("cannot use O_DIRECT with env size %d: not a multiple of %d", size, directBlockSize)
+	}
+	if len(s.directBuf) != size {
+		s.directBuf = alignedBuffer(size)
+	}
+	buf := s.directBuf
+	if err := fill(sliceWriter(buf)); err != nil {
+		return err
+	}
+
+	f, err := openDirect(s.fname, os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt(buf, 0); err != nil {
+		return err
+	}
+
+	return traceSync(s.tracer, f, s.sync)
+}
+
+// writeRename writes the environment to a temporary file in the same
+// directory and renames it over the env file, so that a crash leaves
+// either the old or the new file behind but never a partial one.
+func (s *fileStorage) writeRename(fill func(w io.WriterAt) error, syncDir bool) error {
+	dir := filepath.Dir(s.fname)
+	mode := os.FileMode(0644)
+	if st, err := os.Stat(s.fname); err == nil {
+		mode = st.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(s.fname)+".")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	if err := fill(f); err != nil {
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+	if err := traceSync(s.tracer, f, s.sync); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.fname); err != nil {
+		return err
+	}
+	if !syncDir {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// regionStorage keeps the environment at an offset of a file or a
+// block device
+type regionStorage struct {
+	path     string
+	offset   int64
+	size     int
+	sync     SyncMode
+	tracer   Tracer
+	progress func(Progress)
+}
+
+func newRegionStorage(path string, offset int64, size int, opts Options) (Storage, error) {
+	if size > opts.maxSize() {
+		return nil, fmt.Errorf("cannot use %s: env size %d is larger than the maximum env size of %d bytes", path, size, opts.maxSize())
+	}
+	rs := &regionStorage{path: path, offset: offset, size: size, sync: opts.Sync, tracer: opts.Tracer, progress: opts.Progress}
+	if align := rawDeviceAlignment(path); align > 0 {
+		return &alignedRegionStorage{regionStorage: rs, align: int64(align)}, nil
+	}
+	return rs, nil
+}
+
+func (s *regionStorage) ReadImage() ([]byte, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img := make([]byte, s.size)
+	if _, err := readAt(f, img, s.offset, s.progress); err != nil {
+		return nil, fmt.Errorf("cannot read %d bytes at offset %d of %s: %v", s.size, s.offset, s.path, err)
+	}
+	return img, nil
+}
+
+func (s *regionStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if size != s.size {
+		return fmt.Errorf("cannot write env of size %d to region of size %d", size, s.size)
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := fill(io.NewOffsetWriter(f, s.offset)); err != nil {
+		return err
+	}
+	return traceSync(s.tracer, f, s.sync)
+}
+
+// SyncMode selects how written data is flushed to the storage.
+type SyncMode int
+
+const (
+	// SyncFsync flushes data and metadata with fsync.
+	SyncFsync SyncMode = iota
+	// SyncFdatasync flushes only the data with fdatasync where
+	// available, falling back to fsync elsewhere.
+	SyncFdatasync
+	// SyncNone does not flush at all and leaves it to the kernel. This
+	// is only useful when preparing many images that are synced in one
+	// go afterwards.
+	SyncNone
+)
+
+func syncFile(f *os.File, mode SyncMode) error {
+	switch mode {
+	case SyncFsync:
+		return f.Sync()
+	case SyncFdatasync:
+		return fdatasync(f)
+	case SyncNone:
+		return nil
+	default:
+		return fmt.Errorf("unknown sync mode %v", mode)
+	}
+}
+
+// sliceWriter implements io.WriterAt on top of a fixed size byte slice
+type sliceWriter []byte
+
+func (b sliceWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(b)) {
+		return 0, fmt.Errorf("cannot write %d bytes at offset %d: out of range", len(p), off)
+	}
+	return copy(b[off:], p), nil
+}
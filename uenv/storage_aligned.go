@@ -0,0 +1,73 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// alignedRegionStorage is a regionStorage on a raw device that only
+// allows reads and writes of whole sectors. The sectors covering the
+// environment are read, modified and written back as a whole.
+type alignedRegionStorage struct {
+	*regionStorage
+	align int64
+}
+
+// span returns the aligned start and the length of the sectors
+// covering the environment
+func (s *alignedRegionStorage) span() (start, length int64) {
+	start = s.offset / s.align * s.align
+	end := (s.offset + int64(s.size) + s.align - 1) / s.align * s.align
+	return start, end - start
+}
+
+func (s *alignedRegionStorage) readSectors(f *os.File) ([]byte, error) {
+	start, length := s.span()
+	buf := make([]byte, length)
+	if _, err := readAt(f, buf, start, s.progress); err != nil {
+		return nil, fmt.Errorf("cannot read %d bytes at offset %d of %s: %v", length, start, s.path, err)
+	}
+	return buf, nil
+}
+
+func (s *alignedRegionStorage) ReadImage() ([]byte, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, rawDeviceOpenError(s.path, err)
+	}
+	defer f.Close()
+
+	buf, err := s.readSectors(f)
+	if err != nil {
+		return nil, err
+	}
+	start, _ := s.span()
+	return buf[s.offset-start:][:s.size], nil
+}
+
+func (s *alignedRegionStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if size != s.size {
+		return fmt.Errorf("cannot write env of size %d to region of size %d", size, s.size)
+	}
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		return rawDeviceOpenError(s.path, err)
+	}
+	defer f.Close()
+
+	// the bytes around the environment in the first and last sector
+	// must be kept
+	buf, err := s.readSectors(f)
+	if err != nil {
+		return err
+	}
+	start, _ := s.span()
+	if err := fill(sliceWriter(buf[s.offset-start:][:s.size])); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(buf, start); err != nil {
+		return fmt.Errorf("cannot write %d bytes at offset %d of %s: %v", len(buf), start, s.path, err)
+	}
+	return traceSync(s.tracer, f, s.sync)
+}
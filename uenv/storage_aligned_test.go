@@ -0,0 +1,45 @@
+package uenv
+
+import (
+	"bytes"
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestAlignedRegionStorage(c *C) {
+	c.Assert(os.WriteFile(u.envFile, bytes.Repeat([]byte{0xaa}, 4096), 0644), IsNil)
+	for _, t := range []struct {
+		offset int64
+		size   int
+	}{
+		{0, 512},
+		{100, 300},
+		{1000, 1500},
+		{3584, 512},
+	} {
+		storage := &alignedRegionStorage{
+			regionStorage: &regionStorage{path: u.envFile, offset: t.offset, size: t.size},
+			align:         512,
+		}
+		start, length := storage.span()
+		c.Check(start%512, Equals, int64(0))
+		c.Check(length%512, Equals, int64(0))
+
+		env, err := CreateStorage(storage, t.size, Options{})
+		c.Assert(err, IsNil)
+		env.Set("foo", "bar")
+		c.Assert(env.Save(), IsNil)
+		env, err = OpenStorage(storage, Options{})
+		c.Assert(err, IsNil)
+		c.Check(env.Get("foo"), Equals, "bar")
+
+		// the bytes around the environment are kept
+		content, err := os.ReadFile(u.envFile)
+		c.Assert(err, IsNil)
+		c.Check(content[:t.offset], DeepEquals, bytes.Repeat([]byte{0xaa}, int(t.offset)))
+		end := t.offset + int64(t.size)
+		c.Check(content[end:], DeepEquals, bytes.Repeat([]byte{0xaa}, 4096-int(end)))
+		c.Assert(os.WriteFile(u.envFile, bytes.Repeat([]byte{0xaa}, 4096), 0644), IsNil)
+	}
+}
@@ -0,0 +1,117 @@
+//go:build unix
+
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// mmapStorage keeps the environment file mapped into memory. Reading
+// the image is free once the file is mapped, writes go through the
+// mapping and are synced back to the file.
+type mmapStorage struct {
+	fname    string
+	sync     SyncMode
+	tracer   Tracer
+	maxSize  int
+	f        *os.File
+	data     []byte
+	readOnly bool
+}
+
+func newMmapStorage(fname string, opts Options) Storage {
+	return &mmapStorage{fname: fname, sync: opts.Sync, tracer: opts.Tracer, maxSize: opts.maxSize()}
+}
+
+// mmap maps the file, growing it to size first if it is smaller.
+// A size of zero maps the file with its current size.
+func (s *mmapStorage) mmap(size int) error {
+	if s.data != nil {
+		if size != 0 && size != len(s.data) {
+			return fmt.Errorf("cannot use mapping of %d bytes for env of size %d", len(s.data), size)
+		}
+		return nil
+	}
+
+	f, err := os.OpenFile(s.fname, os.O_RDWR, 0)
+	if os.IsPermission(err) && size == 0 {
+		f, err = os.Open(s.fname)
+		s.readOnly = true
+	}
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if st.Size() < int64(size) {
+		if err := f.Truncate(int64(size)); err != nil {
+			f.Close()
+			return err
+		}
+	} else if size == 0 {
+		if st.Size() > int64(s.maxSize) {
+			f.Close()
+			return fmt.Errorf("cannot map %s: larger than the maximum env size of %d bytes", s.fname, s.maxSize)
+		}
+		size = int(st.Size())
+	}
+	if size == 0 {
+		f.Close()
+		return fmt.Errorf("cannot map empty file %s", s.fname)
+	}
+
+	prot := syscall.PROT_READ
+	if !s.readOnly {
+		prot |= syscall.PROT_WRITE
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, size, prot, syscall.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.data = data
+
+	return nil
+}
+
+func (s *mmapStorage) ReadImage() ([]byte, error) {
+	if err := s.mmap(0); err != nil {
+		return nil, err
+	}
+	return s.data, nil
+}
+
+func (s *mmapStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if err := s.mmap(size); err != nil {
+		return err
+	}
+	if s.readOnly {
+		return fmt.Errorf("cannot write read-only mapping of %s", s.fname)
+	}
+	if err := fill(sliceWriter(s.data)); err != nil {
+		return err
+	}
+	// the mapping is shared, so syncing the file writes back the
+	// dirty pages
+	return traceSync(s.tracer, s.f, s.sync)
+}
+
+func (s *mmapStorage) Close() error {
+	if s.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(s.data)
+	s.data = nil
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	return err
+}
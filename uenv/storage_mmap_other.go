@@ -0,0 +1,25 @@
+//go:build !unix
+
+package uenv
+
+import (
+	"fmt"
+	"io"
+)
+
+// mmapStorage is not available on this platform
+type mmapStorage struct {
+	fname string
+}
+
+func newMmapStorage(fname string, opts Options) Storage {
+	return &mmapStorage{fname: fname}
+}
+
+func (s *mmapStorage) ReadImage() ([]byte, error) {
+	return nil, fmt.Errorf("cannot map %s: mmap is not supported on this platform", s.fname)
+}
+
+func (s *mmapStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	return fmt.Errorf("cannot map %s: mmap is not supported on this platform", s.fname)
+}
@@ -0,0 +1,230 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestMmapOpenSaveReload(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	mapped, err := OpenWithOptions(u.envFile, Options{Mmap: true})
+	c.Assert(err, IsNil)
+	defer mapped.Close()
+	c.Assert(mapped.String(), Equals, "foo=bar\n")
+
+	// changes written in place by someone else are seen on reload
+	env.Set("foo", "baz")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(mapped.Reload(), IsNil)
+	c.Assert(mapped.Get("foo"), Equals, "baz")
+
+	// and saving through the mapping updates the file
+	mapped.Set("a", "b")
+	c.Assert(mapped.Save(), IsNil)
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "a=b\nfoo=baz\n")
+}
+
+func (u *uenvTestSuite) TestMmapCreate(c *C) {
+	env, err := CreateWithOptions(u.envFile, 32, Options{Mmap: true})
+	c.Assert(err, IsNil)
+	defer env.Close()
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(content, HasLen, 32)
+
+	env2, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env2.String(), Equals, "foo=bar\n")
+}
+
+func (u *uenvTestSuite) TestSliceWriterOutOfRange(c *C) {
+	w := make(sliceWriter, 4)
+	_, err := w.WriteAt([]byte{1, 2}, 3)
+	c.Assert(err, ErrorMatches, "cannot write 2 bytes at offset 3: out of range")
+}
+
+func (u *uenvTestSuite) TestSyncModes(c *C) {
+	for _, mode := range []SyncMode{SyncFsync, SyncFdatasync, SyncNone} {
+		env, err := CreateWithOptions(u.envFile, 32, Options{Sync: mode})
+		c.Assert(err, IsNil)
+		env.Set("foo", "bar")
+		c.Assert(env.Save(), IsNil)
+
+		env, err = Open(u.envFile)
+		c.Assert(err, IsNil)
+		c.Assert(env.String(), Equals, "foo=bar\n")
+	}
+
+	_, err := CreateWithOptions(u.envFile, 32, Options{Sync: SyncMode(42)})
+	c.Assert(err, ErrorMatches, "unknown sync mode 42")
+}
+
+func (u *uenvTestSuite) TestDirectNeedsAlignedSize(c *C) {
+	_, err := CreateWithOptions(u.envFile, 100, Options{Direct: true})
+	c.Assert(err, ErrorMatches, "cannot use O_DIRECT with env size 100: not a multiple of 512")
+}
+
+func (u *uenvTestSuite) TestDirectNeedsWriteInPlace(c *C) {
+	for _, t := range []struct {
+		opts Options
+		err  string
+	}{
+		{Options{Direct: true, WriteStrategy: WriteRename}, "cannot use Options.Direct with a write strategy other than WriteInPlace"},
+		{Options{Direct: true, WriteStrategy: WriteChangedRanges}, "cannot use Options.Direct with a write strategy other than WriteInPlace"},
+		{Options{Direct: true, Mmap: true}, "cannot use Options.Direct with Options.Mmap"},
+	} {
+		_, err := CreateWithOptions(u.envFile, 512, t.opts)
+		c.Check(err, ErrorMatches, t.err)
+		_, err = OpenWithOptions(u.envFile, t.opts)
+		c.Check(err, ErrorMatches, t.err)
+	}
+
+	_, err := CreateWithOptions(u.envFile+".gz", 512, Options{Direct: true})
+	c.Check(err, ErrorMatches, "cannot use O_DIRECT with compressed file .*/uboot.env.gz")
+}
+
+func (u *uenvTestSuite) TestAlignedBuffer(c *C) {
+	buf := alignedBuffer(1024)
+	c.Assert(buf, HasLen, 1024)
+}
+
+// countingStorage counts the reads and writes of the wrapped storage
+type countingStorage struct {
+	Storage
+	reads  int
+	writes int
+}
+
+func (s *countingStorage) ReadImage() ([]byte, error) {
+	s.reads++
+	return s.Storage.ReadImage()
+}
+
+func (s *countingStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	s.writes++
+	return s.Storage.WriteImage(size, fill)
+}
+
+func (u *uenvTestSuite) TestSaveSkipsUnchanged(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	for _, force := range []bool{false, true} {
+		counting := &countingStorage{Storage: newStorage(u.envFile, Options{})}
+		env, err = OpenStorage(counting, Options{ForceWrite: force})
+		c.Assert(err, IsNil)
+
+		env.Set("foo", "bar")
+		c.Assert(env.Save(), IsNil)
+		expected := 0
+		if force {
+			expected = 1
+		}
+		c.Assert(counting.writes, Equals, expected)
+
+		env.Set("foo", "baz")
+		c.Assert(env.Save(), IsNil)
+		c.Assert(counting.writes, Equals, expected+1)
+		env.Set("foo", "bar")
+		c.Assert(env.Save(), IsNil)
+	}
+}
+
+func (u *uenvTestSuite) TestSaveWritesOverCorruptedContent(c *C) {
+	env, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	// same payload, broken header
+	content, err := ioutil.ReadFile(u.envFile)
+	c.Assert(err, IsNil)
+	content[0] ^= 0xff
+	c.Assert(ioutil.WriteFile(u.envFile, content, 0644), IsNil)
+
+	counting := &countingStorage{Storage: newStorage(u.envFile, Options{})}
+	env.storage = counting
+	c.Assert(env.Save(), IsNil)
+	c.Assert(counting.writes, Equals, 1)
+	_, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+}
+
+func (u *uenvTestSuite) TestSaveReusesBuffers(c *C) {
+	size := 512 * 1024
+	env, err := Create(u.envFile, size)
+	c.Assert(err, IsNil)
+	for i := 0; i < 100; i++ {
+		env.Set(fmt.Sprintf("key%d", i), "value")
+	}
+	// warm up the buffers
+	c.Assert(env.Save(), IsNil)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	n := 10
+	for i := 0; i < n; i++ {
+		env.Set("counter", fmt.Sprint(i))
+		c.Assert(env.Save(), IsNil)
+	}
+	runtime.ReadMemStats(&after)
+
+	perSave := (after.TotalAlloc - before.TotalAlloc) / uint64(n)
+	c.Check(perSave < uint64(size/8), Equals, true, Commentf("%d bytes allocated per save", perSave))
+}
+
+func (u *uenvTestSuite) TestChangedRanges(c *C) {
+	old := make([]byte, 2000)
+	new := make([]byte, 2000)
+	c.Assert(changedRanges(old, new, 512), HasLen, 0)
+
+	new[0] = 1
+	new[600] = 1
+	new[1100] = 1
+	new[1999] = 1
+	c.Assert(changedRanges(old, new, 512), DeepEquals, [][2]int{{0, 2000}})
+	new[600] = 0
+	c.Assert(changedRanges(old, new, 512), DeepEquals, [][2]int{{0, 512}, {1024, 2000}})
+
+	// everything changes with a different size
+	c.Assert(changedRanges(old[:10], new, 512), DeepEquals, [][2]int{{0, 2000}})
+}
+
+func (u *uenvTestSuite) TestSaveChangedRanges(c *C) {
+	opts := Options{WriteStrategy: WriteChangedRanges}
+	env, err := CreateWithOptions(u.envFile, 4096, opts)
+	c.Assert(err, IsNil)
+	env.Set("a", "b")
+	c.Assert(env.Save(), IsNil)
+	env.Set("c", "d")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = OpenWithOptions(u.envFile, opts)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "a=b\nc=d\n")
+
+	// a file of the wrong size is written completely
+	c.Assert(os.Truncate(u.envFile, 100), IsNil)
+	env.Set("e", "f")
+	c.Assert(env.Save(), IsNil)
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "a=b\nc=d\ne=f\n")
+	c.Assert(env.Size(), Equals, 4096)
+}
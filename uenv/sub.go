@@ -0,0 +1,60 @@
+package uenv
+
+import (
+	"strings"
+)
+
+// SubEnv is a view of the variables of an environment whose names
+// start with a prefix. Names are given and returned without the
+// prefix, so a component handed a SubEnv cannot touch other
+// variables.
+type SubEnv struct {
+	env    *Env
+	prefix string
+}
+
+// Sub returns a view of the variables starting with prefix, e.g.
+// env.Sub("mender_").Get("boot_part") returns mender_boot_part.
+func (env *Env) Sub(prefix string) *SubEnv {
+	return &SubEnv{env: env, prefix: prefix}
+}
+
+// Prefix returns the prefix of the variables of the view.
+func (sub *SubEnv) Prefix() string {
+	return sub.prefix
+}
+
+// Sub returns a view of the variables of this view that start with
+// prefix.
+func (sub *SubEnv) Sub(prefix string) *SubEnv {
+	return &SubEnv{env: sub.env, prefix: sub.prefix + prefix}
+}
+
+// Get returns the value of the variable prefix+name.
+func (sub *SubEnv) Get(name string) string {
+	return sub.env.Get(sub.prefix + name)
+}
+
+// Set sets the variable prefix+name, an empty value removes it.
+func (sub *SubEnv) Set(name, value string) {
+	if name == "" {
+		panic("SubEnv.Set() can not be called with empty name")
+	}
+	sub.env.Set(sub.prefix+name, value)
+}
+
+// Range calls f with the names without prefix and the values of the
+// variables of the view in sorted order until f returns false.
+func (sub *SubEnv) Range(f func(name, value string) bool) {
+	sub.env.Range(func(key, _ string) bool {
+		if !strings.HasPrefix(key, sub.prefix) {
+			return true
+		}
+		return f(strings.TrimPrefix(key, sub.prefix), sub.env.Get(key))
+	})
+}
+
+// Save saves the environment the view belongs to.
+func (sub *SubEnv) Save() error {
+	return sub.env.Save()
+}
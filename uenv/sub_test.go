@@ -0,0 +1,37 @@
+package uenv
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestSub(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("bootcmd", "run mender_setup")
+	env.Set("mender_boot_part", "2")
+	env.Set("mender_uboot_root", "/dev/mmcblk0p2")
+
+	mender := env.Sub("mender_")
+	c.Check(mender.Prefix(), Equals, "mender_")
+	c.Check(mender.Get("boot_part"), Equals, "2")
+	c.Check(mender.Get("bootcmd"), Equals, "")
+	mender.Set("boot_part", "3")
+	mender.Set("bootcmd", "boot")
+	mender.Set("uboot_root", "")
+
+	var names []string
+	mender.Range(func(name, value string) bool {
+		names = append(names, name+"="+value)
+		return true
+	})
+	c.Check(names, DeepEquals, []string{"boot_part=3", "bootcmd=boot"})
+	c.Check(env.String(), Equals, "bootcmd=run mender_setup\nmender_boot_part=3\nmender_bootcmd=boot\n")
+
+	c.Check(mender.Sub("boot").Prefix(), Equals, "mender_boot")
+	c.Check(mender.Sub("boot").Get("_part"), Equals, "3")
+
+	c.Assert(mender.Save(), IsNil)
+	env, err = Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Check(env.Get("mender_boot_part"), Equals, "3")
+}
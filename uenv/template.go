@@ -0,0 +1,34 @@
+package uenv
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// ImportTemplate imports "key=value" lines like Import, but the values
+// are Go templates that are executed with data, e.g. to fill in the
+// serial number or MAC address of a device:
+//
+//	serial#={{.Serial}}
+//	ethaddr={{.MAC}}
+//	bootargs=console=ttyS0 region={{.Region | printf "%q"}}
+//
+// Referencing a key missing in a data map is an error.
+func (env *Env) ImportTemplate(r io.Reader, data interface{}) error {
+	return env.importLines(r, func(lineno int, value string) (string, error) {
+		if !strings.Contains(value, "{{") {
+			return value, nil
+		}
+		tmpl, err := template.New(fmt.Sprintf("line %d", lineno)).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return "", err
+		}
+		var out strings.Builder
+		if err := tmpl.Execute(&out, data); err != nil {
+			return "", err
+		}
+		return out.String(), nil
+	})
+}
@@ -0,0 +1,36 @@
+package uenv
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestImportTemplate(c *C) {
+	env, err := NewEnv(4096)
+	c.Assert(err, IsNil)
+	tmpl := `# factory defaults
+serial#={{.serial}}
+ethaddr={{.mac}}
+bootargs=console=ttyS0 region={{.region | printf "%q"}}
+bootcmd=run ${boot_targets}
+`
+	data := map[string]string{"serial": "SN0042", "mac": "00:11:22:33:44:55", "region": "eu"}
+	c.Assert(env.ImportTemplate(strings.NewReader(tmpl), data), IsNil)
+	c.Assert(env.String(), Equals, `bootargs=console=ttyS0 region="eu"
+bootcmd=run ${boot_targets}
+ethaddr=00:11:22:33:44:55
+serial#=SN0042
+`)
+}
+
+func (u *uenvTestSuite) TestImportTemplateErrors(c *C) {
+	env, err := NewEnv(4096)
+	c.Assert(err, IsNil)
+
+	err = env.ImportTemplate(strings.NewReader("a=1\nserial#={{.serial}}\n"), map[string]string{})
+	c.Assert(err, ErrorMatches, `template: line 2:1:2: executing "line 2" at <.serial>: map has no entry for key "serial"`)
+
+	err = env.ImportTemplate(strings.NewReader("serial#={{.serial\n"), nil)
+	c.Assert(err, ErrorMatches, `template: line 1:1: unclosed action`)
+}
@@ -0,0 +1,126 @@
+package uenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TextProfile selects how forgiving importing a text dump is. Field
+// data often went through vendor tools, terminals and mail clients
+// that mangle the "name=value" lines Import expects.
+type TextProfile struct {
+	Name string
+	// StripANSI removes ANSI escape sequences like colors and other
+	// control characters, including the \r of DOS line endings.
+	StripANSI bool
+	// JoinContinuations joins lines ending with a backslash and
+	// indented lines to the previous line.
+	JoinContinuations bool
+	// TrimSpace removes white space around names and values, e.g.
+	// "name = value".
+	TrimSpace bool
+	// IgnoreNoise skips lines that are not "name=value" instead of
+	// failing.
+	IgnoreNoise bool
+}
+
+var textProfiles = map[string]*TextProfile{
+	"strict": {Name: "strict"},
+	"lenient": {
+		Name:              "lenient",
+		StripANSI:         true,
+		JoinContinuations: true,
+		TrimSpace:         true,
+		IgnoreNoise:       true,
+	},
+}
+
+// TextProfiles returns the names of the text profiles in sorted order.
+// "strict" behaves like Import, "lenient" enables all fixes.
+func TextProfiles() []string {
+	names := make([]string, 0, len(textProfiles))
+	for name := range textProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupTextProfile returns the named text profile.
+func LookupTextProfile(name string) (*TextProfile, error) {
+	profile, ok := textProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown text profile %q", name)
+	}
+	return profile, nil
+}
+
+// ansiRe matches ANSI escape sequences and the remaining control
+// characters except tabs
+var ansiRe = regexp.MustCompile("\x1b\\[[0-9;?]*[ -/]*[@-~]|\x1b[@-_]|[\x00-\x08\x0b-\x1f\x7f]")
+
+// cleanText returns the "name=value" lines of the text dump after the
+// fixes of the profile
+func (p *TextProfile) cleanText(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, MaxSize)
+	continued := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if p.StripANSI {
+			line = ansiRe.ReplaceAllString(line, "")
+		}
+		if p.JoinContinuations && len(lines) > 0 {
+			switch {
+			case continued:
+				lines[len(lines)-1] += line
+			case line != "" && (line[0] == ' ' || line[0] == '\t') && strings.TrimSpace(line) != "":
+				lines[len(lines)-1] += " " + strings.TrimLeft(line, " \t")
+			default:
+				lines = append(lines, line)
+			}
+		} else {
+			lines = append(lines, line)
+		}
+		continued = p.JoinContinuations && strings.HasSuffix(line, "\\")
+		if continued {
+			last := lines[len(lines)-1]
+			lines[len(lines)-1] = last[:len(last)-1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	cleaned := lines[:0]
+	for _, line := range lines {
+		if p.TrimSpace {
+			line = strings.TrimSpace(line)
+			if l := strings.SplitN(line, "=", 2); len(l) == 2 && !strings.HasPrefix(line, "#") {
+				line = strings.TrimSpace(l[0]) + "=" + strings.TrimSpace(l[1])
+			}
+		}
+		if p.IgnoreNoise && !strings.HasPrefix(line, "#") {
+			if l := strings.SplitN(line, "=", 2); len(l) == 1 || l[0] == "" || strings.ContainsAny(l[0], " \t") {
+				continue
+			}
+		}
+		cleaned = append(cleaned, line)
+	}
+	return cleaned, nil
+}
+
+// ImportWithProfile imports a text dump like Import after applying the
+// fixes of the profile.
+func (env *Env) ImportWithProfile(r io.Reader, profile *TextProfile) error {
+	lines, err := profile.cleanText(r)
+	if err != nil {
+		return err
+	}
+	return env.Import(strings.NewReader(strings.Join(lines, "\n")))
+}
@@ -0,0 +1,54 @@
+package uenv
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+const mangledDump = "\x1b[1;32mVendor Flash Tool v2.1\x1b[0m\r\n" +
+	"-----------------------\r\n" +
+	"bootargs = console=ttyS0,115200 \\\r\n" +
+	"root=/dev/mmcblk0p2\r\n" +
+	"bootcmd=run distro_bootcmd;\r\n" +
+	"    run recovery\r\n" +
+	"\x1b[33mbootdelay\x1b[0m=3\r\n" +
+	"# a comment\r\n" +
+	"done.\r\n"
+
+func (u *uenvTestSuite) TestImportWithProfileLenient(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	profile, err := LookupTextProfile("lenient")
+	c.Assert(err, IsNil)
+	c.Assert(env.ImportWithProfile(strings.NewReader(mangledDump), profile), IsNil)
+	c.Check(env.String(), Equals, `bootargs=console=ttyS0,115200 root=/dev/mmcblk0p2
+bootcmd=run distro_bootcmd; run recovery
+bootdelay=3
+`)
+}
+
+func (u *uenvTestSuite) TestImportWithProfileStrict(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	profile, err := LookupTextProfile("strict")
+	c.Assert(err, IsNil)
+	err = env.ImportWithProfile(strings.NewReader(mangledDump), profile)
+	c.Check(err, ErrorMatches, `Invalid line: "\\x1b\[1;32mVendor Flash Tool v2.1\\x1b\[0m"`)
+
+	c.Assert(env.ImportWithProfile(strings.NewReader("foo= bar \n"), profile), IsNil)
+	c.Check(env.Get("foo"), Equals, " bar ")
+}
+
+func (u *uenvTestSuite) TestTextProfiles(c *C) {
+	c.Check(TextProfiles(), DeepEquals, []string{"lenient", "strict"})
+	_, err := LookupTextProfile("sloppy")
+	c.Check(err, ErrorMatches, `unknown text profile "sloppy"`)
+
+	// profiles can be combined as needed
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	profile := &TextProfile{Name: "ansi-only", StripANSI: true}
+	c.Assert(env.ImportWithProfile(strings.NewReader("\x1b[33mfoo\x1b[0m= bar\n"), profile), IsNil)
+	c.Check(env.Get("foo"), Equals, " bar")
+}
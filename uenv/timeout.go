@@ -0,0 +1,110 @@
+package uenv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrTimeout is wrapped by the errors returned when a storage operation
+// does not finish within Options.Timeout.
+var ErrTimeout = errors.New("storage operation timed out")
+
+// timeoutStorage fails operations of the wrapped storage that take
+// longer than the timeout. A blocked read or write cannot be aborted,
+// it keeps running in the background and no further operation is
+// started until it finished. A write that timed out may therefore
+// still complete later.
+type timeoutStorage struct {
+	Storage
+	timeout time.Duration
+	// pending is closed when the last operation finished
+	pending chan struct{}
+}
+
+func (s *timeoutStorage) do(op string, f func() error) error {
+	if s.pending != nil {
+		select {
+		case <-s.pending:
+		default:
+			return fmt.Errorf("cannot %s: previous operation still in progress after timeout", op)
+		}
+	}
+
+	done := make(chan struct{})
+	s.pending = done
+	var err error
+	go func() {
+		defer close(done)
+		err = f()
+	}()
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%s timed out after %v: %w", op, s.timeout, ErrTimeout)
+	}
+}
+
+func (s *timeoutStorage) ReadImage() ([]byte, error) {
+	var img []byte
+	err := s.do("read", func() error {
+		var err error
+		img, err = s.Storage.ReadImage()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func (s *timeoutStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	// fill uses the state of the env, which the caller may change
+	// while a timed out write is still running
+	var writes recordedWrites
+	if err := fill(&writes); err != nil {
+		return err
+	}
+	return s.do("write", func() error {
+		return s.Storage.WriteImage(size, writes.replay)
+	})
+}
+
+// recordedWrites keeps copies of the writes made through it, so that
+// they can be replayed later
+type recordedWrites []recordedWrite
+
+type recordedWrite struct {
+	off int64
+	p   []byte
+}
+
+func (r *recordedWrites) WriteAt(p []byte, off int64) (int, error) {
+	*r = append(*r, recordedWrite{off: off, p: append([]byte(nil), p...)})
+	return len(p), nil
+}
+
+func (r recordedWrites) replay(w io.WriterAt) error {
+	for _, wr := range r {
+		if _, err := w.WriteAt(wr.p, wr.off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *timeoutStorage) Unwrap() Storage {
+	return s.Storage
+}
+
+func (s *timeoutStorage) Close() error {
+	if c, ok := s.Storage.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package uenv
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// hangingStorage blocks writes until release is closed
+type hangingStorage struct {
+	Storage
+	release chan struct{}
+}
+
+func (s *hangingStorage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	<-s.release
+	return s.Storage.WriteImage(size, fill)
+}
+
+func (u *uenvTestSuite) TestTimeout(c *C) {
+	_, err := Create(u.envFile, 64)
+	c.Assert(err, IsNil)
+
+	hanging := &hangingStorage{Storage: newStorage(u.envFile, Options{}), release: make(chan struct{})}
+	env, err := OpenStorage(hanging, Options{Timeout: 10 * time.Millisecond})
+	c.Assert(err, IsNil)
+
+	env.Set("foo", "bar")
+	err = env.Save()
+	c.Assert(err, ErrorMatches, "write timed out after 10ms: storage operation timed out")
+	c.Assert(errors.Is(err, ErrTimeout), Equals, true)
+
+	// the hung write blocks further operations
+	err = env.Reload()
+	c.Assert(err, ErrorMatches, "cannot read: previous operation still in progress after timeout")
+	err = env.Save()
+	c.Assert(err, ErrorMatches, "cannot write: previous operation still in progress after timeout")
+	// and writes what was saved, not later changes
+	env.Set("foo", "baz")
+
+	close(hanging.release)
+	for i := 0; i < 100; i++ {
+		if err = env.Reload(); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	// the write finished in the background
+	c.Assert(env.Get("foo"), Equals, "bar")
+}
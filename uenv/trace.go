@@ -0,0 +1,63 @@
+package uenv
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Phase is a step of reading or saving an environment
+type Phase int
+
+const (
+	// PhaseRead reads the image from the storage.
+	PhaseRead Phase = iota
+	// PhaseSerialize produces the image from the variables.
+	PhaseSerialize
+	// PhaseErase erases flash sectors, it is reported by storage
+	// that erases.
+	PhaseErase
+	// PhaseWrite writes the image to the storage. It covers the
+	// whole storage write except serializing, including erasing and
+	// syncing.
+	PhaseWrite
+	// PhaseSync flushes the written data to the storage.
+	PhaseSync
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseRead:
+		return "read"
+	case PhaseSerialize:
+		return "serialize"
+	case PhaseErase:
+		return "erase"
+	case PhaseWrite:
+		return "write"
+	case PhaseSync:
+		return "sync"
+	}
+	return fmt.Sprintf("Phase(%d)", int(p))
+}
+
+// Tracer receives the duration and byte count of every phase, so that
+// slow saves can be attributed to the storage or to this package.
+type Tracer interface {
+	TracePhase(phase Phase, d time.Duration, bytes int, err error)
+}
+
+func trace(t Tracer, phase Phase, start time.Time, bytes int, err error) {
+	if t != nil {
+		t.TracePhase(phase, time.Since(start), bytes, err)
+	}
+}
+
+func traceSync(t Tracer, f *os.File, mode SyncMode) error {
+	start := time.Now()
+	err := syncFile(f, mode)
+	if mode != SyncNone {
+		trace(t, PhaseSync, start, 0, err)
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package uenv
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type tracedPhase struct {
+	phase Phase
+	bytes int
+	err   error
+}
+
+type recordingTracer struct {
+	phases []tracedPhase
+}
+
+func (t *recordingTracer) TracePhase(phase Phase, d time.Duration, bytes int, err error) {
+	t.phases = append(t.phases, tracedPhase{phase, bytes, err})
+}
+
+func (u *uenvTestSuite) TestTracer(c *C) {
+	tracer := &recordingTracer{}
+	env, err := CreateWithOptions(u.envFile, 64, Options{Tracer: tracer, ForceWrite: true})
+	c.Assert(err, IsNil)
+	c.Assert(env.Reload(), IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	c.Assert(tracer.phases, DeepEquals, []tracedPhase{
+		// create
+		{PhaseSync, 0, nil},
+		{PhaseSerialize, 64, nil},
+		{PhaseWrite, 64, nil},
+		// reload
+		{PhaseRead, 64, nil},
+		// save
+		{PhaseSync, 0, nil},
+		{PhaseSerialize, 64, nil},
+		{PhaseWrite, 64, nil},
+	})
+}
+
+func (u *uenvTestSuite) TestPhaseString(c *C) {
+	c.Assert(PhaseErase.String(), Equals, "erase")
+	c.Assert(Phase(42).String(), Equals, "Phase(42)")
+}
@@ -0,0 +1,165 @@
+package uenvtest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Layout describes how a generated image is laid out
+type Layout struct {
+	Size int
+	// Redundant images are copies of a redundant environment, their
+	// header has the flags byte
+	Redundant bool
+	// Flags is the flags byte of redundant images, redundant
+	// environments count it up
+	Flags byte
+	// BigEndian stores the CRC in big endian byte order like
+	// PowerPC and some MIPS boards do
+	BigEndian bool
+}
+
+// CorpusEntry is a generated image
+type CorpusEntry struct {
+	Name   string
+	Layout Layout
+	Image  []byte
+	// Valid is set for well-formed images, the others are broken in
+	// the way the name says
+	Valid bool
+}
+
+var corpusSizes = []int{16, 64, 512, 4096, 0x2000}
+
+// near-valid variants of a valid image
+var corpusBreakages = []struct {
+	name  string
+	apply func(r *rand.Rand, img []byte, l Layout) []byte
+}{
+	{"badcrc", func(r *rand.Rand, img []byte, l Layout) []byte {
+		return BadCRC(img)
+	}},
+	{"truncated", func(r *rand.Rand, img []byte, l Layout) []byte {
+		return Truncated(img, r.Intn(len(img)))
+	}},
+	{"bitflip", func(r *rand.Rand, img []byte, l Layout) []byte {
+		img = append([]byte(nil), img...)
+		img[l.header()+r.Intn(len(img)-l.header())] ^= 1 << uint(r.Intn(8))
+		return img
+	}},
+	{"noterminator", func(r *rand.Rand, img []byte, l Layout) []byte {
+		payload := img[l.header():]
+		for i := range payload {
+			if payload[i] == 0 {
+				payload[i] = 'x'
+			}
+		}
+		return layoutImage(l, payload)
+	}},
+	{"emptykey", func(r *rand.Rand, img []byte, l Layout) []byte {
+		return layoutImage(l, payload(l.Size, l.header(), []string{"=value"}, true))
+	}},
+	{"uninitialized", func(r *rand.Rand, img []byte, l Layout) []byte {
+		return Uninitialized(len(img), []byte{0, 0xff}[r.Intn(2)])
+	}},
+}
+
+func (l Layout) header() int {
+	if l.Redundant {
+		return redundantHeaderSize
+	}
+	return headerSize
+}
+
+func layoutImage(l Layout, payload []byte) []byte {
+	img := make([]byte, l.header(), l.header()+len(payload))
+	if l.BigEndian {
+		binary.BigEndian.PutUint32(img, crc32.ChecksumIEEE(payload))
+	} else {
+		binary.LittleEndian.PutUint32(img, crc32.ChecksumIEEE(payload))
+	}
+	if l.Redundant {
+		img[4] = l.Flags
+	}
+	return append(img, payload...)
+}
+
+// randomPairs returns as many random key=value pairs as fit into an
+// environment of the given size
+func randomPairs(r *rand.Rand, size, header int) []string {
+	const chars = "abcdefghijklmnopqrstuvwxyz_0123456789"
+	var pairs []string
+	free := size - header - 2
+	for {
+		key := make([]byte, 1+r.Intn(12))
+		for i := range key {
+			key[i] = chars[r.Intn(len(chars))]
+		}
+		value := make([]byte, r.Intn(24))
+		for i := range value {
+			// values may contain anything but the separator
+			value[i] = byte(1 + r.Intn(255))
+		}
+		pair := string(key) + "=" + string(value)
+		if len(pair)+1 > free {
+			return pairs
+		}
+		free -= len(pair) + 1
+		pairs = append(pairs, pair)
+	}
+}
+
+// Corpus returns n valid and near-valid images across sizes, single and
+// redundant layouts, flags and byte orders. The same seed always gives the same corpus.
+func Corpus(seed int64, n int) []CorpusEntry {
+	r := rand.New(rand.NewSource(seed))
+	entries := make([]CorpusEntry, 0, n)
+	for i := 0; i < n; i++ {
+		l := Layout{
+			Size:      corpusSizes[r.Intn(len(corpusSizes))],
+			Redundant: r.Intn(2) == 0,
+			BigEndian: r.Intn(4) == 0,
+		}
+		header := "single"
+		if l.Redundant {
+			l.Flags = []byte{0, 1, 2, 0xff}[r.Intn(4)]
+			header = fmt.Sprintf("redundant-%02x", l.Flags)
+		}
+		endian := "le"
+		if l.BigEndian {
+			endian = "be"
+		}
+		name := fmt.Sprintf("%04d-%d-%s-%s", i, l.Size, header, endian)
+		img := layoutImage(l, payload(l.Size, l.header(), randomPairs(r, l.Size, l.header()), true))
+
+		// every other entry is broken
+		if i%2 == 0 {
+			entries = append(entries, CorpusEntry{Name: name + "-valid", Layout: l, Image: img, Valid: true})
+			continue
+		}
+		b := corpusBreakages[r.Intn(len(corpusBreakages))]
+		entries = append(entries, CorpusEntry{Name: name + "-" + b.name, Layout: l, Image: b.apply(r, img, l)})
+	}
+	return entries
+}
+
+// WriteCorpus writes the entries in the corpus format of "go test
+// -fuzz" to dir, e.g. "testdata/fuzz/FuzzParse", for fuzz targets that
+// take a single []byte.
+func WriteCorpus(dir string, entries []CorpusEntry) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		content := "go test fuzz v1\n[]byte(" + strconv.Quote(string(e.Image)) + ")\n"
+		if err := os.WriteFile(filepath.Join(dir, e.Name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
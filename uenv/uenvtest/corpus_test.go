@@ -0,0 +1,48 @@
+package uenvtest_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+	"github.com/mvo5/uboot-go/uenv/uenvtest"
+)
+
+func (s *uenvtestTestSuite) TestCorpus(c *C) {
+	corpus := uenvtest.Corpus(1, 200)
+	c.Assert(corpus, HasLen, 200)
+	c.Assert(uenvtest.Corpus(1, 200), DeepEquals, corpus)
+
+	layouts := make(map[uenvtest.Layout]bool)
+	for _, e := range corpus {
+		layouts[e.Layout] = true
+		if !e.Valid {
+			continue
+		}
+		c.Check(e.Image, HasLen, e.Layout.Size, Commentf(e.Name))
+		if e.Layout.BigEndian {
+			continue
+		}
+		var err error
+		if e.Layout.Redundant {
+			_, err = uenv.OpenRedundantStorage(uenv.NewMemStorage(e.Image), uenv.NewMemStorage(nil), uenv.Options{})
+		} else {
+			_, err = uenv.OpenStorage(uenv.NewMemStorage(e.Image), uenv.Options{})
+		}
+		c.Check(err, IsNil, Commentf(e.Name))
+	}
+	c.Assert(len(layouts) > 10, Equals, true)
+}
+
+func (s *uenvtestTestSuite) TestWriteCorpus(c *C) {
+	dir := filepath.Join(s.dir, "testdata", "fuzz", "FuzzParse")
+	corpus := uenvtest.Corpus(1, 4)
+	c.Assert(uenvtest.WriteCorpus(dir, corpus), IsNil)
+
+	content, err := os.ReadFile(filepath.Join(dir, corpus[0].Name))
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(content), "go test fuzz v1\n[]byte(\""), Equals, true)
+}
@@ -0,0 +1,166 @@
+package uenvtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPowerLoss is returned by operations on a Flash that lost
+	// power
+	ErrPowerLoss = errors.New("uenvtest: power loss")
+	// ErrBadBlock is returned when a bad block of a Flash is erased
+	ErrBadBlock = errors.New("uenvtest: bad block")
+)
+
+// Flash is a fake flash device holding one environment. It implements
+// uenv.Storage and uenv.EraseCounter. Writes erase every block they
+// touch before programming it, like fw_setenv does on NOR and NAND, and
+// can be made to fail in the ways real flash fails.
+type Flash struct {
+	// Delay is added to every read and write to simulate slow IO
+	Delay time.Duration
+
+	mu        sync.Mutex
+	envSize   int
+	blockSize int
+	data      []byte
+	bad       map[int]bool
+	erased    uint64
+	// budget is the number of bytes that can still be programmed
+	// before the power is lost, negative for unlimited
+	budget int
+	off    bool
+}
+
+// NewFlash returns an erased flash for an environment of envSize bytes
+// with the given erase block size.
+func NewFlash(envSize, blockSize int) *Flash {
+	blocks := (envSize + blockSize - 1) / blockSize
+	f := &Flash{
+		envSize:   envSize,
+		blockSize: blockSize,
+		data:      make([]byte, blocks*blockSize),
+		bad:       make(map[int]bool),
+		budget:    -1,
+	}
+	for i := range f.data {
+		f.data[i] = 0xff
+	}
+	return f
+}
+
+// Load puts the given image onto the flash without any failure
+// injection, e.g. one made with Image.
+func (f *Flash) Load(img []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	copy(f.data, img)
+}
+
+// Bytes returns a copy of the flash contents
+func (f *Flash) Bytes() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]byte(nil), f.data...)
+}
+
+// SetBadBlock marks the given erase block as bad, erasing it fails
+func (f *Flash) SetBadBlock(block int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bad[block] = true
+}
+
+// PowerLossAfter makes the flash lose power after n more bytes were
+// programmed. All operations fail with ErrPowerLoss until PowerOn is
+// called.
+func (f *Flash) PowerLossAfter(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.budget = n
+}
+
+// PowerOn restores the power after a simulated power loss
+func (f *Flash) PowerOn() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.off = false
+	f.budget = -1
+}
+
+// SectorsErased implements uenv.EraseCounter
+func (f *Flash) SectorsErased() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.erased
+}
+
+// ReadImage implements uenv.Storage
+func (f *Flash) ReadImage() ([]byte, error) {
+	time.Sleep(f.Delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.off {
+		return nil, ErrPowerLoss
+	}
+	return append([]byte(nil), f.data[:f.envSize]...), nil
+}
+
+// bufferWriter collects the image written by WriteImage
+type bufferWriter []byte
+
+func (b bufferWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(b)) {
+		return 0, fmt.Errorf("cannot write %d bytes at offset %d: out of range", len(p), off)
+	}
+	return copy(b[off:], p), nil
+}
+
+// WriteImage implements uenv.Storage
+func (f *Flash) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if size != f.envSize {
+		return fmt.Errorf("cannot write env of size %d to flash for env size %d", size, f.envSize)
+	}
+	img := make(bufferWriter, size)
+	if err := fill(img); err != nil {
+		return err
+	}
+
+	time.Sleep(f.Delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.off {
+		return ErrPowerLoss
+	}
+	for start := 0; start < size; start += f.blockSize {
+		block := start / f.blockSize
+		if f.bad[block] {
+			return fmt.Errorf("cannot erase block %d: %w", block, ErrBadBlock)
+		}
+		end := start + f.blockSize
+		for i := start; i < end; i++ {
+			f.data[i] = 0xff
+		}
+		f.erased++
+
+		if end > size {
+			end = size
+		}
+		for i := start; i < end; i++ {
+			if f.budget == 0 {
+				f.off = true
+				return ErrPowerLoss
+			}
+			if f.budget > 0 {
+				f.budget--
+			}
+			// programming can only clear bits
+			f.data[i] &= img[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package uenvtest_test
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+	"github.com/mvo5/uboot-go/uenv/uenvtest"
+)
+
+func (s *uenvtestTestSuite) TestFlashEraseAndProgram(c *C) {
+	flash := uenvtest.NewFlash(1024, 512)
+	flash.Load(uenvtest.Image(1024, "a=b"))
+	env, err := uenv.OpenStorage(flash, uenv.Options{})
+	c.Assert(err, IsNil)
+	env.Set("a", "c")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(flash.SectorsErased(), Equals, uint64(2))
+	c.Assert(env.Stats().SectorsErased, Equals, uint64(2))
+	c.Assert(flash.Bytes(), DeepEquals, uenvtest.Image(1024, "a=c"))
+}
+
+func (s *uenvtestTestSuite) TestFlashBadBlock(c *C) {
+	flash := uenvtest.NewFlash(1024, 512)
+	flash.Load(uenvtest.Image(1024, "a=b"))
+	flash.SetBadBlock(1)
+	env, err := uenv.OpenStorage(flash, uenv.Options{})
+	c.Assert(err, IsNil)
+	env.Set("a", "c")
+	err = env.Save()
+	c.Assert(errors.Is(err, uenvtest.ErrBadBlock), Equals, true)
+	c.Assert(err, ErrorMatches, "cannot erase block 1: uenvtest: bad block")
+}
+
+func (s *uenvtestTestSuite) TestFlashPowerLossRedundant(c *C) {
+	flash1, flash2 := uenvtest.NewFlash(1024, 512), uenvtest.NewFlash(1024, 512)
+	flash1.Load(uenvtest.ImageWithFlags(1024, 1, "bootcount=0"))
+	flash2.Load(uenvtest.ImageWithFlags(1024, 0, "bootcount=0"))
+	env, err := uenv.OpenRedundantStorage(flash1, flash2, uenv.Options{})
+	c.Assert(err, IsNil)
+
+	// the power is lost while writing the CRC of the inactive copy,
+	// later power losses would leave a valid copy as the erased
+	// flash already holds the 0xff padding
+	flash2.PowerLossAfter(2)
+	env.Set("bootcount", "1")
+	c.Assert(env.Save(), Equals, uenvtest.ErrPowerLoss)
+	_, err = flash2.ReadImage()
+	c.Assert(err, Equals, uenvtest.ErrPowerLoss)
+	flash2.PowerOn()
+
+	// the old environment survived
+	env, err = uenv.OpenRedundantStorage(flash1, flash2, uenv.Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.Get("bootcount"), Equals, "0")
+	status, _ := env.RedundancyStatus()
+	c.Assert(status.Active, Equals, 0)
+	c.Assert(status.Copies[1].Valid, Equals, false)
+}
+
+func (s *uenvtestTestSuite) TestFlashDelay(c *C) {
+	flash := uenvtest.NewFlash(64, 64)
+	flash.Load(uenvtest.Image(64))
+	flash.Delay = 20 * time.Millisecond
+	_, err := uenv.OpenStorage(flash, uenv.Options{Timeout: time.Millisecond})
+	c.Assert(errors.Is(err, uenv.ErrTimeout), Equals, true)
+}
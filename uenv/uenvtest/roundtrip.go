@@ -0,0 +1,96 @@
+package uenvtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// RoundTrip checks that opening img and saving it again produces the
+// same image, so that images written by a board can be used as golden
+// files. Copies of redundant environments are saved like the other
+// copy would be. The following differences are normalized away:
+//
+//   - the flags byte of redundant copies, saves count it up
+//   - the fill byte of the padding after the variables, U-Boot pads
+//     with zeros while this package pads with 0xff
+//
+// Variables have to be in sorted order, like U-Boot writes them.
+func RoundTrip(img []byte) error {
+	storage := uenv.NewMemStorage(append([]byte(nil), img...))
+	saved := storage
+	var env *uenv.Env
+	var err error
+	if isRedundant(img) {
+		// the other copy is missing, the save writes it
+		saved = uenv.NewMemStorage(nil)
+		env, err = uenv.OpenRedundantStorage(storage, saved, uenv.Options{ForceWrite: true})
+	} else {
+		env, err = uenv.OpenStorage(storage, uenv.Options{ForceWrite: true})
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open image: %v", err)
+	}
+	if err := env.Save(); err != nil {
+		return fmt.Errorf("cannot save image: %v", err)
+	}
+
+	want, got := normalize(img), normalize(saved.Bytes())
+	if len(got) != len(want) {
+		return fmt.Errorf("saved image has %d bytes, the original %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			return fmt.Errorf("saved image differs at offset %d: 0x%02x != 0x%02x", i, got[i], want[i])
+		}
+	}
+	return nil
+}
+
+// RoundTripFile is RoundTrip for an image stored in a file
+func RoundTripFile(fname string) error {
+	img, err := os.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	if err := RoundTrip(img); err != nil {
+		return fmt.Errorf("%s: %v", fname, err)
+	}
+	return nil
+}
+
+// isRedundant returns true if img is a copy of a redundant environment,
+// whose CRC only matches if the flags byte is skipped
+func isRedundant(img []byte) bool {
+	if len(img) <= redundantHeaderSize {
+		return false
+	}
+	crc := binary.LittleEndian.Uint32(img)
+	return crc != crc32.ChecksumIEEE(img[headerSize:]) && crc == crc32.ChecksumIEEE(img[redundantHeaderSize:])
+}
+
+// normalize returns a copy of img with zero flags, 0xff padding and a
+// CRC matching that
+func normalize(img []byte) []byte {
+	norm := append([]byte(nil), img...)
+	header := headerSize
+	if isRedundant(img) {
+		header = redundantHeaderSize
+		norm[4] = 0
+	}
+	if len(norm) < header {
+		return norm
+	}
+	payload := norm[header:]
+	if eof := bytes.Index(payload, []byte{0, 0}); eof >= 0 {
+		for i := eof + 2; i < len(payload); i++ {
+			payload[i] = 0xff
+		}
+	}
+	binary.LittleEndian.PutUint32(norm, crc32.ChecksumIEEE(payload))
+	return norm
+}
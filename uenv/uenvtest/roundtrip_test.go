@@ -0,0 +1,38 @@
+package uenvtest_test
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv/uenvtest"
+)
+
+func (s *uenvtestTestSuite) TestRoundTrip(c *C) {
+	c.Assert(uenvtest.RoundTrip(uenvtest.Image(64, "a=1", "b=2")), IsNil)
+	// flags are normalized
+	c.Assert(uenvtest.RoundTrip(uenvtest.ImageWithFlags(64, 7, "a=1")), IsNil)
+
+	// zero padding like U-Boot writes it
+	img := uenvtest.Image(64, "a=1")
+	for i := 4 + len("a=1\x00\x00"); i < len(img); i++ {
+		img[i] = 0
+	}
+	binary.LittleEndian.PutUint32(img, crc32.ChecksumIEEE(img[4:]))
+	fname := filepath.Join(s.dir, "dump.env")
+	c.Assert(os.WriteFile(fname, img, 0644), IsNil)
+	c.Assert(uenvtest.RoundTripFile(fname), IsNil)
+}
+
+func (s *uenvtestTestSuite) TestRoundTripDifferences(c *C) {
+	// unsorted variables are written sorted
+	err := uenvtest.RoundTrip(uenvtest.Image(64, "b=2", "a=1"))
+	c.Assert(err, ErrorMatches, "saved image differs at offset 0: .*")
+	err = uenvtest.RoundTrip(uenvtest.DuplicateKeys(64, "a", "1", "2"))
+	c.Assert(err, ErrorMatches, "saved image differs at offset .*")
+	err = uenvtest.RoundTrip(uenvtest.BadCRC(uenvtest.Image(64)))
+	c.Assert(err, ErrorMatches, "cannot open image: bad CRC: .*")
+}
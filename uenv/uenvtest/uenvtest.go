@@ -0,0 +1,117 @@
+// Package uenvtest builds valid and deliberately broken environment
+// images, so that code using the uenv package can test its error paths
+// deterministically.
+package uenvtest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// headerSize is the size of the CRC in front of the variables
+const headerSize = 4
+
+// redundantHeaderSize is the size of the CRC and the flags byte of
+// copies of redundant environments
+const redundantHeaderSize = 5
+
+func payload(size, header int, pairs []string, terminate bool) []byte {
+	p := make([]byte, 0, size-header)
+	for _, pair := range pairs {
+		p = append(p, pair...)
+		p = append(p, 0)
+	}
+	if terminate {
+		p = append(p, 0)
+		if len(pairs) == 0 {
+			p = append(p, 0)
+		}
+	}
+	if len(p) > size-header {
+		panic(fmt.Sprintf("uenvtest: %d bytes of variables do not fit in env of size %d", len(p), size))
+	}
+	for len(p) < size-header {
+		p = append(p, 0xff)
+	}
+	return p
+}
+
+func image(payload []byte) []byte {
+	img := make([]byte, headerSize, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(img, crc32.ChecksumIEEE(payload))
+	return append(img, payload...)
+}
+
+func redundantImage(flags byte, payload []byte) []byte {
+	img := make([]byte, redundantHeaderSize, redundantHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(img, crc32.ChecksumIEEE(payload))
+	img[4] = flags
+	return append(img, payload...)
+}
+
+// Image returns a valid image of the given size with the "key=value"
+// pairs in the given order, like mkenvimage writes it for environments
+// with a single copy. The pairs are not checked, so duplicate keys or
+// pairs without "=" end up in the image as given.
+func Image(size int, pairs ...string) []byte {
+	return image(payload(size, headerSize, pairs, true))
+}
+
+// ImageWithFlags returns a valid copy of a redundant environment, its
+// header has the flags byte after the CRC.
+func ImageWithFlags(size int, flags byte, pairs ...string) []byte {
+	return redundantImage(flags, payload(size, redundantHeaderSize, pairs, true))
+}
+
+// DuplicateKeys returns a valid image that contains key once for every
+// value.
+func DuplicateKeys(size int, key string, values ...string) []byte {
+	pairs := make([]string, len(values))
+	for i, v := range values {
+		pairs[i] = key + "=" + v
+	}
+	return Image(size, pairs...)
+}
+
+// BadCRC returns a copy of img with a wrong CRC
+func BadCRC(img []byte) []byte {
+	bad := append([]byte(nil), img...)
+	bad[0] ^= 0xff
+	return bad
+}
+
+// Truncated returns the first n bytes of img
+func Truncated(img []byte, n int) []byte {
+	return append([]byte(nil), img[:n]...)
+}
+
+// MissingTerminator returns an image with a valid CRC whose variables
+// are not ended by the double zero byte terminator.
+func MissingTerminator(size int, pairs ...string) []byte {
+	return image(payload(size, headerSize, pairs, false))
+}
+
+// Uninitialized returns an image that consists of fill bytes only, like
+// erased flash (0xff) or zeroed disks (0x00).
+func Uninitialized(size int, fill byte) []byte {
+	img := make([]byte, size)
+	for i := range img {
+		img[i] = fill
+	}
+	return img
+}
+
+// TornRedundant returns the two copies of a redundant environment whose
+// last save was interrupted: the first copy was being written with a
+// newer flags value and only its first half reached the storage, which
+// still holds zeros in the second half. The second copy holds the
+// previous, valid environment with the given pairs.
+func TornRedundant(size int, pairs ...string) (copy1, copy2 []byte) {
+	copy2 = ImageWithFlags(size, 1, pairs...)
+	copy1 = ImageWithFlags(size, 2, append(pairs, "torn=write")...)
+	for i := size / 2; i < size; i++ {
+		copy1[i] = 0
+	}
+	return copy1, copy2
+}
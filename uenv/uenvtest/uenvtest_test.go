@@ -0,0 +1,78 @@
+package uenvtest_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+	"github.com/mvo5/uboot-go/uenv/uenvtest"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type uenvtestTestSuite struct {
+	dir string
+}
+
+var _ = Suite(&uenvtestTestSuite{})
+
+func (s *uenvtestTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *uenvtestTestSuite) open(c *C, img []byte, opts uenv.Options) (*uenv.Env, error) {
+	fname := filepath.Join(s.dir, "uboot.env")
+	c.Assert(os.WriteFile(fname, img, 0644), IsNil)
+	return uenv.OpenWithOptions(fname, opts)
+}
+
+func (s *uenvtestTestSuite) TestImage(c *C) {
+	img := uenvtest.Image(64, "foo=bar", "baz=1")
+	c.Assert(img, HasLen, 64)
+	env, err := s.open(c, img, uenv.Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "baz=1\nfoo=bar\n")
+}
+
+func (s *uenvtestTestSuite) TestDuplicateKeys(c *C) {
+	env, err := s.open(c, uenvtest.DuplicateKeys(64, "foo", "1", "2"), uenv.Options{})
+	c.Assert(err, IsNil)
+	// like U-Boot the last value wins
+	c.Assert(env.Get("foo"), Equals, "2")
+}
+
+func (s *uenvtestTestSuite) TestBroken(c *C) {
+	_, err := s.open(c, uenvtest.BadCRC(uenvtest.Image(64, "a=b")), uenv.Options{})
+	var crcErr *uenv.CRCError
+	c.Assert(errors.As(err, &crcErr), Equals, true)
+
+	_, err = s.open(c, uenvtest.Truncated(uenvtest.Image(64, "a=b"), 3), uenv.Options{})
+	c.Assert(err, ErrorMatches, "env too small: .*")
+
+	_, err = s.open(c, uenvtest.MissingTerminator(64, "a=b"), uenv.Options{})
+	c.Assert(err, ErrorMatches, "cannot find end of environment marker at offset 60: .*")
+
+	_, err = s.open(c, uenvtest.Uninitialized(64, 0xff), uenv.Options{})
+	var uninitErr *uenv.UninitializedError
+	c.Assert(errors.As(err, &uninitErr), Equals, true)
+}
+
+func (s *uenvtestTestSuite) TestTornRedundant(c *C) {
+	copy1, copy2 := uenvtest.TornRedundant(64, "a=b")
+	fname1, fname2 := filepath.Join(s.dir, "env1"), filepath.Join(s.dir, "env2")
+	c.Assert(os.WriteFile(fname1, copy1, 0644), IsNil)
+	c.Assert(os.WriteFile(fname2, copy2, 0644), IsNil)
+
+	env, err := uenv.OpenRedundant(fname1, fname2, uenv.Options{})
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "a=b\n")
+	status, ok := env.RedundancyStatus()
+	c.Assert(ok, Equals, true)
+	c.Assert(status.Active, Equals, 1)
+	c.Assert(status.Copies[0].Valid, Equals, false)
+}
@@ -0,0 +1,48 @@
+package uenv
+
+// mutation records the value a variable had before it was changed, so
+// that the change can be undone
+type mutation struct {
+	name    string
+	value   string
+	existed bool
+}
+
+// recordUndo remembers the current value of the variable before it is
+// changed
+func (env *Env) recordUndo(name string) {
+	env.load()
+	value, existed := env.data[name]
+	env.undo = append(env.undo, mutation{name: name, value: value, existed: existed})
+}
+
+// Undo backs out the last n changes made with Set or Import since the
+// environment was opened or reloaded. Saved changes are only undone in
+// memory, the environment needs to be saved again. Undo returns the
+// number of changes that were backed out, which is less than n if
+// there were fewer changes.
+func (env *Env) Undo(n int) int {
+	env.load()
+	undone := 0
+	for ; undone < n && len(env.undo) > 0; undone++ {
+		m := env.undo[len(env.undo)-1]
+		env.undo = env.undo[:len(env.undo)-1]
+		if m.existed {
+			env.data[m.name] = m.value
+		} else {
+			delete(env.data, m.name)
+		}
+	}
+	return undone
+}
+
+// Reset backs out all changes made since the environment was opened or
+// reloaded, see Undo.
+func (env *Env) Reset() {
+	env.Undo(len(env.undo))
+}
+
+// UndoLen returns the number of changes that can be undone.
+func (env *Env) UndoLen() int {
+	return len(env.undo)
+}
@@ -0,0 +1,61 @@
+package uenv
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (u *uenvTestSuite) TestUndo(c *C) {
+	env, err := NewEnv(4096)
+	c.Assert(err, IsNil)
+	env.Set("foo", "1")
+	env.Set("bar", "1")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Reload(), IsNil)
+	c.Assert(env.UndoLen(), Equals, 0)
+
+	env.Set("foo", "2")
+	env.Set("foo", "3")
+	env.Set("bar", "")
+	env.Set("baz", "1")
+	// no-ops are not recorded
+	env.Set("baz", "1")
+	env.Set("missing", "")
+	c.Assert(env.Import(strings.NewReader("qux=1\nfoo=4\n")), IsNil)
+	c.Assert(env.UndoLen(), Equals, 6)
+	c.Assert(env.String(), Equals, "baz=1\nfoo=4\nqux=1\n")
+
+	c.Assert(env.Undo(2), Equals, 2)
+	c.Assert(env.String(), Equals, "baz=1\nfoo=3\n")
+	c.Assert(env.Undo(2), Equals, 2)
+	c.Assert(env.String(), Equals, "bar=1\nfoo=3\n")
+
+	// undoing more than there is stops at the state that was read
+	c.Assert(env.Undo(10), Equals, 2)
+	c.Assert(env.String(), Equals, "bar=1\nfoo=1\n")
+	c.Assert(env.Undo(1), Equals, 0)
+}
+
+func (u *uenvTestSuite) TestReset(c *C) {
+	env, err := Create(u.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("foo", "1")
+	c.Assert(env.Save(), IsNil)
+	env.Set("foo", "2")
+	env.Set("bar", "2")
+
+	env.Reset()
+	c.Assert(env.UndoLen(), Equals, 0)
+	c.Assert(env.String(), Equals, "")
+	// the reset is only in memory
+	env2, err := Open(u.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env2.String(), Equals, "foo=1\n")
+
+	// a reload starts a new history
+	env.Set("foo", "3")
+	c.Assert(env.Reload(), IsNil)
+	env.Reset()
+	c.Assert(env.String(), Equals, "foo=1\n")
+}
@@ -0,0 +1,224 @@
+// Package yamlconfig reads the YAML files that describe environments:
+// libubootenv configurations, board profiles and schemas. It is kept
+// out of package uenv so that users of the environment API do not
+// depend on a YAML parser.
+package yamlconfig
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// hexInt is a number in the YAML configuration, it is read in any
+// base and written in hex like the examples of libubootenv
+type hexInt int64
+
+func (h *hexInt) UnmarshalYAML(value *yaml.Node) error {
+	n, err := strconv.ParseInt(value.Value, 0, 64)
+	if err != nil {
+		return fmt.Errorf("line %d: cannot parse %q as number", value.Line, value.Value)
+	}
+	*h = hexInt(n)
+	return nil
+}
+
+func (h hexInt) MarshalYAML() (interface{}, error) {
+	value := fmt.Sprintf("0x%x", int64(h))
+	if h < 0 {
+		// negative offsets count from the end of the device
+		value = fmt.Sprintf("-0x%x", -int64(h))
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: value}, nil
+}
+
+type yamlDevice struct {
+	Path         string `yaml:"path"`
+	Offset       hexInt `yaml:"offset"`
+	SectorSize   hexInt `yaml:"sectorsize,omitempty"`
+	UnlockOffset hexInt `yaml:"unlockoffset,omitempty"`
+	DisableLock  bool   `yaml:"disablelock,omitempty"`
+}
+
+type yamlNamespace struct {
+	Size     hexInt       `yaml:"size"`
+	Lockfile string       `yaml:"lockfile,omitempty"`
+	Devices  []yamlDevice `yaml:"devices"`
+}
+
+func (ns *yamlNamespace) config() *uenv.Config {
+	cfg := &uenv.Config{
+		Size:     int(ns.Size),
+		Lockfile: ns.Lockfile,
+	}
+	for _, dev := range ns.Devices {
+		cfg.Devices = append(cfg.Devices, uenv.DeviceConfig{
+			Path:         dev.Path,
+			Offset:       int64(dev.Offset),
+			SectorSize:   int64(dev.SectorSize),
+			UnlockOffset: int64(dev.UnlockOffset),
+			DisableLock:  dev.DisableLock,
+		})
+	}
+	return cfg
+}
+
+// Load reads a libubootenv YAML configuration file. The result maps
+// the namespaces of the file (e.g. "uboot") to their configuration.
+func Load(fname string) (map[string]*uenv.Config, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	configs, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", fname, err)
+	}
+	return configs, nil
+}
+
+// Read reads a libubootenv YAML configuration.
+func Read(r io.Reader) (map[string]*uenv.Config, error) {
+	var namespaces map[string]yamlNamespace
+	if err := yaml.NewDecoder(r).Decode(&namespaces); err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string]*uenv.Config, len(namespaces))
+	for name, ns := range namespaces {
+		cfg := ns.config()
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("namespace %q: %v", name, err)
+		}
+		configs[name] = cfg
+	}
+	return configs, nil
+}
+
+// Write writes the configurations as libubootenv YAML configuration,
+// using the map keys as namespaces.
+func Write(w io.Writer, configs map[string]*uenv.Config) error {
+	namespaces := make(map[string]yamlNamespace, len(configs))
+	for name, cfg := range configs {
+		ns := yamlNamespace{
+			Size:     hexInt(cfg.Size),
+			Lockfile: cfg.Lockfile,
+		}
+		for _, dev := range cfg.Devices {
+			ns.Devices = append(ns.Devices, yamlDevice{
+				Path:         dev.Path,
+				Offset:       hexInt(dev.Offset),
+				SectorSize:   hexInt(dev.SectorSize),
+				UnlockOffset: hexInt(dev.UnlockOffset),
+				DisableLock:  dev.DisableLock,
+			})
+		}
+		namespaces[name] = ns
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(namespaces); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// yamlBoard is a board in a profile file, it is a libubootenv
+// namespace with a description
+type yamlBoard struct {
+	Description   string   `yaml:"description,omitempty"`
+	CBSize        hexInt   `yaml:"cbsize,omitempty"`
+	NameLength    hexInt   `yaml:"namelength,omitempty"`
+	Compatible    []string `yaml:"compatible,omitempty"`
+	Models        []string `yaml:"models,omitempty"`
+	yamlNamespace `yaml:",inline"`
+}
+
+// LoadBoardProfiles registers the board profiles of a YAML or JSON
+// file which maps board names to their description and location in
+// the format of libubootenv namespaces:
+//
+//	acme-gateway:
+//	  description: ACME gateway, all revisions
+//	  size: 0x4000
+//	  devices:
+//	    - path: /dev/mmcblk0
+//	      offset: 0x3fc000
+//	  cbsize: 1024
+//	  compatible: ["acme,gateway"]
+//
+// The optional cbsize and namelength set the Limits of the board,
+// compatible and models are used by uenv.DetectBoard.
+// Either all or none of the profiles are registered.
+func LoadBoardProfiles(fname string) error {
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	// JSON is valid YAML
+	var boards map[string]yamlBoard
+	if err := yaml.Unmarshal(content, &boards); err != nil {
+		return fmt.Errorf("cannot read %s: %v", fname, err)
+	}
+
+	profiles := make([]*uenv.BoardProfile, 0, len(boards))
+	for name, board := range boards {
+		profile := &uenv.BoardProfile{
+			Name:        name,
+			Description: board.Description,
+			Config:      *board.config(),
+			Limits: uenv.Limits{
+				CommandBufferSize: int(board.CBSize),
+				MaxNameLength:     int(board.NameLength),
+			},
+			Compatible: board.Compatible,
+			Models:     board.Models,
+		}
+		if err := profile.Config.Validate(); err != nil {
+			return fmt.Errorf("cannot read %s: board %q: %v", fname, name, err)
+		}
+		profiles = append(profiles, profile)
+	}
+	for _, profile := range profiles {
+		if err := uenv.RegisterBoard(profile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadSchema reads a schema from a YAML or JSON file:
+//
+//	variables:
+//	  serial#:
+//	    required: true
+//	    pattern: "[0-9A-F]{12}"
+//	  bootdelay:
+//	    type: int
+//	  boot_mode:
+//	    enum: [normal, recovery]
+//	groups:
+//	  - [ipaddr, netmask, gatewayip]
+func LoadSchema(fname string) (*uenv.Schema, error) {
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is valid YAML
+	var schema uenv.Schema
+	if err := yaml.Unmarshal(content, &schema); err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", fname, err)
+	}
+	if err := schema.Validate(); err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", fname, err)
+	}
+	return &schema, nil
+}
@@ -0,0 +1,203 @@
+package yamlconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type yamlconfigTestSuite struct{}
+
+var _ = Suite(&yamlconfigTestSuite{})
+
+const libubootenvYAML = `uboot:
+  size : 0x4000
+  lockfile : /var/lock/fw_printenv.lock
+  devices:
+    - path : /dev/mmcblk0
+      offset : 0x3FC000
+      sectorsize : 0x1000
+    - path : /dev/mmcblk0
+      offset : 0x400000
+      sectorsize : 0x1000
+      disablelock : true
+appvar:
+  size : 8192
+  devices:
+    - path : /var/appvar.env
+      offset : 0
+`
+
+func (s *yamlconfigTestSuite) TestRead(c *C) {
+	configs, err := Read(strings.NewReader(libubootenvYAML))
+	c.Assert(err, IsNil)
+	c.Assert(configs, DeepEquals, map[string]*uenv.Config{
+		"uboot": {
+			Size:     0x4000,
+			Lockfile: "/var/lock/fw_printenv.lock",
+			Devices: []uenv.DeviceConfig{
+				{Path: "/dev/mmcblk0", Offset: 0x3FC000, SectorSize: 0x1000},
+				{Path: "/dev/mmcblk0", Offset: 0x400000, SectorSize: 0x1000, DisableLock: true},
+			},
+		},
+		"appvar": {
+			Size:    8192,
+			Devices: []uenv.DeviceConfig{{Path: "/var/appvar.env"}},
+		},
+	})
+}
+
+func (s *yamlconfigTestSuite) TestReadErrors(c *C) {
+	_, err := Read(strings.NewReader("uboot:\n  size: lots\n"))
+	c.Assert(err, ErrorMatches, `line 2: cannot parse "lots" as number`)
+
+	_, err = Read(strings.NewReader("uboot:\n  size: 0x1000\n"))
+	c.Assert(err, ErrorMatches, `namespace "uboot": invalid config: need one or two devices, got 0`)
+}
+
+func (s *yamlconfigTestSuite) TestWriteRoundTrip(c *C) {
+	configs, err := Read(strings.NewReader(libubootenvYAML))
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(Write(&buf, configs), IsNil)
+	c.Assert(buf.String(), Equals, `appvar:
+  size: 0x2000
+  devices:
+    - path: /var/appvar.env
+      offset: 0x0
+uboot:
+  size: 0x4000
+  lockfile: /var/lock/fw_printenv.lock
+  devices:
+    - path: /dev/mmcblk0
+      offset: 0x3fc000
+      sectorsize: 0x1000
+    - path: /dev/mmcblk0
+      offset: 0x400000
+      sectorsize: 0x1000
+      disablelock: true
+`)
+
+	again, err := Read(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(again, DeepEquals, configs)
+}
+
+func (s *yamlconfigTestSuite) TestWriteNegativeOffset(c *C) {
+	configs := map[string]*uenv.Config{
+		"uboot": {Size: 0x4000, Devices: []uenv.DeviceConfig{{Path: "/dev/mmcblk0", Offset: -0x4000}}},
+	}
+	var buf bytes.Buffer
+	c.Assert(Write(&buf, configs), IsNil)
+	c.Assert(buf.String(), Equals, `uboot:
+  size: 0x4000
+  devices:
+    - path: /dev/mmcblk0
+      offset: -0x4000
+`)
+
+	again, err := Read(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(again, DeepEquals, configs)
+}
+
+func (s *yamlconfigTestSuite) TestLoadBoardProfiles(c *C) {
+	dir := c.MkDir()
+	yamlFile := filepath.Join(dir, "boards.yaml")
+	c.Assert(os.WriteFile(yamlFile, []byte(`acme-gateway:
+  description: ACME gateway, all revisions
+  size: 0x4000
+  devices:
+    - path: /dev/mmcblk0
+      offset: 0x3fc000
+    - path: /dev/mmcblk0
+      offset: 0x400000
+  cbsize: 1024
+  compatible: ["acme,gateway"]
+`), 0644), IsNil)
+	jsonFile := filepath.Join(dir, "boards.json")
+	c.Assert(os.WriteFile(jsonFile, []byte(`{"acme-sensor": {"size": 8192, "devices": [{"path": "/dev/mtd1", "sectorsize": 65536}]}}`), 0644), IsNil)
+
+	c.Assert(LoadBoardProfiles(yamlFile), IsNil)
+	c.Assert(LoadBoardProfiles(jsonFile), IsNil)
+
+	profile, err := uenv.LookupBoard("acme-gateway")
+	c.Assert(err, IsNil)
+	c.Assert(profile, DeepEquals, &uenv.BoardProfile{
+		Name:        "acme-gateway",
+		Description: "ACME gateway, all revisions",
+		Config: uenv.Config{
+			Size: 0x4000,
+			Devices: []uenv.DeviceConfig{
+				{Path: "/dev/mmcblk0", Offset: 0x3fc000},
+				{Path: "/dev/mmcblk0", Offset: 0x400000},
+			},
+		},
+		Limits:     uenv.Limits{CommandBufferSize: 1024},
+		Compatible: []string{"acme,gateway"},
+	})
+	profile, err = uenv.LookupBoard("acme-sensor")
+	c.Assert(err, IsNil)
+	c.Assert(profile.Config, DeepEquals, uenv.Config{
+		Size:    8192,
+		Devices: []uenv.DeviceConfig{{Path: "/dev/mtd1", SectorSize: 65536}},
+	})
+}
+
+func (s *yamlconfigTestSuite) TestLoadBoardProfilesInvalid(c *C) {
+	fname := filepath.Join(c.MkDir(), "boards.yaml")
+	c.Assert(os.WriteFile(fname, []byte(`good:
+  size: 0x4000
+  devices:
+    - path: /dev/mmcblk0
+bad:
+  size: 0x4000
+`), 0644), IsNil)
+	err := LoadBoardProfiles(fname)
+	c.Assert(err, ErrorMatches, `cannot read .*/boards.yaml: board "bad": invalid config: need one or two devices, got 0`)
+	// nothing was registered
+	_, err = uenv.LookupBoard("good")
+	c.Assert(err, NotNil)
+}
+
+func (s *yamlconfigTestSuite) TestLoadSchema(c *C) {
+	fname := filepath.Join(c.MkDir(), "schema.yaml")
+	c.Assert(os.WriteFile(fname, []byte(`
+variables:
+  serial#:
+    required: true
+    pattern: "[0-9A-F]{12}"
+  bootdelay:
+    type: int
+  boot_mode:
+    enum: [normal, recovery]
+groups:
+  - [ipaddr, netmask]
+strict: true
+`), 0644), IsNil)
+	schema, err := LoadSchema(fname)
+	c.Assert(err, IsNil)
+	c.Check(schema, DeepEquals, &uenv.Schema{
+		Variables: map[string]uenv.VariableSchema{
+			"serial#":   {Required: true, Pattern: "[0-9A-F]{12}"},
+			"bootdelay": {Type: uenv.TypeInt},
+			"boot_mode": {Enum: []string{"normal", "recovery"}},
+		},
+		Groups: [][]string{{"ipaddr", "netmask"}},
+		Strict: true,
+	})
+
+	c.Assert(os.WriteFile(fname, []byte(`{"variables": {"foo": {"pattern": "("}}}`), 0644), IsNil)
+	_, err = LoadSchema(fname)
+	c.Check(err, ErrorMatches, `cannot read .*/schema.yaml: variable "foo": cannot compile pattern: .*`)
+}
@@ -0,0 +1,45 @@
+// Package uenvaudit sends the audit records of environment saves to
+// syslog or journald, see uenv.Options.Audit.
+package uenvaudit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Message is the text of every record, the details are in its fields
+const Message = "uboot environment variable changed"
+
+// fields returns the details of a record as key value pairs in a
+// fixed order
+func fields(r *uenv.AuditRecord) [][2]string {
+	return [][2]string{
+		{"variable", r.Variable},
+		{"old_sha256", r.OldHash},
+		{"new_sha256", r.NewHash},
+		{"program", r.Program},
+		{"uid", strconv.Itoa(r.UID)},
+		{"pid", strconv.Itoa(r.PID)},
+		{"target", r.Target},
+	}
+}
+
+// Format returns the record as a single line of key=value pairs,
+// values with spaces, quotes or "=" are quoted:
+//
+//	uboot environment variable changed: variable=bootcmd old_sha256=... new_sha256=... program=uboot-go uid=0 pid=42 target=/dev/mmcblk0@0x3fc000
+func Format(r *uenv.AuditRecord) string {
+	var b strings.Builder
+	b.WriteString(Message + ":")
+	for _, f := range fields(r) {
+		value := f[1]
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=\\") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, " %s=%s", f[0], value)
+	}
+	return b.String()
+}
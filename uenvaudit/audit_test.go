@@ -0,0 +1,83 @@
+package uenvaudit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type auditTestSuite struct{}
+
+var _ = Suite(&auditTestSuite{})
+
+var testRecord = uenv.AuditRecord{
+	Time:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	Variable: "bootcmd",
+	NewHash:  "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9",
+	Program:  "uboot-go",
+	UID:      0,
+	PID:      42,
+	Target:   "/dev/mmcblk0@0x3fc000",
+}
+
+// listen returns a datagram socket in a temporary directory
+func listen(c *C) *net.UnixConn {
+	addr := &net.UnixAddr{Name: filepath.Join(c.MkDir(), "socket"), Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	c.Assert(err, IsNil)
+	return conn
+}
+
+func (s *auditTestSuite) TestFormat(c *C) {
+	c.Check(Format(&testRecord), Equals, `uboot environment variable changed: variable=bootcmd old_sha256="" new_sha256=fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9 program=uboot-go uid=0 pid=42 target=/dev/mmcblk0@0x3fc000`)
+
+	r := testRecord
+	r.Variable = "odd name=\"x\""
+	c.Check(Format(&r), Matches, `.* variable="odd name=\\"x\\"" .*`)
+}
+
+func (s *auditTestSuite) TestJournald(c *C) {
+	server := listen(c)
+	defer server.Close()
+	defer func(old string) { JournalSocket = old }(JournalSocket)
+	JournalSocket = server.LocalAddr().String()
+
+	j, err := NewJournald("uboot-go")
+	c.Assert(err, IsNil)
+	defer j.Close()
+
+	r := testRecord
+	r.Variable = "multi\nline"
+	c.Assert(j.Audit([]uenv.AuditRecord{testRecord, r}), IsNil)
+
+	buf := make([]byte, 4096)
+	n, err := server.Read(buf)
+	c.Assert(err, IsNil)
+	c.Check(string(buf[:n]), Equals, `MESSAGE=uboot environment variable changed: bootcmd
+PRIORITY=5
+SYSLOG_IDENTIFIER=uboot-go
+UENV_VARIABLE=bootcmd
+UENV_NEW_SHA256=fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9
+UENV_PROGRAM=uboot-go
+UENV_UID=0
+UENV_PID=42
+UENV_TARGET=/dev/mmcblk0@0x3fc000
+`)
+
+	// values with newlines use the binary form
+	n, err = server.Read(buf)
+	c.Assert(err, IsNil)
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len("multi\nline")))
+	c.Check(bytes.Contains(buf[:n], []byte("\nUENV_VARIABLE\n"+string(size[:])+"multi\nline\n")), Equals, true)
+}
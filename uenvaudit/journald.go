@@ -0,0 +1,78 @@
+package uenvaudit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// JournalSocket is where journald receives native protocol messages
+var JournalSocket = "/run/systemd/journal/socket"
+
+// priorityNotice is the syslog priority of the records
+const priorityNotice = 5
+
+// Journald sends the records to journald as structured entries, the
+// details are in fields like UENV_VARIABLE and UENV_NEW_SHA256 so that
+// they can be queried with e.g. "journalctl UENV_TARGET=/dev/mmcblk0".
+type Journald struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+// NewJournald connects to journald, identifier is the
+// SYSLOG_IDENTIFIER of the entries.
+func NewJournald(identifier string) (*Journald, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JournalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Journald{conn: conn, identifier: identifier}, nil
+}
+
+// writeField appends a field in the native journal protocol, values
+// with newlines use the binary form
+func writeField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// entry returns the journal entry of a record
+func (j *Journald) entry(r *uenv.AuditRecord) []byte {
+	var b bytes.Buffer
+	writeField(&b, "MESSAGE", Message+": "+r.Variable)
+	writeField(&b, "PRIORITY", strconv.Itoa(priorityNotice))
+	if j.identifier != "" {
+		writeField(&b, "SYSLOG_IDENTIFIER", j.identifier)
+	}
+	for _, f := range fields(r) {
+		if f[1] != "" {
+			writeField(&b, "UENV_"+strings.ToUpper(f[0]), f[1])
+		}
+	}
+	return b.Bytes()
+}
+
+// Audit implements uenv.AuditSink
+func (j *Journald) Audit(records []uenv.AuditRecord) error {
+	for i := range records {
+		if _, err := j.conn.Write(j.entry(&records[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection to journald
+func (j *Journald) Close() error {
+	return j.conn.Close()
+}
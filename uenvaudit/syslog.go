@@ -0,0 +1,46 @@
+//go:build unix
+
+package uenvaudit
+
+import (
+	"log/syslog"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Syslog sends the records to syslog with the authpriv facility, one
+// message per record in the format of Format.
+type Syslog struct {
+	w *syslog.Writer
+}
+
+// NewSyslog connects to the local syslog daemon, tag is the program
+// name the messages are logged with.
+func NewSyslog(tag string) (*Syslog, error) {
+	return DialSyslog("", "", tag)
+}
+
+// DialSyslog connects to the syslog daemon at the given address, see
+// syslog.Dial.
+func DialSyslog(network, raddr, tag string) (*Syslog, error) {
+	w, err := syslog.Dial(network, raddr, syslog.LOG_AUTHPRIV|syslog.LOG_NOTICE, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &Syslog{w: w}, nil
+}
+
+// Audit implements uenv.AuditSink
+func (s *Syslog) Audit(records []uenv.AuditRecord) error {
+	for i := range records {
+		if err := s.w.Notice(Format(&records[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection to syslog
+func (s *Syslog) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,33 @@
+//go:build !unix
+
+package uenvaudit
+
+import (
+	"fmt"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Syslog is not supported on this platform
+type Syslog struct{}
+
+// NewSyslog returns an error, syslog is not supported on this platform
+func NewSyslog(tag string) (*Syslog, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
+
+// DialSyslog returns an error, syslog is not supported on this
+// platform
+func DialSyslog(network, raddr, tag string) (*Syslog, error) {
+	return NewSyslog(tag)
+}
+
+// Audit implements uenv.AuditSink
+func (s *Syslog) Audit(records []uenv.AuditRecord) error {
+	return fmt.Errorf("syslog is not supported on this platform")
+}
+
+// Close does nothing
+func (s *Syslog) Close() error {
+	return nil
+}
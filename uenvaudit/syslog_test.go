@@ -0,0 +1,25 @@
+//go:build unix
+
+package uenvaudit
+
+import (
+	"github.com/mvo5/uboot-go/uenv"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *auditTestSuite) TestSyslog(c *C) {
+	server := listen(c)
+	defer server.Close()
+
+	w, err := DialSyslog("unixgram", server.LocalAddr().String(), "uboot-go")
+	c.Assert(err, IsNil)
+	defer w.Close()
+	c.Assert(w.Audit([]uenv.AuditRecord{testRecord}), IsNil)
+
+	buf := make([]byte, 4096)
+	n, err := server.Read(buf)
+	c.Assert(err, IsNil)
+	// authpriv.notice
+	c.Check(string(buf[:n]), Matches, `<85>.* uboot-go\[[0-9]+\]: uboot environment variable changed: variable=bootcmd .* target=/dev/mmcblk0@0x3fc000\n`)
+}
@@ -0,0 +1,65 @@
+package uenvdbus
+
+import (
+	"github.com/godbus/dbus/v5"
+)
+
+// Client talks to the environment service
+type Client struct {
+	conn *dbus.Conn
+	obj  dbus.BusObject
+}
+
+// NewClient returns a client for the service on the given connection
+func NewClient(conn *dbus.Conn) *Client {
+	return &Client{
+		conn: conn,
+		obj:  conn.Object(BusName, ObjectPath),
+	}
+}
+
+// GetVariable returns the value of the variable
+func (c *Client) GetVariable(name string) (string, error) {
+	var value string
+	if err := c.obj.Call(Interface+".GetVariable", 0, name).Store(&value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// SetVariable sets the variable, an empty value removes it. The
+// change is written when this client calls Commit.
+func (c *Client) SetVariable(name, value string) error {
+	return c.obj.Call(Interface+".SetVariable", 0, name, value).Err
+}
+
+// Commit writes the pending changes of this client
+func (c *Client) Commit() error {
+	return c.obj.Call(Interface+".Commit", 0).Err
+}
+
+// WatchChanges delivers the names of changed variables to the given
+// channel after every commit until the connection is closed.
+func (c *Client) WatchChanges(ch chan<- []string) error {
+	if err := c.conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(ObjectPath),
+		dbus.WithMatchInterface(Interface),
+		dbus.WithMatchMember("Changed"),
+	); err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 8)
+	c.conn.Signal(signals)
+	go func() {
+		defer close(ch)
+		for sig := range signals {
+			if sig.Name != ChangedSignal || sig.Path != ObjectPath || len(sig.Body) != 1 {
+				continue
+			}
+			if names, ok := sig.Body[0].([]string); ok {
+				ch <- names
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,52 @@
+package uenvdbus
+
+import (
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// polkitAllowUserInteraction lets polkit ask the user for a password
+const polkitAllowUserInteraction = 1
+
+// PolkitAuthorizer checks requests with polkit, using the sender's bus
+// name as subject
+type PolkitAuthorizer struct {
+	conn *dbus.Conn
+}
+
+// NewPolkitAuthorizer returns an authorizer that asks the polkit
+// authority on the given system bus connection
+func NewPolkitAuthorizer(conn *dbus.Conn) *PolkitAuthorizer {
+	return &PolkitAuthorizer{conn: conn}
+}
+
+type polkitSubject struct {
+	Kind    string
+	Details map[string]dbus.Variant
+}
+
+type polkitResult struct {
+	IsAuthorized bool
+	IsChallenge  bool
+	Details      map[string]string
+}
+
+// Authorize implements Authorizer
+func (p *PolkitAuthorizer) Authorize(sender dbus.Sender, action string) error {
+	subject := polkitSubject{
+		Kind:    "system-bus-name",
+		Details: map[string]dbus.Variant{"name": dbus.MakeVariant(string(sender))},
+	}
+	var result polkitResult
+	obj := p.conn.Object("org.freedesktop.PolicyKit1", "/org/freedesktop/PolicyKit1/Authority")
+	call := obj.Call("org.freedesktop.PolicyKit1.Authority.CheckAuthorization", 0,
+		subject, action, map[string]string{}, uint32(polkitAllowUserInteraction), "")
+	if err := call.Store(&result); err != nil {
+		return fmt.Errorf("cannot check authorization: %v", err)
+	}
+	if !result.IsAuthorized {
+		return fmt.Errorf("%s is not authorized for %s", sender, action)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Package uenvdbus exposes a uboot environment on D-Bus, so that
+// system services can change boot state without having root access to
+// the environment storage.
+package uenvdbus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+const (
+	// BusName is the well-known name the service requests
+	BusName = "io.github.mvo5.UbootEnv"
+	// ObjectPath is the path of the environment object
+	ObjectPath = dbus.ObjectPath("/io/github/mvo5/UbootEnv")
+	// Interface is the interface with the methods and signals
+	Interface = "io.github.mvo5.UbootEnv1"
+	// ChangedSignal is emitted after a commit with the names of the
+	// variables that changed
+	ChangedSignal = Interface + ".Changed"
+)
+
+// Actions passed to the Authorizer
+const (
+	ActionRead  = "io.github.mvo5.ubootenv.read"
+	ActionWrite = "io.github.mvo5.ubootenv.write"
+)
+
+// ErrNotAuthorized is the D-Bus error returned for denied requests
+const ErrNotAuthorized = Interface + ".Error.NotAuthorized"
+
+// Authorizer decides whether the sender of a request may perform the
+// given action
+type Authorizer interface {
+	Authorize(sender dbus.Sender, action string) error
+}
+
+const introspectXML = `
+<node>
+	<interface name="` + Interface + `">
+		<method name="GetVariable">
+			<arg name="name" direction="in" type="s"/>
+			<arg name="value" direction="out" type="s"/>
+		</method>
+		<method name="SetVariable">
+			<arg name="name" direction="in" type="s"/>
+			<arg name="value" direction="in" type="s"/>
+		</method>
+		<method name="Commit"/>
+		<signal name="Changed">
+			<arg name="names" type="as"/>
+		</signal>
+	</interface>` + introspect.IntrospectDataString + `</node>`
+
+// Service serves an environment on D-Bus. Changes made with
+// SetVariable are staged for each caller and only written when the
+// same caller calls Commit, so that callers do not commit the changes
+// of each other. The changes of callers that leave the bus without
+// committing are dropped.
+type Service struct {
+	env  *uenv.Env
+	auth Authorizer
+	emit func(name string, values ...interface{}) error
+
+	mu     sync.Mutex
+	staged map[dbus.Sender]map[string]string
+}
+
+// NewService creates a service for the given environment. A nil
+// authorizer allows everything, which is only useful when access is
+// already restricted by the bus policy.
+func NewService(env *uenv.Env, auth Authorizer) *Service {
+	return &Service{
+		env:    env,
+		auth:   auth,
+		staged: make(map[dbus.Sender]map[string]string),
+	}
+}
+
+// Export exports the service on the given connection and requests
+// BusName.
+func (s *Service) Export(conn *dbus.Conn) error {
+	s.emit = func(name string, values ...interface{}) error {
+		return conn.Emit(ObjectPath, name, values...)
+	}
+	if err := conn.Export(methods{s}, ObjectPath, Interface); err != nil {
+		return err
+	}
+	if err := conn.Export(introspect.Introspectable(introspectXML), ObjectPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return err
+	}
+	if err := conn.AddMatchSignal(dbus.WithMatchInterface("org.freedesktop.DBus"), dbus.WithMatchMember("NameOwnerChanged")); err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	go s.dropDisconnected(signals)
+	reply, err := conn.RequestName(BusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("cannot request name %s: already taken", BusName)
+	}
+	return nil
+}
+
+// dropDisconnected drops the staged changes of callers that left the
+// bus
+func (s *Service) dropDisconnected(signals <-chan *dbus.Signal) {
+	for sig := range signals {
+		if sig.Name != "org.freedesktop.DBus.NameOwnerChanged" || len(sig.Body) != 3 {
+			continue
+		}
+		name, _ := sig.Body[0].(string)
+		newOwner, _ := sig.Body[2].(string)
+		if newOwner == "" {
+			s.mu.Lock()
+			delete(s.staged, dbus.Sender(name))
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *Service) authorize(sender dbus.Sender, action string) *dbus.Error {
+	if s.auth == nil {
+		return nil
+	}
+	if err := s.auth.Authorize(sender, action); err != nil {
+		return dbus.NewError(ErrNotAuthorized, []interface{}{err.Error()})
+	}
+	return nil
+}
+
+// methods holds the methods exported on D-Bus, so that the exported
+// Go API of Service is not exported on the bus as well
+type methods struct {
+	s *Service
+}
+
+func (m methods) GetVariable(sender dbus.Sender, name string) (string, *dbus.Error) {
+	if err := m.s.authorize(sender, ActionRead); err != nil {
+		return "", err
+	}
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+	// callers see their own staged changes
+	if value, ok := m.s.staged[sender][name]; ok {
+		return value, nil
+	}
+	return m.s.env.Get(name), nil
+}
+
+func (m methods) SetVariable(sender dbus.Sender, name, value string) *dbus.Error {
+	if err := m.s.authorize(sender, ActionWrite); err != nil {
+		return err
+	}
+	if name == "" {
+		return dbus.MakeFailedError(fmt.Errorf("cannot set variable with empty name"))
+	}
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+	staged := m.s.staged[sender]
+	if staged == nil {
+		staged = make(map[string]string)
+		m.s.staged[sender] = staged
+	}
+	staged[name] = value
+	return nil
+}
+
+func (m methods) Commit(sender dbus.Sender) *dbus.Error {
+	if err := m.s.authorize(sender, ActionWrite); err != nil {
+		return err
+	}
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+	var names []string
+	for name, value := range m.s.staged[sender] {
+		if m.s.env.Get(name) != value {
+			m.s.env.Set(name, value)
+			names = append(names, name)
+		}
+	}
+	if err := m.s.env.Save(); err != nil {
+		// the changes stay staged for another try
+		m.s.env.Reload()
+		return dbus.MakeFailedError(err)
+	}
+	delete(m.s.staged, sender)
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	if m.s.emit != nil {
+		if err := m.s.emit(ChangedSignal, names); err != nil {
+			return dbus.MakeFailedError(err)
+		}
+	}
+	return nil
+}
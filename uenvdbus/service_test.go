@@ -0,0 +1,144 @@
+package uenvdbus
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type serviceTestSuite struct {
+	envFile string
+	env     *uenv.Env
+}
+
+var _ = Suite(&serviceTestSuite{})
+
+func (s *serviceTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+	env, err := uenv.Create(s.envFile, 4096)
+	c.Assert(err, IsNil)
+	s.env = env
+}
+
+type fakeAuthorizer map[string]bool
+
+func (a fakeAuthorizer) Authorize(sender dbus.Sender, action string) error {
+	if !a[action] {
+		return errors.New("denied")
+	}
+	return nil
+}
+
+func (s *serviceTestSuite) TestSetCommitEmitsChanged(c *C) {
+	svc := NewService(s.env, nil)
+	var emitted [][]string
+	svc.emit = func(name string, values ...interface{}) error {
+		c.Check(name, Equals, ChangedSignal)
+		emitted = append(emitted, values[0].([]string))
+		return nil
+	}
+	m := methods{svc}
+
+	c.Assert(m.SetVariable(":1.1", "upgrade_available", "1"), IsNil)
+	c.Assert(m.SetVariable(":1.1", "bootcount", "0"), IsNil)
+	// not written before the commit
+	env, err := uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "")
+
+	c.Assert(m.Commit(":1.1"), IsNil)
+	c.Assert(emitted, DeepEquals, [][]string{{"bootcount", "upgrade_available"}})
+	env, err = uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "bootcount=0\nupgrade_available=1\n")
+
+	value, dbusErr := m.GetVariable(":1.1", "bootcount")
+	c.Assert(dbusErr, IsNil)
+	c.Assert(value, Equals, "0")
+
+	// setting the same value again is not a change
+	c.Assert(m.SetVariable(":1.1", "bootcount", "0"), IsNil)
+	c.Assert(m.Commit(":1.1"), IsNil)
+	c.Assert(emitted, HasLen, 1)
+}
+
+func (s *serviceTestSuite) TestAuthorization(c *C) {
+	s.env.Set("foo", "bar")
+	svc := NewService(s.env, fakeAuthorizer{ActionRead: true})
+	m := methods{svc}
+
+	value, dbusErr := m.GetVariable(":1.2", "foo")
+	c.Assert(dbusErr, IsNil)
+	c.Assert(value, Equals, "bar")
+
+	dbusErr = m.SetVariable(":1.2", "foo", "baz")
+	c.Assert(dbusErr, NotNil)
+	c.Assert(dbusErr.Name, Equals, ErrNotAuthorized)
+	c.Assert(dbusErr.Body, DeepEquals, []interface{}{"denied"})
+	c.Assert(m.Commit(":1.2"), NotNil)
+	c.Assert(s.env.Get("foo"), Equals, "bar")
+}
+
+func (s *serviceTestSuite) TestSetEmptyName(c *C) {
+	m := methods{NewService(s.env, nil)}
+	dbusErr := m.SetVariable(":1.1", "", "x")
+	c.Assert(dbusErr, NotNil)
+	c.Assert(dbusErr.Error(), Equals, "cannot set variable with empty name")
+}
+
+func (s *serviceTestSuite) TestSendersStageSeparately(c *C) {
+	svc := NewService(s.env, nil)
+	var emitted [][]string
+	svc.emit = func(name string, values ...interface{}) error {
+		emitted = append(emitted, values[0].([]string))
+		return nil
+	}
+	m := methods{svc}
+
+	c.Assert(m.SetVariable(":1.1", "upgrade_available", "1"), IsNil)
+	c.Assert(m.SetVariable(":1.2", "bootcount", "0"), IsNil)
+	value, _ := m.GetVariable(":1.1", "upgrade_available")
+	c.Assert(value, Equals, "1")
+	value, _ = m.GetVariable(":1.2", "upgrade_available")
+	c.Assert(value, Equals, "")
+
+	// the commit of one sender does not write the changes of the
+	// other one
+	c.Assert(m.Commit(":1.2"), IsNil)
+	c.Assert(emitted, DeepEquals, [][]string{{"bootcount"}})
+	env, err := uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "bootcount=0\n")
+
+	c.Assert(m.Commit(":1.1"), IsNil)
+	c.Assert(emitted, DeepEquals, [][]string{{"bootcount"}, {"upgrade_available"}})
+	env, err = uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "bootcount=0\nupgrade_available=1\n")
+}
+
+func (s *serviceTestSuite) TestDropDisconnected(c *C) {
+	svc := NewService(s.env, nil)
+	m := methods{svc}
+	c.Assert(m.SetVariable(":1.1", "foo", "bar"), IsNil)
+	c.Assert(m.SetVariable(":1.2", "foo", "baz"), IsNil)
+
+	signals := make(chan *dbus.Signal, 2)
+	signals <- &dbus.Signal{Name: "org.freedesktop.DBus.NameOwnerChanged", Body: []interface{}{":1.1", ":1.1", ""}}
+	close(signals)
+	svc.dropDisconnected(signals)
+
+	c.Assert(svc.staged, HasLen, 1)
+	c.Assert(m.Commit(":1.1"), IsNil)
+	c.Assert(s.env.Get("foo"), Equals, "")
+	c.Assert(m.Commit(":1.2"), IsNil)
+	c.Assert(s.env.Get("foo"), Equals, "baz")
+}
@@ -0,0 +1,267 @@
+// Package uenvdfu writes and reads a uboot environment on a board in
+// USB DFU mode, e.g. U-Boot running "dfu 0 mmc 0" with an alt setting
+// for the env partition in dfu_alt_info, so that factory flashing can
+// be done by one Go program end to end.
+package uenvdfu
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultTransferSize is the number of bytes sent per request, it
+// matches the default of U-Boot.
+const DefaultTransferSize = 4096
+
+// DFU class requests
+const (
+	reqDnload    = 1
+	reqUpload    = 2
+	reqGetStatus = 3
+	reqClrStatus = 4
+	reqAbort     = 6
+)
+
+// request types of class requests to the interface
+const (
+	typeOut = 0x21
+	typeIn  = 0xa1
+)
+
+// State is the state of a DFU device.
+type State uint8
+
+const (
+	StateAppIdle State = iota
+	StateAppDetach
+	StateIdle
+	StateDnloadSync
+	StateDnbusy
+	StateDnloadIdle
+	StateManifestSync
+	StateManifest
+	StateManifestWaitReset
+	StateUploadIdle
+	StateError
+)
+
+var stateNames = []string{
+	"appIDLE", "appDETACH", "dfuIDLE", "dfuDNLOAD-SYNC", "dfuDNBUSY",
+	"dfuDNLOAD-IDLE", "dfuMANIFEST-SYNC", "dfuMANIFEST",
+	"dfuMANIFEST-WAIT-RESET", "dfuUPLOAD-IDLE", "dfuERROR",
+}
+
+func (s State) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return fmt.Sprintf("state %d", s)
+}
+
+var statusNames = []string{
+	"OK", "errTARGET", "errFILE", "errWRITE", "errERASE",
+	"errCHECK_ERASED", "errPROG", "errVERIFY", "errADDRESS",
+	"errNOTDONE", "errFIRMWARE", "errVENDOR", "errUSBR", "errPOR",
+	"errUNKNOWN", "errSTALLEDPKT",
+}
+
+// Status is the answer of the device to DFU_GETSTATUS.
+type Status struct {
+	// Status is zero or the error of the last request.
+	Status uint8
+	// PollTimeout is the time to wait before asking for the status
+	// again.
+	PollTimeout time.Duration
+	State       State
+}
+
+// StatusError is returned when the device reports an error.
+type StatusError struct {
+	Status uint8
+	State  State
+}
+
+func (e *StatusError) Error() string {
+	name := fmt.Sprintf("status %d", e.Status)
+	if int(e.Status) < len(statusNames) {
+		name = statusNames[e.Status]
+	}
+	return fmt.Sprintf("dfu: %s in state %s", name, e.State)
+}
+
+// Device sends control requests to a USB device, it is implemented by
+// *gousb.Device.
+type Device interface {
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+}
+
+// Client runs DFU transfers on one interface of a device. The alt
+// setting of the interface selects what is transferred and needs to be
+// set before, see OpenUSB.
+type Client struct {
+	dev       Device
+	Interface uint16
+	// TransferSize is the number of bytes per request,
+	// DefaultTransferSize if zero. It must not exceed the
+	// wTransferSize of the DFU functional descriptor.
+	TransferSize int
+}
+
+// NewClient returns a client for the DFU interface of the device.
+func NewClient(dev Device, intf uint16) *Client {
+	return &Client{dev: dev, Interface: intf}
+}
+
+var timeSleep = time.Sleep
+
+func (c *Client) transferSize() int {
+	if c.TransferSize > 0 {
+		return c.TransferSize
+	}
+	return DefaultTransferSize
+}
+
+func (c *Client) control(rType, request uint8, val uint16, data []byte) (int, error) {
+	n, err := c.dev.Control(rType, request, val, c.Interface, data)
+	if err != nil {
+		return n, fmt.Errorf("dfu: request %d failed: %v", request, err)
+	}
+	return n, nil
+}
+
+// GetStatus asks the device for its status.
+func (c *Client) GetStatus() (*Status, error) {
+	buf := make([]byte, 6)
+	n, err := c.control(typeIn, reqGetStatus, 0, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(buf) {
+		return nil, fmt.Errorf("dfu: short status of %d bytes", n)
+	}
+	timeout := uint32(buf[1]) | uint32(buf[2])<<8 | uint32(buf[3])<<16
+	return &Status{
+		Status:      buf[0],
+		PollTimeout: time.Duration(timeout) * time.Millisecond,
+		State:       State(buf[4]),
+	}, nil
+}
+
+// ClearStatus leaves the error state.
+func (c *Client) ClearStatus() error {
+	_, err := c.control(typeOut, reqClrStatus, 0, nil)
+	return err
+}
+
+// Abort ends a running transfer.
+func (c *Client) Abort() error {
+	_, err := c.control(typeOut, reqAbort, 0, nil)
+	return err
+}
+
+// idle brings the device into the idle state from which transfers
+// start, clearing errors of earlier runs
+func (c *Client) idle() error {
+	st, err := c.GetStatus()
+	if err != nil {
+		return err
+	}
+	switch st.State {
+	case StateIdle:
+		return nil
+	case StateError:
+		err = c.ClearStatus()
+	default:
+		err = c.Abort()
+	}
+	if err != nil {
+		return err
+	}
+	if st, err = c.GetStatus(); err != nil {
+		return err
+	}
+	if st.State != StateIdle {
+		return fmt.Errorf("dfu: device is in state %s instead of %s", st.State, StateIdle)
+	}
+	return nil
+}
+
+// wait polls the status until the device is in one of the given
+// states
+func (c *Client) wait(states ...State) error {
+	for {
+		st, err := c.GetStatus()
+		if err != nil {
+			return err
+		}
+		if st.Status != 0 || st.State == StateError {
+			return &StatusError{Status: st.Status, State: st.State}
+		}
+		for _, s := range states {
+			if st.State == s {
+				return nil
+			}
+		}
+		switch st.State {
+		case StateDnloadSync, StateDnbusy, StateManifestSync, StateManifest:
+			timeSleep(st.PollTimeout)
+		default:
+			return fmt.Errorf("dfu: unexpected state %s", st.State)
+		}
+	}
+}
+
+// Download sends data to the device, which writes it once the
+// transfer is complete.
+func (c *Client) Download(data []byte) error {
+	if err := c.idle(); err != nil {
+		return err
+	}
+	block := uint16(0)
+	for off := 0; off < len(data); off += c.transferSize() {
+		end := off + c.transferSize()
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := c.control(typeOut, reqDnload, block, data[off:end]); err != nil {
+			return err
+		}
+		if err := c.wait(StateDnloadIdle); err != nil {
+			return err
+		}
+		block++
+	}
+	// a request without data ends the transfer
+	if _, err := c.control(typeOut, reqDnload, block, nil); err != nil {
+		return err
+	}
+	return c.wait(StateIdle, StateManifestWaitReset)
+}
+
+// Upload reads n bytes from the device.
+func (c *Client) Upload(n int) ([]byte, error) {
+	if err := c.idle(); err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, n)
+	buf := make([]byte, c.transferSize())
+	for block := uint16(0); len(data) < n; block++ {
+		got, err := c.control(typeIn, reqUpload, block, buf)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, buf[:got]...)
+		if got < len(buf) {
+			// a short answer ends the transfer
+			if len(data) < n {
+				return nil, fmt.Errorf("dfu: upload ended after %d of %d bytes", len(data), n)
+			}
+			return data[:n], nil
+		}
+	}
+	// the device has more to send
+	if err := c.Abort(); err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
@@ -0,0 +1,182 @@
+package uenvdfu
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type dfuTestSuite struct {
+	dev    *fakeDevice
+	client *Client
+	sleeps []time.Duration
+}
+
+var _ = Suite(&dfuTestSuite{})
+
+// fakeDevice implements the DFU state machine of U-Boot for one alt
+// setting of 16KiB
+type fakeDevice struct {
+	mem      []byte
+	state    State
+	status   uint8
+	buf      []byte
+	block    uint16
+	upload   int
+	writeErr bool
+}
+
+func (d *fakeDevice) fail(status uint8) (int, error) {
+	d.state = StateError
+	d.status = status
+	return 0, nil
+}
+
+func (d *fakeDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	if idx != 0 {
+		return 0, fmt.Errorf("pipe")
+	}
+	switch request {
+	case reqGetStatus:
+		switch d.state {
+		case StateDnloadSync:
+			d.state = StateDnbusy
+		case StateDnbusy:
+			d.state = StateDnloadIdle
+		case StateManifestSync:
+			if d.writeErr {
+				d.state, d.status = StateError, 3
+			} else {
+				copy(d.mem, d.buf)
+				d.state = StateIdle
+			}
+		}
+		copy(data, []byte{d.status, 5, 0, 0, byte(d.state), 0})
+		return 6, nil
+	case reqClrStatus:
+		d.state, d.status = StateIdle, 0
+		return 0, nil
+	case reqAbort:
+		d.state = StateIdle
+		return 0, nil
+	case reqDnload:
+		switch {
+		case d.state == StateIdle && val == 0 && len(data) > 0:
+			d.buf = nil
+		case d.state != StateDnloadIdle || val != d.block+1:
+			return d.fail(14)
+		}
+		d.block = val
+		if len(data) == 0 {
+			d.state = StateManifestSync
+			return 0, nil
+		}
+		d.buf = append(d.buf, data...)
+		d.state = StateDnloadSync
+		return len(data), nil
+	case reqUpload:
+		if d.state == StateIdle && val == 0 {
+			d.upload = 0
+			d.state = StateUploadIdle
+		} else if d.state != StateUploadIdle {
+			return d.fail(14)
+		}
+		n := copy(data, d.mem[d.upload:])
+		d.upload += n
+		if n < len(data) {
+			d.state = StateIdle
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("pipe")
+}
+
+func (s *dfuTestSuite) SetUpTest(c *C) {
+	s.dev = &fakeDevice{mem: make([]byte, 16384), state: StateIdle}
+	s.client = NewClient(s.dev, 0)
+	s.sleeps = nil
+	timeSleep = func(d time.Duration) {
+		s.sleeps = append(s.sleeps, d)
+	}
+}
+
+func (s *dfuTestSuite) TearDownTest(c *C) {
+	timeSleep = time.Sleep
+}
+
+func (s *dfuTestSuite) TestDownloadUpload(c *C) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	err := s.client.Download(data)
+	c.Assert(err, IsNil)
+	c.Check(s.dev.mem[:len(data)], DeepEquals, data)
+	c.Check(s.dev.state, Equals, StateIdle)
+	// the device was busy once per block
+	c.Check(s.sleeps, HasLen, 3)
+	c.Check(s.sleeps[0], Equals, 5*time.Millisecond)
+
+	// stops before the end of the alt setting
+	got, err := s.client.Upload(len(data))
+	c.Assert(err, IsNil)
+	c.Check(got, DeepEquals, data)
+	c.Check(s.dev.state, Equals, StateIdle)
+
+	_, err = s.client.Upload(20000)
+	c.Check(err, ErrorMatches, "dfu: upload ended after 16384 of 20000 bytes")
+}
+
+func (s *dfuTestSuite) TestDownloadClearsError(c *C) {
+	s.dev.state, s.dev.status = StateError, 14
+	err := s.client.Download([]byte("data"))
+	c.Assert(err, IsNil)
+	c.Check(string(s.dev.mem[:4]), Equals, "data")
+}
+
+func (s *dfuTestSuite) TestDownloadWriteError(c *C) {
+	s.dev.writeErr = true
+	err := s.client.Download([]byte("data"))
+	c.Check(err, ErrorMatches, "dfu: errWRITE in state dfuERROR")
+	c.Check(err.(*StatusError).Status, Equals, uint8(3))
+}
+
+func (s *dfuTestSuite) TestRequestError(c *C) {
+	s.client.Interface = 1
+	_, err := s.client.GetStatus()
+	c.Check(err, ErrorMatches, "dfu: request 3 failed: pipe")
+}
+
+func (s *dfuTestSuite) TestStorage(c *C) {
+	env, err := uenv.CreateStorage(NewStorage(s.client, 16384), 16384, uenv.Options{})
+	c.Assert(err, IsNil)
+	env.Set("bootcmd", "run distro_bootcmd")
+	c.Assert(env.Save(), IsNil)
+
+	env, err = uenv.OpenStorage(NewStorage(s.client, 16384), uenv.Options{})
+	c.Assert(err, IsNil)
+	c.Check(env.Get("bootcmd"), Equals, "run distro_bootcmd")
+}
+
+func (s *dfuTestSuite) TestPush(c *C) {
+	mem := uenv.NewMemStorage(nil)
+	env, err := uenv.CreateStorage(mem, 4096, uenv.Options{})
+	c.Assert(err, IsNil)
+	env.Set("serial#", "SN0042")
+	c.Assert(env.Save(), IsNil)
+
+	c.Assert(Push(s.client, mem.Bytes(), uenv.Options{}), IsNil)
+	c.Check(s.dev.mem[:4096], DeepEquals, mem.Bytes())
+
+	broken := append([]byte(nil), mem.Bytes()...)
+	broken[10] ^= 1
+	err = Push(s.client, broken, uenv.Options{})
+	c.Check(err, ErrorMatches, "cannot push environment: bad CRC: .*")
+}
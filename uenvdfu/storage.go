@@ -0,0 +1,47 @@
+package uenvdfu
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Storage keeps an environment in the alt setting of a DFU device, it
+// implements uenv.Storage.
+type Storage struct {
+	client *Client
+	// Size is the size of the environment.
+	Size int
+}
+
+// NewStorage returns the storage of the environment of the given size
+// transferred by the client.
+func NewStorage(client *Client, size int) *Storage {
+	return &Storage{client: client, Size: size}
+}
+
+func (s *Storage) ReadImage() ([]byte, error) {
+	return s.client.Upload(s.Size)
+}
+
+func (s *Storage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if size != s.Size {
+		return fmt.Errorf("cannot write environment of size %d to storage of size %d", size, s.Size)
+	}
+	mem := uenv.NewMemStorage(nil)
+	if err := mem.WriteImage(size, fill); err != nil {
+		return err
+	}
+	return s.client.Download(mem.Bytes())
+}
+
+// Push writes an environment image built on the host, e.g. with
+// imagebuild, to the device. The image is checked with opts first so
+// that no broken environment ends up on the board.
+func Push(client *Client, img []byte, opts uenv.Options) error {
+	if _, err := uenv.OpenStorage(uenv.NewMemStorage(img), opts); err != nil {
+		return fmt.Errorf("cannot push environment: %v", err)
+	}
+	return client.Download(img)
+}
@@ -0,0 +1,59 @@
+//go:build gousb
+
+package uenvdfu
+
+import (
+	"fmt"
+
+	"github.com/google/gousb"
+)
+
+// USB is the DFU interface of a board opened with gousb, which needs
+// libusb and the gousb build tag.
+type USB struct {
+	*Client
+
+	ctx  *gousb.Context
+	dev  *gousb.Device
+	cfg  *gousb.Config
+	intf *gousb.Interface
+}
+
+// OpenUSB opens the board with the given vendor and product ID and
+// selects the alt setting of its DFU interface, e.g. the one of the
+// env partition in the dfu_alt_info of U-Boot.
+func OpenUSB(vid, pid uint16, intf, alt int) (*USB, error) {
+	u := &USB{ctx: gousb.NewContext()}
+	dev, err := u.ctx.OpenDeviceWithVIDPID(gousb.ID(vid), gousb.ID(pid))
+	if err == nil && dev == nil {
+		err = fmt.Errorf("cannot find usb device %04x:%04x", vid, pid)
+	}
+	if err == nil {
+		u.dev = dev
+		dev.SetAutoDetach(true)
+		u.cfg, err = dev.Config(1)
+	}
+	if err == nil {
+		u.intf, err = u.cfg.Interface(intf, alt)
+	}
+	if err != nil {
+		u.Close()
+		return nil, err
+	}
+	u.Client = NewClient(dev, uint16(intf))
+	return u, nil
+}
+
+// Close releases the interface and closes the device.
+func (u *USB) Close() error {
+	if u.intf != nil {
+		u.intf.Close()
+	}
+	if u.cfg != nil {
+		u.cfg.Close()
+	}
+	if u.dev != nil {
+		u.dev.Close()
+	}
+	return u.ctx.Close()
+}
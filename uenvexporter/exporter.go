@@ -0,0 +1,106 @@
+// Package uenvexporter publishes the boot state kept in a uboot
+// environment as Prometheus metrics.
+package uenvexporter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// DefaultSlotVariable is the variable that names the active slot when
+// none is configured
+const DefaultSlotVariable = "boot_slot"
+
+// Exporter reads the environment on every scrape
+type Exporter struct {
+	open func() (*uenv.Env, error)
+
+	// SlotVariable names the variable holding the active slot
+	SlotVariable string
+}
+
+// New returns an exporter that uses open to get the environment for
+// every scrape. The environment is closed after the scrape.
+func New(open func() (*uenv.Env, error)) *Exporter {
+	return &Exporter{
+		open:         open,
+		SlotVariable: DefaultSlotVariable,
+	}
+}
+
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (m *metricsWriter) metric(name, help string, labels string, value int) {
+	if m.err != nil {
+		return
+	}
+	_, m.err = fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s gauge\n%s%s %d\n", name, help, name, name, labels, value)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	m := &metricsWriter{w: w}
+
+	env, err := e.open()
+	var crcErr *uenv.CRCError
+	m.metric("uboot_env_up", "Whether the environment could be read.", "", boolValue(err == nil))
+	// the CRC is only known to be good or bad if the environment
+	// could be read
+	switch {
+	case err == nil:
+		m.metric("uboot_env_crc_ok", "Whether the CRC of the environment is valid.", "", 1)
+	case errors.As(err, &crcErr):
+		m.metric("uboot_env_crc_ok", "Whether the CRC of the environment is valid.", "", 0)
+	}
+	if err != nil {
+		return m.err
+	}
+	defer env.Close()
+
+	if n, err := strconv.Atoi(env.Get("bootcount")); err == nil {
+		m.metric("uboot_env_bootcount", "Number of boot attempts since the last successful boot.", "", n)
+	}
+	m.metric("uboot_env_upgrade_available", "Whether an upgrade is being tried.", "", boolValue(env.Get("upgrade_available") == "1"))
+	if slot := env.Get(e.SlotVariable); slot != "" {
+		m.metric("uboot_env_active_slot", "The active boot slot.", fmt.Sprintf(`{slot="%s"}`, escapeLabel(slot)), 1)
+	}
+	m.metric("uboot_env_size_bytes", "Size of the environment.", "", env.Size())
+	m.metric("uboot_env_free_bytes", "Free space in the environment.", "", env.Free())
+	if status, ok := env.RedundancyStatus(); ok {
+		m.metric("uboot_env_redundancy_healthy", "Whether both redundant copies are valid.", "", boolValue(status.Healthy()))
+	}
+
+	return m.err
+}
+
+// ServeHTTP implements http.Handler
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := e.WriteMetrics(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
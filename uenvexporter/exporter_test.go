@@ -0,0 +1,89 @@
+package uenvexporter
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type exporterTestSuite struct {
+	envFile string
+}
+
+var _ = Suite(&exporterTestSuite{})
+
+func (s *exporterTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+}
+
+func (s *exporterTestSuite) open() (*uenv.Env, error) {
+	return uenv.Open(s.envFile)
+}
+
+func (s *exporterTestSuite) TestMetrics(c *C) {
+	env, err := uenv.Create(s.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("bootcount", "2")
+	env.Set("upgrade_available", "1")
+	env.Set("boot_slot", "b")
+	c.Assert(env.Save(), IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(New(s.open).WriteMetrics(&buf), IsNil)
+	c.Assert(buf.String(), Equals, `# HELP uboot_env_up Whether the environment could be read.
+# TYPE uboot_env_up gauge
+uboot_env_up 1
+# HELP uboot_env_crc_ok Whether the CRC of the environment is valid.
+# TYPE uboot_env_crc_ok gauge
+uboot_env_crc_ok 1
+# HELP uboot_env_bootcount Number of boot attempts since the last successful boot.
+# TYPE uboot_env_bootcount gauge
+uboot_env_bootcount 2
+# HELP uboot_env_upgrade_available Whether an upgrade is being tried.
+# TYPE uboot_env_upgrade_available gauge
+uboot_env_upgrade_available 1
+# HELP uboot_env_active_slot The active boot slot.
+# TYPE uboot_env_active_slot gauge
+uboot_env_active_slot{slot="b"} 1
+# HELP uboot_env_size_bytes Size of the environment.
+# TYPE uboot_env_size_bytes gauge
+uboot_env_size_bytes 64
+# HELP uboot_env_free_bytes Free space in the environment.
+# TYPE uboot_env_free_bytes gauge
+uboot_env_free_bytes 15
+`)
+}
+
+func (s *exporterTestSuite) TestBadCRC(c *C) {
+	_, err := uenv.Create(s.envFile, 64)
+	c.Assert(err, IsNil)
+	f, err := os.OpenFile(s.envFile, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	_, err = f.WriteAt([]byte("x"), 10)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	rec := httptest.NewRecorder()
+	New(s.open).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	c.Assert(rec.Code, Equals, 200)
+	c.Assert(rec.Body.String(), Matches, "(?s).*\nuboot_env_up 0\n.*\nuboot_env_crc_ok 0\n")
+}
+
+func (s *exporterTestSuite) TestMissing(c *C) {
+	var buf bytes.Buffer
+	c.Assert(New(s.open).WriteMetrics(&buf), IsNil)
+	// without an environment nothing is known about its CRC
+	c.Assert(buf.String(), Equals, `# HELP uboot_env_up Whether the environment could be read.
+# TYPE uboot_env_up gauge
+uboot_env_up 0
+`)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// variable is a variable of the schema as seen by the template
+type variable struct {
+	// Name is the name in the environment.
+	Name string
+	// GoName is the exported Go name used for the accessors.
+	GoName string
+	Type   uenv.VariableType
+	// Enum are the allowed values and their constant names.
+	Enum []enumValue
+}
+
+type enumValue struct {
+	Value  string
+	GoName string
+}
+
+// goName turns a variable name like "kernel_addr_r" into an exported
+// Go identifier like "KernelAddrR"
+func goName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) || r > unicode.MaxASCII {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if s != "" && unicode.IsDigit(rune(s[0])) {
+		s = "Var" + s
+	}
+	return s
+}
+
+// variables returns the variables of the schema sorted by name
+func variables(schema *uenv.Schema, typeName string) ([]variable, error) {
+	// the generated names must not clash with each other or with
+	// the methods of the wrapper
+	used := map[string]string{typeName: "the type"}
+	claim := func(goName, what string) error {
+		if goName == "" {
+			return fmt.Errorf("%s has no usable Go name", what)
+		}
+		if other, ok := used[goName]; ok {
+			return fmt.Errorf("%s and %s both map to %s", what, other, goName)
+		}
+		used[goName] = what
+		return nil
+	}
+
+	names := make([]string, 0, len(schema.Variables))
+	for name := range schema.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var vars []variable
+	for _, name := range names {
+		vs := schema.Variables[name]
+		v := variable{Name: name, GoName: goName(name), Type: vs.Type}
+		what := fmt.Sprintf("variable %q", name)
+		if err := claim(v.GoName, what); err != nil {
+			return nil, err
+		}
+		if err := claim("Set"+v.GoName, what); err != nil {
+			return nil, err
+		}
+		if len(vs.Enum) > 0 {
+			if vs.Type != "" && vs.Type != uenv.TypeString {
+				return nil, fmt.Errorf("variable %q: enums need to be strings", name)
+			}
+			if err := claim(typeName+v.GoName, what); err != nil {
+				return nil, err
+			}
+			for _, value := range vs.Enum {
+				ev := enumValue{Value: value, GoName: typeName + v.GoName + goName(value)}
+				if err := claim(ev.GoName, fmt.Sprintf("value %q of variable %q", value, name)); err != nil {
+					return nil, err
+				}
+				v.Enum = append(v.Enum, ev)
+			}
+		}
+		vars = append(vars, v)
+	}
+	return vars, nil
+}
+
+var accessorsTemplate = template.Must(template.New("accessors").Parse(`// Code generated by uenvgen from {{.Schema}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .Strconv}}
+	"strconv"
+{{end}}
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// {{.Type}} gives typed access to the variables of an environment.
+type {{.Type}} struct {
+	Env *uenv.Env
+}
+{{range .Vars}}{{$v := .}}{{$t := printf "%s%s" $.Type .GoName}}
+{{- if .Enum}}
+// {{$t}} is a value of the {{.Name}} variable.
+type {{$t}} string
+
+const (
+{{- range .Enum}}
+	{{.GoName}} {{$t}} = {{printf "%q" .Value}}
+{{- end}}
+)
+
+// {{.GoName}} returns the value of the {{.Name}} variable.
+func (e {{$.Type}}) {{.GoName}}() {{$t}} {
+	return {{$t}}(e.Env.Get({{printf "%q" .Name}}))
+}
+
+// Set{{.GoName}} sets the {{.Name}} variable.
+func (e {{$.Type}}) Set{{.GoName}}(value {{$t}}) {
+	e.Env.Set({{printf "%q" .Name}}, string(value))
+}
+{{- else if eq .Type "int"}}
+// {{.GoName}} returns the value of the {{.Name}} variable, zero if it
+// is not set.
+func (e {{$.Type}}) {{.GoName}}() (int64, error) {
+	value := e.Env.Get({{printf "%q" .Name}})
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 0, 64)
+}
+
+// Set{{.GoName}} sets the {{.Name}} variable.
+func (e {{$.Type}}) Set{{.GoName}}(value int64) {
+	e.Env.Set({{printf "%q" .Name}}, strconv.FormatInt(value, 10))
+}
+{{- else if eq .Type "hex"}}
+// {{.GoName}} returns the value of the {{.Name}} variable, zero if it
+// is not set.
+func (e {{$.Type}}) {{.GoName}}() (uint64, error) {
+	value := e.Env.Get({{printf "%q" .Name}})
+	if value == "" {
+		return 0, nil
+	}
+	return uenv.ParseHex(value)
+}
+
+// Set{{.GoName}} sets the {{.Name}} variable.
+func (e {{$.Type}}) Set{{.GoName}}(value uint64) {
+	e.Env.Set({{printf "%q" .Name}}, "0x"+strconv.FormatUint(value, 16))
+}
+{{- else if eq .Type "bool"}}
+// {{.GoName}} returns the value of the {{.Name}} variable, false if
+// it is not set or not a boolean.
+func (e {{$.Type}}) {{.GoName}}() bool {
+	value, _ := uenv.ParseBool(e.Env.Get({{printf "%q" .Name}}))
+	return value
+}
+
+// Set{{.GoName}} sets the {{.Name}} variable to "1" or "0".
+func (e {{$.Type}}) Set{{.GoName}}(value bool) {
+	if value {
+		e.Env.Set({{printf "%q" .Name}}, "1")
+	} else {
+		e.Env.Set({{printf "%q" .Name}}, "0")
+	}
+}
+{{- else}}
+// {{.GoName}} returns the value of the {{.Name}} variable.
+func (e {{$.Type}}) {{.GoName}}() string {
+	return e.Env.Get({{printf "%q" .Name}})
+}
+
+// Set{{.GoName}} sets the {{.Name}} variable.
+func (e {{$.Type}}) Set{{.GoName}}(value string) {
+	e.Env.Set({{printf "%q" .Name}}, value)
+}
+{{- end}}
+{{end}}`))
+
+// generate returns the formatted source of the accessors for the
+// variables of the schema
+func generate(schema *uenv.Schema, schemaFile, pkg, typeName string) ([]byte, error) {
+	vars, err := variables(schema, typeName)
+	if err != nil {
+		return nil, err
+	}
+	// strconv is only imported if a number is formatted
+	needStrconv := false
+	for _, v := range vars {
+		if v.Type == uenv.TypeInt || v.Type == uenv.TypeHex {
+			needStrconv = true
+		}
+	}
+	var buf bytes.Buffer
+	err = accessorsTemplate.Execute(&buf, map[string]interface{}{
+		"Schema":  filepath.Base(schemaFile),
+		"Package": pkg,
+		"Type":    typeName,
+		"Vars":    vars,
+		"Strconv": needStrconv,
+	})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cannot format generated code: %v", err)
+	}
+	return src, nil
+}
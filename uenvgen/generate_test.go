@@ -0,0 +1,88 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"regexp"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type generateTestSuite struct{}
+
+var _ = Suite(&generateTestSuite{})
+
+var testSchema = &uenv.Schema{
+	Variables: map[string]uenv.VariableSchema{
+		"kernel_addr_r":     {Type: uenv.TypeHex},
+		"bootdelay":         {Type: uenv.TypeInt},
+		"upgrade_available": {Type: uenv.TypeBool},
+		"active_slot":       {Enum: []string{"a", "b"}},
+		"serial#":           {Required: true},
+	},
+}
+
+func (s *generateTestSuite) TestGoName(c *C) {
+	for _, t := range []struct{ name, goName string }{
+		{"kernel_addr_r", "KernelAddrR"},
+		{"serial#", "Serial"},
+		{"fdt-file", "FdtFile"},
+		{"bootcmd", "Bootcmd"},
+		{"2nd_stage", "Var2ndStage"},
+		{"#", ""},
+	} {
+		c.Check(goName(t.name), Equals, t.goName, Commentf(t.name))
+	}
+}
+
+func (s *generateTestSuite) TestGenerate(c *C) {
+	src, err := generate(testSchema, "testdata/bootenv.yaml", "board", "BootEnv")
+	c.Assert(err, IsNil)
+	_, err = parser.ParseFile(token.NewFileSet(), "bootenv.go", src, 0)
+	c.Assert(err, IsNil)
+
+	code := string(src)
+	c.Check(code, Matches, `(?s)// Code generated by uenvgen from bootenv.yaml. DO NOT EDIT.\n\npackage board\n.*`)
+	for _, decl := range []string{
+		`type BootEnv struct {`,
+		`func (e BootEnv) KernelAddrR() (uint64, error) {`,
+		`func (e BootEnv) SetKernelAddrR(value uint64) {`,
+		`func (e BootEnv) Bootdelay() (int64, error) {`,
+		`func (e BootEnv) SetBootdelay(value int64) {`,
+		`func (e BootEnv) UpgradeAvailable() bool {`,
+		`func (e BootEnv) SetUpgradeAvailable(value bool) {`,
+		`type BootEnvActiveSlot string`,
+		`BootEnvActiveSlotA BootEnvActiveSlot = "a"`,
+		`func (e BootEnv) ActiveSlot() BootEnvActiveSlot {`,
+		`func (e BootEnv) SetActiveSlot(value BootEnvActiveSlot) {`,
+		`func (e BootEnv) Serial() string {`,
+		`	e.Env.Set("serial#", value)`,
+	} {
+		c.Check(code, Matches, `(?s).*`+regexp.QuoteMeta(decl)+`.*`)
+	}
+}
+
+func (s *generateTestSuite) TestGenerateNoNumbers(c *C) {
+	src, err := generate(&uenv.Schema{Variables: map[string]uenv.VariableSchema{"bootcmd": {}}}, "schema.yaml", "board", "BootEnv")
+	c.Assert(err, IsNil)
+	c.Check(string(src), Not(Matches), `(?s).*strconv.*`)
+}
+
+func (s *generateTestSuite) TestGenerateClash(c *C) {
+	schema := &uenv.Schema{Variables: map[string]uenv.VariableSchema{
+		"fdt_file": {},
+		"fdt-file": {},
+	}}
+	_, err := generate(schema, "schema.yaml", "board", "BootEnv")
+	c.Check(err, ErrorMatches, `variable "fdt_file" and variable "fdt-file" both map to FdtFile`)
+
+	schema = &uenv.Schema{Variables: map[string]uenv.VariableSchema{"#": {}}}
+	_, err = generate(schema, "schema.yaml", "board", "BootEnv")
+	c.Check(err, ErrorMatches, `variable "#" has no usable Go name`)
+}
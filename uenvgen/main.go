@@ -0,0 +1,47 @@
+// Command uenvgen generates a wrapper with typed accessors for the
+// variables of a uenv schema, so that application code does not need
+// to Get and Set strings:
+//
+//	//go:generate go run github.com/mvo5/uboot-go/uenvgen -schema bootenv.yaml -type BootEnv
+//
+// Variables of type int, hex and bool get getters and setters of the
+// matching Go type, variables with an enum get their own string type
+// with a constant for every value.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mvo5/uboot-go/uenv/yamlconfig"
+)
+
+func main() {
+	schemaFile := flag.String("schema", "", "schema file to generate the accessors for")
+	typeName := flag.String("type", "BootEnv", "name of the generated type")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "package of the generated file")
+	output := flag.String("o", "", "output file, <type>.go in lower case by default")
+	flag.Parse()
+
+	if *schemaFile == "" || *pkg == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = strings.ToLower(*typeName) + ".go"
+	}
+
+	schema, err := yamlconfig.LoadSchema(*schemaFile)
+	if err != nil {
+		log.Fatalf("yamlconfig.LoadSchema failed: %s", err)
+	}
+	src, err := generate(schema, *schemaFile, *pkg, *typeName)
+	if err != nil {
+		log.Fatalf("cannot generate accessors for %s: %s", *schemaFile, err)
+	}
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		log.Fatalf("WriteFile failed for %s: %s", *output, err)
+	}
+}
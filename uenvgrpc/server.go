@@ -0,0 +1,204 @@
+// Package uenvgrpc serves a uboot environment over gRPC, so that
+// provisioning backends can manage the boot environment of a device.
+//
+// The server does no authentication itself, pass the credentials of
+// the channel (e.g. grpc.Creds with mutual TLS) when creating the
+// grpc.Server it is registered on.
+package uenvgrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mvo5/uboot-go/uenv"
+	"github.com/mvo5/uboot-go/uenvgrpc/uenvpb"
+)
+
+// DefaultPollInterval is how often watchers check the storage for
+// changes made by others
+const DefaultPollInterval = 5 * time.Second
+
+// Server implements the UbootEnv gRPC service
+type Server struct {
+	uenvpb.UnimplementedUbootEnvServer
+
+	open func() (*uenv.Env, error)
+
+	// PollInterval is how often Watch re-reads the storage to notice
+	// changes not made through this server
+	PollInterval time.Duration
+
+	mu sync.Mutex
+	// env holds the unsaved changes, saved is the environment as it
+	// was last read from or written to the storage
+	env   *uenv.Env
+	saved *uenv.Env
+
+	watchers map[chan struct{}]bool
+}
+
+// NewServer returns a server for the environment returned by open.
+// open is also used to re-read the storage for Verify and Watch.
+func NewServer(open func() (*uenv.Env, error)) (*Server, error) {
+	env, err := open()
+	if err != nil {
+		return nil, err
+	}
+	saved, err := open()
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	return &Server{
+		open:         open,
+		PollInterval: DefaultPollInterval,
+		env:          env,
+		saved:        saved,
+		watchers:     make(map[chan struct{}]bool),
+	}, nil
+}
+
+// Register registers the service on the given gRPC server
+func (s *Server) Register(g *grpc.Server) {
+	uenvpb.RegisterUbootEnvServer(g, s)
+}
+
+func toProto(changes []uenv.Change) []*uenvpb.Change {
+	pb := make([]*uenvpb.Change, len(changes))
+	for i, c := range changes {
+		pb[i] = &uenvpb.Change{Name: c.Name, OldValue: c.OldValue, NewValue: c.NewValue}
+	}
+	return pb
+}
+
+func (s *Server) Get(ctx context.Context, req *uenvpb.GetRequest) (*uenvpb.GetResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vars := make(map[string]string)
+	if len(req.Names) == 0 {
+		s.env.Range(func(key, value string) bool {
+			vars[key] = value
+			return true
+		})
+	}
+	for _, name := range req.Names {
+		if value := s.env.Get(name); value != "" {
+			vars[name] = value
+		}
+	}
+	return &uenvpb.GetResponse{Variables: vars}, nil
+}
+
+func (s *Server) Set(ctx context.Context, req *uenvpb.SetRequest) (*uenvpb.SetResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name := range req.Variables {
+		if name == "" {
+			return nil, status.Error(codes.InvalidArgument, "cannot set variable with empty name")
+		}
+	}
+	for name, value := range req.Variables {
+		s.env.Set(name, value)
+	}
+	return &uenvpb.SetResponse{}, nil
+}
+
+func (s *Server) Diff(ctx context.Context, req *uenvpb.DiffRequest) (*uenvpb.DiffResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &uenvpb.DiffResponse{Changes: toProto(uenv.Diff(s.saved, s.env))}, nil
+}
+
+func (s *Server) Save(ctx context.Context, req *uenvpb.SaveRequest) (*uenvpb.SaveResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.env.Save(); err != nil {
+		if errors.Is(err, uenv.ErrConcurrentModification) {
+			return nil, status.Error(codes.Aborted, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	saved, err := s.open()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.saved.Close()
+	s.saved = saved
+
+	for ch := range s.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// a notification is already pending
+		}
+	}
+	return &uenvpb.SaveResponse{}, nil
+}
+
+func (s *Server) Verify(ctx context.Context, req *uenvpb.VerifyRequest) (*uenvpb.VerifyResponse, error) {
+	stored, err := s.open()
+	if err != nil {
+		return &uenvpb.VerifyResponse{Error: err.Error()}, nil
+	}
+	defer stored.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &uenvpb.VerifyResponse{
+		Valid:   true,
+		Matches: len(uenv.Diff(s.saved, stored)) == 0,
+	}, nil
+}
+
+func (s *Server) Watch(req *uenvpb.WatchRequest, stream uenvpb.UbootEnv_WatchServer) error {
+	notify := make(chan struct{}, 1)
+	s.mu.Lock()
+	last, err := s.open()
+	s.watchers[notify] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, notify)
+		s.mu.Unlock()
+	}()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer func() { last.Close() }()
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-notify:
+		case <-ticker.C:
+		}
+
+		current, err := s.open()
+		if err != nil {
+			// the storage may be in the middle of a write
+			continue
+		}
+		changes := uenv.Diff(last, current)
+		last.Close()
+		last = current
+		if len(changes) == 0 {
+			continue
+		}
+		if err := stream.Send(&uenvpb.WatchEvent{Changes: toProto(changes)}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package uenvgrpc
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+	"github.com/mvo5/uboot-go/uenvgrpc/uenvpb"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type serverTestSuite struct {
+	envFile string
+	server  *Server
+	grpc    *grpc.Server
+	conn    *grpc.ClientConn
+	client  uenvpb.UbootEnvClient
+}
+
+var _ = Suite(&serverTestSuite{})
+
+func (s *serverTestSuite) open() (*uenv.Env, error) {
+	return uenv.Open(s.envFile)
+}
+
+func (s *serverTestSuite) SetUpTest(c *C) {
+	s.envFile = filepath.Join(c.MkDir(), "uboot.env")
+	env, err := uenv.Create(s.envFile, 4096)
+	c.Assert(err, IsNil)
+	env.Set("bootcount", "0")
+	c.Assert(env.Save(), IsNil)
+
+	s.server, err = NewServer(s.open)
+	c.Assert(err, IsNil)
+	s.server.PollInterval = 10 * time.Millisecond
+
+	lis := bufconn.Listen(1 << 16)
+	s.grpc = grpc.NewServer()
+	s.server.Register(s.grpc)
+	go s.grpc.Serve(lis)
+
+	s.conn, err = grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	c.Assert(err, IsNil)
+	s.client = uenvpb.NewUbootEnvClient(s.conn)
+}
+
+func (s *serverTestSuite) TearDownTest(c *C) {
+	s.conn.Close()
+	s.grpc.Stop()
+}
+
+func (s *serverTestSuite) TestGetSetDiffSave(c *C) {
+	ctx := context.Background()
+	_, err := s.client.Set(ctx, &uenvpb.SetRequest{Variables: map[string]string{"upgrade_available": "1", "bootcount": ""}})
+	c.Assert(err, IsNil)
+
+	get, err := s.client.Get(ctx, &uenvpb.GetRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(get.Variables, DeepEquals, map[string]string{"upgrade_available": "1"})
+
+	diff, err := s.client.Diff(ctx, &uenvpb.DiffRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(diff.Changes, HasLen, 2)
+	c.Assert(diff.Changes[0].Name, Equals, "bootcount")
+	c.Assert(diff.Changes[0].OldValue, Equals, "0")
+	c.Assert(diff.Changes[1].Name, Equals, "upgrade_available")
+	c.Assert(diff.Changes[1].NewValue, Equals, "1")
+
+	// nothing is written before Save
+	env, err := s.open()
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "bootcount=0\n")
+
+	_, err = s.client.Save(ctx, &uenvpb.SaveRequest{})
+	c.Assert(err, IsNil)
+	env, err = s.open()
+	c.Assert(err, IsNil)
+	c.Assert(env.String(), Equals, "upgrade_available=1\n")
+
+	diff, err = s.client.Diff(ctx, &uenvpb.DiffRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(diff.Changes, HasLen, 0)
+}
+
+func (s *serverTestSuite) TestSetEmptyName(c *C) {
+	_, err := s.client.Set(context.Background(), &uenvpb.SetRequest{Variables: map[string]string{"": "x"}})
+	c.Assert(status.Code(err), Equals, codes.InvalidArgument)
+}
+
+func (s *serverTestSuite) TestVerify(c *C) {
+	ctx := context.Background()
+	res, err := s.client.Verify(ctx, &uenvpb.VerifyRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(res.Valid, Equals, true)
+	c.Assert(res.Matches, Equals, true)
+
+	// changed behind the server's back
+	env, err := s.open()
+	c.Assert(err, IsNil)
+	env.Set("bootcount", "1")
+	c.Assert(env.Save(), IsNil)
+	res, err = s.client.Verify(ctx, &uenvpb.VerifyRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(res.Valid, Equals, true)
+	c.Assert(res.Matches, Equals, false)
+
+	// corrupted
+	f, err := os.OpenFile(s.envFile, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	_, err = f.WriteAt([]byte("x"), 10)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	res, err = s.client.Verify(ctx, &uenvpb.VerifyRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(res.Valid, Equals, false)
+	c.Assert(res.Error, Matches, "bad CRC: .*")
+}
+
+func (s *serverTestSuite) TestWatch(c *C) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := s.client.Watch(ctx, &uenvpb.WatchRequest{})
+	c.Assert(err, IsNil)
+	// make sure the watch is set up before changing anything
+	for {
+		s.server.mu.Lock()
+		n := len(s.server.watchers)
+		s.server.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// saved through the server
+	_, err = s.client.Set(ctx, &uenvpb.SetRequest{Variables: map[string]string{"bootcount": "1"}})
+	c.Assert(err, IsNil)
+	_, err = s.client.Save(ctx, &uenvpb.SaveRequest{})
+	c.Assert(err, IsNil)
+	ev, err := stream.Recv()
+	c.Assert(err, IsNil)
+	c.Assert(ev.Changes, HasLen, 1)
+	c.Assert(ev.Changes[0].Name, Equals, "bootcount")
+	c.Assert(ev.Changes[0].NewValue, Equals, "1")
+
+	// and by someone else
+	env, err := s.open()
+	c.Assert(err, IsNil)
+	env.Set("upgrade_available", "1")
+	c.Assert(env.Save(), IsNil)
+	ev, err = stream.Recv()
+	c.Assert(err, IsNil)
+	c.Assert(ev.Changes, HasLen, 1)
+	c.Assert(ev.Changes[0].Name, Equals, "upgrade_available")
+}
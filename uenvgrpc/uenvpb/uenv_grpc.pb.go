@@ -0,0 +1,335 @@
+// API to manage a uboot environment remotely.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v4.25.3
+// source: uenv.proto
+
+package uenvpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	UbootEnv_Get_FullMethodName    = "/uenv.v1.UbootEnv/Get"
+	UbootEnv_Set_FullMethodName    = "/uenv.v1.UbootEnv/Set"
+	UbootEnv_Diff_FullMethodName   = "/uenv.v1.UbootEnv/Diff"
+	UbootEnv_Save_FullMethodName   = "/uenv.v1.UbootEnv/Save"
+	UbootEnv_Verify_FullMethodName = "/uenv.v1.UbootEnv/Verify"
+	UbootEnv_Watch_FullMethodName  = "/uenv.v1.UbootEnv/Watch"
+)
+
+// UbootEnvClient is the client API for UbootEnv service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type UbootEnvClient interface {
+	// Get returns the requested variables, all of them if no names are
+	// given. Unsaved changes are included.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// Set changes variables, an empty value removes the variable. The
+	// changes are kept in memory until Save is called.
+	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error)
+	// Diff returns the unsaved changes.
+	Diff(ctx context.Context, in *DiffRequest, opts ...grpc.CallOption) (*DiffResponse, error)
+	// Save writes the environment to its storage.
+	Save(ctx context.Context, in *SaveRequest, opts ...grpc.CallOption) (*SaveResponse, error)
+	// Verify checks that the stored environment is intact and matches
+	// the saved state.
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+	// Watch streams the changes of the stored environment.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error)
+}
+
+type ubootEnvClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUbootEnvClient(cc grpc.ClientConnInterface) UbootEnvClient {
+	return &ubootEnvClient{cc}
+}
+
+func (c *ubootEnvClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, UbootEnv_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ubootEnvClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetResponse)
+	err := c.cc.Invoke(ctx, UbootEnv_Set_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ubootEnvClient) Diff(ctx context.Context, in *DiffRequest, opts ...grpc.CallOption) (*DiffResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DiffResponse)
+	err := c.cc.Invoke(ctx, UbootEnv_Diff_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ubootEnvClient) Save(ctx context.Context, in *SaveRequest, opts ...grpc.CallOption) (*SaveResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SaveResponse)
+	err := c.cc.Invoke(ctx, UbootEnv_Save_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ubootEnvClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, UbootEnv_Verify_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ubootEnvClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &UbootEnv_ServiceDesc.Streams[0], UbootEnv_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, WatchEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type UbootEnv_WatchClient = grpc.ServerStreamingClient[WatchEvent]
+
+// UbootEnvServer is the server API for UbootEnv service.
+// All implementations must embed UnimplementedUbootEnvServer
+// for forward compatibility.
+type UbootEnvServer interface {
+	// Get returns the requested variables, all of them if no names are
+	// given. Unsaved changes are included.
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// Set changes variables, an empty value removes the variable. The
+	// changes are kept in memory until Save is called.
+	Set(context.Context, *SetRequest) (*SetResponse, error)
+	// Diff returns the unsaved changes.
+	Diff(context.Context, *DiffRequest) (*DiffResponse, error)
+	// Save writes the environment to its storage.
+	Save(context.Context, *SaveRequest) (*SaveResponse, error)
+	// Verify checks that the stored environment is intact and matches
+	// the saved state.
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	// Watch streams the changes of the stored environment.
+	Watch(*WatchRequest, grpc.ServerStreamingServer[WatchEvent]) error
+	mustEmbedUnimplementedUbootEnvServer()
+}
+
+// UnimplementedUbootEnvServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedUbootEnvServer struct{}
+
+func (UnimplementedUbootEnvServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedUbootEnvServer) Set(context.Context, *SetRequest) (*SetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Set not implemented")
+}
+func (UnimplementedUbootEnvServer) Diff(context.Context, *DiffRequest) (*DiffResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Diff not implemented")
+}
+func (UnimplementedUbootEnvServer) Save(context.Context, *SaveRequest) (*SaveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Save not implemented")
+}
+func (UnimplementedUbootEnvServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedUbootEnvServer) Watch(*WatchRequest, grpc.ServerStreamingServer[WatchEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedUbootEnvServer) mustEmbedUnimplementedUbootEnvServer() {}
+func (UnimplementedUbootEnvServer) testEmbeddedByValue()                  {}
+
+// UnsafeUbootEnvServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UbootEnvServer will
+// result in compilation errors.
+type UnsafeUbootEnvServer interface {
+	mustEmbedUnimplementedUbootEnvServer()
+}
+
+func RegisterUbootEnvServer(s grpc.ServiceRegistrar, srv UbootEnvServer) {
+	// If the following call pancis, it indicates UnimplementedUbootEnvServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&UbootEnv_ServiceDesc, srv)
+}
+
+func _UbootEnv_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UbootEnvServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UbootEnv_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UbootEnvServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UbootEnv_Set_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UbootEnvServer).Set(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UbootEnv_Set_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UbootEnvServer).Set(ctx, req.(*SetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UbootEnv_Diff_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DiffRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UbootEnvServer).Diff(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UbootEnv_Diff_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UbootEnvServer).Diff(ctx, req.(*DiffRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UbootEnv_Save_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SaveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UbootEnvServer).Save(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UbootEnv_Save_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UbootEnvServer).Save(ctx, req.(*SaveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UbootEnv_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UbootEnvServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UbootEnv_Verify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UbootEnvServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UbootEnv_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UbootEnvServer).Watch(m, &grpc.GenericServerStream[WatchRequest, WatchEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type UbootEnv_WatchServer = grpc.ServerStreamingServer[WatchEvent]
+
+// UbootEnv_ServiceDesc is the grpc.ServiceDesc for UbootEnv service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var UbootEnv_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "uenv.v1.UbootEnv",
+	HandlerType: (*UbootEnvServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _UbootEnv_Get_Handler,
+		},
+		{
+			MethodName: "Set",
+			Handler:    _UbootEnv_Set_Handler,
+		},
+		{
+			MethodName: "Diff",
+			Handler:    _UbootEnv_Diff_Handler,
+		},
+		{
+			MethodName: "Save",
+			Handler:    _UbootEnv_Save_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _UbootEnv_Verify_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _UbootEnv_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "uenv.proto",
+}
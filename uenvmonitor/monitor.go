@@ -0,0 +1,188 @@
+// Package uenvmonitor periodically checks the copies of a uboot
+// environment, e.g. to notice failing flash before all copies are
+// lost.
+package uenvmonitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// DefaultInterval is the time between checks when none is configured
+const DefaultInterval = time.Hour
+
+// timeNow can be mocked in tests
+var timeNow = time.Now
+
+// Report is the outcome of a single check
+type Report struct {
+	Time time.Time
+	// Redundant is set for environments kept in two copies
+	Redundant bool
+	// Status is the state of the copies as found by the check, it
+	// is only set for redundant environments
+	Status uenv.RedundancyStatus
+	// Repaired is set if a copy was rewritten from the other one
+	Repaired *uenv.RepairReport
+	// Err is set if the environment could not be read or repaired
+	Err error
+}
+
+// Healthy returns true if the environment could be read and all its
+// copies were intact when it was checked.
+func (r *Report) Healthy() bool {
+	return r.Err == nil && (!r.Redundant || r.Status.Healthy())
+}
+
+// Monitor checks an environment in regular intervals
+type Monitor struct {
+	open func() (*uenv.Env, error)
+
+	// Interval is the time between checks, DefaultInterval if zero
+	Interval time.Duration
+	// Repair allows rewriting a bad copy of a redundant environment
+	// from the good one
+	Repair bool
+	// Logger gets a record for every check if set
+	Logger *slog.Logger
+
+	mu       sync.Mutex
+	last     *Report
+	checks   int
+	failures int
+	repairs  int
+}
+
+// New returns a monitor that uses open to get the environment for
+// every check. The environment is closed after the check.
+func New(open func() (*uenv.Env, error)) *Monitor {
+	return &Monitor{open: open}
+}
+
+// Check checks the environment once and repairs it if allowed
+func (m *Monitor) Check() *Report {
+	res := &Report{Time: timeNow()}
+	env, err := m.open()
+	if err != nil {
+		res.Err = err
+	} else {
+		res.Status, res.Redundant = env.RedundancyStatus()
+		if res.Redundant && !res.Status.Healthy() && m.Repair {
+			res.Repaired, res.Err = env.Repair()
+		}
+		env.Close()
+	}
+
+	m.mu.Lock()
+	m.last = res
+	m.checks++
+	if !res.Healthy() {
+		m.failures++
+	}
+	if res.Repaired != nil {
+		m.repairs++
+	}
+	m.mu.Unlock()
+
+	m.log(res)
+	return res
+}
+
+func (m *Monitor) log(res *Report) {
+	if m.Logger == nil {
+		return
+	}
+	switch {
+	case res.Err != nil:
+		m.Logger.Error("environment check failed", "err", res.Err)
+	case res.Repaired != nil:
+		m.Logger.Warn("repaired environment", "copy", res.Repaired.Copy, "problem", res.Repaired.Problem)
+	case !res.Healthy():
+		for i, c := range res.Status.Copies {
+			if !c.Valid {
+				m.Logger.Warn("bad copy of environment", "copy", i, "err", c.Err)
+			}
+		}
+		if res.Status.Copies[0].Valid && res.Status.Copies[1].Valid {
+			m.Logger.Warn("copies of environment have the same flags", "flags", res.Status.Copies[0].Flags)
+		}
+	default:
+		m.Logger.Debug("environment is healthy")
+	}
+}
+
+// Last returns the result of the latest check or nil
+func (m *Monitor) Last() *Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+// Run checks the environment right away and then every Interval until
+// the context is done.
+func (m *Monitor) Run(ctx context.Context) error {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.Check()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// WriteMetrics writes the counters and the latest result in the
+// Prometheus text format
+func (m *Monitor) WriteMetrics(w io.Writer) error {
+	m.mu.Lock()
+	last, checks, failures, repairs := m.last, m.checks, m.failures, m.repairs
+	m.mu.Unlock()
+
+	var buf bytes.Buffer
+	metric := func(name, typ, help, labels string, value int64) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n%s%s %d\n", name, help, name, typ, name, labels, value)
+	}
+	metric("uboot_env_monitor_checks_total", "counter", "Number of checks of the environment.", "", int64(checks))
+	metric("uboot_env_monitor_failures_total", "counter", "Number of checks that found a problem.", "", int64(failures))
+	metric("uboot_env_monitor_repairs_total", "counter", "Number of copies that were repaired.", "", int64(repairs))
+	if last != nil {
+		metric("uboot_env_monitor_healthy", "gauge", "Whether the latest check found no problem.", "", int64(boolValue(last.Healthy())))
+		metric("uboot_env_monitor_last_check_timestamp_seconds", "gauge", "Time of the latest check.", "", last.Time.Unix())
+		if last.Redundant {
+			fmt.Fprintf(&buf, "# HELP uboot_env_monitor_copy_valid Whether the copy had a valid CRC in the latest check.\n# TYPE uboot_env_monitor_copy_valid gauge\n")
+			for i, c := range last.Status.Copies {
+				fmt.Fprintf(&buf, "uboot_env_monitor_copy_valid{copy=\"%d\"} %d\n", i, boolValue(c.Valid))
+			}
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP implements http.Handler
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteMetrics(w)
+}
@@ -0,0 +1,174 @@
+package uenvmonitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type monitorTestSuite struct {
+	env1, env2 string
+}
+
+var _ = Suite(&monitorTestSuite{})
+
+func (s *monitorTestSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	s.env1 = filepath.Join(dir, "uboot.env")
+	s.env2 = filepath.Join(dir, "uboot-redund.env")
+	env, err := uenv.CreateRedundant(s.env1, s.env2, 64, uenv.Options{})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	timeNow = func() time.Time { return time.Unix(1700000000, 0) }
+}
+
+func (s *monitorTestSuite) TearDownTest(c *C) {
+	timeNow = time.Now
+}
+
+func (s *monitorTestSuite) open() (*uenv.Env, error) {
+	return uenv.OpenRedundant(s.env1, s.env2, uenv.Options{})
+}
+
+func (s *monitorTestSuite) corrupt(c *C, fname string) {
+	f, err := os.OpenFile(fname, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	_, err = f.WriteAt([]byte("x"), 10)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+}
+
+func (s *monitorTestSuite) TestCheckHealthy(c *C) {
+	m := New(s.open)
+	res := m.Check()
+	c.Assert(res.Err, IsNil)
+	c.Check(res.Redundant, Equals, true)
+	c.Check(res.Healthy(), Equals, true)
+	c.Check(res.Repaired, IsNil)
+	c.Check(m.Last(), Equals, res)
+}
+
+func (s *monitorTestSuite) TestCheckBadCopy(c *C) {
+	s.corrupt(c, s.env2)
+
+	m := New(s.open)
+	res := m.Check()
+	c.Assert(res.Err, IsNil)
+	c.Check(res.Healthy(), Equals, false)
+	c.Check(res.Status.Copies[0].Valid, Equals, true)
+	c.Check(res.Status.Copies[1].Valid, Equals, false)
+	c.Check(res.Repaired, IsNil)
+
+	// nothing was repaired without permission
+	c.Check(m.Check().Healthy(), Equals, false)
+}
+
+func (s *monitorTestSuite) TestCheckRepair(c *C) {
+	s.corrupt(c, s.env2)
+
+	m := New(s.open)
+	m.Repair = true
+	res := m.Check()
+	c.Assert(res.Err, IsNil)
+	c.Check(res.Healthy(), Equals, false)
+	c.Assert(res.Repaired, NotNil)
+	c.Check(res.Repaired.Copy, Equals, 1)
+
+	res = m.Check()
+	c.Check(res.Healthy(), Equals, true)
+	c.Check(res.Repaired, IsNil)
+
+	// the bad copy was rewritten from the good one
+	env1, err := uenv.Open(s.env1)
+	c.Assert(err, IsNil)
+	env2, err := uenv.Open(s.env2)
+	c.Assert(err, IsNil)
+	c.Check(env2.String(), Equals, env1.String())
+}
+
+func (s *monitorTestSuite) TestCheckOpenError(c *C) {
+	m := New(func() (*uenv.Env, error) {
+		return nil, errors.New("boom")
+	})
+	res := m.Check()
+	c.Check(res.Err, ErrorMatches, "boom")
+	c.Check(res.Healthy(), Equals, false)
+}
+
+func (s *monitorTestSuite) TestMetrics(c *C) {
+	m := New(s.open)
+	var buf bytes.Buffer
+	c.Assert(m.WriteMetrics(&buf), IsNil)
+	c.Check(buf.String(), Equals, `# HELP uboot_env_monitor_checks_total Number of checks of the environment.
+# TYPE uboot_env_monitor_checks_total counter
+uboot_env_monitor_checks_total 0
+# HELP uboot_env_monitor_failures_total Number of checks that found a problem.
+# TYPE uboot_env_monitor_failures_total counter
+uboot_env_monitor_failures_total 0
+# HELP uboot_env_monitor_repairs_total Number of copies that were repaired.
+# TYPE uboot_env_monitor_repairs_total counter
+uboot_env_monitor_repairs_total 0
+`)
+
+	m.Check()
+	s.corrupt(c, s.env1)
+	m.Repair = true
+	m.Check()
+
+	buf.Reset()
+	c.Assert(m.WriteMetrics(&buf), IsNil)
+	c.Check(buf.String(), Equals, `# HELP uboot_env_monitor_checks_total Number of checks of the environment.
+# TYPE uboot_env_monitor_checks_total counter
+uboot_env_monitor_checks_total 2
+# HELP uboot_env_monitor_failures_total Number of checks that found a problem.
+# TYPE uboot_env_monitor_failures_total counter
+uboot_env_monitor_failures_total 1
+# HELP uboot_env_monitor_repairs_total Number of copies that were repaired.
+# TYPE uboot_env_monitor_repairs_total counter
+uboot_env_monitor_repairs_total 1
+# HELP uboot_env_monitor_healthy Whether the latest check found no problem.
+# TYPE uboot_env_monitor_healthy gauge
+uboot_env_monitor_healthy 0
+# HELP uboot_env_monitor_last_check_timestamp_seconds Time of the latest check.
+# TYPE uboot_env_monitor_last_check_timestamp_seconds gauge
+uboot_env_monitor_last_check_timestamp_seconds 1700000000
+# HELP uboot_env_monitor_copy_valid Whether the copy had a valid CRC in the latest check.
+# TYPE uboot_env_monitor_copy_valid gauge
+uboot_env_monitor_copy_valid{copy="0"} 0
+uboot_env_monitor_copy_valid{copy="1"} 1
+`)
+}
+
+func (s *monitorTestSuite) TestRun(c *C) {
+	m := New(s.open)
+	m.Interval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+
+	for {
+		m.mu.Lock()
+		checks := m.checks
+		m.mu.Unlock()
+		if checks >= 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	c.Check(<-done, Equals, context.Canceled)
+	c.Check(m.Last().Healthy(), Equals, true)
+}
@@ -0,0 +1,172 @@
+// Package uenvnetboot checks the network boot settings of an
+// environment from the host side, e.g. that the TFTP server named by
+// serverip answers and has the bootfile, before a board is rebooted
+// into a netboot that hangs.
+package uenvnetboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// DefaultPort is the port of TFTP servers
+const DefaultPort = 69
+
+// DefaultTimeout is the time the server has to answer by default
+const DefaultTimeout = 3 * time.Second
+
+// TFTP opcodes
+const (
+	opRRQ   = 1
+	opDATA  = 3
+	opERROR = 5
+	opOACK  = 6
+)
+
+// TFTPError is an error reported by the TFTP server, e.g. code 1 for
+// a file that does not exist.
+type TFTPError struct {
+	Code uint16
+	Msg  string
+}
+
+func (e *TFTPError) Error() string {
+	return fmt.Sprintf("tftp error %d: %s", e.Code, e.Msg)
+}
+
+// NotFound returns true if the server does not have the file.
+func (e *TFTPError) NotFound() bool {
+	return e.Code == 1
+}
+
+// Options alter how the server is checked.
+type Options struct {
+	// Port is the port of the server, DefaultPort if zero.
+	Port int
+	// Timeout is the time the server has to answer, DefaultTimeout
+	// if zero.
+	Timeout time.Duration
+}
+
+func (opts *Options) port() int {
+	if opts.Port > 0 {
+		return opts.Port
+	}
+	return DefaultPort
+}
+
+func (opts *Options) timeout() time.Duration {
+	if opts.Timeout > 0 {
+		return opts.Timeout
+	}
+	return DefaultTimeout
+}
+
+// CheckFile asks the TFTP server for the file like U-Boot does and
+// aborts the transfer once the server starts to send it. The size is
+// the one the server announces and -1 if it does not support the
+// tsize option.
+func CheckFile(server, file string, opts Options) (size int64, err error) {
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(server, strconv.Itoa(opts.port())))
+	if err != nil {
+		return 0, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var rrq bytes.Buffer
+	binary.Write(&rrq, binary.BigEndian, uint16(opRRQ))
+	for _, s := range []string{file, "octet", "tsize", "0"} {
+		rrq.WriteString(s)
+		rrq.WriteByte(0)
+	}
+	if _, err := conn.WriteToUDP(rrq.Bytes(), raddr); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(opts.timeout()))
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return 0, fmt.Errorf("tftp server %s does not answer", raddr)
+		}
+		if err != nil {
+			return 0, err
+		}
+		// the server answers from a new port
+		if !addr.IP.Equal(raddr.IP) || n < 4 {
+			continue
+		}
+		switch binary.BigEndian.Uint16(buf) {
+		case opERROR:
+			return 0, &TFTPError{Code: binary.BigEndian.Uint16(buf[2:]), Msg: string(bytes.TrimRight(buf[4:n], "\x00"))}
+		case opOACK:
+			size = -1
+			opts := bytes.Split(bytes.TrimRight(buf[2:n], "\x00"), []byte{0})
+			for i := 0; i+1 < len(opts); i += 2 {
+				if string(bytes.ToLower(opts[i])) == "tsize" {
+					size, _ = strconv.ParseInt(string(opts[i+1]), 10, 64)
+				}
+			}
+			abort(conn, addr)
+			return size, nil
+		case opDATA:
+			abort(conn, addr)
+			return -1, nil
+		}
+	}
+}
+
+// abort ends the transfer so that the server does not retransmit
+func abort(conn *net.UDPConn, addr *net.UDPAddr) {
+	msg := []byte{0, opERROR, 0, 0}
+	msg = append(msg, "transfer aborted"...)
+	conn.WriteToUDP(append(msg, 0), addr)
+}
+
+// FileCheck is the outcome of checking one file of the environment.
+type FileCheck struct {
+	// Variable is the variable naming the file, e.g. "bootfile".
+	Variable string
+	File     string
+	// Size is the size announced by the server, -1 if unknown.
+	Size int64
+	// Err is why the file cannot be loaded.
+	Err error
+}
+
+// CheckEnv checks that the TFTP server in serverip has the files named
+// by the given variables of the environment, "bootfile" if none are
+// given. The error is only set if the environment lacks serverip.
+func CheckEnv(env *uenv.Env, opts Options, variables ...string) ([]FileCheck, error) {
+	server := env.Get("serverip")
+	if server == "" {
+		return nil, errors.New("serverip is not set")
+	}
+	if len(variables) == 0 {
+		variables = []string{"bootfile"}
+	}
+	results := make([]FileCheck, 0, len(variables))
+	for _, name := range variables {
+		r := FileCheck{Variable: name, File: env.Get(name)}
+		if r.File == "" {
+			r.Err = fmt.Errorf("%s is not set", name)
+		} else {
+			r.Size, r.Err = CheckFile(server, r.File, opts)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
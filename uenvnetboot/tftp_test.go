@@ -0,0 +1,138 @@
+package uenvnetboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type netbootTestSuite struct {
+	server  *net.UDPConn
+	opts    Options
+	aborted chan bool
+
+	// mu protects tsize, which the server goroutine reads
+	mu sync.Mutex
+	// tsize makes the fake server support the tsize option
+	tsize bool
+}
+
+var _ = Suite(&netbootTestSuite{})
+
+var serverFiles = map[string]int{
+	"zImage":  4 << 20,
+	"rpi.dtb": 30000,
+}
+
+func (s *netbootTestSuite) SetUpTest(c *C) {
+	var err error
+	s.server, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, IsNil)
+	s.opts = Options{Port: s.server.LocalAddr().(*net.UDPAddr).Port, Timeout: 2 * time.Second}
+	s.setTsize(true)
+	s.aborted = make(chan bool, 1)
+	go s.serve(s.server, s.aborted)
+}
+
+func (s *netbootTestSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *netbootTestSuite) setTsize(tsize bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tsize = tsize
+}
+
+func (s *netbootTestSuite) supportsTsize() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tsize
+}
+
+// serve answers read requests like a TFTP server, from a new port. It
+// gets the connection and channel of its test so that it does not race
+// with the setup of the next test.
+func (s *netbootTestSuite) serve(server *net.UDPConn, aborted chan<- bool) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := server.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		fields := bytes.Split(buf[2:n], []byte{0})
+		size, ok := serverFiles[string(fields[0])]
+		tid, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return
+		}
+		switch {
+		case !ok:
+			tid.WriteToUDP(append([]byte{0, opERROR, 0, 1}, "File not found\x00"...), addr)
+		case s.supportsTsize():
+			tid.WriteToUDP(append([]byte{0, opOACK}, fmt.Sprintf("tsize\x00%d\x00", size)...), addr)
+		default:
+			tid.WriteToUDP(append([]byte{0, opDATA, 0, 1}, make([]byte, 512)...), addr)
+		}
+		if ok {
+			tid.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := tid.ReadFromUDP(buf)
+			aborted <- err == nil && n >= 2 && binary.BigEndian.Uint16(buf) == opERROR
+		}
+		tid.Close()
+	}
+}
+
+func (s *netbootTestSuite) TestCheckFile(c *C) {
+	size, err := CheckFile("127.0.0.1", "zImage", s.opts)
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, int64(4<<20))
+	c.Check(<-s.aborted, Equals, true)
+
+	s.setTsize(false)
+	size, err = CheckFile("127.0.0.1", "zImage", s.opts)
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, int64(-1))
+	c.Check(<-s.aborted, Equals, true)
+}
+
+func (s *netbootTestSuite) TestCheckFileNotFound(c *C) {
+	_, err := CheckFile("127.0.0.1", "uImage", s.opts)
+	c.Assert(err, ErrorMatches, "tftp error 1: File not found")
+	c.Check(err.(*TFTPError).NotFound(), Equals, true)
+}
+
+func (s *netbootTestSuite) TestCheckFileNoServer(c *C) {
+	s.server.Close()
+	s.opts.Timeout = 100 * time.Millisecond
+	_, err := CheckFile("127.0.0.1", "zImage", s.opts)
+	c.Check(err, NotNil)
+}
+
+func (s *netbootTestSuite) TestCheckEnv(c *C) {
+	env, err := uenv.NewEnv(4096)
+	c.Assert(err, IsNil)
+	_, err = CheckEnv(env, s.opts)
+	c.Check(err, ErrorMatches, "serverip is not set")
+
+	env.Set("serverip", "127.0.0.1")
+	env.Set("bootfile", "zImage")
+	env.Set("fdtfile", "missing.dtb")
+	results, err := CheckEnv(env, s.opts, "bootfile", "fdtfile", "ramdisk_file")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 3)
+	c.Check(results[0], DeepEquals, FileCheck{Variable: "bootfile", File: "zImage", Size: 4 << 20})
+	c.Check(results[1].Err, ErrorMatches, "tftp error 1: File not found")
+	c.Check(results[2].Err, ErrorMatches, "ramdisk_file is not set")
+}
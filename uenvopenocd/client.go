@@ -0,0 +1,159 @@
+// Package uenvopenocd accesses a uboot environment on a halted target
+// through the TCL interface of OpenOCD, e.g. to repair a broken
+// environment during bring-up without booting anything.
+package uenvopenocd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAddr is where OpenOCD listens for TCL commands by default
+const DefaultAddr = "localhost:6666"
+
+// terminator ends commands and replies of the TCL interface
+const terminator = 0x1a
+
+// chunkSize is the number of bytes read or written per command
+const chunkSize = 1024
+
+// Client sends commands to the TCL interface of OpenOCD.
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// Timeout limits the time a command may take, zero means no
+	// limit.
+	Timeout time.Duration
+}
+
+// Dial connects to the TCL interface of OpenOCD at addr.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Close closes the connection to OpenOCD.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// eval sends a command and returns its result
+func (c *Client) eval(cmd string) (string, error) {
+	if c.Timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.Timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	if _, err := c.conn.Write(append([]byte(cmd), terminator)); err != nil {
+		return "", err
+	}
+	reply, err := c.r.ReadString(terminator)
+	if err != nil {
+		return "", err
+	}
+	return reply[:len(reply)-1], nil
+}
+
+// Command runs an OpenOCD command and returns its result. The TCL
+// interface does not report failures, so the command is run in catch
+// and the message of a failed command is returned as error.
+func (c *Client) Command(cmd string) (string, error) {
+	status, err := c.eval(fmt.Sprintf("catch {%s} _uenv_result", cmd))
+	if err != nil {
+		return "", err
+	}
+	result, err := c.eval("set _uenv_result")
+	if err != nil {
+		return "", err
+	}
+	if status != "0" {
+		return "", fmt.Errorf("openocd: %s: %s", strings.Fields(cmd)[0], result)
+	}
+	return result, nil
+}
+
+// Halt halts the target.
+func (c *Client) Halt() error {
+	_, err := c.Command("halt")
+	return err
+}
+
+// ReadMemory reads n bytes at the given address of the target.
+func (c *Client) ReadMemory(addr uint64, n int) ([]byte, error) {
+	data := make([]byte, 0, n)
+	for len(data) < n {
+		count := n - len(data)
+		if count > chunkSize {
+			count = chunkSize
+		}
+		result, err := c.Command(fmt.Sprintf("read_memory 0x%x 8 %d", addr+uint64(len(data)), count))
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(result)
+		if len(fields) != count {
+			return nil, fmt.Errorf("openocd: read_memory returned %d bytes, expected %d", len(fields), count)
+		}
+		for _, f := range fields {
+			b, err := strconv.ParseUint(f, 0, 8)
+			if err != nil {
+				return nil, fmt.Errorf("openocd: cannot parse read_memory result %q", f)
+			}
+			data = append(data, byte(b))
+		}
+	}
+	return data, nil
+}
+
+// WriteMemory writes data to the given address of the target, e.g. to
+// RAM or memory mapped registers. Flash is written with WriteFlash.
+func (c *Client) WriteMemory(addr uint64, data []byte) error {
+	for off := 0; off < len(data); off += chunkSize {
+		end := off + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		var cmd strings.Builder
+		fmt.Fprintf(&cmd, "write_memory 0x%x 8 {", addr+uint64(off))
+		for i, b := range data[off:end] {
+			if i > 0 {
+				cmd.WriteByte(' ')
+			}
+			fmt.Fprintf(&cmd, "0x%02x", b)
+		}
+		cmd.WriteByte('}')
+		if _, err := c.Command(cmd.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFlash erases the flash sectors at the given address and writes
+// data to them. OpenOCD reads the data from a temporary file, so it
+// needs to run on the same machine.
+func (c *Client) WriteFlash(addr uint64, data []byte) error {
+	f, err := os.CreateTemp("", "uenv-openocd-*.bin")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Command(fmt.Sprintf("flash write_image erase {%s} 0x%x bin", f.Name(), addr))
+	return err
+}
@@ -0,0 +1,79 @@
+package uenvopenocd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Storage keeps an environment at a fixed address of the target, it
+// implements uenv.Storage.
+type Storage struct {
+	client *Client
+	// Addr is the address of the environment on the target.
+	Addr uint64
+	// Size is the size of the environment.
+	Size int
+	// Flash selects writing with WriteFlash instead of WriteMemory,
+	// for environments in memory mapped NOR flash.
+	Flash bool
+}
+
+// NewStorage returns the storage of the environment of the given size
+// at addr, accessed through the client.
+func NewStorage(client *Client, addr uint64, size int, flash bool) *Storage {
+	return &Storage{client: client, Addr: addr, Size: size, Flash: flash}
+}
+
+// Open halts the target behind the OpenOCD at openocdAddr and opens
+// the environment at the given address.
+func Open(openocdAddr string, addr uint64, size int, flash bool, opts uenv.Options) (*uenv.Env, error) {
+	client, err := Dial(openocdAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Halt(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	env, err := uenv.OpenStorage(NewStorage(client, addr, size, flash), opts)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return env, nil
+}
+
+func (s *Storage) ReadImage() ([]byte, error) {
+	return s.client.ReadMemory(s.Addr, s.Size)
+}
+
+func (s *Storage) WriteImage(size int, fill func(w io.WriterAt) error) error {
+	if size != s.Size {
+		return fmt.Errorf("cannot write environment of size %d to storage of size %d", size, s.Size)
+	}
+	buf := make(bufferWriter, size)
+	if err := fill(buf); err != nil {
+		return err
+	}
+	if s.Flash {
+		return s.client.WriteFlash(s.Addr, buf)
+	}
+	return s.client.WriteMemory(s.Addr, buf)
+}
+
+// Close closes the connection to OpenOCD, the target stays halted.
+func (s *Storage) Close() error {
+	return s.client.Close()
+}
+
+// bufferWriter implements io.WriterAt on top of a fixed size slice
+type bufferWriter []byte
+
+func (b bufferWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(b)) {
+		return 0, fmt.Errorf("write of %d bytes at offset %d exceeds size %d", len(p), off, len(b))
+	}
+	return copy(b[off:], p), nil
+}
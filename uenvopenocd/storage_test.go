@@ -0,0 +1,185 @@
+package uenvopenocd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type openocdTestSuite struct {
+	target *fakeTarget
+	addr   string
+}
+
+var _ = Suite(&openocdTestSuite{})
+
+// fakeTarget implements the commands of the OpenOCD TCL interface that
+// the client uses on a memory of 64KiB
+type fakeTarget struct {
+	mu       sync.Mutex
+	mem      []byte
+	halted   bool
+	commands []string
+	result   string
+}
+
+var (
+	catchRe = regexp.MustCompile(`^catch \{(.*)\} _uenv_result$`)
+	readRe  = regexp.MustCompile(`^read_memory (0x[0-9a-f]+) 8 (\d+)$`)
+	writeRe = regexp.MustCompile(`^write_memory (0x[0-9a-f]+) 8 \{(.*)\}$`)
+	flashRe = regexp.MustCompile(`^flash write_image erase \{(.*)\} (0x[0-9a-f]+) bin$`)
+)
+
+func (t *fakeTarget) run(cmd string) (string, error) {
+	t.commands = append(t.commands, strings.Fields(cmd)[0])
+	if cmd == "halt" {
+		t.halted = true
+		return "", nil
+	}
+	if !t.halted {
+		return "", fmt.Errorf("Target not halted")
+	}
+	if m := readRe.FindStringSubmatch(cmd); m != nil {
+		addr, _ := strconv.ParseUint(m[1], 0, 64)
+		n, _ := strconv.Atoi(m[2])
+		var out []string
+		for _, b := range t.mem[addr : addr+uint64(n)] {
+			out = append(out, fmt.Sprintf("0x%02x", b))
+		}
+		return strings.Join(out, " "), nil
+	}
+	if m := writeRe.FindStringSubmatch(cmd); m != nil {
+		addr, _ := strconv.ParseUint(m[1], 0, 64)
+		for i, f := range strings.Fields(m[2]) {
+			b, _ := strconv.ParseUint(f, 0, 8)
+			t.mem[addr+uint64(i)] = byte(b)
+		}
+		return "", nil
+	}
+	if m := flashRe.FindStringSubmatch(cmd); m != nil {
+		addr, _ := strconv.ParseUint(m[2], 0, 64)
+		data, err := os.ReadFile(m[1])
+		if err != nil {
+			return "", err
+		}
+		copy(t.mem[addr:], data)
+		return "", nil
+	}
+	return "", fmt.Errorf("invalid command name %q", cmd)
+}
+
+func (t *fakeTarget) eval(cmd string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if cmd == "set _uenv_result" {
+		return t.result
+	}
+	m := catchRe.FindStringSubmatch(cmd)
+	if m == nil {
+		return "unexpected command"
+	}
+	result, err := t.run(m[1])
+	if err != nil {
+		t.result = err.Error()
+		return "1"
+	}
+	t.result = result
+	return "0"
+}
+
+func (t *fakeTarget) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				cmd, err := r.ReadString(terminator)
+				if err != nil {
+					return
+				}
+				reply := t.eval(cmd[:len(cmd)-1])
+				conn.Write(append([]byte(reply), terminator))
+			}
+		}()
+	}
+}
+
+func (s *openocdTestSuite) SetUpTest(c *C) {
+	s.target = &fakeTarget{mem: make([]byte, 64*1024)}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.addr = l.Addr().String()
+	go s.target.serve(l)
+	// the listener is closed with the test process, connections are
+	// closed by the tests
+}
+
+func (s *openocdTestSuite) TestCreateAndOpenInMemory(c *C) {
+	client, err := Dial(s.addr)
+	c.Assert(err, IsNil)
+	c.Assert(client.Halt(), IsNil)
+	env, err := uenv.CreateStorage(NewStorage(client, 0x2000, 4096, false), 4096, uenv.Options{})
+	c.Assert(err, IsNil)
+	env.Set("bootcmd", "run recovery")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Close(), IsNil)
+
+	env, err = Open(s.addr, 0x2000, 4096, false, uenv.Options{})
+	c.Assert(err, IsNil)
+	defer env.Close()
+	c.Assert(env.Get("bootcmd"), Equals, "run recovery")
+	c.Assert(s.target.mem[0x2000+4:0x2000+4+7], DeepEquals, []byte("bootcmd"))
+}
+
+func (s *openocdTestSuite) TestFlash(c *C) {
+	client, err := Dial(s.addr)
+	c.Assert(err, IsNil)
+	c.Assert(client.Halt(), IsNil)
+	env, err := uenv.CreateStorage(NewStorage(client, 0x8000, 2048, true), 2048, uenv.Options{})
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+	c.Assert(env.Close(), IsNil)
+
+	c.Assert(s.target.commands, DeepEquals, []string{
+		"halt",
+		"flash",
+		// the unchanged check of Save
+		"read_memory", "read_memory",
+		"flash",
+	})
+	env, err = Open(s.addr, 0x8000, 2048, true, uenv.Options{})
+	c.Assert(err, IsNil)
+	defer env.Close()
+	c.Assert(env.Get("foo"), Equals, "bar")
+}
+
+func (s *openocdTestSuite) TestCommandError(c *C) {
+	client, err := Dial(s.addr)
+	c.Assert(err, IsNil)
+	defer client.Close()
+	_, err = client.ReadMemory(0, 16)
+	c.Assert(err, ErrorMatches, "openocd: read_memory: Target not halted")
+
+	// Open halts the target
+	_, err = Open(s.addr, 0, 4096, false, uenv.Options{})
+	c.Assert(err, ErrorMatches, "environment is not initialized: all bytes are 0x00")
+}
@@ -0,0 +1,178 @@
+// Package uenvserial reads and fixes the environment of a running
+// U-Boot through its serial console, e.g. on boards that no longer
+// boot Linux.
+package uenvserial
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// DefaultPrompt is the prompt of U-Boot's default configuration
+const DefaultPrompt = "=> "
+
+// DefaultTimeout is the time a command may take by default
+const DefaultTimeout = 10 * time.Second
+
+// pollInterval is the read timeout of serial ports, it limits how
+// late a command timeout is noticed
+const pollInterval = 100 * time.Millisecond
+
+// Client runs commands on the U-Boot console.
+type Client struct {
+	rw io.ReadWriteCloser
+
+	// Prompt is the prompt of the U-Boot console, CONFIG_SYS_PROMPT.
+	Prompt string
+	// Timeout limits the time a command may take.
+	Timeout time.Duration
+}
+
+// Open opens the serial port, e.g. /dev/ttyUSB0, with the given baud
+// rate and waits for the U-Boot prompt. U-Boot must already be
+// stopped at its prompt or stop there when a key is pressed.
+func Open(port string, baud int) (*Client, error) {
+	p, err := serial.Open(port, &serial.Mode{BaudRate: baud})
+	if err != nil {
+		return nil, err
+	}
+	if err := p.SetReadTimeout(pollInterval); err != nil {
+		p.Close()
+		return nil, err
+	}
+	c := NewClient(p)
+	if err := c.Sync(); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("cannot find U-Boot prompt on %s: %v", port, err)
+	}
+	return c, nil
+}
+
+// NewClient returns a client for a console that is already open, e.g.
+// a serial port opened elsewhere or a network console.
+func NewClient(rw io.ReadWriteCloser) *Client {
+	return &Client{rw: rw, Prompt: DefaultPrompt, Timeout: DefaultTimeout}
+}
+
+// Close closes the console.
+func (c *Client) Close() error {
+	return c.rw.Close()
+}
+
+// Sync discards any partial command line and waits for the prompt.
+// It also stops an autoboot countdown.
+func (c *Client) Sync() error {
+	// ctrl-c aborts the line that is being typed
+	if _, err := c.rw.Write([]byte("\x03\n")); err != nil {
+		return err
+	}
+	_, err := c.readUntilPrompt()
+	return err
+}
+
+// readUntilPrompt returns everything up to the next prompt
+func (c *Client) readUntilPrompt() (string, error) {
+	deadline := time.Now().Add(c.Timeout)
+	var out []byte
+	buf := make([]byte, 512)
+	for {
+		if bytes.HasSuffix(out, []byte(c.Prompt)) {
+			return string(out[:len(out)-len(c.Prompt)]), nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("timeout waiting for prompt %q, got %q", c.Prompt, out)
+		}
+		n, err := c.rw.Read(buf)
+		out = append(out, buf[:n]...)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// Command runs a command and returns its output without the echo of
+// the command and with "\n" line endings.
+func (c *Client) Command(cmd string) (string, error) {
+	if strings.ContainsAny(cmd, "\r\n") {
+		return "", fmt.Errorf("cannot run command %q: contains a newline", cmd)
+	}
+	if _, err := c.rw.Write([]byte(cmd + "\n")); err != nil {
+		return "", err
+	}
+	out, err := c.readUntilPrompt()
+	if err != nil {
+		return "", err
+	}
+	out = strings.ReplaceAll(out, "\r\n", "\n")
+	// the console echoes the command
+	if echo, rest, ok := strings.Cut(out, "\n"); ok && strings.TrimSpace(echo) == cmd {
+		out = rest
+	}
+	return out, nil
+}
+
+// Printenv returns the variables of the running U-Boot.
+func (c *Client) Printenv() (map[string]string, error) {
+	out, err := c.Command("printenv")
+	if err != nil {
+		return nil, err
+	}
+	vars, _, err := uenv.ReadConsoleLog(strings.NewReader(out), uenv.ConsoleOptions{})
+	return vars, err
+}
+
+// ReadEnv returns the environment of the running U-Boot as an
+// environment that is only kept in memory, with the size U-Boot
+// reports.
+func (c *Client) ReadEnv() (*uenv.Env, error) {
+	out, err := c.Command("printenv")
+	if err != nil {
+		return nil, err
+	}
+	return uenv.NewConsoleLogEnv(strings.NewReader(out), 0, uenv.ConsoleOptions{})
+}
+
+// Setenv sets a variable of the running U-Boot, an empty value removes
+// it. The change is lost at reset unless Saveenv is called.
+func (c *Client) Setenv(name, value string) error {
+	if name == "" || strings.ContainsAny(name, " \t'=;$\"\\") {
+		return fmt.Errorf("cannot set variable %q: invalid name", name)
+	}
+	cmd := "setenv " + name
+	if value != "" {
+		// the hush shell of U-Boot does not support any escapes in
+		// single quotes
+		if strings.ContainsAny(value, "'\r\n") {
+			return fmt.Errorf("cannot set variable %q: value contains a quote or newline", name)
+		}
+		cmd += " '" + value + "'"
+	}
+	out, err := c.Command(cmd)
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("cannot set variable %q: %s", name, out)
+	}
+	return nil
+}
+
+// Saveenv makes U-Boot write its environment to the storage.
+func (c *Client) Saveenv() error {
+	out, err := c.Command("saveenv")
+	if err != nil {
+		return err
+	}
+	lower := strings.ToLower(out)
+	if strings.Contains(lower, "fail") || strings.Contains(lower, "error") {
+		return fmt.Errorf("saveenv failed: %s", strings.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package uenvserial
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type serialTestSuite struct {
+	uboot *fakeUBoot
+	c     *Client
+}
+
+var _ = Suite(&serialTestSuite{})
+
+// fakeUBoot emulates the console of U-Boot with echo and prompt
+type fakeUBoot struct {
+	conn     net.Conn
+	vars     map[string]string
+	saved    bool
+	failSave bool
+	commands []string
+}
+
+func (u *fakeUBoot) serve() {
+	r := bufio.NewReader(u.conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if i := strings.LastIndexByte(line, 0x03); i >= 0 {
+			line = line[i+1:]
+		}
+		if u.commands == nil {
+			// the key stopped the autoboot countdown
+			fmt.Fprintf(u.conn, "Hit any key to stop autoboot:  3 \x08\x08\x08 0 \r\n")
+		}
+		u.commands = append(u.commands, line)
+		fmt.Fprintf(u.conn, "%s\r\n%s=> ", line, u.run(line))
+	}
+}
+
+func (u *fakeUBoot) run(line string) string {
+	fields := strings.SplitN(line, " ", 3)
+	switch fields[0] {
+	case "":
+		return ""
+	case "printenv":
+		var out []string
+		size := 0
+		for k, v := range u.vars {
+			out = append(out, k+"="+v+"\r\n")
+			size += len(k) + len(v) + 2
+		}
+		sort.Strings(out)
+		return strings.Join(out, "") + fmt.Sprintf("\r\nEnvironment size: %d/16380 bytes\r\n", size)
+	case "setenv":
+		if len(fields) == 2 {
+			delete(u.vars, fields[1])
+		} else {
+			u.vars[fields[1]] = strings.Trim(fields[2], "'")
+		}
+		return ""
+	case "saveenv":
+		if u.failSave {
+			return "Saving Environment to MMC... Writing to MMC(0)... FAILED\r\n"
+		}
+		u.saved = true
+		return "Saving Environment to MMC... Writing to MMC(0)... OK\r\n"
+	}
+	return fmt.Sprintf("Unknown command '%s' - try 'help'\r\n", fields[0])
+}
+
+func (s *serialTestSuite) SetUpTest(c *C) {
+	client, server := net.Pipe()
+	s.uboot = &fakeUBoot{conn: server, vars: map[string]string{
+		"bootcmd":   "run distro_bootcmd",
+		"bootdelay": "3",
+	}}
+	go s.uboot.serve()
+	s.c = NewClient(client)
+	s.c.Timeout = 5 * time.Second
+	c.Assert(s.c.Sync(), IsNil)
+}
+
+func (s *serialTestSuite) TearDownTest(c *C) {
+	s.c.Close()
+}
+
+func (s *serialTestSuite) TestPrintenv(c *C) {
+	vars, err := s.c.Printenv()
+	c.Assert(err, IsNil)
+	c.Check(vars, DeepEquals, map[string]string{
+		"bootcmd":   "run distro_bootcmd",
+		"bootdelay": "3",
+	})
+
+	env, err := s.c.ReadEnv()
+	c.Assert(err, IsNil)
+	c.Check(env.Size(), Equals, 16384)
+	c.Check(env.Get("bootcmd"), Equals, "run distro_bootcmd")
+}
+
+func (s *serialTestSuite) TestSetenvSaveenv(c *C) {
+	c.Assert(s.c.Setenv("bootcmd", "run recovery; reset"), IsNil)
+	c.Assert(s.c.Setenv("bootdelay", ""), IsNil)
+	c.Check(s.uboot.vars, DeepEquals, map[string]string{"bootcmd": "run recovery; reset"})
+	c.Check(s.uboot.commands[len(s.uboot.commands)-2:], DeepEquals, []string{
+		"setenv bootcmd 'run recovery; reset'",
+		"setenv bootdelay",
+	})
+
+	c.Assert(s.c.Saveenv(), IsNil)
+	c.Check(s.uboot.saved, Equals, true)
+	s.uboot.failSave = true
+	c.Check(s.c.Saveenv(), ErrorMatches, `saveenv failed: Saving Environment to MMC... Writing to MMC\(0\)... FAILED`)
+}
+
+func (s *serialTestSuite) TestSetenvInvalid(c *C) {
+	c.Check(s.c.Setenv("boot cmd", "x"), ErrorMatches, `cannot set variable "boot cmd": invalid name`)
+	c.Check(s.c.Setenv("bootcmd", "echo 'hi'"), ErrorMatches, `cannot set variable "bootcmd": value contains a quote or newline`)
+}
+
+func (s *serialTestSuite) TestCommand(c *C) {
+	out, err := s.c.Command("version")
+	c.Assert(err, IsNil)
+	c.Check(out, Equals, "Unknown command 'version' - try 'help'\n")
+	_, err = s.c.Command("echo\nreset")
+	c.Check(err, ErrorMatches, `cannot run command "echo\\nreset": contains a newline`)
+}
+
+func (s *serialTestSuite) TestTimeout(c *C) {
+	client, server := net.Pipe()
+	defer server.Close()
+	// a console that echoes nothing
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	cl := NewClient(client)
+	defer cl.Close()
+	cl.Timeout = 10 * time.Millisecond
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	c.Check(cl.Sync(), NotNil)
+}
@@ -0,0 +1,92 @@
+package uenvserial
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultNetconsolePort is the UDP port U-Boot's netconsole uses for
+// input and output by default, see ncinport and ncoutport
+const DefaultNetconsolePort = 6666
+
+// netconsole is the console of U-Boot over UDP
+type netconsole struct {
+	conn  *net.UDPConn
+	board *net.UDPAddr
+	// pending is the part of the last packet that was not read yet
+	pending []byte
+	buf     []byte
+}
+
+// DialNetconsole connects to the netconsole of the U-Boot at board,
+// an address with an optional port, and waits for the prompt. The
+// netconsole of the board must be enabled and its ncip must point to
+// this host, e.g. with "setenv ncip <host>; setenv stdin nc; setenv
+// stdout nc; setenv stderr nc". The output of the board is received
+// on local, ":6666" if empty.
+func DialNetconsole(board, local string) (*Client, error) {
+	if _, _, err := net.SplitHostPort(board); err != nil {
+		board = net.JoinHostPort(board, strconv.Itoa(DefaultNetconsolePort))
+	}
+	boardAddr, err := net.ResolveUDPAddr("udp", board)
+	if err != nil {
+		return nil, err
+	}
+	if local == "" {
+		local = fmt.Sprintf(":%d", DefaultNetconsolePort)
+	}
+	localAddr, err := net.ResolveUDPAddr("udp", local)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	c := NewClient(newNetconsole(conn, boardAddr))
+	if err := c.Sync(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot find U-Boot prompt on netconsole of %s: %v", board, err)
+	}
+	return c, nil
+}
+
+func newNetconsole(conn *net.UDPConn, board *net.UDPAddr) *netconsole {
+	return &netconsole{conn: conn, board: board, buf: make([]byte, 64*1024)}
+}
+
+// Read returns the output of the board. It returns no data without
+// error after a short time without output, so that the client can
+// check its timeout.
+func (nc *netconsole) Read(p []byte) (int, error) {
+	for len(nc.pending) == 0 {
+		nc.conn.SetReadDeadline(time.Now().Add(pollInterval))
+		n, addr, err := nc.conn.ReadFromUDP(nc.buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		// other boards may send their output to the same port
+		if !addr.IP.Equal(nc.board.IP) {
+			continue
+		}
+		nc.pending = nc.buf[:n]
+	}
+	n := copy(p, nc.pending)
+	nc.pending = nc.pending[n:]
+	return n, nil
+}
+
+func (nc *netconsole) Write(p []byte) (int, error) {
+	return nc.conn.WriteToUDP(p, nc.board)
+}
+
+func (nc *netconsole) Close() error {
+	return nc.conn.Close()
+}
@@ -0,0 +1,37 @@
+package uenvserial
+
+import (
+	"net"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *serialTestSuite) TestNetconsole(c *C) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, IsNil)
+	board, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	c.Assert(err, IsNil)
+	defer board.Close()
+
+	uboot := &fakeUBoot{conn: board, vars: map[string]string{"bootdelay": "3"}}
+	go uboot.serve()
+	client := NewClient(newNetconsole(conn, board.LocalAddr().(*net.UDPAddr)))
+	defer client.Close()
+	client.Timeout = 5 * time.Second
+	c.Assert(client.Sync(), IsNil)
+
+	c.Assert(client.Setenv("serverip", "192.168.0.1"), IsNil)
+	vars, err := client.Printenv()
+	c.Assert(err, IsNil)
+	c.Check(vars, DeepEquals, map[string]string{"bootdelay": "3", "serverip": "192.168.0.1"})
+}
+
+func (s *serialTestSuite) TestNetconsoleNoBoard(c *C) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, IsNil)
+	client := NewClient(newNetconsole(conn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: DefaultNetconsolePort}))
+	defer client.Close()
+	client.Timeout = 300 * time.Millisecond
+	c.Check(client.Sync(), ErrorMatches, `timeout waiting for prompt "=> ", got ""`)
+}
@@ -0,0 +1,281 @@
+package uenvsign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// minisign algorithms, signatures of the blake2b hash of the data are
+// the default since minisign 0.10
+var (
+	algEd       = []byte("Ed")
+	algHashedEd = []byte("ED")
+	kdfScrypt   = []byte("Sc")
+	kdfNone     = []byte{0, 0}
+	chkBlake2b  = []byte("B2")
+)
+
+// the scrypt limits of minisign, variables for the tests
+var (
+	scryptOpsLimit uint64 = 1 << 25
+	scryptMemLimit uint64 = 1 << 30
+)
+
+const (
+	untrustedPrefix = "untrusted comment: "
+	trustedPrefix   = "trusted comment: "
+)
+
+// MinisignPublicKey verifies minisign signatures.
+type MinisignPublicKey struct {
+	ID  [8]byte
+	Key ed25519.PublicKey
+}
+
+// MinisignSecretKey creates minisign signatures.
+type MinisignSecretKey struct {
+	ID  [8]byte
+	Key ed25519.PrivateKey
+}
+
+// keyID formats a key ID like minisign
+func keyID(id [8]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// decodeKeyFile returns the base64 decoded line of a key file, it is
+// either the only line or follows an untrusted comment
+func decodeKeyFile(text []byte) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(string(text), "\r", "")), "\n")
+	if len(lines) == 2 && strings.HasPrefix(lines[0], untrustedPrefix) {
+		lines = lines[1:]
+	}
+	if len(lines) != 1 {
+		return nil, errors.New("invalid minisign key file")
+	}
+	return base64.StdEncoding.DecodeString(lines[0])
+}
+
+// ParseMinisignPublicKey reads a minisign public key, either a key file
+// or just its base64 encoded line.
+func ParseMinisignPublicKey(text []byte) (*MinisignPublicKey, error) {
+	raw, err := decodeKeyFile(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 2+8+ed25519.PublicKeySize || !bytes.Equal(raw[:2], algEd) {
+		return nil, errors.New("invalid minisign public key")
+	}
+	pk := &MinisignPublicKey{Key: ed25519.PublicKey(raw[10:])}
+	copy(pk.ID[:], raw[2:10])
+	return pk, nil
+}
+
+// MarshalText returns the public key in the format of minisign key
+// files.
+func (pk *MinisignPublicKey) MarshalText() ([]byte, error) {
+	raw := append(append(append([]byte(nil), algEd...), pk.ID[:]...), pk.Key...)
+	return []byte(fmt.Sprintf("%sminisign public key %s\n%s\n", untrustedPrefix, keyID(pk.ID), base64.StdEncoding.EncodeToString(raw))), nil
+}
+
+func (pk *MinisignPublicKey) Ext() string {
+	return ".minisig"
+}
+
+// Verify checks a minisign signature of the data, including its
+// trusted comment.
+func (pk *MinisignPublicKey) Verify(data, sig []byte) error {
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(string(sig), "\r", ""), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], untrustedPrefix) || !strings.HasPrefix(lines[2], trustedPrefix) {
+		return errors.New("invalid minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	var id [8]byte
+	copy(id[:], raw[2:10])
+	if id != pk.ID {
+		return fmt.Errorf("signature is from key %s, not %s", keyID(id), keyID(pk.ID))
+	}
+
+	msg := data
+	switch {
+	case bytes.Equal(raw[:2], algHashedEd):
+		h := blake2b.Sum512(data)
+		msg = h[:]
+	case !bytes.Equal(raw[:2], algEd):
+		return fmt.Errorf("unsupported signature algorithm %q", raw[:2])
+	}
+	if !ed25519.Verify(pk.Key, msg, raw[10:]) {
+		return errors.New("invalid signature")
+	}
+	trusted := append(append([]byte(nil), raw[10:]...), lines[2][len(trustedPrefix):]...)
+	if !ed25519.Verify(pk.Key, trusted, global) {
+		return errors.New("invalid signature of the trusted comment")
+	}
+	return nil
+}
+
+// GenerateMinisignKey creates a new minisign key pair.
+func GenerateMinisignKey() (*MinisignSecretKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sk := &MinisignSecretKey{Key: key}
+	if _, err := rand.Read(sk.ID[:]); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// PublicKey returns the public key that verifies the signatures of the
+// secret key.
+func (sk *MinisignSecretKey) PublicKey() *MinisignPublicKey {
+	return &MinisignPublicKey{ID: sk.ID, Key: sk.Key.Public().(ed25519.PublicKey)}
+}
+
+// scryptParams converts the limits of minisign key files to scrypt
+// parameters like libsodium does
+func scryptParams(opsLimit, memLimit uint64) (n, r, p int) {
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+	r = 8
+	var maxN uint64
+	if opsLimit < memLimit/32 {
+		p = 1
+		maxN = opsLimit / uint64(r*4)
+	} else {
+		maxN = memLimit / uint64(r*128)
+	}
+	logN := uint(1)
+	for ; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+	if opsLimit >= memLimit/32 {
+		maxRP := (opsLimit / 4) / (uint64(1) << logN)
+		if maxRP > 0x3fffffff {
+			maxRP = 0x3fffffff
+		}
+		p = int(maxRP) / r
+	}
+	return 1 << logN, r, p
+}
+
+// keynumChecksum is the checksum minisign keeps in secret keys
+func keynumChecksum(id []byte, key []byte) []byte {
+	h, _ := blake2b.New256(nil)
+	h.Write(algEd)
+	h.Write(id)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// xorKeynum encrypts or decrypts the key ID, key and checksum of a
+// secret key file in place
+func xorKeynum(keynum, password, salt []byte, opsLimit, memLimit uint64) error {
+	n, r, p := scryptParams(opsLimit, memLimit)
+	stream, err := scrypt.Key(password, salt, n, r, p, len(keynum))
+	if err != nil {
+		return err
+	}
+	subtle.XORBytes(keynum, keynum, stream)
+	return nil
+}
+
+// ParseMinisignSecretKey reads a minisign secret key file, the password
+// is needed if the key is encrypted.
+func ParseMinisignSecretKey(text, password []byte) (*MinisignSecretKey, error) {
+	raw, err := decodeKeyFile(text)
+	if err != nil {
+		return nil, err
+	}
+	// algorithms, salt, limits and the key ID, key and checksum
+	const keynumSize = 8 + ed25519.PrivateKeySize + 32
+	if len(raw) != 6+32+16+keynumSize || !bytes.Equal(raw[:2], algEd) || !bytes.Equal(raw[4:6], chkBlake2b) {
+		return nil, errors.New("invalid minisign secret key")
+	}
+	keynum := raw[54:]
+	switch {
+	case bytes.Equal(raw[2:4], kdfScrypt):
+		if len(password) == 0 {
+			return nil, errors.New("secret key is encrypted, a password is needed")
+		}
+		if err := xorKeynum(keynum, password, raw[6:38], binary.LittleEndian.Uint64(raw[38:]), binary.LittleEndian.Uint64(raw[46:])); err != nil {
+			return nil, err
+		}
+	case !bytes.Equal(raw[2:4], kdfNone):
+		return nil, fmt.Errorf("unsupported key derivation %q", raw[2:4])
+	}
+	if subtle.ConstantTimeCompare(keynumChecksum(keynum[:8], keynum[8:72]), keynum[72:]) != 1 {
+		return nil, errors.New("wrong password for secret key")
+	}
+	sk := &MinisignSecretKey{Key: ed25519.PrivateKey(keynum[8:72])}
+	copy(sk.ID[:], keynum[:8])
+	return sk, nil
+}
+
+// Marshal returns the secret key in the format of minisign key files,
+// encrypted with the password unless it is empty.
+func (sk *MinisignSecretKey) Marshal(password []byte) ([]byte, error) {
+	raw := append(append([]byte(nil), algEd...), kdfNone...)
+	raw = append(raw, chkBlake2b...)
+	salt := make([]byte, 32)
+	if len(password) > 0 {
+		copy(raw[2:], kdfScrypt)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	}
+	raw = append(raw, salt...)
+	raw = binary.LittleEndian.AppendUint64(raw, scryptOpsLimit)
+	raw = binary.LittleEndian.AppendUint64(raw, scryptMemLimit)
+	keynum := append(append(sk.ID[:], sk.Key...), keynumChecksum(sk.ID[:], sk.Key)...)
+	if len(password) > 0 {
+		if err := xorKeynum(keynum, password, salt, scryptOpsLimit, scryptMemLimit); err != nil {
+			return nil, err
+		}
+	}
+	raw = append(raw, keynum...)
+	comment := "minisign secret key"
+	if len(password) > 0 {
+		comment = "minisign encrypted secret key"
+	}
+	return []byte(fmt.Sprintf("%s%s\n%s\n", untrustedPrefix, comment, base64.StdEncoding.EncodeToString(raw))), nil
+}
+
+func (sk *MinisignSecretKey) Ext() string {
+	return ".minisig"
+}
+
+// Sign returns a minisign signature of the blake2b hash of the data.
+func (sk *MinisignSecretKey) Sign(data []byte) ([]byte, error) {
+	h := blake2b.Sum512(data)
+	sig := ed25519.Sign(sk.Key, h[:])
+	trusted := fmt.Sprintf("timestamp:%d\thashed", time.Now().Unix())
+	global := ed25519.Sign(sk.Key, append(append([]byte(nil), sig...), trusted...))
+
+	raw := append(append(append([]byte(nil), algHashedEd...), sk.ID[:]...), sig...)
+	return []byte(fmt.Sprintf("%ssignature from minisign secret key %s\n%s\n%s%s\n%s\n",
+		untrustedPrefix, keyID(sk.ID), base64.StdEncoding.EncodeToString(raw),
+		trustedPrefix, trusted, base64.StdEncoding.EncodeToString(global))), nil
+}
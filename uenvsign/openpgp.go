@@ -0,0 +1,74 @@
+package uenvsign
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// OpenPGPSigner creates armored detached OpenPGP signatures.
+type OpenPGPSigner struct {
+	entity *openpgp.Entity
+}
+
+// NewOpenPGPSigner uses the first key with a private key of the
+// armored key ring, the passphrase decrypts encrypted keys.
+func NewOpenPGPSigner(armored, passphrase []byte) (*OpenPGPSigner, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range keyring {
+		if e.PrivateKey == nil {
+			continue
+		}
+		if e.PrivateKey.Encrypted {
+			if len(passphrase) == 0 {
+				return nil, errors.New("secret key is encrypted, a password is needed")
+			}
+			if err := e.DecryptPrivateKeys(passphrase); err != nil {
+				return nil, err
+			}
+		}
+		return &OpenPGPSigner{entity: e}, nil
+	}
+	return nil, errors.New("no private key found")
+}
+
+func (s *OpenPGPSigner) Ext() string {
+	return ".asc"
+}
+
+func (s *OpenPGPSigner) Sign(data []byte) ([]byte, error) {
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, s.entity, bytes.NewReader(data), nil); err != nil {
+		return nil, err
+	}
+	sig.WriteByte('\n')
+	return sig.Bytes(), nil
+}
+
+// OpenPGPVerifier checks armored detached OpenPGP signatures against
+// the keys of a key ring.
+type OpenPGPVerifier struct {
+	keyring openpgp.EntityList
+}
+
+// NewOpenPGPVerifier reads the armored public keys that are trusted.
+func NewOpenPGPVerifier(armored []byte) (*OpenPGPVerifier, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+	return &OpenPGPVerifier{keyring: keyring}, nil
+}
+
+func (v *OpenPGPVerifier) Ext() string {
+	return ".asc"
+}
+
+func (v *OpenPGPVerifier) Verify(data, sig []byte) error {
+	_, err := openpgp.CheckArmoredDetachedSignature(v.keyring, bytes.NewReader(data), bytes.NewReader(sig), nil)
+	return err
+}
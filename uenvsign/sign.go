@@ -0,0 +1,113 @@
+// Package uenvsign signs exported environments, as text or binary
+// images, with detached minisign or OpenPGP signatures and verifies
+// them before an import, so that provisioning pipelines only apply
+// approved environment changes.
+package uenvsign
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Signer creates detached signatures.
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+	// Ext is the extension of signature files, e.g. ".minisig".
+	Ext() string
+}
+
+// Verifier checks detached signatures.
+type Verifier interface {
+	Verify(data, sig []byte) error
+	Ext() string
+}
+
+// LoadSigner reads a minisign or armored OpenPGP secret key. The
+// password decrypts encrypted keys and may be empty otherwise.
+func LoadSigner(fname string, password []byte) (Signer, error) {
+	key, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var signer Signer
+	if isOpenPGP(key) {
+		signer, err = NewOpenPGPSigner(key, password)
+	} else {
+		signer, err = ParseMinisignSecretKey(key, password)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read secret key %s: %v", fname, err)
+	}
+	return signer, nil
+}
+
+// LoadVerifier reads a minisign or armored OpenPGP public key.
+func LoadVerifier(fname string) (Verifier, error) {
+	key, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var verifier Verifier
+	if isOpenPGP(key) {
+		verifier, err = NewOpenPGPVerifier(key)
+	} else {
+		verifier, err = ParseMinisignPublicKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read public key %s: %v", fname, err)
+	}
+	return verifier, nil
+}
+
+func isOpenPGP(key []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(key), []byte("-----BEGIN PGP"))
+}
+
+// SignFile writes the signature of the file next to it, with the
+// extension of the signer appended, and returns its name.
+func SignFile(fname string, signer Signer) (string, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return "", err
+	}
+	sig, err := signer.Sign(data)
+	if err != nil {
+		return "", fmt.Errorf("cannot sign %s: %v", fname, err)
+	}
+	sigFile := fname + signer.Ext()
+	if err := os.WriteFile(sigFile, sig, 0644); err != nil {
+		return "", err
+	}
+	return sigFile, nil
+}
+
+// ReadVerifiedFile returns the content of the file if the signature
+// next to it, see SignFile, is valid.
+func ReadVerifiedFile(fname string, verifier Verifier) ([]byte, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := os.ReadFile(fname + verifier.Ext())
+	if err != nil {
+		return nil, err
+	}
+	if err := verifier.Verify(data, sig); err != nil {
+		return nil, fmt.Errorf("cannot verify %s: %v", fname, err)
+	}
+	return data, nil
+}
+
+// ImportVerified imports the "key=value" lines of the file into the
+// environment, see uenv.Env.Import, if its signature is valid. Nothing
+// is imported otherwise.
+func ImportVerified(env *uenv.Env, fname string, verifier Verifier) error {
+	data, err := ReadVerifiedFile(fname, verifier)
+	if err != nil {
+		return err
+	}
+	return env.Import(bytes.NewReader(data))
+}
@@ -0,0 +1,173 @@
+package uenvsign
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type signTestSuite struct {
+	dir string
+}
+
+var _ = Suite(&signTestSuite{})
+
+func (s *signTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	// keep encrypting keys fast
+	scryptOpsLimit, scryptMemLimit = 1<<15, 1<<20
+}
+
+func (s *signTestSuite) TearDownTest(c *C) {
+	scryptOpsLimit, scryptMemLimit = 1<<25, 1<<30
+}
+
+// signatures made by minisign itself
+const (
+	minisignPublicKey = "RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3"
+	minisignLegacySig = "untrusted comment: signature from minisign secret key\nRWQf6LRCGA9i59SLOFxz6NxvASXDJeRtuZykwQepbDEGt87ig1BNpWaVWuNrm73YiIiJbq71Wi+dP9eKL8OC351vwIasSSbXxwA=\ntrusted comment: timestamp:1635442742\tfile:test\n0YteLgV960ia80vnA/fHbvkyjl/IoP/HNOCaZfrF0CdhAlp7ok+Tpkya+VpWPX5C/Is3q8a/kEDSY7fBmmgJCg==\n"
+	minisignHashedSig = "untrusted comment: signature from minisign secret key\nRUQf6LRCGA9i559r3g7V1qNyJDApGip8MfqcadIgT9CuhV3EMhHoN1mGTkUidF/z7SrlQgXdy8ofjb7bNJJylDOocrCo8KLzZwo=\ntrusted comment: timestamp:1635443258\tfile:test\thashed\n/cj37GK60vryibFn+ftOgbCvW9NKhKYgjVpFFQUcWPAnjO23wrvVDTt7cloNC06maoBli9q6qwZDXXoaxweICQ==\n"
+)
+
+func (s *signTestSuite) TestMinisignVerifyUpstream(c *C) {
+	pk, err := ParseMinisignPublicKey([]byte(minisignPublicKey))
+	c.Assert(err, IsNil)
+	c.Check(pk.Verify([]byte("test"), []byte(minisignLegacySig)), IsNil)
+	c.Check(pk.Verify([]byte("test"), []byte(minisignHashedSig)), IsNil)
+	c.Check(pk.Verify([]byte("tesT"), []byte(minisignHashedSig)), ErrorMatches, "invalid signature")
+
+	tampered := strings.Replace(minisignHashedSig, "file:test", "file:evil", 1)
+	c.Check(pk.Verify([]byte("test"), []byte(tampered)), ErrorMatches, "invalid signature of the trusted comment")
+
+	text, err := pk.MarshalText()
+	c.Assert(err, IsNil)
+	c.Check(string(text), Equals, "untrusted comment: minisign public key E7620F1842B4E81F\n"+minisignPublicKey+"\n")
+}
+
+func (s *signTestSuite) TestMinisignSignVerify(c *C) {
+	sk, err := GenerateMinisignKey()
+	c.Assert(err, IsNil)
+	sig, err := sk.Sign([]byte("bootdelay=3\n"))
+	c.Assert(err, IsNil)
+	c.Check(sk.PublicKey().Verify([]byte("bootdelay=3\n"), sig), IsNil)
+	c.Check(sk.PublicKey().Verify([]byte("bootdelay=0\n"), sig), ErrorMatches, "invalid signature")
+
+	other, err := GenerateMinisignKey()
+	c.Assert(err, IsNil)
+	c.Check(other.PublicKey().Verify([]byte("bootdelay=3\n"), sig), ErrorMatches, "signature is from key [0-9A-F]{16}, not [0-9A-F]{16}")
+}
+
+func (s *signTestSuite) TestMinisignSecretKeyFile(c *C) {
+	sk, err := GenerateMinisignKey()
+	c.Assert(err, IsNil)
+
+	plain, err := sk.Marshal(nil)
+	c.Assert(err, IsNil)
+	sk2, err := ParseMinisignSecretKey(plain, nil)
+	c.Assert(err, IsNil)
+	c.Check(sk2, DeepEquals, sk)
+
+	encrypted, err := sk.Marshal([]byte("secret"))
+	c.Assert(err, IsNil)
+	c.Check(string(encrypted), Matches, "untrusted comment: minisign encrypted secret key\n(?s).*")
+	_, err = ParseMinisignSecretKey(encrypted, nil)
+	c.Check(err, ErrorMatches, "secret key is encrypted, a password is needed")
+	_, err = ParseMinisignSecretKey(encrypted, []byte("wrong"))
+	c.Check(err, ErrorMatches, "wrong password for secret key")
+	sk2, err = ParseMinisignSecretKey(encrypted, []byte("secret"))
+	c.Assert(err, IsNil)
+	c.Check(sk2, DeepEquals, sk)
+}
+
+func (s *signTestSuite) TestScryptParams(c *C) {
+	// the limits of minisign need 1GiB like with libsodium
+	n, r, p := scryptParams(1<<25, 1<<30)
+	c.Check([]int{n, r, p}, DeepEquals, []int{1 << 20, 8, 1})
+}
+
+// openPGPKeys returns an armored secret and public key
+func openPGPKeys(c *C) (secret, public []byte) {
+	e, err := openpgp.NewEntity("provisioning", "", "provisioning@example.com", nil)
+	c.Assert(err, IsNil)
+	var sec, pub bytes.Buffer
+	w, err := armor.Encode(&sec, openpgp.PrivateKeyType, nil)
+	c.Assert(err, IsNil)
+	c.Assert(e.SerializePrivate(w, nil), IsNil)
+	c.Assert(w.Close(), IsNil)
+	w, err = armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	c.Assert(err, IsNil)
+	c.Assert(e.Serialize(w), IsNil)
+	c.Assert(w.Close(), IsNil)
+	return sec.Bytes(), pub.Bytes()
+}
+
+func (s *signTestSuite) TestOpenPGPSignVerify(c *C) {
+	secret, public := openPGPKeys(c)
+	signer, err := NewOpenPGPSigner(secret, nil)
+	c.Assert(err, IsNil)
+	sig, err := signer.Sign([]byte("bootdelay=3\n"))
+	c.Assert(err, IsNil)
+	c.Check(string(sig), Matches, "-----BEGIN PGP SIGNATURE-----\n(?s).*")
+
+	verifier, err := NewOpenPGPVerifier(public)
+	c.Assert(err, IsNil)
+	c.Check(verifier.Verify([]byte("bootdelay=3\n"), sig), IsNil)
+	c.Check(verifier.Verify([]byte("bootdelay=0\n"), sig), NotNil)
+
+	_, err = NewOpenPGPSigner(public, nil)
+	c.Check(err, ErrorMatches, "no private key found")
+}
+
+func (s *signTestSuite) testImportVerified(c *C, secret, public []byte) {
+	secFile := filepath.Join(s.dir, "key.sec")
+	pubFile := filepath.Join(s.dir, "key.pub")
+	c.Assert(os.WriteFile(secFile, secret, 0600), IsNil)
+	c.Assert(os.WriteFile(pubFile, public, 0644), IsNil)
+	signer, err := LoadSigner(secFile, nil)
+	c.Assert(err, IsNil)
+	verifier, err := LoadVerifier(pubFile)
+	c.Assert(err, IsNil)
+
+	txt := filepath.Join(s.dir, "env.txt")
+	c.Assert(os.WriteFile(txt, []byte("bootdelay=0\n"), 0644), IsNil)
+	sigFile, err := SignFile(txt, signer)
+	c.Assert(err, IsNil)
+	c.Check(sigFile, Equals, txt+signer.Ext())
+
+	env, err := uenv.NewEnv(4096)
+	c.Assert(err, IsNil)
+	c.Assert(ImportVerified(env, txt, verifier), IsNil)
+	c.Check(env.Get("bootdelay"), Equals, "0")
+
+	// changes after signing are rejected
+	c.Assert(os.WriteFile(txt, []byte("bootdelay=0\nbootcmd=evil\n"), 0644), IsNil)
+	err = ImportVerified(env, txt, verifier)
+	c.Check(err, ErrorMatches, "cannot verify .*/env.txt: .*")
+	c.Check(env.Get("bootcmd"), Equals, "")
+}
+
+func (s *signTestSuite) TestImportVerifiedMinisign(c *C) {
+	sk, err := GenerateMinisignKey()
+	c.Assert(err, IsNil)
+	secret, err := sk.Marshal(nil)
+	c.Assert(err, IsNil)
+	public, err := sk.PublicKey().MarshalText()
+	c.Assert(err, IsNil)
+	s.testImportVerified(c, secret, public)
+}
+
+func (s *signTestSuite) TestImportVerifiedOpenPGP(c *C) {
+	secret, public := openPGPKeys(c)
+	s.testImportVerified(c, secret, public)
+}
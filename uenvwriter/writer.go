@@ -0,0 +1,193 @@
+// Package uenvwriter splits writing a uboot environment into an
+// unprivileged front-end and a small privileged helper. The helper
+// owns the device and listens on a unix socket, it only writes
+// complete environment images of the size of its target that have a
+// valid CRC, so a compromised front-end cannot write anything else to
+// the device.
+//
+// A request is the length of the image as 4 byte big endian number
+// followed by the image, the helper answers with a line "ok" or
+// "error: " and the reason.
+//
+// The helper does no authentication itself, access is controlled by
+// the permissions of the socket, see Listen.
+package uenvwriter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// DefaultTimeout limits the time a client may take to send its request
+const DefaultTimeout = 10 * time.Second
+
+// Helper writes the images it receives to its target environment
+type Helper struct {
+	open func() (*uenv.Env, error)
+
+	// Checksum is the CRC the images and the target use
+	Checksum uenv.Checksum
+	// Schema is checked by the images if set
+	Schema *uenv.Schema
+	// Timeout limits the time to read a request, DefaultTimeout if
+	// zero
+	Timeout time.Duration
+	// Logger gets a record for every request if set
+	Logger *slog.Logger
+
+	// mu serializes the writes
+	mu sync.Mutex
+}
+
+// NewHelper returns a helper that writes to the environment returned
+// by open. The environment is opened for every request and closed
+// afterwards.
+func NewHelper(open func() (*uenv.Env, error)) *Helper {
+	return &Helper{open: open}
+}
+
+// Listen creates the unix socket the helper listens on, replacing a
+// stale one. Only the owner and the group of the socket may connect,
+// use os.Chown to give the front-end's group access.
+func Listen(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve handles the requests on the listener until it is closed
+func (h *Helper) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go h.serveConn(conn)
+	}
+}
+
+func (h *Helper) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := h.handle(conn); err != nil {
+		if h.Logger != nil {
+			h.Logger.Warn("rejected environment image", "err", err)
+		}
+		fmt.Fprintf(conn, "error: %s\n", strings.ReplaceAll(err.Error(), "\n", " "))
+		return
+	}
+	if h.Logger != nil {
+		h.Logger.Info("wrote environment image")
+	}
+	fmt.Fprintf(conn, "ok\n")
+}
+
+// handle reads the image from r, checks it and writes it to the target
+func (h *Helper) handle(r io.Reader) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	target, err := h.open()
+	if err != nil {
+		return fmt.Errorf("cannot open target: %v", err)
+	}
+	defer target.Close()
+
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return fmt.Errorf("cannot read request: %v", err)
+	}
+	// check the size before reading so that a client cannot make
+	// the helper allocate arbitrary amounts of memory
+	if int64(size) != int64(target.Size()) {
+		return fmt.Errorf("image has %d bytes, the target needs %d", size, target.Size())
+	}
+	img := make([]byte, size)
+	if _, err := io.ReadFull(r, img); err != nil {
+		return fmt.Errorf("cannot read image: %v", err)
+	}
+
+	env, err := uenv.OpenStorage(uenv.NewMemStorage(img), uenv.Options{Checksum: h.Checksum})
+	if err != nil {
+		return fmt.Errorf("invalid image: %v", err)
+	}
+	if h.Schema != nil {
+		violations, err := env.CheckSchema(h.Schema)
+		if err != nil {
+			return err
+		}
+		if len(violations) > 0 {
+			return fmt.Errorf("invalid image: %v", violations[0])
+		}
+	}
+	return uenv.Restore(target, &uenv.Snapshot{Name: "request", Time: time.Now(), Image: img, Checksum: h.Checksum})
+}
+
+// WriteImage asks the helper listening on the socket to write the
+// image to its target.
+func WriteImage(socket string, img []byte) error {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if int64(len(img)) > math.MaxUint32 {
+		return fmt.Errorf("image too big: %d bytes", len(img))
+	}
+	req := make([]byte, 4, 4+len(img))
+	binary.BigEndian.PutUint32(req, uint32(len(img)))
+	_, writeErr := conn.Write(append(req, img...))
+	// the helper may reject the request before reading all of it,
+	// its reply tells why
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		if writeErr != nil {
+			return writeErr
+		}
+		return fmt.Errorf("cannot read reply: %v", err)
+	}
+	reply = strings.TrimSuffix(reply, "\n")
+	if reply != "ok" {
+		return fmt.Errorf("cannot write environment: %s", strings.TrimPrefix(reply, "error: "))
+	}
+	return nil
+}
+
+// Commit asks the helper listening on the socket to write the
+// variables of env, which must have the size of the helper's target.
+// env is typically a copy of the target in memory that was changed by
+// the front-end.
+func Commit(socket string, env *uenv.Env) error {
+	snap, err := env.Snapshot("commit")
+	if err != nil {
+		return err
+	}
+	return WriteImage(socket, snap.Image)
+}
@@ -0,0 +1,116 @@
+package uenvwriter
+
+import (
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/mvo5/uboot-go/uenv"
+)
+
+// Hook up check.v1 into the "go test" runner
+func Test(t *testing.T) { TestingT(t) }
+
+type writerTestSuite struct {
+	envFile string
+	socket  string
+	helper  *Helper
+	l       net.Listener
+}
+
+var _ = Suite(&writerTestSuite{})
+
+func (s *writerTestSuite) SetUpTest(c *C) {
+	dir := c.MkDir()
+	s.envFile = filepath.Join(dir, "uboot.env")
+	env, err := uenv.Create(s.envFile, 64)
+	c.Assert(err, IsNil)
+	env.Set("foo", "bar")
+	c.Assert(env.Save(), IsNil)
+
+	s.socket = filepath.Join(dir, "writer.sock")
+	s.l, err = Listen(s.socket)
+	c.Assert(err, IsNil)
+	s.helper = NewHelper(func() (*uenv.Env, error) {
+		return uenv.Open(s.envFile)
+	})
+	go s.helper.Serve(s.l)
+}
+
+func (s *writerTestSuite) TearDownTest(c *C) {
+	s.l.Close()
+}
+
+func (s *writerTestSuite) memEnv(c *C, size int) *uenv.Env {
+	env, err := uenv.NewEnv(size)
+	c.Assert(err, IsNil)
+	return env
+}
+
+func (s *writerTestSuite) TestCommit(c *C) {
+	env := s.memEnv(c, 64)
+	env.Set("foo", "baz")
+	env.Set("a", "b")
+	c.Assert(Commit(s.socket, env), IsNil)
+
+	target, err := uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(target.String(), Equals, "a=b\nfoo=baz\n")
+}
+
+func (s *writerTestSuite) TestWrongSize(c *C) {
+	env := s.memEnv(c, 128)
+	env.Set("foo", "baz")
+	c.Check(Commit(s.socket, env), ErrorMatches, "cannot write environment: image has 128 bytes, the target needs 64")
+
+	target, err := uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(target.Get("foo"), Equals, "bar")
+}
+
+func (s *writerTestSuite) TestBadCRC(c *C) {
+	env := s.memEnv(c, 64)
+	env.Set("foo", "baz")
+	snap, err := env.Snapshot("test")
+	c.Assert(err, IsNil)
+	snap.Image[10] ^= 0xff
+
+	err = WriteImage(s.socket, snap.Image)
+	c.Check(err, ErrorMatches, "cannot write environment: invalid image: .*")
+
+	target, err := uenv.Open(s.envFile)
+	c.Assert(err, IsNil)
+	c.Check(target.Get("foo"), Equals, "bar")
+}
+
+func (s *writerTestSuite) TestSchema(c *C) {
+	s.helper.Schema = &uenv.Schema{
+		Variables: map[string]uenv.VariableSchema{
+			"bootdelay": {Type: uenv.TypeInt},
+		},
+	}
+	env := s.memEnv(c, 64)
+	env.Set("bootdelay", "soon")
+	c.Check(Commit(s.socket, env), ErrorMatches, `cannot write environment: invalid image: variable "bootdelay": value "soon" is not a number`)
+
+	env.Set("bootdelay", "3")
+	c.Check(Commit(s.socket, env), IsNil)
+}
+
+func (s *writerTestSuite) TestTruncatedRequest(c *C) {
+	conn, err := net.Dial("unix", s.socket)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	var req [4]byte
+	binary.BigEndian.PutUint32(req[:], 64)
+	_, err = conn.Write(append(req[:], "short"...))
+	c.Assert(err, IsNil)
+	conn.(*net.UnixConn).CloseWrite()
+
+	reply := make([]byte, 128)
+	n, _ := conn.Read(reply)
+	c.Check(string(reply[:n]), Matches, "error: cannot read image: .*\n")
+}